@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/imageio"
+	"github.com/smackem/ylang/internal/interpreter"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// nameVar is replaced with the name of the source image without extension in the -out path of batches
+const nameVar = "{name}"
+
+// the names of the constants describing the source image a script is executed against
+const (
+	fileNameConst  = "FileName"
+	fileIndexConst = "FileIndex"
+)
+
+// newFileRegistry returns a registry holding the constants FileName, the file name of the source
+// image, and FileIndex, its zero-based index in the batch. Their values are passed with fileConstants.
+func newFileRegistry() *interpreter.Registry {
+	registry := interpreter.NewRegistry()
+	for _, err := range []error{
+		registry.AddConstant(fileNameConst, interpreter.Str("")),
+		registry.AddConstant(fileIndexConst, interpreter.Number(0)),
+	} {
+		if err != nil {
+			panic(err)
+		}
+	}
+	return registry
+}
+
+// fileConstants returns the values of the constants of newFileRegistry for the source image
+// at path, which is the index-th image of a batch
+func fileConstants(path string, index int) map[string]interpreter.Value {
+	return map[string]interpreter.Value{
+		fileNameConst:  interpreter.Str(filepath.Base(path)),
+		fileIndexConst: interpreter.Number(index),
+	}
+}
+
+// batchInputs returns the paths of the source images specified by the -image flag and true if they
+// form a batch, which is the case if path is a glob pattern or a directory. The images of a
+// directory are the files with the extension of a known image format.
+func batchInputs(path string) ([]string, bool, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, false, err
+		}
+		var inputs []string
+		for _, file := range files {
+			if _, err := imageio.FormatOf(file.Name()); err == nil && !file.IsDir() && filepath.Ext(file.Name()) != "" {
+				inputs = append(inputs, filepath.Join(path, file.Name()))
+			}
+		}
+		if len(inputs) == 0 {
+			return nil, false, fmt.Errorf("no images found in directory '%s'", path)
+		}
+		return inputs, true, nil
+	}
+	if !strings.ContainsAny(path, "*?[") {
+		return []string{path}, false, nil
+	}
+	inputs, err := filepath.Glob(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid pattern '%s': %s", path, err)
+	}
+	if len(inputs) == 0 {
+		return nil, false, fmt.Errorf("no images match '%s'", path)
+	}
+	return inputs, true, nil
+}
+
+// batchTargetPath returns the target path of the source image at sourcePath by replacing
+// nameVar in pattern with the name of the source image without extension
+func batchTargetPath(pattern string, sourcePath string) string {
+	name := filepath.Base(sourcePath)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.Replace(pattern, nameVar, name, -1)
+}
+
+// checkBatchTargets returns an error if the target paths of two source images at inputs are the same,
+// e.g. of a.png and a.jpg if the pattern contains nameVar and an extension
+func checkBatchTargets(inputs []string, targetPattern string) error {
+	sources := make(map[string]string, len(inputs))
+	for _, sourcePath := range inputs {
+		targetPath := filepath.Clean(batchTargetPath(targetPattern, sourcePath))
+		if other, ok := sources[targetPath]; ok {
+			return fmt.Errorf("'%s' and '%s' have the same target path '%s'", other, sourcePath, targetPath)
+		}
+		sources[targetPath] = sourcePath
+	}
+	return nil
+}
+
+// runBatch invokes process for each of the source images at inputs with the index and target path
+// of the image, using jobs workers or one per CPU if jobs is 0. Errors are logged without stopping
+// the batch. Returns the number of images that failed.
+func runBatch(inputs []string, targetPattern string, jobs int, process func(sourcePath string, index int, targetPath string) error) int {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	indices := make(chan int)
+	failed := int32(0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				sourcePath := inputs[index]
+				if err := process(sourcePath, index, batchTargetPath(targetPattern, sourcePath)); err != nil {
+					log.Printf("error processing '%s': %s", sourcePath, err)
+					atomic.AddInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	for index := range inputs {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+	return int(failed)
+}
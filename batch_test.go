@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func Test_batchInputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ylang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"b.png", "a.JPG", "notes.txt", "raw"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.png"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path      string
+		want      []string
+		wantBatch bool
+		wantErr   bool
+	}{
+		{path: dir, want: []string{"a.JPG", "b.png"}, wantBatch: true},
+		{path: filepath.Join(dir, "*.png"), want: []string{"b.png", "sub.png"}, wantBatch: true},
+		{path: filepath.Join(dir, "b.png"), want: []string{"b.png"}},
+		{path: filepath.Join(dir, "*.gif"), wantErr: true},
+		{path: filepath.Join(dir, "[.png"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(filepath.Base(tt.path), func(t *testing.T) {
+			inputs, batch, err := batchInputs(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("batchInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, input := range inputs {
+				got = append(got, filepath.Base(input))
+			}
+			if !reflect.DeepEqual(got, tt.want) || batch != tt.wantBatch {
+				t.Errorf("batchInputs() = %v, %v, want %v, %v", got, batch, tt.want, tt.wantBatch)
+			}
+		})
+	}
+}
+
+func Test_runBatch(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	inputs := []string{"in/a.jpg", "in/b.c.jpg", "in/fail.jpg", "in/d.png"}
+	mutex := sync.Mutex{}
+	var got []string
+	process := func(sourcePath string, index int, targetPath string) error {
+		if inputs[index] != sourcePath {
+			t.Errorf("index %d of %s, want %s", index, sourcePath, inputs[index])
+		}
+		if filepath.Base(sourcePath) == "fail.jpg" {
+			return errors.New("failed")
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		got = append(got, targetPath)
+		return nil
+	}
+	if failed := runBatch(inputs, "out/{name}-x.png", 3, process); failed != 1 {
+		t.Errorf("runBatch() failed = %d, want 1", failed)
+	}
+	sort.Strings(got)
+	if want := []string{"out/a-x.png", "out/b.c-x.png", "out/d-x.png"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("target paths = %v, want %v", got, want)
+	}
+}
+
+func Test_checkBatchTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		inputs  []string
+		pattern string
+		want    string
+	}{
+		{
+			name:    "distinct",
+			inputs:  []string{"in/a.png", "in/b.png", "in/a.b.png"},
+			pattern: "out/{name}.png",
+		},
+		{
+			name:    "extensions",
+			inputs:  []string{"in/a.png", "in/b.png", "in/a.jpg"},
+			pattern: "out/{name}.png",
+			want:    "'in/a.png' and 'in/a.jpg' have the same target path 'out/a.png'",
+		},
+		{
+			name:    "directories",
+			inputs:  []string{"x/a.png", "y/a.png"},
+			pattern: "out/{name}-small.png",
+			want:    "'x/a.png' and 'y/a.png' have the same target path 'out/a-small.png'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBatchTargets(tt.inputs, tt.pattern)
+			if tt.want == "" && err != nil || tt.want != "" && (err == nil || err.Error() != tt.want) {
+				t.Errorf("checkBatchTargets() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
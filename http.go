@@ -1,31 +1,37 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/smackem/ylang/internal/emitter"
+	"github.com/smackem/ylang/internal/interpreter"
 	"github.com/smackem/ylang/internal/program"
 	"log"
 	"net/http"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/smackem/ylang/internal/goobar"
 )
 
 const (
-	httpPort       = 9090
-	targetImageDir = "res/pub/temp"
+	httpPort         = 9090
+	targetImageDir   = "res/pub/temp"
+	paramFieldPrefix = "param." // the prefix of the form fields holding script parameters
 )
 
-func httpMain() {
+func httpMain(options interpreter.Options) {
 	err := os.Mkdir(targetImageDir, os.ModeDir)
 	if err != nil && os.IsExist(err) == false { // ignore "already exists" error
 		log.Fatalf("error initializing http server: %s", err.Error())
 	}
 	goobar.SetViewFolder("res/view")
 	http.Handle("/", goobar.Get(getIndex))
-	http.Handle("/render", goobar.Post(postRender))
+	http.Handle("/render", goobar.Post(func(x *goobar.Exchange) goobar.Responder {
+		return postRender(x, options)
+	}))
 	http.Handle("/pub/", http.FileServer(http.Dir("res")))
 
 	srv := http.Server{
@@ -40,7 +46,7 @@ func getIndex(x *goobar.Exchange) goobar.Responder {
 	return goobar.View("index.html", nil)
 }
 
-func postRender(x *goobar.Exchange) goobar.Responder {
+func postRender(x *goobar.Exchange, options interpreter.Options) goobar.Responder {
 	uri := x.MustGetString("imageUri")
 	source := x.MustGetString("sourceCode")
 
@@ -56,12 +62,24 @@ func postRender(x *goobar.Exchange) goobar.Responder {
 
 	prog, err := program.Compile(string(source))
 	if err != nil {
-		return goobar.Error(500, fmt.Sprintf("compilation error: %s", err.Error()))
+		return goobar.Error(500, fmt.Sprintf("compilation error: %s", describeError(err, "", source, nil)))
 	}
 
-	err = program.Execute(prog, surf)
+	// the fields param.NAME of the form are script parameters, all others are ignored
+	options.Params = make(map[string]string)
+	for field, values := range x.Request().Form {
+		if name := strings.TrimPrefix(field, paramFieldPrefix); name != field && len(values) > 0 {
+			options.Params[name] = values[0]
+		}
+	}
+
+	err = program.Execute(x.Request().Context(), prog, surf, options)
+	var limitErr *interpreter.LimitError
+	if errors.As(err, &limitErr) {
+		return goobar.Error(503, fmt.Sprintf("execution aborted: %s", describeError(err, "", source, nil)))
+	}
 	if err != nil {
-		return goobar.Error(500, fmt.Sprintf("execution error: %s", err.Error()))
+		return goobar.Error(500, fmt.Sprintf("execution error: %s", describeError(err, "", source, nil)))
 	}
 
 	guid := uuid.New()
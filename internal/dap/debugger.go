@@ -0,0 +1,223 @@
+package dap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/parser"
+	"path/filepath"
+	"sync"
+)
+
+// errTerminated stops a program that has been terminated by the client
+var errTerminated = errors.New("the program has been terminated")
+
+// stepMode determines where the debugger pauses a running program
+type stepMode int
+
+const (
+	runToBreakpoint stepMode = iota
+	stepIn                   // pause at the next statement
+	stepOver                 // pause at the next statement that is not executed by a function invoked by the current one
+	stepOut                  // pause at the next statement of the caller of the current function
+)
+
+type breakpoint struct {
+	line      int
+	condition string // a ylang expression, the breakpoint is hit if it evaluates to true
+}
+
+// debugger pauses a program at breakpoints and after steps. Statement is called on the goroutine
+// executing the program, all other methods on the goroutine serving the client.
+type debugger struct {
+	mu          sync.Mutex
+	breakpoints map[string][]breakpoint // by canonical file path
+	mode        stepMode
+	depth       int  // the depth of the call stack when the program has been resumed
+	entry       bool // true if the program is paused before the first statement
+	pause       bool // true if the client requested to pause the program
+	terminated  bool
+	paused      *interpreter.DebugState // non-nil while the program is paused
+	resumed     chan struct{}
+	paths       map[string]string // the canonical paths of the module files by module name
+	stopped     func(reason string, description string)
+	output      func(category string, output string)
+}
+
+func newDebugger(stopped func(reason string, description string), output func(category string, output string)) *debugger {
+	return &debugger{
+		breakpoints: make(map[string][]breakpoint),
+		resumed:     make(chan struct{}, 1),
+		paths:       make(map[string]string),
+		stopped:     stopped,
+		output:      output,
+	}
+}
+
+// Statement implements interpreter.Debugger
+func (d *debugger) Statement(stmt parser.Statement, state *interpreter.DebugState) error {
+	d.mu.Lock()
+	if d.terminated {
+		d.mu.Unlock()
+		return errTerminated
+	}
+	reason := d.stepReason(state.Depth())
+	var breakpoints []breakpoint
+	if reason == "" && len(d.breakpoints) > 0 {
+		breakpoints = d.breakpoints[d.path(state.File())]
+	}
+	d.mu.Unlock()
+
+	description := ""
+	line := stmt.Token().LineNumber
+	for _, bp := range breakpoints {
+		if bp.line != line {
+			continue
+		}
+		if hit, err := d.hit(bp, state); hit {
+			reason = "breakpoint"
+			if err != nil {
+				description = fmt.Sprintf("error in breakpoint condition: %s", err)
+				d.output("stderr", description+"\n")
+			}
+			break
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+	return d.stop(reason, description, state)
+}
+
+// stepReason returns the reason to pause at a statement executed at the specified call stack depth
+// or an empty string if the program should not be paused regardless of breakpoints
+func (d *debugger) stepReason(depth int) string {
+	switch {
+	case d.entry:
+		d.entry = false
+		return "entry"
+	case d.pause:
+		return "pause"
+	case d.mode == stepIn,
+		d.mode == stepOver && depth <= d.depth,
+		d.mode == stepOut && depth < d.depth:
+		return "step"
+	}
+	return ""
+}
+
+// hit evaluates the condition of bp. a condition that cannot be evaluated counts as hit,
+// so that the error can be inspected.
+func (d *debugger) hit(bp breakpoint, state *interpreter.DebugState) (bool, error) {
+	if bp.condition == "" {
+		return true, nil
+	}
+	val, err := state.Evaluate(0, bp.condition)
+	if err != nil {
+		return true, err
+	}
+	b, ok := val.(interpreter.Boolean)
+	if !ok {
+		return true, fmt.Errorf("expected boolean, found %s", val.RuntimeTypeName())
+	}
+	return bool(b), nil
+}
+
+// stop pauses the program until it is resumed or terminated
+func (d *debugger) stop(reason string, description string, state *interpreter.DebugState) error {
+	d.mu.Lock()
+	d.paused = state
+	d.pause = false
+	d.mu.Unlock()
+	d.stopped(reason, description)
+	<-d.resumed
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.terminated {
+		return errTerminated
+	}
+	return nil
+}
+
+// path returns the canonical path of the module file name
+func (d *debugger) path(name string) string {
+	path, ok := d.paths[name]
+	if !ok {
+		path = canonicalPath(name)
+		d.paths[name] = path
+	}
+	return path
+}
+
+// setBreakpoints replaces the breakpoints in the file at path
+func (d *debugger) setBreakpoints(path string, breakpoints []breakpoint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path = canonicalPath(path)
+	if len(breakpoints) == 0 {
+		delete(d.breakpoints, path)
+		return
+	}
+	d.breakpoints[path] = breakpoints
+}
+
+// state returns the state of the paused program or nil if the program is not paused
+func (d *debugger) state() *interpreter.DebugState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// resume continues the paused program. returns false if the program is not paused.
+func (d *debugger) resume(mode stepMode) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paused == nil {
+		return false
+	}
+	d.mode = mode
+	d.depth = d.paused.Depth()
+	d.paused = nil
+	d.resumed <- struct{}{}
+	return true
+}
+
+// stopOnEntry determines whether the program is paused before the first statement
+func (d *debugger) stopOnEntry(stop bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entry = stop
+}
+
+// requestPause pauses the running program at the next statement
+func (d *debugger) requestPause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pause = true
+}
+
+// terminate stops the program at the next statement or, if it is paused, immediately
+func (d *debugger) terminate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.terminated = true
+	if d.paused != nil {
+		d.paused = nil
+		d.resumed <- struct{}{}
+	}
+}
+
+func (d *debugger) isTerminated() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.terminated
+}
+
+// canonicalPath returns the absolute, clean form of path, so that paths sent by the client
+// can be compared to the module names of the program
+func canonicalPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
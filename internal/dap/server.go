@@ -0,0 +1,442 @@
+// Package dap implements a Debug Adapter Protocol server for ylang scripts, supporting
+// line and conditional breakpoints, stepping, inspection of variables, evaluation of
+// expressions in paused frames and viewing the target image.
+package dap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/smackem/ylang/internal/imageio"
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/parser"
+	"github.com/smackem/ylang/internal/program"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Bitmap is the surface a debugged program is executed against
+type Bitmap interface {
+	interpreter.BitmapContext
+	// Target returns the current target image
+	Target() image.Image
+}
+
+// Loader loads the image at path into a new Bitmap that passes the output of log statements to log
+type Loader func(path string, log func(message string)) (Bitmap, error)
+
+// threadID is the id of the only thread of a ylang program
+const threadID = 1
+
+// Server is a debug adapter communicating over a pair of streams like stdin and stdout.
+// Requests are handled one after another in the order they arrive, while the program
+// is executed on a separate goroutine.
+type Server struct {
+	in         *bufio.Reader
+	out        io.Writer
+	resolver   program.Resolver
+	load       Loader
+	mu         sync.Mutex // guards out and seq, which are also used by the program
+	seq        int
+	args       *LaunchArguments // nil until the launch request has been handled
+	prog       parser.Program
+	bitmap     Bitmap
+	debugger   *debugger
+	configured bool
+	started    bool
+	done       chan struct{}            // closed when the program has finished
+	refs       [][]interpreter.DebugVar // the variables referenced by the client while the program is paused, by reference - 1
+}
+
+// NewServer returns a Server that reads requests from in and writes responses and events to out.
+// Modules imported by the debugged program are loaded with resolver, its source image with load.
+func NewServer(in io.Reader, out io.Writer, resolver program.Resolver, load Loader) *Server {
+	s := &Server{
+		in:       bufio.NewReader(in),
+		out:      out,
+		resolver: resolver,
+		load:     load,
+		done:     make(chan struct{}),
+	}
+	s.debugger = newDebugger(s.stopped, s.output)
+	return s
+}
+
+// Serve handles requests until the client sends the disconnect request or closes the input stream.
+// A running program is terminated before Serve returns.
+func (s *Server) Serve() error {
+	defer s.stop()
+	for {
+		content, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("invalid message: %s", err)
+		}
+		body, err := s.handle(req)
+		resp := response{Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+		if err != nil {
+			resp.Message = err.Error()
+		}
+		if err := s.send(&resp); err != nil {
+			return err
+		}
+		if req.Command == "initialize" {
+			if err := s.sendEvent("initialized", nil); err != nil {
+				return err
+			}
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+		s.start()
+	}
+}
+
+func (s *Server) handle(req request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsConditionalBreakpoints:   true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+		}, nil
+
+	case "launch":
+		var args LaunchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(args)
+
+	case "configurationDone":
+		s.configured = true
+		return nil, nil
+
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		breakpoints := make([]breakpoint, len(args.Breakpoints))
+		body := SetBreakpointsResponseBody{Breakpoints: make([]Breakpoint, len(args.Breakpoints))}
+		for i, bp := range args.Breakpoints {
+			breakpoints[i] = breakpoint{line: bp.Line, condition: bp.Condition}
+			body.Breakpoints[i] = Breakpoint{Verified: true, Line: bp.Line, Source: args.Source}
+		}
+		s.debugger.setBreakpoints(args.Source.Path, breakpoints)
+		return body, nil
+
+	case "setExceptionBreakpoints":
+		return nil, nil
+
+	case "threads":
+		return ThreadsResponseBody{Threads: []Thread{{ID: threadID, Name: "main"}}}, nil
+
+	case "stackTrace":
+		state := s.debugger.state()
+		if state == nil {
+			return StackTraceResponseBody{StackFrames: []StackFrame{}}, nil
+		}
+		frames := state.Stack()
+		body := StackTraceResponseBody{StackFrames: make([]StackFrame, len(frames)), TotalFrames: len(frames)}
+		for i, frame := range frames {
+			body.StackFrames[i] = StackFrame{
+				ID:     i + 1,
+				Name:   frame.Name,
+				Source: s.source(frame.File),
+				Line:   frame.Line,
+				Column: frame.Col,
+			}
+		}
+		return body, nil
+
+	case "scopes":
+		var args ScopesArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		state := s.debugger.state()
+		if state == nil {
+			return nil, fmt.Errorf("the program is not paused")
+		}
+		scopes, err := state.Scopes(args.FrameID - 1)
+		if err != nil {
+			return nil, err
+		}
+		body := ScopesResponseBody{Scopes: []Scope{}}
+		for _, scope := range scopes {
+			if len(scope.Vars) > 0 {
+				body.Scopes = append(body.Scopes, Scope{Name: scope.Name, VariablesReference: s.reference(scope.Vars)})
+			}
+		}
+		return body, nil
+
+	case "variables":
+		var args VariablesArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if args.VariablesReference < 1 || args.VariablesReference > len(s.refs) {
+			return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+		}
+		vars := s.refs[args.VariablesReference-1]
+		body := VariablesResponseBody{Variables: make([]Variable, len(vars))}
+		for i, v := range vars {
+			body.Variables[i] = Variable{
+				Name:               v.Name,
+				Value:              formatValue(v.Value),
+				Type:               v.Value.RuntimeTypeName(),
+				VariablesReference: s.reference(interpreter.Members(v.Value)),
+			}
+		}
+		return body, nil
+
+	case "evaluate":
+		var args EvaluateArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		state := s.debugger.state()
+		if state == nil {
+			return nil, fmt.Errorf("expressions can only be evaluated while the program is paused")
+		}
+		frameIndex := 0
+		if args.FrameID > 0 {
+			frameIndex = args.FrameID - 1
+		}
+		val, err := state.Evaluate(frameIndex, args.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return EvaluateResponseBody{
+			Result:             formatValue(val),
+			Type:               val.RuntimeTypeName(),
+			VariablesReference: s.reference(interpreter.Members(val)),
+		}, nil
+
+	case "targetImage":
+		if s.debugger.state() == nil && !s.finished() {
+			return nil, fmt.Errorf("the target image can only be viewed while the program is paused or has finished")
+		}
+		img := s.bitmap.Target()
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		return TargetImageResponseBody{
+			MimeType: "image/png",
+			Data:     base64.StdEncoding.EncodeToString(buf.Bytes()),
+			Width:    img.Bounds().Dx(),
+			Height:   img.Bounds().Dy(),
+		}, nil
+
+	case "continue":
+		return ContinueResponseBody{AllThreadsContinued: true}, s.resume(runToBreakpoint)
+
+	case "next":
+		return nil, s.resume(stepOver)
+
+	case "stepIn":
+		return nil, s.resume(stepIn)
+
+	case "stepOut":
+		return nil, s.resume(stepOut)
+
+	case "pause":
+		s.debugger.requestPause()
+		return nil, nil
+
+	case "terminate", "disconnect":
+		s.stop()
+		return nil, nil
+	}
+	return nil, fmt.Errorf("command '%s' not supported", req.Command)
+}
+
+// launch compiles the program and loads the source image specified by args
+func (s *Server) launch(args LaunchArguments) error {
+	if s.args != nil {
+		return fmt.Errorf("a program has already been launched")
+	}
+	src, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return fmt.Errorf("error loading source code from '%s': %s", args.Program, err)
+	}
+	if s.prog, err = program.CompileModule(args.Program, string(src), s.resolver); err != nil {
+		return fmt.Errorf("compilation error: %s", describeError(err, args.Program))
+	}
+	if s.bitmap, err = s.load(args.Image, func(message string) { s.output("stdout", message+"\n") }); err != nil {
+		return fmt.Errorf("error loading image from '%s': %s", args.Image, err)
+	}
+	s.args = &args
+	s.debugger.stopOnEntry(args.StopOnEntry)
+	return nil
+}
+
+// start executes the program once it has been launched and configured
+func (s *Server) start() {
+	if s.started || s.args == nil || !s.configured {
+		return
+	}
+	s.started = true
+	go s.run()
+}
+
+// run executes the program and reports its end to the client
+func (s *Server) run() {
+	defer close(s.done)
+	var err error
+	if s.args.NoDebug {
+		err = program.Execute(context.Background(), s.prog, s.bitmap, interpreter.Options{})
+	} else {
+		err = interpreter.Debug(s.prog, s.bitmap, s.debugger)
+	}
+	exitCode := 0
+	if err != nil {
+		exitCode = 1
+		if !s.debugger.isTerminated() {
+			s.output("stderr", describeError(err, s.args.Program)+"\n")
+		}
+	} else if s.args.Out != "" {
+		if err := writeImage(s.bitmap.Target(), s.args.Out); err != nil {
+			s.output("stderr", err.Error()+"\n")
+			exitCode = 1
+		} else {
+			s.output("console", fmt.Sprintf("Saved image to '%s'\n", s.args.Out))
+		}
+	}
+	_ = s.sendEvent("exited", ExitedEventBody{ExitCode: exitCode})
+	_ = s.sendEvent("terminated", nil)
+}
+
+// resume continues the paused program with the specified step mode
+func (s *Server) resume(mode stepMode) error {
+	s.refs = nil
+	if !s.debugger.resume(mode) {
+		return fmt.Errorf("the program is not paused")
+	}
+	return nil
+}
+
+// stop terminates the program and waits until it has finished. a program executed without
+// debugging cannot be terminated and is abandoned.
+func (s *Server) stop() {
+	s.refs = nil
+	s.debugger.terminate()
+	if s.started && !s.args.NoDebug {
+		<-s.done
+	}
+}
+
+// finished returns true if the program has been executed to the end
+func (s *Server) finished() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// reference returns a reference the client can use to request vars or 0 if vars is empty
+func (s *Server) reference(vars []interpreter.DebugVar) int {
+	if len(vars) == 0 {
+		return 0
+	}
+	s.refs = append(s.refs, vars)
+	return len(s.refs)
+}
+
+// source returns the source of the module with the specified name
+func (s *Server) source(name string) *Source {
+	if name == "" {
+		return nil
+	}
+	return &Source{Name: filepath.Base(name), Path: canonicalPath(name)}
+}
+
+func (s *Server) stopped(reason string, description string) {
+	_ = s.sendEvent("stopped", StoppedEventBody{
+		Reason:            reason,
+		Description:       description,
+		ThreadID:          threadID,
+		AllThreadsStopped: true,
+	})
+}
+
+func (s *Server) output(category string, output string) {
+	_ = s.sendEvent("output", OutputEventBody{Category: category, Output: output})
+}
+
+func (s *Server) sendEvent(name string, body interface{}) error {
+	return s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// send assigns the next sequence number to msg, which is a *response or an *event, and writes it
+func (s *Server) send(msg interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+	return writeMessage(s.out, msg)
+}
+
+// formatValue formats a value for display in a single line
+func formatValue(val interpreter.Value) string {
+	if str, ok := val.(interpreter.Str); ok {
+		return strconv.Quote(string(str))
+	}
+	return val.PrintStr()
+}
+
+// describeError formats an error of the program including its traceback
+func describeError(err error, fileName string) string {
+	lerr, ok := err.(*lang.Error)
+	if !ok {
+		return err.Error()
+	}
+	e := *lerr
+	if e.File == "" {
+		e.File = fileName
+	}
+	if traceback := e.Traceback(); traceback != "" {
+		return traceback + "\n" + e.Error()
+	}
+	return e.Error()
+}
+
+// writeImage writes img to path in the format of its file extension
+func writeImage(img image.Image, path string) error {
+	format, err := imageio.FormatOf(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating file %s: %s", path, err)
+	}
+	defer file.Close()
+	return imageio.Encode(file, img, imageio.Options{Format: format})
+}
@@ -0,0 +1,344 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/program"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testBitmap is a Bitmap with a black source image
+type testBitmap struct {
+	interpreter.BitmapContext
+	target *image.NRGBA
+	log    func(string)
+}
+
+func newTestBitmap(path string, log func(string)) (Bitmap, error) {
+	return &testBitmap{target: image.NewNRGBA(image.Rect(0, 0, 4, 3)), log: log}, nil
+}
+
+func (b *testBitmap) GetPixel(x int, y int) lang.Color { return lang.NewRgba(0, 0, 0, 255) }
+func (b *testBitmap) SetPixel(x int, y int, c lang.Color) {
+	c = c.Clamp()
+	b.target.SetNRGBA(x, y, color.NRGBA{R: uint8(c.R), G: uint8(c.G), B: uint8(c.B), A: uint8(c.A)})
+}
+func (b *testBitmap) SourceWidth() int    { return 4 }
+func (b *testBitmap) SourceHeight() int   { return 3 }
+func (b *testBitmap) TargetWidth() int    { return 4 }
+func (b *testBitmap) TargetHeight() int   { return 3 }
+func (b *testBitmap) Log(message string)  { b.log(message) }
+func (b *testBitmap) Target() image.Image { return b.target }
+
+// testClient sends requests to a Server and records the events it receives
+type testClient struct {
+	t      *testing.T
+	w      io.Writer
+	msgs   chan testMessage // messages sent by the server, read on a goroutine of its own
+	seq    int
+	events []event // received events that have not been awaited yet
+}
+
+type testMessage struct {
+	Type       string          `json:"type"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Event      string          `json:"event"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// receive reads the messages sent by the server until r is closed
+func (c *testClient) receive(r *bufio.Reader) {
+	defer close(c.msgs)
+	for {
+		content, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		var msg testMessage
+		if err := json.Unmarshal(content, &msg); err != nil {
+			c.t.Errorf("invalid message %s: %s", content, err)
+			return
+		}
+		c.msgs <- msg
+	}
+}
+
+func (c *testClient) read() testMessage {
+	msg, ok := <-c.msgs
+	if !ok {
+		c.t.Fatalf("the server has closed the connection")
+	}
+	return msg
+}
+
+// request sends a request and decodes the body of the response into body, which may be nil.
+// returns the error message if the request failed.
+func (c *testClient) request(command string, args interface{}, body interface{}) string {
+	c.seq++
+	data, _ := json.Marshal(args)
+	if err := writeMessage(c.w, request{Seq: c.seq, Type: "request", Command: command, Arguments: data}); err != nil {
+		c.t.Fatal(err)
+	}
+	for {
+		msg := c.read()
+		if msg.Type == "event" {
+			c.events = append(c.events, event{Event: msg.Event, Body: msg.Body})
+			continue
+		}
+		if msg.RequestSeq != c.seq {
+			c.t.Fatalf("response to request %d, want %d", msg.RequestSeq, c.seq)
+		}
+		if !msg.Success {
+			return msg.Message
+		}
+		if body != nil {
+			if err := json.Unmarshal(msg.Body, body); err != nil {
+				c.t.Fatalf("invalid %s response %s: %s", command, msg.Body, err)
+			}
+		}
+		return ""
+	}
+}
+
+// await waits for the event with the specified name and decodes its body into body, which may be nil.
+// returns the names of the events received before.
+func (c *testClient) await(name string, body interface{}) []string {
+	var skipped []string
+	for {
+		var evt event
+		if len(c.events) > 0 {
+			evt, c.events = c.events[0], c.events[1:]
+		} else {
+			msg := c.read()
+			if msg.Type != "event" {
+				c.t.Fatalf("unexpected %s while waiting for event %s", msg.Type, name)
+			}
+			evt = event{Event: msg.Event, Body: msg.Body}
+		}
+		if evt.Event != name {
+			skipped = append(skipped, evt.Event)
+			continue
+		}
+		if body != nil {
+			if err := json.Unmarshal(evt.Body.(json.RawMessage), body); err != nil {
+				c.t.Fatal(err)
+			}
+		}
+		return skipped
+	}
+}
+
+// variables returns the variables with the specified reference as name=value pairs
+func (c *testClient) variables(ref int) map[string]string {
+	var body VariablesResponseBody
+	if msg := c.request("variables", VariablesArguments{VariablesReference: ref}, &body); msg != "" {
+		c.t.Fatal(msg)
+	}
+	vars := make(map[string]string)
+	for _, v := range body.Variables {
+		vars[v.Name] = v.Value
+	}
+	return vars
+}
+
+// stopped awaits the stopped event and returns its reason and the function and line of the innermost frame
+func (c *testClient) stopped() (string, string, int) {
+	var evt StoppedEventBody
+	c.await("stopped", &evt)
+	var trace StackTraceResponseBody
+	if msg := c.request("stackTrace", nil, &trace); msg != "" {
+		c.t.Fatal(msg)
+	}
+	return evt.Reason, trace.StackFrames[0].Name, trace.StackFrames[0].Line
+}
+
+func startTestServer(t *testing.T) (*testClient, func()) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- NewServer(inR, outW, program.FileResolver{}, newTestBitmap).Serve()
+		outW.Close()
+	}()
+	client := &testClient{t: t, w: inW, msgs: make(chan testMessage, 100)}
+	go client.receive(bufio.NewReader(outR))
+	return client, func() {
+		inW.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error = %s", err)
+		}
+	}
+}
+
+func writeScript(t *testing.T, src string) string {
+	dir, err := ioutil.TempDir("", "ylang-dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "main.ylang")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestServer_Serve(t *testing.T) {
+	path := writeScript(t, `total := 0
+add := fn(a, b) {
+    sum := a + b
+    return sum
+}
+for i in 0 .. 3 {
+    total = add(total, i)
+}
+@(1;1) = #ff0000
+log(total)
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+	client, stop := startTestServer(t)
+	defer stop()
+
+	var caps Capabilities
+	client.request("initialize", nil, &caps)
+	if !caps.SupportsConditionalBreakpoints {
+		t.Errorf("initialize: conditional breakpoints not supported")
+	}
+	client.await("initialized", nil)
+	if msg := client.request("launch", LaunchArguments{Program: path}, nil); msg != "" {
+		t.Fatal(msg)
+	}
+	var bps SetBreakpointsResponseBody
+	client.request("setBreakpoints", SetBreakpointsArguments{
+		Source:      Source{Path: path},
+		Breakpoints: []SourceBreakpoint{{Line: 7, Condition: "i == 2"}},
+	}, &bps)
+	if len(bps.Breakpoints) != 1 || !bps.Breakpoints[0].Verified {
+		t.Errorf("setBreakpoints = %+v, want a verified breakpoint", bps)
+	}
+	client.request("configurationDone", nil, nil)
+
+	if reason, name, line := client.stopped(); reason != "breakpoint" || name != "<script>" || line != 7 {
+		t.Fatalf("stopped at %s:%d because of %s, want <script>:7 because of breakpoint", name, line, reason)
+	}
+	var scopes ScopesResponseBody
+	client.request("scopes", ScopesArguments{FrameID: 1}, &scopes)
+	got := make(map[string]map[string]string)
+	for _, scope := range scopes.Scopes {
+		got[scope.Name] = client.variables(scope.VariablesReference)
+	}
+	want := map[string]map[string]string{
+		"Locals":  {"i": "2"},
+		"Globals": {"total": "1", "add": "fn(a, b)"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scopes = %v, want %v", got, want)
+	}
+	var result EvaluateResponseBody
+	if msg := client.request("evaluate", EvaluateArguments{Expression: "[total, i]", FrameID: 1}, &result); msg != "" {
+		t.Fatal(msg)
+	}
+	if elems := client.variables(result.VariablesReference); result.Result != "list(count: 2)" || !reflect.DeepEqual(elems, map[string]string{"[0]": "1", "[1]": "2"}) {
+		t.Errorf("evaluate = %s %v, want list(count: 2) [1 2]", result.Result, elems)
+	}
+	if msg := client.request("evaluate", EvaluateArguments{Expression: "sum", FrameID: 1}, nil); msg == "" {
+		t.Errorf("evaluate sum succeeded, want error")
+	}
+
+	client.request("stepIn", nil, nil)
+	if reason, name, line := client.stopped(); reason != "step" || name != "add" || line != 3 {
+		t.Errorf("stepIn: stopped at %s:%d because of %s, want add:3 because of step", name, line, reason)
+	}
+	client.request("next", nil, nil)
+	if _, name, line := client.stopped(); name != "add" || line != 4 {
+		t.Errorf("next: stopped at %s:%d, want add:4", name, line)
+	}
+	client.request("scopes", ScopesArguments{FrameID: 1}, &scopes)
+	if locals := client.variables(scopes.Scopes[0].VariablesReference); !reflect.DeepEqual(locals, map[string]string{"a": "1", "b": "2", "sum": "3"}) {
+		t.Errorf("locals of add = %v", locals)
+	}
+	client.request("stepOut", nil, nil)
+	if _, name, line := client.stopped(); name != "<script>" || line != 9 {
+		t.Errorf("stepOut: stopped at %s:%d, want <script>:9", name, line)
+	}
+	client.request("next", nil, nil)
+	client.stopped()
+	var img TargetImageResponseBody
+	if msg := client.request("targetImage", nil, &img); msg != "" || img.MimeType != "image/png" || img.Width != 4 || img.Height != 3 {
+		t.Errorf("targetImage = %s %dx%d %s", img.MimeType, img.Width, img.Height, msg)
+	}
+
+	client.request("continue", nil, nil)
+	var output OutputEventBody
+	client.await("output", &output)
+	if output.Output != "3\n" {
+		t.Errorf("output = %q, want 3", output.Output)
+	}
+	var exited ExitedEventBody
+	client.await("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("exit code = %d, want 0", exited.ExitCode)
+	}
+	client.await("terminated", nil)
+	client.request("disconnect", nil, nil)
+}
+
+func TestServer_Serve_terminate(t *testing.T) {
+	path := writeScript(t, `n := 0
+while true {
+    n = n + 1
+}
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+	client, stop := startTestServer(t)
+	defer stop()
+
+	client.request("initialize", nil, nil)
+	client.request("launch", LaunchArguments{Program: path, StopOnEntry: true}, nil)
+	client.request("configurationDone", nil, nil)
+	if reason, _, line := client.stopped(); reason != "entry" || line != 1 {
+		t.Errorf("stopped at line %d because of %s, want line 1 because of entry", line, reason)
+	}
+	client.request("continue", nil, nil)
+	client.request("pause", nil, nil)
+	if reason, _, line := client.stopped(); reason != "pause" || line != 3 {
+		t.Errorf("stopped at line %d because of %s, want line 3 because of pause", line, reason)
+	}
+	var result EvaluateResponseBody
+	client.request("evaluate", EvaluateArguments{Expression: "n > 0", FrameID: 1}, &result)
+	if result.Result != "true" {
+		t.Errorf("evaluate n > 0 = %s, want true", result.Result)
+	}
+	client.request("terminate", nil, nil)
+	var exited ExitedEventBody
+	if skipped := client.await("exited", &exited); len(skipped) > 0 || exited.ExitCode != 1 {
+		t.Errorf("exit code = %d after %v, want 1 without output", exited.ExitCode, skipped)
+	}
+	client.request("disconnect", nil, nil)
+}
+
+func TestServer_Serve_launchError(t *testing.T) {
+	path := writeScript(t, "x := y\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	client, stop := startTestServer(t)
+	defer stop()
+
+	client.request("initialize", nil, nil)
+	if msg := client.request("launch", LaunchArguments{Program: path}, nil); msg == "" {
+		t.Errorf("launch succeeded, want compilation error")
+	}
+	if msg := client.request("continue", nil, nil); msg == "" {
+		t.Errorf("continue succeeded, want error")
+	}
+	client.request("disconnect", nil, nil)
+}
@@ -0,0 +1,48 @@
+package emitter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+)
+
+// FormatVersion is the version of the bytecode format written by Encode.
+// It must be incremented whenever the instruction set or its semantics change.
+const FormatVersion = 4
+
+// Encode serializes the bytecode of a program into the content of a compiled ylang file.
+// src is the source code of the main script the program has been compiled from.
+func Encode(code Program, src string) ([]byte, error) {
+	return proto.Marshal(&CompiledProgram{
+		FormatVersion: FormatVersion,
+		SourceHash:    SourceHash(src),
+		Program:       &code,
+	})
+}
+
+// Decode deserializes the content of a compiled ylang file written by Encode.
+func Decode(data []byte) (*CompiledProgram, error) {
+	compiled := &CompiledProgram{}
+	if err := proto.Unmarshal(data, compiled); err != nil {
+		return nil, fmt.Errorf("error decoding compiled program: %s", err)
+	}
+	if compiled.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("unsupported format version %d of compiled program, expected %d", compiled.FormatVersion, FormatVersion)
+	}
+	if compiled.Program == nil {
+		return nil, fmt.Errorf("compiled program is empty")
+	}
+	return compiled, nil
+}
+
+// SourceHash returns the hash of the source code stored in compiled files
+func SourceHash(src string) []byte {
+	hash := sha256.Sum256([]byte(src))
+	return hash[:]
+}
+
+// IsCompiledFrom returns true if the compiled program has been compiled from src
+func (m *CompiledProgram) IsCompiledFrom(src string) bool {
+	return bytes.Equal(m.SourceHash, SourceHash(src))
+}
@@ -0,0 +1,63 @@
+package emitter
+
+import (
+	"github.com/golang/protobuf/proto"
+	"strings"
+	"testing"
+)
+
+func Test_EncodeDecode(t *testing.T) {
+	src := "f := fn(x) -> x * 2 log(f(21))"
+	code := Emit(compile(t, src))
+	data, err := Encode(code, src)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	compiled, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !proto.Equal(compiled.Program, &code) {
+		t.Errorf("Decode() program = %v, want %v", compiled.Program, &code)
+	}
+	if !compiled.IsCompiledFrom(src) {
+		t.Errorf("IsCompiledFrom(src) = false, want true")
+	}
+	if compiled.IsCompiledFrom(src + " ") {
+		t.Errorf("IsCompiledFrom(modified src) = true, want false")
+	}
+}
+
+func Test_Decode_errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		compiled *CompiledProgram
+		want     string
+	}{
+		{
+			name:     "format_version",
+			compiled: &CompiledProgram{FormatVersion: FormatVersion + 1, Program: &Program{}},
+			want:     "unsupported format version",
+		},
+		{
+			name:     "empty",
+			compiled: &CompiledProgram{FormatVersion: FormatVersion},
+			want:     "compiled program is empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := proto.Marshal(tt.compiled)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Decode(data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Decode() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+	if _, err := Decode([]byte("not a compiled program")); err == nil {
+		t.Errorf("Decode(garbage) error = nil, want error")
+	}
+}
@@ -1,9 +1,485 @@
 package emitter
 
 import (
+	"github.com/smackem/ylang/internal/lexer"
 	"github.com/smackem/ylang/internal/parser"
 )
 
+// Emit compiles the program and all modules it imports into bytecode
+// that can be executed by interpreter.Run. The program and the modules must
+// have been resolved with parser.Resolve.
 func Emit(program parser.Program) Program {
-	return Program{}
+	prog := emitModule(program)
+	if len(program.Modules) > 0 {
+		prog.Modules = make(map[string]*Program)
+		for name, module := range program.Modules {
+			prog.Modules[name] = emitModule(module)
+		}
+	}
+	return *prog
+}
+
+func emitModule(program parser.Program) *Program {
+	e := emitter{}
+	e.visitStmtList(program.Stmts)
+	e.emit(OpCode_END)
+	// function bodies are placed behind the code of the module
+	for len(e.pending) > 0 {
+		fn := e.pending[0]
+		e.pending = e.pending[1:]
+		e.functions[fn.index].Address = int32(len(e.code))
+		e.loops = nil
+		e.visitStmtList(fn.body)
+		e.emit(OpCode_END)
+	}
+	params := make([]string, len(program.Params))
+	for i, decl := range program.Params {
+		params[i] = decl.Ident
+	}
+	return &Program{
+		Instructions: e.code,
+		Functions:    e.functions,
+		File:         program.File,
+		ImportNames:  program.ImportNames,
+		FrameSize:    int32(program.Frame.Size),
+		CellCount:    int32(program.Frame.Cells),
+		Globals:      program.Globals,
+		Params:       params,
+	}
+}
+
+type emitter struct {
+	code      []*Instruction
+	functions []*Function
+	pending   []pendingFunction // the functions whose bodies have not been emitted yet
+	loops     []loop            // the enclosing loops of the current statement, innermost last
+	tok       lexer.Token       // the position of the node being emitted
+}
+
+type pendingFunction struct {
+	index int
+	body  []parser.Statement
+}
+
+type loop struct {
+	forIn     bool  // FOR_IN loops execute their body as a block, so break needs a BREAK instruction
+	breaks    []int // the branch instructions to patch with the end of the loop
+	continues []int // the branch instructions to patch with the continuation of the loop
+}
+
+func (e *emitter) emit(opcode OpCode) *Instruction {
+	instr := &Instruction{
+		Opcode: opcode,
+		Line:   int32(e.tok.LineNumber),
+		Column: int32(e.tok.Column),
+	}
+	e.code = append(e.code, instr)
+	return instr
+}
+
+func (e *emitter) emitInt(opcode OpCode, n int) int {
+	e.emit(opcode).Integer = int32(n)
+	return len(e.code) - 1
+}
+
+func (e *emitter) emitStr(opcode OpCode, s string) {
+	e.emit(opcode).Arg = &Instruction_Str{Str: s}
+}
+
+func (e *emitter) emitIntStr(opcode OpCode, n int, s string) int {
+	instr := e.emit(opcode)
+	instr.Integer = int32(n)
+	instr.Arg = &Instruction_Str{Str: s}
+	return len(e.code) - 1
+}
+
+// patch sets the target of the branch instruction at index to the next instruction
+func (e *emitter) patch(index int) {
+	e.code[index].Integer = int32(len(e.code))
+}
+
+var loadOpCodes = map[parser.VarKind]OpCode{
+	parser.LocalVar:    OpCode_LOAD,
+	parser.CellVar:     OpCode_LOAD_CELL,
+	parser.CaptureVar:  OpCode_LOAD_CAPTURE,
+	parser.GlobalVar:   OpCode_LOAD_GLOBAL,
+	parser.ConstantVar: OpCode_LOAD_CONST,
+}
+
+var storeOpCodes = map[parser.VarKind]OpCode{
+	parser.LocalVar:    OpCode_STORE,
+	parser.CellVar:     OpCode_STORE_CELL,
+	parser.CaptureVar:  OpCode_STORE_CAPTURE,
+	parser.GlobalVar:   OpCode_STORE_GLOBAL,
+	parser.ConstantVar: OpCode_STORE_CONST,
+}
+
+// load pushes the value of the variable v named ident
+func (e *emitter) load(v *parser.Var, ident string) {
+	e.emitIntStr(loadOpCodes[v.Kind], v.Index, ident)
+}
+
+// store pops a value and assigns it to the variable v named ident
+func (e *emitter) store(v *parser.Var, ident string) {
+	e.emitIntStr(storeOpCodes[v.Kind], v.Index, ident)
+}
+
+// declare pops a value and initializes the variable v named ident with it
+func (e *emitter) declare(v *parser.Var, ident string) {
+	if v.Kind == parser.CellVar {
+		e.emitInt(OpCode_NEW_CELL, v.Index)
+	}
+	e.store(v, ident)
+}
+
+func newVariables(vars []*parser.Var) []*Variable {
+	var result []*Variable
+	for _, v := range vars {
+		result = append(result, &Variable{Kind: int32(v.Kind), Index: int32(v.Index)})
+	}
+	return result
+}
+
+func (e *emitter) visitStmtList(stmts []parser.Statement) {
+	for _, stmt := range stmts {
+		e.visitStmt(stmt)
+	}
+}
+
+func (e *emitter) visitStmt(stmt parser.Statement) {
+	outerTok := e.tok
+	e.tok = stmt.Token()
+	defer func() { e.tok = outerTok }()
+
+	switch s := stmt.(type) {
+	case parser.DeclStmt:
+		if s.Var.Kind == parser.CellVar {
+			e.emitInt(OpCode_NEW_CELL, s.Var.Index) // the function declared by s may capture itself
+		}
+		e.visitExpr(s.Rhs)
+		if s.Param {
+			e.emitStr(OpCode_PARAM, s.Ident)
+		}
+		e.store(s.Var, s.Ident)
+
+	case parser.AssignStmt:
+		e.visitExpr(s.Rhs)
+		e.store(s.Var, s.Ident)
+
+	case parser.IndexedAssignStmt:
+		e.load(s.Var, s.Ident)
+		e.visitExpr(s.Index)
+		e.visitExpr(s.Rhs)
+		e.emit(OpCode_STORE_AT)
+
+	case parser.PixelAssignStmt:
+		e.visitExpr(s.Lhs)
+		e.visitExpr(s.Rhs)
+		e.emit(OpCode_SET_PIXEL)
+
+	case parser.InvocationStmt:
+		e.visitExpr(s.Invocation)
+		e.emit(OpCode_POP)
+
+	case parser.IfStmt:
+		e.visitExpr(s.Cond)
+		brFalse := e.emitIntStr(OpCode_BR_FALSE, 0, "type mismatch: expected if(boolean)")
+		e.visitStmtList(s.TrueStmts)
+		if s.FalseStmts == nil {
+			e.patch(brFalse)
+			break
+		}
+		br := e.emitInt(OpCode_BR, 0)
+		e.patch(brFalse)
+		e.visitStmtList(s.FalseStmts)
+		e.patch(br)
+
+	case parser.ParallelForStmt:
+		// the virtual machine executes parallel loops serially like a single worker of the interpreter.
+		// the loop body has been checked by interpreter.CheckParallelLoops, so both engines reject the same loops.
+		e.visitExpr(s.Collection)
+		merges := make([]int, len(s.Reductions))
+		for i, r := range s.Reductions {
+			e.load(r.Var, r.Ident)
+			if ident, ok := r.Merge.(parser.IdentExpr); ok && ident.Var == nil { // builtin function
+				merges[i] = 2
+			} else if r.Merge != nil {
+				e.visitExpr(r.Merge)
+				merges[i] = 1
+			}
+			e.emitIntStr(OpCode_REDUCE_BEGIN, merges[i], r.Ident)
+			e.store(r.Var, r.Ident)
+		}
+		e.visitForIn(s.ForStmt)
+		for i := len(s.Reductions) - 1; i >= 0; i-- {
+			r := s.Reductions[i]
+			e.load(r.Var, r.Ident)
+			e.emitIntStr(OpCode_REDUCE_END, merges[i], r.Ident)
+			e.store(r.Var, r.Ident)
+		}
+
+	case parser.ForStmt:
+		e.visitExpr(s.Collection)
+		e.visitForIn(s)
+
+	case parser.ForRangeStmt:
+		e.visitExpr(s.Lower)
+		e.visitExpr(s.Upper)
+		e.visitExpr(s.Step)
+		e.emitStr(OpCode_RANGE_INIT, s.Ident)
+		next := len(e.code)
+		rangeNext := e.emitInt(OpCode_RANGE_NEXT, 0)
+		e.declare(s.Var, s.Ident)
+		l := e.visitLoopBody(s.Stmts, false)
+		for _, index := range l.continues {
+			e.patch(index)
+		}
+		e.emit(OpCode_RANGE_STEP)
+		e.emitInt(OpCode_BR, next)
+		e.patch(rangeNext)
+		for _, index := range l.breaks {
+			e.patch(index)
+		}
+		e.emit(OpCode_RANGE_END)
+
+	case parser.WhileStmt:
+		cond := len(e.code)
+		e.visitExpr(s.Cond)
+		brFalse := e.emitIntStr(OpCode_BR_FALSE, 0, "type mismatch: expected while(boolean)")
+		l := e.visitLoopBody(s.Stmts, false)
+		e.emitInt(OpCode_BR, cond)
+		for _, index := range l.continues {
+			e.code[index].Integer = int32(cond)
+		}
+		e.patch(brFalse)
+		for _, index := range l.breaks {
+			e.patch(index)
+		}
+
+	case parser.YieldStmt:
+		e.visitExpr(s.Result)
+		e.emit(OpCode_YIELD)
+
+	case parser.LogStmt:
+		for _, arg := range s.Args {
+			e.visitExpr(arg)
+		}
+		e.emitInt(OpCode_LOG, len(s.Args))
+
+	case parser.ReturnStmt:
+		e.visitExpr(s.Result)
+		e.emit(OpCode_RET)
+
+	case parser.BreakStmt:
+		l := &e.loops[len(e.loops)-1]
+		if l.forIn {
+			e.emit(OpCode_BREAK)
+			break
+		}
+		l.breaks = append(l.breaks, e.emitInt(OpCode_BR, 0))
+
+	case parser.ContinueStmt:
+		l := &e.loops[len(e.loops)-1]
+		l.continues = append(l.continues, e.emitInt(OpCode_BR, 0))
+	}
+}
+
+// visitForIn emits the loop s over the collection on top of the stack
+func (e *emitter) visitForIn(s parser.ForStmt) {
+	forIn := e.emitIntStr(OpCode_FOR_IN, 0, s.Ident)
+	e.declare(s.Var, s.Ident)
+	l := e.visitLoopBody(s.Stmts, true)
+	for _, index := range l.continues {
+		e.patch(index)
+	}
+	e.emit(OpCode_END)
+	e.patch(forIn)
+}
+
+// visitLoopBody emits the statements of a loop body and returns the
+// branch instructions emitted for break and continue statements.
+func (e *emitter) visitLoopBody(stmts []parser.Statement, forIn bool) loop {
+	e.loops = append(e.loops, loop{forIn: forIn})
+	e.visitStmtList(stmts)
+	l := e.loops[len(e.loops)-1]
+	e.loops = e.loops[:len(e.loops)-1]
+	return l
+}
+
+func (e *emitter) visitBinaryExpr(opcode OpCode, left parser.Expression, right parser.Expression) {
+	e.visitExpr(left)
+	e.visitExpr(right)
+	e.emit(opcode)
+}
+
+func (e *emitter) visitExprList(exprs []parser.Expression) {
+	for _, expr := range exprs {
+		e.visitExpr(expr)
+	}
+}
+
+func (e *emitter) visitExpr(expr parser.Expression) {
+	outerTok := e.tok
+	e.tok = expr.Token()
+	defer func() { e.tok = outerTok }()
+
+	switch ex := expr.(type) {
+	case parser.TernaryExpr:
+		e.visitExpr(ex.Cond)
+		brFalse := e.emitIntStr(OpCode_BR_FALSE, 0, "type mismatch: expected bool?x:x")
+		e.visitExpr(ex.TrueResult)
+		br := e.emitInt(OpCode_BR, 0)
+		e.patch(brFalse)
+		e.visitExpr(ex.FalseResult)
+		e.patch(br)
+
+	case parser.OrExpr:
+		e.visitExpr(ex.Left)
+		or := e.emitInt(OpCode_OR, 0)
+		e.visitExpr(ex.Right)
+		e.emit(OpCode_BOOL)
+		e.patch(or)
+
+	case parser.AndExpr:
+		e.visitExpr(ex.Left)
+		and := e.emitInt(OpCode_AND, 0)
+		e.visitExpr(ex.Right)
+		e.emit(OpCode_BOOL)
+		e.patch(and)
+
+	case parser.EqExpr:
+		e.visitBinaryExpr(OpCode_EQ, ex.Left, ex.Right)
+	case parser.NeqExpr:
+		e.visitBinaryExpr(OpCode_NEQ, ex.Left, ex.Right)
+	case parser.GtExpr:
+		e.visitBinaryExpr(OpCode_GT, ex.Left, ex.Right)
+	case parser.GeExpr:
+		e.visitBinaryExpr(OpCode_GE, ex.Left, ex.Right)
+	case parser.LtExpr:
+		e.visitBinaryExpr(OpCode_LT, ex.Left, ex.Right)
+	case parser.LeExpr:
+		e.visitBinaryExpr(OpCode_LE, ex.Left, ex.Right)
+	case parser.ConcatExpr:
+		e.visitBinaryExpr(OpCode_CONCAT, ex.Left, ex.Right)
+	case parser.AddExpr:
+		e.visitBinaryExpr(OpCode_ADD, ex.Left, ex.Right)
+	case parser.SubExpr:
+		e.visitBinaryExpr(OpCode_SUB, ex.Left, ex.Right)
+	case parser.MulExpr:
+		e.visitBinaryExpr(OpCode_MUL, ex.Left, ex.Right)
+	case parser.DivExpr:
+		e.visitBinaryExpr(OpCode_DIV, ex.Left, ex.Right)
+	case parser.ModExpr:
+		e.visitBinaryExpr(OpCode_MOD, ex.Left, ex.Right)
+	case parser.InExpr:
+		e.visitBinaryExpr(OpCode_IN, ex.Left, ex.Right)
+	case parser.PosExpr:
+		e.visitBinaryExpr(OpCode_MK_POINT, ex.X, ex.Y)
+
+	case parser.NegExpr:
+		e.visitExpr(ex.Inner)
+		e.emit(OpCode_NEG)
+
+	case parser.NotExpr:
+		e.visitExpr(ex.Inner)
+		e.emit(OpCode_NOT)
+
+	case parser.AtExpr:
+		e.visitExpr(ex.Inner)
+		e.emit(OpCode_GET_PIXEL)
+
+	case parser.MemberExpr:
+		e.visitExpr(ex.Recvr)
+		e.emitStr(OpCode_MEMBER, ex.Member)
+
+	case parser.IndexExpr:
+		e.visitBinaryExpr(OpCode_INDEX, ex.Recvr, ex.Index)
+
+	case parser.SampleExpr:
+		e.visitBinaryExpr(OpCode_SAMPLE, ex.Image, ex.Point)
+
+	case parser.IndexRangeExpr:
+		e.visitExpr(ex.Recvr)
+		e.visitExpr(ex.Lower)
+		e.visitExpr(ex.Upper)
+		e.emit(OpCode_INDEX_RANGE)
+
+	case parser.StrExpr:
+		e.emit(OpCode_PUSH).Arg = &Instruction_Str{Str: string(ex.Value)}
+
+	case parser.BoolExpr:
+		e.emit(OpCode_PUSH).Arg = &Instruction_Boolean{Boolean: bool(ex.Value)}
+
+	case parser.NumberExpr:
+		e.emit(OpCode_PUSH).Arg = &Instruction_Float{Float: float32(ex.Value)}
+
+	case parser.ColorExpr:
+		c := ex.Value
+		rgba := uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+		e.emit(OpCode_PUSH).Arg = &Instruction_Color{Color: rgba}
+
+	case parser.NilExpr:
+		e.emit(OpCode_PUSH)
+
+	case parser.IdentExpr:
+		e.load(ex.Var, ex.Ident)
+
+	case parser.InvokeExpr:
+		if ex.Var != nil {
+			e.load(ex.Var, ex.FuncName)
+			e.visitExprList(ex.Args)
+			e.emitIntStr(OpCode_CALL_MEMBER, len(ex.Args), ex.FuncName)
+			break
+		}
+		e.visitExprList(ex.Args)
+		e.emitIntStr(OpCode_CALL, len(ex.Args), ex.FuncName)
+
+	case parser.CallExpr:
+		name := "<fn>"
+		switch c := ex.Callee.(type) {
+		case parser.MemberExpr:
+			name = c.Member
+		case parser.IdentExpr:
+			name = c.Ident
+		}
+		e.visitExpr(ex.Callee)
+		e.visitExprList(ex.Args)
+		e.emitIntStr(OpCode_CALL_MEMBER, len(ex.Args), name)
+
+	case parser.ImportExpr:
+		e.emitStr(OpCode_IMPORT, ex.Path)
+
+	case parser.KernelExpr:
+		e.visitExprList(ex.Elements)
+		e.emitInt(OpCode_MK_KERNEL, len(ex.Elements))
+
+	case parser.FunctionExpr:
+		index := len(e.functions)
+		e.functions = append(e.functions, &Function{
+			ParameterNames: ex.ParameterNames,
+			IsGenerator:    ex.IsGenerator,
+			FrameSize:      int32(ex.Frame.Size),
+			CellCount:      int32(ex.Frame.Cells),
+			Params:         newVariables(ex.Frame.Params),
+			Captures:       newVariables(ex.Frame.Captures),
+		})
+		e.pending = append(e.pending, pendingFunction{index: index, body: ex.Body})
+		e.emitInt(OpCode_MK_FUNCTION, index)
+
+	case parser.HashMapExpr:
+		for _, entry := range ex.Entries {
+			e.visitExpr(entry.Key)
+			e.visitExpr(entry.Value)
+		}
+		e.emitInt(OpCode_MK_HASHMAP, len(ex.Entries))
+
+	case parser.ListExpr:
+		e.visitExprList(ex.Elements)
+		e.emitInt(OpCode_MK_LIST, len(ex.Elements))
+
+	case parser.PipelineExpr:
+		e.visitExpr(ex.Left)
+		e.declare(ex.Var, lexer.TokenTypeName(lexer.TTDollar))
+		e.visitExpr(ex.Right)
+	}
 }
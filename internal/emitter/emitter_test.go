@@ -0,0 +1,97 @@
+package emitter
+
+import (
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"testing"
+)
+
+// compile parses and resolves src, which must not refer to constants or builtin functions
+func compile(t *testing.T, src string) parser.Program {
+	tokens, err := lexer.Lex(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := parser.Parse(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err = parser.Resolve(program, nil, func(string) bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+func Test_Emit(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []OpCode
+	}{
+		{
+			name: "decl",
+			src:  "x := 1 + 2",
+			want: []OpCode{OpCode_PUSH, OpCode_PUSH, OpCode_ADD, OpCode_STORE_GLOBAL, OpCode_END},
+		},
+		{
+			name: "if_without_decl",
+			src:  "x := 1 if x > 0 { x = 2 }",
+			want: []OpCode{
+				OpCode_PUSH, OpCode_STORE_GLOBAL,
+				OpCode_LOAD_GLOBAL, OpCode_PUSH, OpCode_GT, OpCode_BR_FALSE,
+				OpCode_PUSH, OpCode_STORE_GLOBAL,
+				OpCode_END,
+			},
+		},
+		{
+			name: "for_in_with_break",
+			src:  "for x in [1] { if x > 0 { y := 1 break } }",
+			want: []OpCode{
+				OpCode_PUSH, OpCode_MK_LIST, OpCode_FOR_IN,
+				OpCode_STORE, OpCode_LOAD, OpCode_PUSH, OpCode_GT, OpCode_BR_FALSE,
+				OpCode_PUSH, OpCode_STORE, OpCode_BREAK,
+				OpCode_END,
+				OpCode_END,
+			},
+		},
+		{
+			name: "function",
+			src:  "f := fn(x) -> x",
+			want: []OpCode{OpCode_MK_FUNCTION, OpCode_STORE_GLOBAL, OpCode_END, OpCode_LOAD, OpCode_RET, OpCode_END},
+		},
+		{
+			name: "closure",
+			src:  "f := fn() { n := 0 return fn() -> n }",
+			want: []OpCode{
+				OpCode_MK_FUNCTION, OpCode_STORE_GLOBAL, OpCode_END,
+				OpCode_NEW_CELL, OpCode_PUSH, OpCode_STORE_CELL, OpCode_MK_FUNCTION, OpCode_RET, OpCode_END,
+				OpCode_LOAD_CAPTURE, OpCode_RET, OpCode_END,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := Emit(compile(t, tt.src))
+			var got []OpCode
+			for _, instr := range code.Instructions {
+				got = append(got, instr.Opcode)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Emit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Emit_branchTargets(t *testing.T) {
+	code := Emit(compile(t, "i := 0 while i < 3 { i = i + 1 }"))
+	// 0 PUSH, 1 STORE_GLOBAL, 2 LOAD_GLOBAL, 3 PUSH, 4 LT, 5 BR_FALSE, 6 LOAD_GLOBAL, 7 PUSH, 8 ADD, 9 STORE_GLOBAL, 10 BR, 11 END
+	if brFalse := code.Instructions[5]; brFalse.Opcode != OpCode_BR_FALSE || brFalse.Integer != 11 {
+		t.Errorf("instruction 5 = %v, want BR_FALSE 11", brFalse)
+	}
+	if br := code.Instructions[10]; br.Opcode != OpCode_BR || br.Integer != 2 {
+		t.Errorf("instruction 10 = %v, want BR 2", br)
+	}
+}
@@ -2,7 +2,6 @@ package emitter
 
 import (
 	"fmt"
-	"github.com/smackem/ylang/internal/lang"
 	"github.com/smackem/ylang/internal/parser"
 	"strings"
 )
@@ -85,6 +84,9 @@ func (js *jsemitter) visitStmt(stmt parser.Statement) {
 			js.print("}")
 		}
 
+	case parser.ParallelForStmt:
+		js.visitStmt(s.ForStmt)
+
 	case parser.ForStmt:
 		js.printf("for (var %s of %s.iter()) {", s.Ident, js.visitExpr(s.Collection))
 		js.println()
@@ -107,7 +109,7 @@ func (js *jsemitter) visitStmt(stmt parser.Statement) {
 		js.print("}")
 
 	case parser.YieldStmt:
-		js.print("UNSUPPORTED_STMT")
+		js.printf("yield %s;", js.visitExpr(s.Result))
 
 	case parser.LogStmt:
 		js.print("console.log(")
@@ -121,6 +123,12 @@ func (js *jsemitter) visitStmt(stmt parser.Statement) {
 
 	case parser.ReturnStmt:
 		js.printf("return %s;", js.visitExpr(s.Result))
+
+	case parser.BreakStmt:
+		js.print("break;")
+
+	case parser.ContinueStmt:
+		js.print("continue;")
 	}
 
 	js.println()
@@ -191,30 +199,33 @@ func (js *jsemitter) visitExpr(expr parser.Expression) string {
 	case parser.IndexExpr:
 		return fmt.Sprintf("(%s).at(%s)", js.visitExpr(e.Recvr), js.visitExpr(e.Index))
 
+	case parser.SampleExpr:
+		return fmt.Sprintf("(%s).at(%s)", js.visitExpr(e.Image), js.visitExpr(e.Point))
+
 	case parser.IndexRangeExpr:
 		return fmt.Sprintf("(%s).slice(%s, %s)", js.visitExpr(e.Recvr), js.visitExpr(e.Lower), js.visitExpr(e.Upper))
 
-	case lang.Str:
-		return fmt.Sprintf("\"%s\"", e)
+	case parser.StrExpr:
+		return fmt.Sprintf("\"%s\"", e.Value)
 
-	case lang.Boolean:
-		if e {
+	case parser.BoolExpr:
+		if e.Value {
 			return "true"
 		} else {
 			return "false"
 		}
 
-	case lang.Number:
-		return fmt.Sprintf("%f", e)
+	case parser.NumberExpr:
+		return fmt.Sprintf("%f", e.Value)
 
-	case lang.Color:
-		return fmt.Sprintf("new Color(%d, %d, %d, %d)", int(e.R), int(e.G), int(e.B), int(e.A))
+	case parser.ColorExpr:
+		return fmt.Sprintf("new Color(%d, %d, %d, %d)", int(e.Value.R), int(e.Value.G), int(e.Value.B), int(e.Value.A))
 
-	case lang.Nil:
+	case parser.NilExpr:
 		return "null"
 
 	case parser.IdentExpr:
-		return string(e)
+		return e.Ident
 
 	case parser.AtExpr:
 		return fmt.Sprintf("surface.getPixel(%s)", js.visitExpr(e.Inner))
@@ -222,6 +233,9 @@ func (js *jsemitter) visitExpr(expr parser.Expression) string {
 	case parser.InvokeExpr:
 		return fmt.Sprintf("%s(%s)", e.FuncName, js.joinArgs(e.Args))
 
+	case parser.CallExpr:
+		return fmt.Sprintf("(%s)(%s)", js.visitExpr(e.Callee), js.joinArgs(e.Args))
+
 	case parser.KernelExpr:
 		return fmt.Sprintf("new Kernel(%s)", js.joinArgs(e.Elements))
 
@@ -229,6 +243,9 @@ func (js *jsemitter) visitExpr(expr parser.Expression) string {
 		params := strings.Join(e.ParameterNames, ",")
 		js.pushBuffer()
 		js.visitStmtList(e.Body)
+		if e.IsGenerator {
+			return fmt.Sprintf("function*(%s) {\n%s}", params, js.popBuffer())
+		}
 		return fmt.Sprintf("function(%s) {\n%s}", params, js.popBuffer())
 
 	case parser.HashMapExpr:
@@ -20,53 +20,81 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+// the operands of an instruction are the values on top of the evaluation stack.
+// instructions that refer to a code address use the integer argument,
+// instructions that refer to a variable use the integer argument as index and the str argument as name.
 type OpCode int32
 
 const (
-	OpCode_NOP         OpCode = 0
-	OpCode_PUSH        OpCode = 1
-	OpCode_POP         OpCode = 2
-	OpCode_LOCAL       OpCode = 3
-	OpCode_LOAD        OpCode = 4
-	OpCode_STORE       OpCode = 5
-	OpCode_STORE_AT    OpCode = 6
-	OpCode_SET_PIXEL   OpCode = 7
-	OpCode_CALL        OpCode = 8
-	OpCode_BR          OpCode = 9
-	OpCode_BR_FALSE    OpCode = 10
-	OpCode_LOG         OpCode = 11
-	OpCode_RET         OpCode = 12
-	OpCode_OR          OpCode = 13
-	OpCode_AND         OpCode = 14
-	OpCode_EQ          OpCode = 15
-	OpCode_GT          OpCode = 16
-	OpCode_GE          OpCode = 17
-	OpCode_LT          OpCode = 18
-	OpCode_LE          OpCode = 19
-	OpCode_CONCAT      OpCode = 20
-	OpCode_ADD         OpCode = 21
-	OpCode_SUB         OpCode = 22
-	OpCode_MUL         OpCode = 23
-	OpCode_DIV         OpCode = 24
-	OpCode_MOD         OpCode = 25
-	OpCode_IN          OpCode = 26
-	OpCode_NEG         OpCode = 27
-	OpCode_NOT         OpCode = 28
-	OpCode_MK_POINT    OpCode = 29
-	OpCode_CALL_MEMBER OpCode = 30
-	OpCode_INDEX       OpCode = 31
-	OpCode_INDEX_RANGE OpCode = 32
-	OpCode_GET_PIXEL   OpCode = 33
-	OpCode_MK_KERNEL   OpCode = 34
-	OpCode_MK_HASHMAP  OpCode = 35
-	OpCode_MK_LIST     OpCode = 36
+	OpCode_NOP           OpCode = 0
+	OpCode_PUSH          OpCode = 1
+	OpCode_POP           OpCode = 2
+	OpCode_NEW_CELL      OpCode = 3
+	OpCode_LOAD          OpCode = 4
+	OpCode_STORE         OpCode = 5
+	OpCode_STORE_AT      OpCode = 6
+	OpCode_SET_PIXEL     OpCode = 7
+	OpCode_CALL          OpCode = 8
+	OpCode_BR            OpCode = 9
+	OpCode_BR_FALSE      OpCode = 10
+	OpCode_LOG           OpCode = 11
+	OpCode_RET           OpCode = 12
+	OpCode_OR            OpCode = 13
+	OpCode_AND           OpCode = 14
+	OpCode_EQ            OpCode = 15
+	OpCode_GT            OpCode = 16
+	OpCode_GE            OpCode = 17
+	OpCode_LT            OpCode = 18
+	OpCode_LE            OpCode = 19
+	OpCode_CONCAT        OpCode = 20
+	OpCode_ADD           OpCode = 21
+	OpCode_SUB           OpCode = 22
+	OpCode_MUL           OpCode = 23
+	OpCode_DIV           OpCode = 24
+	OpCode_MOD           OpCode = 25
+	OpCode_IN            OpCode = 26
+	OpCode_NEG           OpCode = 27
+	OpCode_NOT           OpCode = 28
+	OpCode_MK_POINT      OpCode = 29
+	OpCode_CALL_MEMBER   OpCode = 30
+	OpCode_INDEX         OpCode = 31
+	OpCode_INDEX_RANGE   OpCode = 32
+	OpCode_GET_PIXEL     OpCode = 33
+	OpCode_MK_KERNEL     OpCode = 34
+	OpCode_MK_HASHMAP    OpCode = 35
+	OpCode_MK_LIST       OpCode = 36
+	OpCode_NEQ           OpCode = 37
+	OpCode_BOOL          OpCode = 38
+	OpCode_MEMBER        OpCode = 39
+	OpCode_MK_FUNCTION   OpCode = 40
+	OpCode_IMPORT        OpCode = 41
+	OpCode_LOAD_CELL     OpCode = 42
+	OpCode_STORE_CELL    OpCode = 43
+	OpCode_LOAD_CAPTURE  OpCode = 44
+	OpCode_FOR_IN        OpCode = 45
+	OpCode_END           OpCode = 46
+	OpCode_BREAK         OpCode = 47
+	OpCode_RANGE_INIT    OpCode = 48
+	OpCode_RANGE_NEXT    OpCode = 49
+	OpCode_RANGE_STEP    OpCode = 50
+	OpCode_RANGE_END     OpCode = 51
+	OpCode_YIELD         OpCode = 52
+	OpCode_STORE_CAPTURE OpCode = 53
+	OpCode_LOAD_GLOBAL   OpCode = 54
+	OpCode_STORE_GLOBAL  OpCode = 55
+	OpCode_LOAD_CONST    OpCode = 56
+	OpCode_STORE_CONST   OpCode = 57
+	OpCode_PARAM         OpCode = 58
+	OpCode_SAMPLE        OpCode = 59
+	OpCode_REDUCE_BEGIN  OpCode = 60
+	OpCode_REDUCE_END    OpCode = 61
 )
 
 var OpCode_name = map[int32]string{
 	0:  "NOP",
 	1:  "PUSH",
 	2:  "POP",
-	3:  "LOCAL",
+	3:  "NEW_CELL",
 	4:  "LOAD",
 	5:  "STORE",
 	6:  "STORE_AT",
@@ -100,46 +128,96 @@ var OpCode_name = map[int32]string{
 	34: "MK_KERNEL",
 	35: "MK_HASHMAP",
 	36: "MK_LIST",
+	37: "NEQ",
+	38: "BOOL",
+	39: "MEMBER",
+	40: "MK_FUNCTION",
+	41: "IMPORT",
+	42: "LOAD_CELL",
+	43: "STORE_CELL",
+	44: "LOAD_CAPTURE",
+	45: "FOR_IN",
+	46: "END",
+	47: "BREAK",
+	48: "RANGE_INIT",
+	49: "RANGE_NEXT",
+	50: "RANGE_STEP",
+	51: "RANGE_END",
+	52: "YIELD",
+	53: "STORE_CAPTURE",
+	54: "LOAD_GLOBAL",
+	55: "STORE_GLOBAL",
+	56: "LOAD_CONST",
+	57: "STORE_CONST",
+	58: "PARAM",
+	59: "SAMPLE",
+	60: "REDUCE_BEGIN",
+	61: "REDUCE_END",
 }
 
 var OpCode_value = map[string]int32{
-	"NOP":         0,
-	"PUSH":        1,
-	"POP":         2,
-	"LOCAL":       3,
-	"LOAD":        4,
-	"STORE":       5,
-	"STORE_AT":    6,
-	"SET_PIXEL":   7,
-	"CALL":        8,
-	"BR":          9,
-	"BR_FALSE":    10,
-	"LOG":         11,
-	"RET":         12,
-	"OR":          13,
-	"AND":         14,
-	"EQ":          15,
-	"GT":          16,
-	"GE":          17,
-	"LT":          18,
-	"LE":          19,
-	"CONCAT":      20,
-	"ADD":         21,
-	"SUB":         22,
-	"MUL":         23,
-	"DIV":         24,
-	"MOD":         25,
-	"IN":          26,
-	"NEG":         27,
-	"NOT":         28,
-	"MK_POINT":    29,
-	"CALL_MEMBER": 30,
-	"INDEX":       31,
-	"INDEX_RANGE": 32,
-	"GET_PIXEL":   33,
-	"MK_KERNEL":   34,
-	"MK_HASHMAP":  35,
-	"MK_LIST":     36,
+	"NOP":           0,
+	"PUSH":          1,
+	"POP":           2,
+	"NEW_CELL":      3,
+	"LOAD":          4,
+	"STORE":         5,
+	"STORE_AT":      6,
+	"SET_PIXEL":     7,
+	"CALL":          8,
+	"BR":            9,
+	"BR_FALSE":      10,
+	"LOG":           11,
+	"RET":           12,
+	"OR":            13,
+	"AND":           14,
+	"EQ":            15,
+	"GT":            16,
+	"GE":            17,
+	"LT":            18,
+	"LE":            19,
+	"CONCAT":        20,
+	"ADD":           21,
+	"SUB":           22,
+	"MUL":           23,
+	"DIV":           24,
+	"MOD":           25,
+	"IN":            26,
+	"NEG":           27,
+	"NOT":           28,
+	"MK_POINT":      29,
+	"CALL_MEMBER":   30,
+	"INDEX":         31,
+	"INDEX_RANGE":   32,
+	"GET_PIXEL":     33,
+	"MK_KERNEL":     34,
+	"MK_HASHMAP":    35,
+	"MK_LIST":       36,
+	"NEQ":           37,
+	"BOOL":          38,
+	"MEMBER":        39,
+	"MK_FUNCTION":   40,
+	"IMPORT":        41,
+	"LOAD_CELL":     42,
+	"STORE_CELL":    43,
+	"LOAD_CAPTURE":  44,
+	"FOR_IN":        45,
+	"END":           46,
+	"BREAK":         47,
+	"RANGE_INIT":    48,
+	"RANGE_NEXT":    49,
+	"RANGE_STEP":    50,
+	"RANGE_END":     51,
+	"YIELD":         52,
+	"STORE_CAPTURE": 53,
+	"LOAD_GLOBAL":   54,
+	"STORE_GLOBAL":  55,
+	"LOAD_CONST":    56,
+	"STORE_CONST":   57,
+	"PARAM":         58,
+	"SAMPLE":        59,
+	"REDUCE_BEGIN":  60,
+	"REDUCE_END":    61,
 }
 
 func (x OpCode) String() string {
@@ -151,15 +229,20 @@ func (OpCode) EnumDescriptor() ([]byte, []int) {
 }
 
 type Instruction struct {
-	Opcode OpCode `protobuf:"varint,1,opt,name=opcode,proto3,enum=emitter.OpCode" json:"opcode,omitempty"`
+	Opcode  OpCode `protobuf:"varint,1,opt,name=opcode,proto3,enum=emitter.OpCode" json:"opcode,omitempty"`
+	Integer int32  `protobuf:"varint,2,opt,name=integer,proto3" json:"integer,omitempty"`
 	// Types that are valid to be assigned to Arg:
-	//	*Instruction_Integer
 	//	*Instruction_Float
 	//	*Instruction_Str
-	Arg                  isInstruction_Arg `protobuf_oneof:"arg"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+	//	*Instruction_Boolean
+	//	*Instruction_Color
+	Arg isInstruction_Arg `protobuf_oneof:"arg"`
+	// the position of the source code the instruction has been compiled from
+	Line                 int32    `protobuf:"varint,7,opt,name=line,proto3" json:"line,omitempty"`
+	Column               int32    `protobuf:"varint,8,opt,name=column,proto3" json:"column,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Instruction) Reset()         { *m = Instruction{} }
@@ -194,12 +277,15 @@ func (m *Instruction) GetOpcode() OpCode {
 	return OpCode_NOP
 }
 
-type isInstruction_Arg interface {
-	isInstruction_Arg()
+func (m *Instruction) GetInteger() int32 {
+	if m != nil {
+		return m.Integer
+	}
+	return 0
 }
 
-type Instruction_Integer struct {
-	Integer int32 `protobuf:"varint,2,opt,name=integer,proto3,oneof"`
+type isInstruction_Arg interface {
+	isInstruction_Arg()
 }
 
 type Instruction_Float struct {
@@ -210,12 +296,22 @@ type Instruction_Str struct {
 	Str string `protobuf:"bytes,4,opt,name=str,proto3,oneof"`
 }
 
-func (*Instruction_Integer) isInstruction_Arg() {}
+type Instruction_Boolean struct {
+	Boolean bool `protobuf:"varint,5,opt,name=boolean,proto3,oneof"`
+}
+
+type Instruction_Color struct {
+	Color uint32 `protobuf:"fixed32,6,opt,name=color,proto3,oneof"`
+}
 
 func (*Instruction_Float) isInstruction_Arg() {}
 
 func (*Instruction_Str) isInstruction_Arg() {}
 
+func (*Instruction_Boolean) isInstruction_Arg() {}
+
+func (*Instruction_Color) isInstruction_Arg() {}
+
 func (m *Instruction) GetArg() isInstruction_Arg {
 	if m != nil {
 		return m.Arg
@@ -223,13 +319,6 @@ func (m *Instruction) GetArg() isInstruction_Arg {
 	return nil
 }
 
-func (m *Instruction) GetInteger() int32 {
-	if x, ok := m.GetArg().(*Instruction_Integer); ok {
-		return x.Integer
-	}
-	return 0
-}
-
 func (m *Instruction) GetFloat() float32 {
 	if x, ok := m.GetArg().(*Instruction_Float); ok {
 		return x.Float
@@ -244,27 +333,199 @@ func (m *Instruction) GetStr() string {
 	return ""
 }
 
+func (m *Instruction) GetBoolean() bool {
+	if x, ok := m.GetArg().(*Instruction_Boolean); ok {
+		return x.Boolean
+	}
+	return false
+}
+
+func (m *Instruction) GetColor() uint32 {
+	if x, ok := m.GetArg().(*Instruction_Color); ok {
+		return x.Color
+	}
+	return 0
+}
+
+func (m *Instruction) GetLine() int32 {
+	if m != nil {
+		return m.Line
+	}
+	return 0
+}
+
+func (m *Instruction) GetColumn() int32 {
+	if m != nil {
+		return m.Column
+	}
+	return 0
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*Instruction) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
-		(*Instruction_Integer)(nil),
 		(*Instruction_Float)(nil),
 		(*Instruction_Str)(nil),
+		(*Instruction_Boolean)(nil),
+		(*Instruction_Color)(nil),
 	}
 }
 
+// Variable is the storage location of a parameter or a captured variable
+type Variable struct {
+	Kind                 int32    `protobuf:"varint,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Index                int32    `protobuf:"varint,2,opt,name=index,proto3" json:"index,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Variable) Reset()         { *m = Variable{} }
+func (m *Variable) String() string { return proto.CompactTextString(m) }
+func (*Variable) ProtoMessage()    {}
+func (*Variable) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d43067efeb224de, []int{1}
+}
+
+func (m *Variable) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Variable.Unmarshal(m, b)
+}
+func (m *Variable) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Variable.Marshal(b, m, deterministic)
+}
+func (m *Variable) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Variable.Merge(m, src)
+}
+func (m *Variable) XXX_Size() int {
+	return xxx_messageInfo_Variable.Size(m)
+}
+func (m *Variable) XXX_DiscardUnknown() {
+	xxx_messageInfo_Variable.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Variable proto.InternalMessageInfo
+
+func (m *Variable) GetKind() int32 {
+	if m != nil {
+		return m.Kind
+	}
+	return 0
+}
+
+func (m *Variable) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+type Function struct {
+	ParameterNames       []string    `protobuf:"bytes,1,rep,name=parameterNames,proto3" json:"parameterNames,omitempty"`
+	IsGenerator          bool        `protobuf:"varint,2,opt,name=isGenerator,proto3" json:"isGenerator,omitempty"`
+	Address              int32       `protobuf:"varint,3,opt,name=address,proto3" json:"address,omitempty"`
+	FrameSize            int32       `protobuf:"varint,4,opt,name=frameSize,proto3" json:"frameSize,omitempty"`
+	CellCount            int32       `protobuf:"varint,5,opt,name=cellCount,proto3" json:"cellCount,omitempty"`
+	Params               []*Variable `protobuf:"bytes,6,rep,name=params,proto3" json:"params,omitempty"`
+	Captures             []*Variable `protobuf:"bytes,7,rep,name=captures,proto3" json:"captures,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *Function) Reset()         { *m = Function{} }
+func (m *Function) String() string { return proto.CompactTextString(m) }
+func (*Function) ProtoMessage()    {}
+func (*Function) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d43067efeb224de, []int{2}
+}
+
+func (m *Function) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Function.Unmarshal(m, b)
+}
+func (m *Function) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Function.Marshal(b, m, deterministic)
+}
+func (m *Function) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Function.Merge(m, src)
+}
+func (m *Function) XXX_Size() int {
+	return xxx_messageInfo_Function.Size(m)
+}
+func (m *Function) XXX_DiscardUnknown() {
+	xxx_messageInfo_Function.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Function proto.InternalMessageInfo
+
+func (m *Function) GetParameterNames() []string {
+	if m != nil {
+		return m.ParameterNames
+	}
+	return nil
+}
+
+func (m *Function) GetIsGenerator() bool {
+	if m != nil {
+		return m.IsGenerator
+	}
+	return false
+}
+
+func (m *Function) GetAddress() int32 {
+	if m != nil {
+		return m.Address
+	}
+	return 0
+}
+
+func (m *Function) GetFrameSize() int32 {
+	if m != nil {
+		return m.FrameSize
+	}
+	return 0
+}
+
+func (m *Function) GetCellCount() int32 {
+	if m != nil {
+		return m.CellCount
+	}
+	return 0
+}
+
+func (m *Function) GetParams() []*Variable {
+	if m != nil {
+		return m.Params
+	}
+	return nil
+}
+
+func (m *Function) GetCaptures() []*Variable {
+	if m != nil {
+		return m.Captures
+	}
+	return nil
+}
+
 type Program struct {
-	Instructions         []*Instruction `protobuf:"bytes,1,rep,name=instructions,proto3" json:"instructions,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
-	XXX_unrecognized     []byte         `json:"-"`
-	XXX_sizecache        int32          `json:"-"`
+	Instructions         []*Instruction      `protobuf:"bytes,1,rep,name=instructions,proto3" json:"instructions,omitempty"`
+	Functions            []*Function         `protobuf:"bytes,2,rep,name=functions,proto3" json:"functions,omitempty"`
+	File                 string              `protobuf:"bytes,3,opt,name=file,proto3" json:"file,omitempty"`
+	ImportNames          map[string]string   `protobuf:"bytes,4,rep,name=importNames,proto3" json:"importNames,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Modules              map[string]*Program `protobuf:"bytes,5,rep,name=modules,proto3" json:"modules,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	FrameSize            int32               `protobuf:"varint,6,opt,name=frameSize,proto3" json:"frameSize,omitempty"`
+	CellCount            int32               `protobuf:"varint,7,opt,name=cellCount,proto3" json:"cellCount,omitempty"`
+	Globals              []string            `protobuf:"bytes,8,rep,name=globals,proto3" json:"globals,omitempty"`
+	Params               []string            `protobuf:"bytes,9,rep,name=params,proto3" json:"params,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
 }
 
 func (m *Program) Reset()         { *m = Program{} }
 func (m *Program) String() string { return proto.CompactTextString(m) }
 func (*Program) ProtoMessage()    {}
 func (*Program) Descriptor() ([]byte, []int) {
-	return fileDescriptor_3d43067efeb224de, []int{1}
+	return fileDescriptor_3d43067efeb224de, []int{3}
 }
 
 func (m *Program) XXX_Unmarshal(b []byte) error {
@@ -292,44 +553,202 @@ func (m *Program) GetInstructions() []*Instruction {
 	return nil
 }
 
+func (m *Program) GetFunctions() []*Function {
+	if m != nil {
+		return m.Functions
+	}
+	return nil
+}
+
+func (m *Program) GetFile() string {
+	if m != nil {
+		return m.File
+	}
+	return ""
+}
+
+func (m *Program) GetImportNames() map[string]string {
+	if m != nil {
+		return m.ImportNames
+	}
+	return nil
+}
+
+func (m *Program) GetModules() map[string]*Program {
+	if m != nil {
+		return m.Modules
+	}
+	return nil
+}
+
+func (m *Program) GetFrameSize() int32 {
+	if m != nil {
+		return m.FrameSize
+	}
+	return 0
+}
+
+func (m *Program) GetCellCount() int32 {
+	if m != nil {
+		return m.CellCount
+	}
+	return 0
+}
+
+func (m *Program) GetGlobals() []string {
+	if m != nil {
+		return m.Globals
+	}
+	return nil
+}
+
+func (m *Program) GetParams() []string {
+	if m != nil {
+		return m.Params
+	}
+	return nil
+}
+
+// CompiledProgram is the content of a compiled ylang file
+type CompiledProgram struct {
+	FormatVersion        int32    `protobuf:"varint,1,opt,name=formatVersion,proto3" json:"formatVersion,omitempty"`
+	SourceHash           []byte   `protobuf:"bytes,2,opt,name=sourceHash,proto3" json:"sourceHash,omitempty"`
+	Program              *Program `protobuf:"bytes,3,opt,name=program,proto3" json:"program,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CompiledProgram) Reset()         { *m = CompiledProgram{} }
+func (m *CompiledProgram) String() string { return proto.CompactTextString(m) }
+func (*CompiledProgram) ProtoMessage()    {}
+func (*CompiledProgram) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3d43067efeb224de, []int{4}
+}
+
+func (m *CompiledProgram) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CompiledProgram.Unmarshal(m, b)
+}
+func (m *CompiledProgram) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CompiledProgram.Marshal(b, m, deterministic)
+}
+func (m *CompiledProgram) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CompiledProgram.Merge(m, src)
+}
+func (m *CompiledProgram) XXX_Size() int {
+	return xxx_messageInfo_CompiledProgram.Size(m)
+}
+func (m *CompiledProgram) XXX_DiscardUnknown() {
+	xxx_messageInfo_CompiledProgram.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CompiledProgram proto.InternalMessageInfo
+
+func (m *CompiledProgram) GetFormatVersion() int32 {
+	if m != nil {
+		return m.FormatVersion
+	}
+	return 0
+}
+
+func (m *CompiledProgram) GetSourceHash() []byte {
+	if m != nil {
+		return m.SourceHash
+	}
+	return nil
+}
+
+func (m *CompiledProgram) GetProgram() *Program {
+	if m != nil {
+		return m.Program
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("emitter.OpCode", OpCode_name, OpCode_value)
 	proto.RegisterType((*Instruction)(nil), "emitter.Instruction")
+	proto.RegisterType((*Variable)(nil), "emitter.Variable")
+	proto.RegisterType((*Function)(nil), "emitter.Function")
 	proto.RegisterType((*Program)(nil), "emitter.Program")
+	proto.RegisterMapType((map[string]string)(nil), "emitter.Program.ImportNamesEntry")
+	proto.RegisterMapType((map[string]*Program)(nil), "emitter.Program.ModulesEntry")
+	proto.RegisterType((*CompiledProgram)(nil), "emitter.CompiledProgram")
 }
 
 func init() { proto.RegisterFile("ylang.proto", fileDescriptor_3d43067efeb224de) }
 
 var fileDescriptor_3d43067efeb224de = []byte{
-	// 469 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x52, 0x4d, 0x73, 0x93, 0x50,
-	0x14, 0x0d, 0x21, 0x81, 0xe4, 0x92, 0x26, 0xd7, 0x67, 0xad, 0x58, 0xbf, 0x30, 0x3a, 0x63, 0xc6,
-	0x45, 0x16, 0x75, 0xe3, 0x96, 0xc0, 0x33, 0x30, 0x3c, 0x78, 0xf8, 0x20, 0x4e, 0x77, 0x4c, 0x6c,
-	0x31, 0x93, 0x99, 0x36, 0x64, 0x08, 0x2e, 0xfc, 0x01, 0xfe, 0x04, 0xff, 0xaf, 0x73, 0x49, 0xad,
-	0x76, 0x75, 0xce, 0x3d, 0xf7, 0x5c, 0x38, 0x9c, 0x01, 0xac, 0x9f, 0x37, 0xeb, 0xdd, 0x66, 0xbe,
-	0xaf, 0xab, 0xa6, 0x62, 0x66, 0x79, 0xbb, 0x6d, 0x9a, 0xb2, 0x9e, 0xfe, 0xd2, 0xc0, 0x0a, 0x77,
-	0x87, 0xa6, 0xfe, 0x71, 0xd5, 0x6c, 0xab, 0x1d, 0x7b, 0x0f, 0x46, 0xb5, 0xbf, 0xaa, 0xae, 0x4b,
-	0x5b, 0x73, 0xb4, 0xd9, 0xf8, 0x62, 0x32, 0xbf, 0x73, 0xce, 0xe5, 0xde, 0xab, 0xae, 0x4b, 0x75,
-	0xb7, 0x66, 0xe7, 0x60, 0x6e, 0x77, 0x4d, 0xb9, 0x29, 0x6b, 0xbb, 0xeb, 0x68, 0xb3, 0x7e, 0xd0,
-	0x51, 0x7f, 0x05, 0x76, 0x06, 0xfd, 0xef, 0x37, 0xd5, 0xba, 0xb1, 0x75, 0x47, 0x9b, 0x75, 0x83,
-	0x8e, 0x3a, 0x8e, 0x8c, 0x81, 0x7e, 0x68, 0x6a, 0xbb, 0xe7, 0x68, 0xb3, 0x61, 0xd0, 0x51, 0x34,
-	0x2c, 0xfa, 0xa0, 0xaf, 0xeb, 0xcd, 0xd4, 0x03, 0x33, 0xad, 0xab, 0x4d, 0xbd, 0xbe, 0x65, 0x9f,
-	0x60, 0xb4, 0xfd, 0x97, 0xe8, 0x60, 0x6b, 0x8e, 0x3e, 0xb3, 0x2e, 0x4e, 0xef, 0x83, 0xfc, 0x17,
-	0x57, 0x3d, 0x70, 0x7e, 0xf8, 0xad, 0x83, 0x71, 0x8c, 0xc9, 0x4c, 0xd0, 0x13, 0x99, 0x62, 0x87,
-	0x0d, 0xa0, 0x97, 0xae, 0xb2, 0x00, 0x35, 0x92, 0x52, 0x99, 0x62, 0x97, 0x0d, 0xa1, 0x2f, 0xa4,
-	0xe7, 0x0a, 0xd4, 0x69, 0x2b, 0xa4, 0xeb, 0x63, 0x8f, 0xc4, 0x2c, 0x97, 0x8a, 0x63, 0x9f, 0x8d,
-	0x60, 0xd0, 0xd2, 0xc2, 0xcd, 0xd1, 0x60, 0x27, 0x30, 0xcc, 0x78, 0x5e, 0xa4, 0xe1, 0x25, 0x17,
-	0x68, 0xd2, 0x85, 0xe7, 0x0a, 0x81, 0x03, 0x66, 0x40, 0x77, 0xa1, 0x70, 0x48, 0xf6, 0x85, 0x2a,
-	0x3e, 0xbb, 0x22, 0xe3, 0x08, 0xf4, 0x16, 0x21, 0x97, 0x68, 0x11, 0x51, 0x3c, 0xc7, 0x11, 0xf9,
-	0xa4, 0xc2, 0x13, 0x12, 0xdc, 0xc4, 0xc7, 0x31, 0x09, 0xfc, 0x0b, 0x4e, 0x08, 0x97, 0x39, 0x62,
-	0x8b, 0x1c, 0x1f, 0x11, 0x8a, 0x1c, 0x59, 0x8b, 0x1c, 0x1f, 0x33, 0x00, 0xc3, 0x93, 0x89, 0xe7,
-	0xe6, 0x78, 0xda, 0x1e, 0xfb, 0x3e, 0x3e, 0x21, 0x92, 0xad, 0x16, 0x78, 0x46, 0x24, 0x5e, 0x09,
-	0x7c, 0x4a, 0xc4, 0x0f, 0xbf, 0xa2, 0xdd, 0x2a, 0xd2, 0xc7, 0x67, 0xf4, 0x80, 0x30, 0xc1, 0xf3,
-	0xb6, 0x04, 0xbe, 0xc4, 0xe7, 0xc7, 0x36, 0x72, 0x7c, 0x41, 0x59, 0xe3, 0xa8, 0x48, 0x65, 0x98,
-	0xe4, 0xf8, 0x92, 0x4d, 0xc0, 0xa2, 0x6f, 0x29, 0x62, 0x1e, 0x2f, 0xb8, 0xc2, 0x57, 0x54, 0x42,
-	0x98, 0xf8, 0xfc, 0x12, 0x5f, 0xd3, 0xae, 0xa5, 0x85, 0x72, 0x93, 0x25, 0x47, 0x87, 0x7a, 0x58,
-	0xde, 0xf7, 0xf0, 0x86, 0xc6, 0x38, 0x2a, 0x22, 0xae, 0x12, 0x2e, 0x70, 0xca, 0xc6, 0x00, 0x71,
-	0x54, 0x04, 0x6e, 0x16, 0xc4, 0x6e, 0x8a, 0x6f, 0x99, 0x05, 0x66, 0x1c, 0x15, 0x22, 0xcc, 0x72,
-	0x7c, 0xf7, 0xcd, 0x68, 0x7f, 0xba, 0x8f, 0x7f, 0x02, 0x00, 0x00, 0xff, 0xff, 0x26, 0x3e, 0x9f,
-	0x25, 0x83, 0x02, 0x00, 0x00,
+	// 1125 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x55, 0x6d, 0x73, 0xdb, 0x44,
+	0x10, 0x8e, 0xed, 0xc8, 0xb2, 0xcf, 0x79, 0xd9, 0x1e, 0xa5, 0xa8, 0xa1, 0x2d, 0x6e, 0x28, 0xad,
+	0x5b, 0xa8, 0x0b, 0x69, 0xa1, 0xa5, 0xbc, 0xcc, 0xc8, 0xf6, 0xc5, 0xd6, 0x58, 0x6f, 0x3d, 0x2b,
+	0xa5, 0xfd, 0xa4, 0x51, 0xec, 0x4b, 0xd0, 0x54, 0x96, 0x3c, 0x92, 0xcc, 0x50, 0x3e, 0x02, 0xff,
+	0x94, 0x1f, 0x02, 0xb3, 0x27, 0x39, 0x76, 0x03, 0xe5, 0xd3, 0xed, 0x3e, 0xb7, 0xfb, 0xec, 0xee,
+	0xb3, 0x67, 0x8b, 0xb4, 0xde, 0x46, 0x41, 0x7c, 0xde, 0x5d, 0xa4, 0x49, 0x9e, 0x50, 0x55, 0xcc,
+	0xc3, 0x3c, 0x17, 0xe9, 0xe1, 0x5f, 0x15, 0xd2, 0x32, 0xe2, 0x2c, 0x4f, 0x97, 0xd3, 0x3c, 0x4c,
+	0x62, 0x7a, 0x8f, 0xd4, 0x93, 0xc5, 0x34, 0x99, 0x09, 0xad, 0xd2, 0xae, 0x74, 0xf6, 0x8e, 0xf6,
+	0xbb, 0x65, 0x64, 0xd7, 0x59, 0xf4, 0x93, 0x99, 0xe0, 0xe5, 0x35, 0xd5, 0x88, 0x1a, 0xc6, 0xb9,
+	0x38, 0x17, 0xa9, 0x56, 0x6d, 0x57, 0x3a, 0x0a, 0x5f, 0xb9, 0xf4, 0x1a, 0x51, 0xce, 0xa2, 0x24,
+	0xc8, 0xb5, 0x5a, 0xbb, 0xd2, 0xa9, 0x8e, 0xb6, 0x78, 0xe1, 0x52, 0x4a, 0x6a, 0x59, 0x9e, 0x6a,
+	0xdb, 0xed, 0x4a, 0xa7, 0x39, 0xda, 0xe2, 0xe8, 0xd0, 0x03, 0xa2, 0x9e, 0x26, 0x49, 0x24, 0x82,
+	0x58, 0x53, 0xda, 0x95, 0x4e, 0x63, 0xb4, 0xc5, 0x57, 0x00, 0xf2, 0x4c, 0x93, 0x28, 0x49, 0xb5,
+	0x7a, 0xbb, 0xd2, 0x51, 0x91, 0x47, 0xba, 0x94, 0x92, 0xed, 0x28, 0x8c, 0x85, 0xa6, 0xca, 0xb2,
+	0xd2, 0xa6, 0xd7, 0x48, 0x7d, 0x9a, 0x44, 0xcb, 0x79, 0xac, 0x35, 0x24, 0x5a, 0x7a, 0x3d, 0x85,
+	0xd4, 0x82, 0xf4, 0xfc, 0xf0, 0x09, 0x69, 0xbc, 0x0c, 0xd2, 0x30, 0x38, 0x8d, 0x04, 0xa6, 0xbf,
+	0x09, 0xe3, 0x99, 0x9c, 0x4f, 0xe1, 0xd2, 0xa6, 0x57, 0x89, 0x12, 0xc6, 0x33, 0xf1, 0x6b, 0x39,
+	0x4a, 0xe1, 0x1c, 0xfe, 0x59, 0x25, 0x8d, 0xe3, 0x65, 0x5c, 0x08, 0x73, 0x97, 0xec, 0x2d, 0x82,
+	0x34, 0x98, 0x8b, 0x5c, 0xa4, 0x76, 0x30, 0x17, 0x99, 0x56, 0x69, 0xd7, 0x3a, 0x4d, 0x7e, 0x09,
+	0xa5, 0x6d, 0xd2, 0x0a, 0xb3, 0xa1, 0x88, 0x45, 0x1a, 0xe4, 0x49, 0xa1, 0x4d, 0x83, 0x6f, 0x42,
+	0xa8, 0x5c, 0x30, 0x9b, 0xa5, 0x22, 0xcb, 0xa4, 0x42, 0x0a, 0x5f, 0xb9, 0xf4, 0x06, 0x69, 0x9e,
+	0x21, 0xd9, 0x24, 0xfc, 0x4d, 0x48, 0x9d, 0x14, 0xbe, 0x06, 0xf0, 0x76, 0x2a, 0xa2, 0xa8, 0x9f,
+	0x2c, 0xe3, 0x5c, 0xaa, 0xa5, 0xf0, 0x35, 0x40, 0xef, 0x93, 0xba, 0xec, 0x24, 0xd3, 0xea, 0xed,
+	0x5a, 0xa7, 0x75, 0x74, 0xe5, 0x62, 0x71, 0xab, 0xc9, 0x79, 0x19, 0x40, 0x1f, 0x92, 0xc6, 0x34,
+	0x58, 0xe4, 0xcb, 0x54, 0x64, 0x9a, 0xfa, 0xbe, 0xe0, 0x8b, 0x90, 0xc3, 0xdf, 0xb7, 0x89, 0xea,
+	0xa6, 0xc9, 0x79, 0x1a, 0xcc, 0xe9, 0x33, 0xb2, 0x13, 0xae, 0x5f, 0x4b, 0xa1, 0x41, 0xeb, 0xe8,
+	0xea, 0x45, 0xfa, 0xc6, 0x53, 0xe2, 0xef, 0x44, 0xd2, 0x47, 0xa4, 0x79, 0x56, 0x6a, 0x99, 0x69,
+	0xd5, 0x4b, 0x55, 0x57, 0x2a, 0xf3, 0x75, 0x0c, 0xee, 0xe9, 0x2c, 0x8c, 0x84, 0xd4, 0xa8, 0xc9,
+	0xa5, 0x4d, 0xfb, 0xa4, 0x15, 0xce, 0x17, 0x49, 0x9a, 0x17, 0x1b, 0xd8, 0x96, 0x34, 0xb7, 0x2f,
+	0x68, 0xca, 0x2e, 0xbb, 0xc6, 0x3a, 0x86, 0xc5, 0x79, 0xfa, 0x96, 0x6f, 0x66, 0xd1, 0xa7, 0x44,
+	0x9d, 0x27, 0xb3, 0x65, 0x24, 0x32, 0x4d, 0x91, 0x04, 0x37, 0xff, 0x45, 0x60, 0x15, 0xf7, 0x45,
+	0xf2, 0x2a, 0xfa, 0xdd, 0xf5, 0xd4, 0xff, 0x77, 0x3d, 0xea, 0xe5, 0xf5, 0x68, 0x44, 0x3d, 0x8f,
+	0x92, 0xd3, 0x20, 0xca, 0xb4, 0x86, 0x7c, 0x37, 0x2b, 0x17, 0x9f, 0x6e, 0xb9, 0xb8, 0xa6, 0xbc,
+	0x28, 0xbd, 0x83, 0x1f, 0x09, 0x5c, 0x9e, 0x83, 0x02, 0xa9, 0xbd, 0x11, 0x6f, 0xe5, 0xd3, 0x6d,
+	0x72, 0x34, 0xf1, 0xe5, 0xfe, 0x12, 0x44, 0x4b, 0x21, 0x1f, 0x5a, 0x93, 0x17, 0xce, 0xf3, 0xea,
+	0xb3, 0xca, 0x81, 0x49, 0x76, 0x36, 0xc7, 0xf8, 0x8f, 0xdc, 0xbb, 0x9b, 0xb9, 0xad, 0x23, 0xb8,
+	0x2c, 0xc3, 0x06, 0xdb, 0xe1, 0x1f, 0x15, 0xb2, 0xdf, 0x4f, 0xe6, 0x8b, 0x30, 0x12, 0xb3, 0xf2,
+	0x9a, 0xde, 0x21, 0xbb, 0x67, 0x49, 0x3a, 0x0f, 0xf2, 0x97, 0x22, 0xcd, 0xc2, 0x24, 0x2e, 0x7f,
+	0x52, 0xef, 0x82, 0xf4, 0x16, 0x21, 0x59, 0xb2, 0x4c, 0xa7, 0x62, 0x14, 0x64, 0x3f, 0xcb, 0x52,
+	0x3b, 0x7c, 0x03, 0xa1, 0x0f, 0x88, 0xba, 0x28, 0x08, 0xb5, 0xda, 0x7b, 0xfa, 0x58, 0x05, 0x3c,
+	0xf8, 0x5b, 0x21, 0xf5, 0xe2, 0x7f, 0x88, 0xaa, 0xa4, 0x66, 0x3b, 0x2e, 0x6c, 0xd1, 0x06, 0xd9,
+	0x76, 0x4f, 0x26, 0x23, 0xa8, 0x20, 0xe4, 0x3a, 0x2e, 0x54, 0xe9, 0x0e, 0x69, 0xd8, 0xec, 0x27,
+	0xbf, 0xcf, 0x4c, 0x13, 0x6a, 0x18, 0x60, 0x3a, 0xfa, 0x00, 0xb6, 0x69, 0x93, 0x28, 0x13, 0xcf,
+	0xe1, 0x0c, 0x14, 0x0c, 0x91, 0xa6, 0xaf, 0x7b, 0x50, 0xa7, 0xbb, 0xa4, 0x39, 0x61, 0x9e, 0xef,
+	0x1a, 0xaf, 0x98, 0x09, 0x2a, 0x66, 0xf4, 0x75, 0xd3, 0x84, 0x06, 0xad, 0x93, 0x6a, 0x8f, 0x43,
+	0x13, 0xc3, 0x7b, 0xdc, 0x3f, 0xd6, 0xcd, 0x09, 0x03, 0x82, 0x85, 0x4c, 0x67, 0x08, 0x2d, 0x34,
+	0x38, 0xf3, 0x60, 0x07, 0xe3, 0x1c, 0x0e, 0xbb, 0x08, 0xe8, 0xf6, 0x00, 0xf6, 0x10, 0x60, 0x2f,
+	0x60, 0x1f, 0xcf, 0xa1, 0x07, 0x20, 0x4f, 0x06, 0x57, 0xf0, 0x34, 0x3d, 0xa0, 0xf2, 0x64, 0xf0,
+	0x01, 0x25, 0xa4, 0xde, 0x77, 0xec, 0xbe, 0xee, 0xc1, 0x55, 0x99, 0x3c, 0x18, 0xc0, 0x87, 0x68,
+	0x4c, 0x4e, 0x7a, 0x70, 0x0d, 0x0d, 0xeb, 0xc4, 0x84, 0x8f, 0xd0, 0x18, 0x18, 0x2f, 0x41, 0x93,
+	0x88, 0x33, 0x80, 0xeb, 0x48, 0x60, 0xd8, 0x70, 0x20, 0x75, 0x60, 0x43, 0xf8, 0xb8, 0x10, 0xc4,
+	0x83, 0x1b, 0xd8, 0xab, 0x35, 0xf6, 0x5d, 0xc7, 0xb0, 0x3d, 0xb8, 0x49, 0xf7, 0x49, 0x0b, 0x67,
+	0xf1, 0x2d, 0x66, 0xf5, 0x18, 0x87, 0x5b, 0x28, 0x82, 0x61, 0x0f, 0xd8, 0x2b, 0xf8, 0x04, 0xef,
+	0xa4, 0xe9, 0x73, 0xdd, 0x1e, 0x32, 0x68, 0xa3, 0x0e, 0xc3, 0x0b, 0x1d, 0x6e, 0xa3, 0x6b, 0x8d,
+	0xfd, 0x31, 0xe3, 0x36, 0x33, 0xe1, 0x90, 0xee, 0x11, 0x62, 0x8d, 0xfd, 0x91, 0x3e, 0x19, 0x59,
+	0xba, 0x0b, 0x9f, 0xd2, 0x16, 0x51, 0xad, 0xb1, 0x6f, 0x1a, 0x13, 0x0f, 0xee, 0x14, 0x7d, 0xbc,
+	0x80, 0xcf, 0x50, 0xbc, 0x9e, 0xe3, 0x98, 0x70, 0x17, 0x67, 0x2b, 0xab, 0xde, 0xc3, 0x52, 0xd6,
+	0xd8, 0x3f, 0x3e, 0xb1, 0xfb, 0x9e, 0xe1, 0xd8, 0xd0, 0xc1, 0x4b, 0xc3, 0x72, 0x1d, 0xee, 0xc1,
+	0x7d, 0xac, 0x83, 0x1b, 0x2a, 0x16, 0xf6, 0x00, 0xeb, 0x14, 0xbb, 0x91, 0xfe, 0xe7, 0x14, 0xc8,
+	0x4e, 0x71, 0xad, 0xbb, 0xde, 0x09, 0x67, 0xf0, 0x05, 0x26, 0x1f, 0x3b, 0xdc, 0x37, 0x6c, 0x78,
+	0x88, 0x85, 0x99, 0x3d, 0x80, 0x2e, 0x0e, 0xd6, 0xe3, 0x4c, 0x1f, 0xc3, 0x23, 0x64, 0x90, 0x23,
+	0xf9, 0x86, 0x6d, 0x78, 0xf0, 0xe5, 0xda, 0xb7, 0xd9, 0x2b, 0x0f, 0xbe, 0x5a, 0xfb, 0x13, 0x8f,
+	0xb9, 0x70, 0x84, 0x0d, 0x14, 0x3e, 0x32, 0x3d, 0x46, 0xa6, 0xd7, 0x06, 0x33, 0x07, 0xf0, 0x84,
+	0x5e, 0x21, 0xbb, 0x65, 0x2f, 0x65, 0xf1, 0xaf, 0x71, 0x14, 0xd9, 0xce, 0xd0, 0x74, 0x7a, 0xba,
+	0x09, 0xdf, 0x60, 0x7f, 0x45, 0x4c, 0x89, 0x3c, 0x45, 0xfe, 0xa2, 0x63, 0xc7, 0x9e, 0x78, 0xf0,
+	0x0c, 0x53, 0x4a, 0x16, 0x09, 0x7c, 0x8b, 0x15, 0x5c, 0x9d, 0xeb, 0x16, 0x3c, 0xc7, 0x59, 0x26,
+	0xba, 0xe5, 0x9a, 0x0c, 0xbe, 0x43, 0x26, 0xce, 0x06, 0x27, 0x7d, 0xe6, 0xf7, 0xd8, 0xd0, 0xb0,
+	0xe1, 0x7b, 0xd9, 0x69, 0x81, 0x60, 0x6b, 0x3f, 0xf4, 0x3a, 0xe4, 0x7a, 0x2c, 0xf2, 0x6e, 0x36,
+	0x0f, 0xa6, 0x6f, 0xc4, 0xbc, 0x5b, 0x7c, 0xd3, 0xcb, 0xdf, 0x4b, 0xaf, 0xf5, 0xda, 0x0c, 0xe2,
+	0x73, 0x17, 0xbf, 0xf0, 0xd9, 0x69, 0x5d, 0x7e, 0xe9, 0x1f, 0xff, 0x33, 0x00, 0x7e, 0x9c, 0x1d,
+	0x66, 0xf8, 0x07, 0x00, 0x00,
 }
@@ -0,0 +1,141 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// Diff returns the line-based differences between a and b in unified diff format.
+// Returns an empty string if a and b are equal.
+func Diff(aName string, bName string, a string, b string) string {
+	if a == b {
+		return ""
+	}
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+	edits := diffLines(aLines, bLines)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+	for start := 0; start < len(edits); {
+		// find the next change and the end of its hunk
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start >= len(edits) {
+			break
+		}
+		end := start
+		for unchanged := 0; end < len(edits) && unchanged <= 2*diffContext; end++ {
+			if edits[end].op == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > start && edits[end-1].op == ' ' {
+			end--
+		}
+		first := max(start-diffContext, 0)
+		last := min(end+diffContext, len(edits))
+
+		aStart, bStart := edits[first].aLine, edits[first].bLine
+		aCount, bCount := 0, 0
+		for _, e := range edits[first:last] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, e := range edits[first:last] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.text)
+			sb.WriteByte('\n')
+		}
+		start = last
+	}
+	return sb.String()
+}
+
+// edit is a line of a diff: unchanged (' '), deleted from a ('-') or inserted from b ('+')
+type edit struct {
+	op    byte
+	text  string
+	aLine int // the 1-based line number in a before or at which the edit occurs
+	bLine int // the 1-based line number in b before or at which the edit occurs
+}
+
+func splitLines(s string) []string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	lines := strings.Split(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the edits turning a into b, based on the longest common subsequence of lines
+func diffLines(a []string, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i + 1, j + 1})
+			i++
+			j++
+		case i < len(a) && (j >= len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i + 1, j + 1})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i + 1, j + 1})
+			j++
+		}
+	}
+	return edits
+}
+
+// hunkRange formats the start and length of a hunk like diff -u
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,833 @@
+// Package format implements the canonical formatting of ylang source code.
+package format
+
+import (
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Source formats the ylang source code src. The code is parsed and printed
+// with normalized indentation and spacing, keeping comments and single blank
+// lines between statements. Returns an error if src cannot be parsed.
+func Source(src string) (string, error) {
+	tokens, comments, err := lexer.LexWithComments(src)
+	if err != nil {
+		return "", err
+	}
+	prog, err := parser.Parse(tokens, false)
+	if err != nil {
+		return "", err
+	}
+	p := printer{tokens: tokens, comments: comments}
+	p.stmtList(prog.Stmts, len(tokens))
+	p.buf.WriteString("\n")
+	out := p.buf.String()
+	if strings.Contains(src, "\r\n") {
+		out = strings.Replace(out, "\n", "\r\n", -1)
+	}
+	return out, nil
+}
+
+// Expr formats the expression expr of a parsed program like Source does within a statement
+// at the top level. Comments inside the expression are dropped.
+func Expr(expr parser.Expression) string {
+	p := printer{}
+	p.expr(expr, precTernary)
+	return p.buf.String()
+}
+
+const indentation = "    "
+
+// operator precedences, following the recursive descent of the parser
+const (
+	precTernary = iota
+	precPipeline
+	precOr
+	precAnd
+	precCond
+	precConcat
+	precTuple
+	precTerm
+	precProduct
+	precUnary
+	precPostfix
+	precAtom
+)
+
+type printer struct {
+	buf      strings.Builder
+	tokens   []lexer.Token
+	comments []lexer.Token // the comments not printed yet
+	indent   int
+	column   int  // the number of runes in the current output line
+	bol      bool // true if the indentation of the current output line is pending
+	lastLine int  // the source line of the last printed statement or comment
+	open     bool // true if nothing has been printed since an opening brace or bracket
+	inKernel bool // true while printing a kernel element, where '(' starts the next element
+}
+
+func (p *printer) write(s string) {
+	if p.bol {
+		p.bol = false
+		p.write(strings.Repeat(indentation, p.indent))
+	}
+	p.buf.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.column = utf8.RuneCountInString(s[i+1:])
+	} else {
+		p.column += utf8.RuneCountInString(s)
+	}
+}
+
+func (p *printer) newline() {
+	p.buf.WriteString("\n")
+	p.column = 0
+	p.bol = true
+}
+
+// token returns the token with the specified index or an end-of-file token past all tokens
+func (p *printer) token(index int) lexer.Token {
+	if index < 0 || index >= len(p.tokens) {
+		return lexer.Token{Type: lexer.TTEOF, LineNumber: math.MaxInt32}
+	}
+	return p.tokens[index]
+}
+
+// tokenIndex returns the index of tok in the token stream or -1
+func (p *printer) tokenIndex(tok lexer.Token) int {
+	for i, t := range p.tokens {
+		if t.LineNumber == tok.LineNumber && t.Column == tok.Column {
+			return i
+		}
+		if t.LineNumber > tok.LineNumber {
+			break
+		}
+	}
+	return -1
+}
+
+func before(a lexer.Token, b lexer.Token) bool {
+	return a.LineNumber < b.LineNumber || a.LineNumber == b.LineNumber && a.Column < b.Column
+}
+
+// lineBreak starts a new output line for a source element starting at line.
+// a single blank line is kept if the element is separated from the last one by blank lines.
+func (p *printer) lineBreak(line int) {
+	if p.buf.Len() == 0 {
+		return
+	}
+	p.newline()
+	if !p.open && p.lastLine > 0 && line > p.lastLine+1 {
+		p.newline()
+	}
+	p.open = false
+}
+
+// flushComments prints the pending comments located before tok. comments on the line of
+// the last printed source element are appended to the current output line.
+func (p *printer) flushComments(tok lexer.Token) {
+	for len(p.comments) > 0 && before(p.comments[0], tok) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+		if comment.LineNumber == p.lastLine && p.buf.Len() > 0 {
+			p.write(" ")
+		} else {
+			p.lineBreak(comment.LineNumber)
+		}
+		p.write(strings.TrimRight(comment.Lexeme, " \t"))
+		p.lastLine = comment.LineNumber
+	}
+}
+
+// commentBefore returns true if a comment not printed yet is located before tok
+func (p *printer) commentBefore(tok lexer.Token) bool {
+	return len(p.comments) > 0 && before(p.comments[0], tok)
+}
+
+// stmtList prints stmts, each on a new line, followed by the comments before
+// the token with index end, which closes the statement list.
+func (p *printer) stmtList(stmts []parser.Statement, end int) {
+	for i, stmt := range stmts {
+		start := stmt.Token()
+		p.flushComments(start)
+		p.lineBreak(start.LineNumber)
+		p.stmt(stmt)
+		next := end
+		if i+1 < len(stmts) {
+			next = p.tokenIndex(stmts[i+1].Token())
+		}
+		p.lastLine = p.token(next - 1).LineNumber
+	}
+	p.flushComments(p.token(end))
+}
+
+// block prints a statement block enclosed in braces, the opening brace has the index open.
+// empty blocks are printed as {} unless they contain comments.
+func (p *printer) block(stmts []parser.Statement, open int) {
+	if len(stmts) == 0 {
+		p.emptyBlock(open)
+		return
+	}
+	p.write("{")
+	first := p.tokenIndex(stmts[0].Token())
+	end := p.closingBrace(stmts[len(stmts)-1])
+	p.lastLine = p.token(first - 1).LineNumber
+	p.open = true
+	p.indent++
+	p.stmtList(stmts, end)
+	p.indent--
+	p.newline()
+	p.write("}")
+	p.open = false
+	p.lastLine = p.token(end).LineNumber
+}
+
+func (p *printer) emptyBlock(open int) {
+	end := open + 1
+	if p.token(open).Type != lexer.TTLBrace || p.token(end).Type != lexer.TTRBrace || !p.commentBefore(p.token(end)) {
+		p.write("{}")
+		return
+	}
+	p.write("{")
+	p.lastLine = p.token(open).LineNumber
+	p.open = true
+	p.indent++
+	p.flushComments(p.token(end))
+	p.indent--
+	p.newline()
+	p.write("}")
+	p.open = false
+	p.lastLine = p.token(end).LineNumber
+}
+
+// blockEnd returns the index of the brace closing the block with the opening brace open
+func (p *printer) blockEnd(stmts []parser.Statement, open int) int {
+	if len(stmts) == 0 {
+		return open + 1
+	}
+	return p.closingBrace(stmts[len(stmts)-1])
+}
+
+// blockOpen returns the index of the first opening brace after the token with index after,
+// which opens the block of a statement whose header ends with that token
+func (p *printer) blockOpen(after int) int {
+	for i := after + 1; i < len(p.tokens); i++ {
+		if p.tokens[i].Type == lexer.TTLBrace {
+			return i
+		}
+	}
+	return len(p.tokens)
+}
+
+// matching returns the index of the token closing the parenthesis, bracket, brace or kernel bar with index open
+func (p *printer) matching(open int) int {
+	if open < 0 || open >= len(p.tokens) {
+		return len(p.tokens)
+	}
+	depth := 0
+	for i := open; i < len(p.tokens); i++ {
+		switch p.tokens[i].Type {
+		case lexer.TTLBrace, lexer.TTLParen, lexer.TTLBracket:
+			depth++
+		case lexer.TTRBrace, lexer.TTRParen, lexer.TTRBracket:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		case lexer.TTPipe:
+			if i > open && depth == 0 && p.tokens[open].Type == lexer.TTPipe {
+				return i
+			}
+		}
+	}
+	return len(p.tokens)
+}
+
+// lastTokenIndex returns the index of the last token of expr, not counting enclosing parentheses
+func (p *printer) lastTokenIndex(expr parser.Expression) int {
+	if _, right, _, _, ok := binary(expr); ok {
+		return p.lastTokenIndex(right)
+	}
+	switch e := expr.(type) {
+	case parser.TernaryExpr:
+		return p.lastTokenIndex(e.FalseResult)
+	case parser.NegExpr:
+		return p.lastTokenIndex(e.Inner)
+	case parser.NotExpr:
+		return p.lastTokenIndex(e.Inner)
+	case parser.AtExpr:
+		return p.lastTokenIndex(e.Inner)
+	case parser.SampleExpr:
+		return p.lastTokenIndex(e.Point)
+	case parser.IndexExpr, parser.IndexRangeExpr, parser.CallExpr, parser.ListExpr, parser.HashMapExpr, parser.KernelExpr:
+		return p.matching(p.tokenIndex(e.Token()))
+	case parser.InvokeExpr:
+		return p.matching(p.tokenIndex(e.Token()) + 1)
+	case parser.FunctionExpr:
+		if ret, ok := arrowBody(e); ok {
+			return p.lastTokenIndex(ret.Result)
+		}
+		params := p.tokenIndex(e.Token()) + 1
+		return p.matching(p.blockOpen(p.matching(params)))
+	}
+	return p.tokenIndex(expr.Token())
+}
+
+// closingBrace returns the index of the brace closing the block that ends with last
+func (p *printer) closingBrace(last parser.Statement) int {
+	depth := 0
+	for i := p.tokenIndex(last.Token()); i >= 0 && i < len(p.tokens); i++ {
+		switch p.tokens[i].Type {
+		case lexer.TTLBrace, lexer.TTLParen, lexer.TTLBracket:
+			depth++
+		case lexer.TTRParen, lexer.TTRBracket:
+			depth--
+		case lexer.TTRBrace:
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(p.tokens)
+}
+
+func (p *printer) stmt(stmt parser.Statement) {
+	switch s := stmt.(type) {
+	case parser.DeclStmt:
+		if s.Param {
+			p.write("param ")
+		}
+		p.write(s.Ident + " := ")
+		p.expr(s.Rhs, precTernary)
+	case parser.AssignStmt:
+		p.write(s.Ident + " = ")
+		p.expr(s.Rhs, precTernary)
+	case parser.IndexedAssignStmt:
+		p.write(s.Ident + "[")
+		p.expr(s.Index, precTernary)
+		p.write("] = ")
+		p.expr(s.Rhs, precTernary)
+	case parser.PixelAssignStmt:
+		p.write("@")
+		p.expr(s.Lhs, precAtom)
+		p.write(" = ")
+		p.expr(s.Rhs, precTernary)
+	case parser.InvocationStmt:
+		p.expr(s.Invocation, precTernary)
+	case parser.IfStmt:
+		p.ifStmt(s)
+	case parser.ForStmt:
+		p.write("for " + s.Ident + " in ")
+		p.expr(s.Collection, precTernary)
+		p.write(" ")
+		p.block(s.Stmts, p.blockOpen(p.lastTokenIndex(s.Collection)))
+	case parser.ParallelForStmt:
+		p.write("parallel for " + s.Ident + " in ")
+		p.expr(s.Collection, precTernary)
+		header := p.lastTokenIndex(s.Collection)
+		for i, r := range s.Reductions {
+			if i == 0 {
+				p.write(" reduce ")
+			} else {
+				p.write(", ")
+			}
+			p.write(r.Ident)
+			if r.Merge != nil {
+				p.write(": ")
+				p.expr(r.Merge, precTernary)
+				header = p.lastTokenIndex(r.Merge)
+			}
+		}
+		p.write(" ")
+		p.block(s.Stmts, p.blockOpen(header))
+	case parser.ForRangeStmt:
+		p.write("for " + s.Ident + " in ")
+		p.expr(s.Lower, precTernary)
+		p.write(" .. ")
+		if step, ok := s.Step.(parser.NumberExpr); !ok || step.Token().Type != lexer.TTDotDot {
+			p.expr(s.Step, precTernary) // the step has been specified explicitly
+			p.write(" .. ")
+		}
+		p.expr(s.Upper, precTernary)
+		p.write(" ")
+		p.block(s.Stmts, p.blockOpen(p.lastTokenIndex(s.Upper)))
+	case parser.WhileStmt:
+		p.write("while ")
+		p.expr(s.Cond, precTernary)
+		p.write(" ")
+		p.block(s.Stmts, p.blockOpen(p.lastTokenIndex(s.Cond)))
+	case parser.YieldStmt:
+		p.write("yield ")
+		p.expr(s.Result, precTernary)
+	case parser.LogStmt:
+		p.write("log(")
+		p.exprList(s.Args)
+		p.write(")")
+	case parser.ReturnStmt:
+		p.write("return ")
+		p.expr(s.Result, precTernary)
+	case parser.BreakStmt:
+		p.write("break")
+	case parser.ContinueStmt:
+		p.write("continue")
+	}
+}
+
+func (p *printer) ifStmt(s parser.IfStmt) {
+	p.write("if ")
+	p.expr(s.Cond, precTernary)
+	p.write(" ")
+	open := p.blockOpen(p.lastTokenIndex(s.Cond))
+	p.block(s.TrueStmts, open)
+	elseOpen := p.blockEnd(s.TrueStmts, open) + 2 // the brace following 'else'
+	if s.FalseStmts == nil {
+		// empty else branches are dropped unless they contain comments
+		if p.token(elseOpen-1).Type == lexer.TTElse && p.token(elseOpen+1).Type == lexer.TTRBrace && p.commentBefore(p.token(elseOpen+1)) {
+			p.write(" else ")
+			p.emptyBlock(elseOpen)
+		}
+		return
+	}
+	p.write(" else ")
+	// the parser attributes the token of the outermost if to 'else if' branches
+	if elseIf, ok := s.FalseStmts[0].(parser.IfStmt); ok && len(s.FalseStmts) == 1 && elseIf.Token() == s.Token() {
+		p.ifStmt(elseIf)
+		return
+	}
+	p.block(s.FalseStmts, elseOpen)
+}
+
+func (p *printer) exprList(exprs []parser.Expression) {
+	outerInKernel := p.inKernel
+	p.inKernel = false
+	for i, expr := range exprs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(expr, precTernary)
+	}
+	p.inKernel = outerInKernel
+}
+
+// expr prints expr, enclosed in parentheses if it binds less tightly than prec
+func (p *printer) expr(expr parser.Expression, prec int) {
+	if precedence(expr) < prec || p.inKernel && isCall(expr) {
+		outerInKernel := p.inKernel
+		p.inKernel = false
+		p.write("(")
+		p.expr(expr, precTernary)
+		p.write(")")
+		p.inKernel = outerInKernel
+		return
+	}
+	if left, right, op, opPrec, ok := binary(expr); ok {
+		switch opPrec {
+		case precPipeline: // right-associative
+			p.expr(left, opPrec+1)
+			p.write(op)
+			p.expr(right, opPrec)
+		case precCond, precTuple: // not associative
+			p.expr(left, opPrec+1)
+			p.write(op)
+			p.expr(right, opPrec+1)
+		default:
+			p.expr(left, opPrec)
+			p.write(op)
+			p.expr(right, opPrec+1)
+		}
+		return
+	}
+	switch e := expr.(type) {
+	case parser.TernaryExpr:
+		p.expr(e.Cond, precPipeline)
+		p.write(" ? ")
+		p.expr(e.TrueResult, precPipeline)
+		p.write(" : ")
+		p.expr(e.FalseResult, precTernary)
+	case parser.NegExpr:
+		p.write("-")
+		p.expr(e.Inner, precUnary)
+	case parser.NotExpr:
+		p.write("not ")
+		p.expr(e.Inner, precUnary)
+	case parser.MemberExpr:
+		p.expr(e.Recvr, precPostfix)
+		p.write("." + e.Member)
+	case parser.IndexExpr:
+		p.expr(e.Recvr, precPostfix)
+		p.write("[")
+		p.expr(e.Index, precTernary)
+		p.write("]")
+	case parser.IndexRangeExpr:
+		p.expr(e.Recvr, precPostfix)
+		p.write("[")
+		p.expr(e.Lower, precTernary)
+		p.write(" .. ")
+		p.expr(e.Upper, precTernary)
+		p.write("]")
+	case parser.CallExpr:
+		p.expr(e.Callee, precPostfix)
+		p.write("(")
+		p.exprList(e.Args)
+		p.write(")")
+	case parser.InvokeExpr:
+		p.write(e.FuncName + "(")
+		p.exprList(e.Args)
+		p.write(")")
+	case parser.AtExpr:
+		p.write("@")
+		p.expr(e.Inner, precAtom)
+	case parser.SampleExpr:
+		p.expr(e.Image, precPostfix)
+		p.write("@")
+		p.expr(e.Point, precAtom)
+	case parser.IdentExpr:
+		p.write(e.Ident)
+	case parser.NumberExpr:
+		p.write(e.Token().Lexeme)
+	case parser.StrExpr:
+		p.write(e.Token().Lexeme)
+	case parser.ColorExpr:
+		p.write(e.Token().Lexeme)
+	case parser.BoolExpr:
+		p.write(e.Token().Lexeme)
+	case parser.NilExpr:
+		p.write("nil")
+	case parser.ImportExpr:
+		p.write(`import "` + e.Path + `"`)
+	case parser.FunctionExpr:
+		p.function(e)
+	case parser.KernelExpr:
+		p.kernel(e)
+	case parser.ListExpr:
+		p.list(e)
+	case parser.HashMapExpr:
+		p.hashMap(e)
+	}
+}
+
+func (p *printer) function(e parser.FunctionExpr) {
+	p.write("fn(")
+	paren := p.tokenIndex(e.Token()) + 1
+	p.paramList(e.ParameterNames, paren)
+	p.write(")")
+	if ret, ok := arrowBody(e); ok {
+		p.write(" -> ")
+		p.expr(ret.Result, precTernary)
+		return
+	}
+	p.write(" ")
+	outerInKernel := p.inKernel
+	p.inKernel = false
+	p.block(e.Body, p.blockOpen(p.matching(paren)))
+	p.inKernel = outerInKernel
+}
+
+// paramList prints the parameter names of a function literal whose parameter list starts with the
+// parenthesis with index paren. A parameter following a comment is continued on an indented line.
+func (p *printer) paramList(names []string, paren int) {
+	for i, name := range names {
+		if i > 0 {
+			p.write(",")
+		}
+		tok := p.token(paren + 1 + 2*i) // the parameters are separated by commas
+		if p.commentBefore(tok) {
+			p.lastLine = p.token(paren + 2*i).LineNumber
+			p.indent++
+			p.flushComments(tok)
+			p.newline()
+			p.write(name)
+			p.indent--
+			continue
+		}
+		if i > 0 {
+			p.write(" ")
+		}
+		p.write(name)
+	}
+	end := paren + 1
+	if len(names) > 0 {
+		end = paren + 2*len(names)
+	}
+	if p.commentBefore(p.token(end)) {
+		p.lastLine = p.token(end - 1).LineNumber
+		p.indent++
+		p.flushComments(p.token(end))
+		p.indent--
+		p.newline()
+	}
+}
+
+// arrowBody returns the statement of a function defined with the arrow syntax fn(x) -> expr
+func arrowBody(e parser.FunctionExpr) (parser.ReturnStmt, bool) {
+	if len(e.Body) != 1 {
+		return parser.ReturnStmt{}, false
+	}
+	ret, ok := e.Body[0].(parser.ReturnStmt)
+	return ret, ok && ret.Token().Type == lexer.TTArrow
+}
+
+// kernel prints a kernel literal. kernels written on multiple lines or containing comments
+// are printed as a grid with one row per line and right-aligned cells of equal width.
+// a row is broken after a comment.
+func (p *printer) kernel(e parser.KernelExpr) {
+	elements := make([]string, len(e.Elements))
+	for i, element := range e.Elements {
+		sub := printer{tokens: p.tokens, inKernel: true}
+		sub.expr(element, precUnary)
+		elements[i] = sub.buf.String()
+		if i > 0 && strings.HasPrefix(elements[i], "[") {
+			elements[i] = "(" + elements[i] + ")" // would index the preceding element otherwise
+		}
+	}
+	width := int(math.Sqrt(float64(len(elements))))
+	end := p.matching(p.tokenIndex(e.Token()))
+	comments := p.commentBefore(p.token(end))
+	if !comments && (width <= 1 || !p.multiline(e.Token(), e.Elements)) {
+		p.write("|" + strings.Join(elements, " ") + "|")
+		return
+	}
+	if width < 1 {
+		width = 1
+	}
+	cellWidth := 0
+	for _, element := range elements {
+		if n := utf8.RuneCountInString(element); n > cellWidth {
+			cellWidth = n
+		}
+	}
+	p.write("|")
+	column := p.column
+	p.lastLine = e.Token().LineNumber
+	for i, element := range elements {
+		breakRow := i > 0 && i%width == 0
+		if tok := firstToken(e.Elements[i]); p.commentBefore(tok) {
+			p.gridComments(tok, column)
+			breakRow = true
+		}
+		if breakRow {
+			p.newline()
+			p.bol = false
+			p.write(strings.Repeat(" ", column+i%width*(cellWidth+1)))
+		} else if i > 0 {
+			p.write(" ")
+		}
+		p.write(strings.Repeat(" ", cellWidth-utf8.RuneCountInString(element)) + element)
+		p.lastLine = p.token(p.lastTokenIndex(e.Elements[i])).LineNumber
+	}
+	if p.commentBefore(p.token(end)) {
+		p.gridComments(p.token(end), column)
+		p.newline()
+		p.bol = false
+		p.write(strings.Repeat(" ", column-1))
+	}
+	p.write("|")
+}
+
+// gridComments prints the pending comments before tok within a kernel grid starting at column
+func (p *printer) gridComments(tok lexer.Token, column int) {
+	for p.commentBefore(tok) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+		if comment.LineNumber == p.lastLine {
+			p.write(" ")
+		} else {
+			p.newline()
+			p.bol = false
+			p.write(strings.Repeat(" ", column))
+		}
+		p.write(strings.TrimRight(comment.Lexeme, " \t"))
+		p.lastLine = comment.LineNumber
+	}
+}
+
+// multiline returns true if the first element of a literal starting with open
+// or one of the following elements has been written on a separate line
+func (p *printer) multiline(open lexer.Token, elements []parser.Expression) bool {
+	for _, element := range elements {
+		if firstToken(element).LineNumber != open.LineNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// list prints a list literal. lists written on multiple lines or containing comments
+// are printed with one element per line.
+func (p *printer) list(e parser.ListExpr) {
+	p.write("[")
+	end := p.matching(p.tokenIndex(e.Token()))
+	if p.multiline(e.Token(), e.Elements) || p.commentBefore(p.token(end)) {
+		p.open = true
+		p.indent++
+		p.lastLine = e.Token().LineNumber
+		for _, element := range e.Elements {
+			p.literalLine(firstToken(element))
+			p.expr(element, precTernary)
+			p.write(",")
+		}
+		p.flushComments(p.token(end))
+		p.indent--
+		p.newline()
+	} else {
+		p.exprList(e.Elements)
+	}
+	p.write("]")
+}
+
+func (p *printer) hashMap(e parser.HashMapExpr) {
+	keys := make([]parser.Expression, len(e.Entries))
+	for i, entry := range e.Entries {
+		keys[i] = entry.Key
+	}
+	p.write("{")
+	end := p.matching(p.tokenIndex(e.Token()))
+	multiline := p.multiline(e.Token(), keys) || p.commentBefore(p.token(end))
+	if multiline {
+		p.open = true
+		p.indent++
+		p.lastLine = e.Token().LineNumber
+	} else if len(e.Entries) > 0 {
+		p.write(" ")
+	}
+	for i, entry := range e.Entries {
+		if multiline {
+			p.literalLine(firstToken(entry.Key))
+		} else if i > 0 {
+			p.write(", ")
+		}
+		if key, ok := entry.Key.(parser.StrExpr); ok && key.Token().Type == lexer.TTIdent {
+			p.write(key.Token().Lexeme)
+		} else {
+			p.expr(entry.Key, precTernary)
+		}
+		p.write(": ")
+		p.expr(entry.Value, precTernary)
+		if multiline {
+			p.write(",")
+		}
+	}
+	if multiline {
+		p.flushComments(p.token(end))
+		p.indent--
+		p.newline()
+	} else if len(e.Entries) > 0 {
+		p.write(" ")
+	}
+	p.write("}")
+}
+
+// literalLine starts a new line for an element of a multi-line list or hash map literal
+func (p *printer) literalLine(tok lexer.Token) {
+	p.flushComments(tok)
+	p.newline()
+	p.open = false
+	p.lastLine = tok.LineNumber
+}
+
+// firstToken returns the leftmost token of expr
+func firstToken(expr parser.Expression) lexer.Token {
+	if left, _, _, _, ok := binary(expr); ok {
+		return firstToken(left)
+	}
+	switch e := expr.(type) {
+	case parser.TernaryExpr:
+		return firstToken(e.Cond)
+	case parser.MemberExpr:
+		return firstToken(e.Recvr)
+	case parser.IndexExpr:
+		return firstToken(e.Recvr)
+	case parser.IndexRangeExpr:
+		return firstToken(e.Recvr)
+	case parser.SampleExpr:
+		return firstToken(e.Image)
+	case parser.CallExpr:
+		return firstToken(e.Callee)
+	}
+	return expr.Token()
+}
+
+// binary returns the operands, the operator and its precedence if expr is a binary expression
+func binary(expr parser.Expression) (left parser.Expression, right parser.Expression, op string, prec int, ok bool) {
+	switch e := expr.(type) {
+	case parser.PipelineExpr:
+		return e.Left, e.Right, " | ", precPipeline, true
+	case parser.OrExpr:
+		return e.Left, e.Right, " or ", precOr, true
+	case parser.AndExpr:
+		return e.Left, e.Right, " and ", precAnd, true
+	case parser.EqExpr:
+		return e.Left, e.Right, " == ", precCond, true
+	case parser.NeqExpr:
+		return e.Left, e.Right, " != ", precCond, true
+	case parser.GtExpr:
+		return e.Left, e.Right, " > ", precCond, true
+	case parser.GeExpr:
+		return e.Left, e.Right, " >= ", precCond, true
+	case parser.LtExpr:
+		return e.Left, e.Right, " < ", precCond, true
+	case parser.LeExpr:
+		return e.Left, e.Right, " <= ", precCond, true
+	case parser.ConcatExpr:
+		return e.Left, e.Right, " :: ", precConcat, true
+	case parser.PosExpr:
+		// simple points like x;y are written without spaces
+		if precedence(e.X) >= precUnary && precedence(e.Y) >= precUnary {
+			return e.X, e.Y, ";", precTuple, true
+		}
+		return e.X, e.Y, "; ", precTuple, true
+	case parser.AddExpr:
+		return e.Left, e.Right, " + ", precTerm, true
+	case parser.SubExpr:
+		return e.Left, e.Right, " - ", precTerm, true
+	case parser.InExpr:
+		return e.Left, e.Right, " in ", precTerm, true
+	case parser.MulExpr:
+		return e.Left, e.Right, " * ", precProduct, true
+	case parser.DivExpr:
+		return e.Left, e.Right, " / ", precProduct, true
+	case parser.ModExpr:
+		return e.Left, e.Right, " % ", precProduct, true
+	}
+	return nil, nil, "", 0, false
+}
+
+func precedence(expr parser.Expression) int {
+	if _, _, _, prec, ok := binary(expr); ok {
+		return prec
+	}
+	switch e := expr.(type) {
+	case parser.TernaryExpr:
+		return precTernary
+	case parser.NegExpr, parser.NotExpr:
+		return precUnary
+	case parser.MemberExpr, parser.IndexExpr, parser.IndexRangeExpr, parser.SampleExpr, parser.CallExpr:
+		return precPostfix
+	case parser.FunctionExpr:
+		if _, ok := arrowBody(e); ok {
+			return precTernary // the body extends as far as possible
+		}
+	}
+	return precAtom
+}
+
+// isCall returns true if expr is a call that cannot be written in a kernel without parentheses
+func isCall(expr parser.Expression) bool {
+	switch e := expr.(type) {
+	case parser.CallExpr:
+		return true
+	case parser.MemberExpr:
+		return isCall(e.Recvr)
+	case parser.IndexExpr:
+		return isCall(e.Recvr)
+	case parser.IndexRangeExpr:
+		return isCall(e.Recvr)
+	case parser.SampleExpr:
+		return isCall(e.Image)
+	}
+	return false
+}
@@ -0,0 +1,311 @@
+package format
+
+import (
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "spacing",
+			src:  "x:=1+2*3\ny  :=  x;x*2\nz := (x;x)|$.x",
+			want: "x := 1 + 2 * 3\ny := x; x * 2\nz := x;x | $.x\n",
+		},
+		{
+			name: "parentheses",
+			src:  "x := (1 + 2) * -(3 - 4) - (5 - 6)\ny := @(1;2).r\nz := (fn(a) -> a)(1)",
+			want: "x := (1 + 2) * -(3 - 4) - (5 - 6)\ny := @(1;2).r\nz := (fn(a) -> a)(1)\n",
+		},
+		{
+			name: "sample",
+			src:  "x := Images.bg@(1;2).r\ny := b@p @p = y\nz := |a@p  b@(0;0) 1 2|",
+			want: "x := Images.bg@(1;2).r\ny := b@p\n@p = y\nz := |a@p b@(0;0) 1 2|\n",
+		},
+		{
+			name: "indentation",
+			src: `for p in Bounds {
+  if p.x>1 { @p=Black }
+	else if p.y > 1 {
+    @p = White } else { log(p) }
+}`,
+			want: `for p in Bounds {
+    if p.x > 1 {
+        @p = Black
+    } else if p.y > 1 {
+        @p = White
+    } else {
+        log(p)
+    }
+}
+`,
+		},
+		{
+			name: "comments",
+			src: `// header
+
+x := 1 // one
+
+
+// two
+y := fn(a) { // function
+    return a
+    // end of function
+}
+// end`,
+			want: `// header
+
+x := 1 // one
+
+// two
+y := fn(a) { // function
+    return a
+    // end of function
+}
+// end
+`,
+		},
+		{
+			name: "kernel_grid",
+			src: `k := |1 0 -1
+2 0 -2
+  1 0 -1|
+l := |1 2 3 4|`,
+			want: `k := | 1  0 -1
+       2  0 -2
+       1  0 -1|
+l := |1 2 3 4|
+`,
+		},
+		{
+			name: "kernel_elements",
+			src:  "k := |(a + 1) (m.f(x)) ($[0]) ([1, 2][0])|",
+			want: "k := |(a + 1) (m.f(x)) $[0] ([1, 2][0])|\n",
+		},
+		{
+			name: "literals",
+			src: `m := {a:1,b:[1,2,],"c d": {}}
+n := [
+  1,
+  {x: 2,
+  y: 3}
+]`,
+			want: `m := { a: 1, b: [1, 2], "c d": {} }
+n := [
+    1,
+    {
+        x: 2,
+        y: 3,
+    },
+]
+`,
+		},
+		{
+			name: "loops",
+			src:  "for i in 0..2..10 { break }\nfor i in 0..10 { continue }\nparallel for p in Bounds reduce n, m: fn(a, b) -> a :: b { n = n + 1 }\ng := fn() { while true { yield 1 } }",
+			want: "for i in 0 .. 2 .. 10 {\n    break\n}\nfor i in 0 .. 10 {\n    continue\n}\nparallel for p in Bounds reduce n, m: fn(a, b) -> a :: b {\n    n = n + 1\n}\ng := fn() {\n    while true {\n        yield 1\n    }\n}\n",
+		},
+		{
+			name: "params",
+			src:  "param  threshold:=200\nparam tint := #ff0000",
+			want: "param threshold := 200\nparam tint := #ff0000\n",
+		},
+		{
+			name: "crlf",
+			src:  "x := 1 // one\r\ny := 2\r\n",
+			want: "x := 1 // one\r\ny := 2\r\n",
+		},
+		{
+			name: "comments_in_empty_blocks",
+			src: `if true { // note
+}
+for i in 0..3 {
+  // nothing yet
+}
+f := fn() { } // no comment`,
+			want: `if true { // note
+}
+for i in 0 .. 3 {
+    // nothing yet
+}
+f := fn() {} // no comment
+`,
+		},
+		{
+			name: "comments_in_empty_else",
+			src: `if x > 0 { log(x) } else { // never
+}
+if x > 0 { log(x) } else if x < 0 {
+  // negative
+} else {}`,
+			want: `if x > 0 {
+    log(x)
+} else { // never
+}
+if x > 0 {
+    log(x)
+} else if x < 0 {
+    // negative
+}
+`,
+		},
+		{
+			name: "comments_in_kernel",
+			src: `k := |1 0 // first
+0 1
+// last
+|`,
+			want: `k := |1 0 // first
+      0 1
+      // last
+     |
+`,
+		},
+		{
+			name: "comments_in_params",
+			src: `f := fn(a, // first
+b // second
+) -> a + b`,
+			want: `f := fn(a, // first
+    b // second
+) -> a + b
+`,
+		},
+		{
+			name: "comments_in_literals",
+			src: `l := [ // empty
+]
+m := [1, 2 // two
+]
+h := {a: 1, // a
+b: 2}`,
+			want: `l := [ // empty
+]
+m := [
+    1,
+    2, // two
+]
+h := {
+    a: 1, // a
+    b: 2,
+}
+`,
+		},
+		{
+			name:    "syntax_error",
+			src:     "x := (1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Source(tt.src)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Source() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Source() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if again, _ := Source(got); !tt.wantErr && again != got {
+				t.Errorf("formatting is not idempotent:\n%s", Diff("formatted", "again", got, again))
+			}
+		})
+	}
+}
+
+// TestSource_samples verifies that formatting the samples preserves their meaning
+// and that formatted code is not changed by formatting it again
+func TestSource_samples(t *testing.T) {
+	paths, err := filepath.Glob("../../samples/*.ylang")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no samples found: %v", err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			src, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			formatted, err := Source(string(src))
+			if err != nil {
+				t.Fatalf("Source() error = %v", err)
+			}
+			if !reflect.DeepEqual(parse(t, formatted), parse(t, string(src))) {
+				t.Errorf("formatted program differs from the original:\n%s", formatted)
+			}
+			if again, _ := Source(formatted); again != formatted {
+				t.Errorf("formatting is not idempotent:\n%s", Diff("formatted", "again", formatted, again))
+			}
+			_, comments, _ := lexer.LexWithComments(string(src))
+			_, formattedComments, _ := lexer.LexWithComments(formatted)
+			if len(formattedComments) != len(comments) {
+				t.Errorf("formatted code has %d comments, want %d", len(formattedComments), len(comments))
+			}
+		})
+	}
+}
+
+func parse(t *testing.T, src string) parser.Program {
+	tokens, err := lexer.Lex(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parser.Parse(tokens, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prog
+}
+
+func TestExpr(t *testing.T) {
+	tokens, err := lexer.Lex("a := W/2+1 // comment\nb := [1,2]\nc := fn(x)->x*2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := parser.Parse(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"W / 2 + 1", "[1, 2]", "fn(x) -> x * 2"}
+	for i, stmt := range prog.Stmts {
+		if got := Expr(stmt.(parser.DeclStmt).Rhs); got != want[i] {
+			t.Errorf("Expr() = %q, want %q", got, want[i])
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+	want := `--- a
++++ b
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`
+	if got := Diff("a", "b", a, b); got != want {
+		t.Errorf("Diff() =\n%s\nwant\n%s", got, want)
+	}
+	if got := Diff("a", "b", a, a); got != "" {
+		t.Errorf("Diff() of equal texts = %q, want empty", got)
+	}
+}
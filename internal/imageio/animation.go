@@ -0,0 +1,42 @@
+package imageio
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// EncodeAnimation writes frames as animated GIF to w, which loops forever. Each frame is shown
+// for the delay with the same index, rounded to the hundredths of a second GIF supports.
+// Each frame gets its own palette of at most options.Colors colors.
+func EncodeAnimation(w io.Writer, frames []image.Image, delays []time.Duration, options Options) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	if options.Format != "gif" {
+		return fmt.Errorf("animations are only supported by gif, not by %s", options.Format)
+	}
+	if len(frames) == 0 || len(frames) != len(delays) {
+		return fmt.Errorf("animation has %d frames and %d delays", len(frames), len(delays))
+	}
+	anim := gif.GIF{
+		Image: make([]*image.Paletted, len(frames)),
+		Delay: make([]int, len(frames)),
+	}
+	bounds := image.Rectangle{}
+	for i, frame := range frames {
+		frame = withDepth(frame, Depth8)
+		palette := MedianCut{}.Quantize(make(color.Palette, 0, options.colors()), frame)
+		paletted := image.NewPaletted(frame.Bounds(), palette)
+		options.drawer().Draw(paletted, paletted.Rect, frame, frame.Bounds().Min)
+		anim.Image[i] = paletted
+		anim.Delay[i] = int((delays[i] + 5*time.Millisecond) / (10 * time.Millisecond))
+		bounds = bounds.Union(frame.Bounds())
+	}
+	// frames may differ in size if scripts resize the target image between frames
+	anim.Config = image.Config{Width: bounds.Max.X, Height: bounds.Max.Y}
+	return gif.EncodeAll(w, &anim)
+}
@@ -0,0 +1,105 @@
+package imageio
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// FloatImage is an image whose channels are float values that are neither clamped nor rounded.
+// A channel value of 1 is full intensity.
+type FloatImage interface {
+	image.Image
+	// FloatAt returns the channels of the pixel at x;y
+	FloatAt(x, y int) (r, g, b, a float32)
+}
+
+// Float is an in-memory FloatImage
+type Float struct {
+	// Pix holds the channels of the pixels in R, G, B, A order.
+	// The pixel at x;y starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	Pix    []float32
+	Stride int // the number of channels of a row
+	Rect   image.Rectangle
+}
+
+// NewFloat returns a transparent Float image with the bounds r
+func NewFloat(r image.Rectangle) *Float {
+	return &Float{
+		Pix:    make([]float32, r.Dx()*r.Dy()*4),
+		Stride: r.Dx() * 4,
+		Rect:   r,
+	}
+}
+
+func (f *Float) ColorModel() color.Model {
+	return color.NRGBA64Model
+}
+
+func (f *Float) Bounds() image.Rectangle {
+	return f.Rect
+}
+
+// At returns the color of the pixel at x;y with the channels clamped to 0..1
+func (f *Float) At(x, y int) color.Color {
+	r, g, b, a := f.FloatAt(x, y)
+	return color.NRGBA64{R: clamp16(r), G: clamp16(g), B: clamp16(b), A: clamp16(a)}
+}
+
+func (f *Float) FloatAt(x, y int) (r, g, b, a float32) {
+	if !(image.Point{X: x, Y: y}.In(f.Rect)) {
+		return 0, 0, 0, 0
+	}
+	i := f.offset(x, y)
+	return f.Pix[i], f.Pix[i+1], f.Pix[i+2], f.Pix[i+3]
+}
+
+// SetFloat sets the channels of the pixel at x;y
+func (f *Float) SetFloat(x, y int, r, g, b, a float32) {
+	if !(image.Point{X: x, Y: y}.In(f.Rect)) {
+		return
+	}
+	i := f.offset(x, y)
+	f.Pix[i], f.Pix[i+1], f.Pix[i+2], f.Pix[i+3] = r, g, b, a
+}
+
+func (f *Float) offset(x, y int) int {
+	return (y-f.Rect.Min.Y)*f.Stride + (x-f.Rect.Min.X)*4
+}
+
+func clamp16(v float32) uint16 {
+	return uint16(math.Max(0, math.Min(1, float64(v)))*0xffff + 0.5)
+}
+
+// floatAt returns the channels of the pixel of img at x;y as float values
+func floatAt(img image.Image, x, y int) (r, g, b, a float32) {
+	if f, ok := img.(FloatImage); ok {
+		return f.FloatAt(x, y)
+	}
+	c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+	return float32(c.R) / 0xffff, float32(c.G) / 0xffff, float32(c.B) / 0xffff, float32(c.A) / 0xffff
+}
+
+// withDepth returns img with channels of the specified depth, converting it if necessary
+func withDepth(img image.Image, depth Depth) image.Image {
+	switch depth {
+	case Depth16:
+		switch img.(type) {
+		case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+			return img
+		}
+		dst := image.NewNRGBA64(img.Bounds())
+		draw.Draw(dst, dst.Rect, img, img.Bounds().Min, draw.Src)
+		return dst
+	case DepthFloat:
+		return img
+	}
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16, FloatImage:
+		dst := image.NewNRGBA(img.Bounds())
+		draw.Draw(dst, dst.Rect, img, img.Bounds().Min, draw.Src)
+		return dst
+	}
+	return img
+}
@@ -0,0 +1,177 @@
+// Package imageio encodes images in the file formats supported by ylang and registers
+// the decoders of these formats with the image package.
+package imageio
+
+import (
+	"fmt"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Formats lists the names of all supported image formats
+var Formats = []string{"png", "jpeg", "gif", "bmp", "tiff", "pfm"}
+
+// extensions maps the file extensions of images to format names
+var extensions = map[string]string{
+	".png":  "png",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".gif":  "gif",
+	".bmp":  "bmp",
+	".tif":  "tiff",
+	".tiff": "tiff",
+	".pfm":  "pfm",
+}
+
+// Depth is the precision of the channels of encoded images
+type Depth int
+
+const (
+	Depth8     Depth = 8  // 8 bit integer channels
+	Depth16    Depth = 16 // 16 bit integer channels, supported by png and tiff
+	DepthFloat Depth = 32 // unclamped 32 bit float channels, supported by pfm
+)
+
+// ParseDepth parses the name of a channel depth: 8, 16 or float
+func ParseDepth(s string) (Depth, error) {
+	switch s {
+	case "8":
+		return Depth8, nil
+	case "16":
+		return Depth16, nil
+	case "float":
+		return DepthFloat, nil
+	}
+	return 0, fmt.Errorf("unknown depth '%s', expected 8, 16 or float", s)
+}
+
+// Options control the encoding of images
+type Options struct {
+	Format      string               // the name of the image format, one of Formats
+	Depth       Depth                // the precision of the channels, 0 for the only or lowest depth the format supports
+	Quality     int                  // the quality of JPEG images from 1 to 100, 0 for jpeg.DefaultQuality
+	Compression png.CompressionLevel // the compression of PNG images, also used for TIFF images unless png.NoCompression
+	Colors      int                  // the maximum number of palette colors of GIF images from 2 to 256, 0 for 256
+	Dither      bool                 // if true, GIF images are dithered with Floyd-Steinberg error diffusion
+}
+
+// FormatOf returns the name of the format of the image file path by its extension.
+// Paths without extension default to png.
+func FormatOf(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return "png", nil
+	}
+	if format, ok := extensions[ext]; ok {
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown image format '%s'", ext)
+}
+
+// ParseCompression parses the name of a PNG compression level: default, none, speed or best
+func ParseCompression(s string) (png.CompressionLevel, error) {
+	switch s {
+	case "default", "":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "speed":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	}
+	return 0, fmt.Errorf("unknown compression '%s', expected default, none, speed or best", s)
+}
+
+// Validate returns an error if options are out of range or name an unknown format
+func (o Options) Validate() error {
+	known := false
+	for _, format := range Formats {
+		known = known || o.Format == format
+	}
+	if !known {
+		return fmt.Errorf("unknown image format '%s', expected one of %s", o.Format, strings.Join(Formats, ", "))
+	}
+	switch {
+	case o.Depth == Depth16 && o.Format != "png" && o.Format != "tiff":
+		return fmt.Errorf("depth 16 is only supported by png and tiff, not by %s", o.Format)
+	case o.Depth == DepthFloat && o.Format != "pfm":
+		return fmt.Errorf("depth float is only supported by pfm, not by %s", o.Format)
+	case o.Format == "pfm" && o.Depth != 0 && o.Depth != DepthFloat:
+		return fmt.Errorf("pfm only supports depth float")
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("JPEG quality %d is out of range 1..100", o.Quality)
+	}
+	if o.Colors != 0 && (o.Colors < 2 || o.Colors > 256) {
+		return fmt.Errorf("number of GIF colors %d is out of range 2..256", o.Colors)
+	}
+	return nil
+}
+
+// EffectiveDepth returns the depth images are encoded with, which is the depth of o or the
+// depth of its format if o specifies none
+func (o Options) EffectiveDepth() Depth {
+	if o.Depth != 0 {
+		return o.Depth
+	}
+	if o.Format == "pfm" {
+		return DepthFloat
+	}
+	return Depth8
+}
+
+// Encode writes img to w in the format and depth specified by options. img is converted to
+// the depth if necessary. Float images should implement FloatImage to keep unclamped values.
+func Encode(w io.Writer, img image.Image, options Options) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	img = withDepth(img, options.EffectiveDepth())
+	switch options.Format {
+	case "pfm":
+		return encodePFM(w, img)
+	case "jpeg":
+		quality := options.Quality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		return gif.Encode(w, img, &gif.Options{NumColors: options.colors(), Quantizer: MedianCut{}, Drawer: options.drawer()})
+	case "bmp":
+		return bmp.Encode(w, img)
+	case "tiff":
+		compression := tiff.Deflate
+		if options.Compression == png.NoCompression {
+			compression = tiff.Uncompressed
+		}
+		return tiff.Encode(w, img, &tiff.Options{Compression: compression})
+	}
+	encoder := png.Encoder{CompressionLevel: options.Compression}
+	return encoder.Encode(w, img)
+}
+
+// colors returns the number of palette colors of GIF images
+func (o Options) colors() int {
+	if o.Colors == 0 {
+		return 256
+	}
+	return o.Colors
+}
+
+// drawer returns the drawer mapping the colors of GIF images to their palette
+func (o Options) drawer() draw.Drawer {
+	if o.Dither {
+		return draw.FloydSteinberg
+	}
+	return draw.Src
+}
@@ -0,0 +1,272 @@
+package imageio
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestImage returns an image with a horizontal gradient of red and a vertical gradient of green
+func newTestImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		options   Options
+		tolerance int // the maximum mean difference of the channels after decoding
+	}{
+		{options: Options{Format: "png"}},
+		{options: Options{Format: "png", Compression: png.BestCompression}},
+		{options: Options{Format: "jpeg", Quality: 100}, tolerance: 8},
+		{options: Options{Format: "gif"}, tolerance: 4},
+		{options: Options{Format: "gif", Colors: 4}, tolerance: 24},
+		{options: Options{Format: "bmp"}},
+		{options: Options{Format: "tiff"}},
+		{options: Options{Format: "tiff", Compression: png.NoCompression}},
+		{options: Options{Format: "png", Depth: Depth16}},
+		{options: Options{Format: "tiff", Depth: Depth16}},
+		{options: Options{Format: "pfm"}},
+	}
+	src := newTestImage()
+	for _, tt := range tests {
+		t.Run(tt.options.Format, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := Encode(&buf, src, tt.options); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			img, format, err := image.Decode(&buf)
+			if err != nil {
+				t.Fatalf("image.Decode() error = %v", err)
+			}
+			if format != tt.options.Format {
+				t.Errorf("decoded format = %s, want %s", format, tt.options.Format)
+			}
+			if img.Bounds() != src.Bounds() {
+				t.Fatalf("decoded bounds = %v, want %v", img.Bounds(), src.Bounds())
+			}
+			total := 0
+			for y := 0; y < 64; y++ {
+				for x := 0; x < 64; x++ {
+					got := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+					want := src.NRGBAAt(x, y)
+					total += diff(got.R, want.R) + diff(got.G, want.G) + diff(got.B, want.B)
+				}
+			}
+			if mean := total / (64 * 64 * 3); mean > tt.tolerance {
+				t.Errorf("mean difference of the channels = %d, want <= %d", mean, tt.tolerance)
+			}
+		})
+	}
+}
+
+func diff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestEncode_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		want    string
+	}{
+		{name: "format", options: Options{Format: "webp"}, want: "unknown image format 'webp', expected one of png, jpeg, gif, bmp, tiff, pfm"},
+		{name: "quality", options: Options{Format: "jpeg", Quality: 101}, want: "JPEG quality 101 is out of range 1..100"},
+		{name: "colors", options: Options{Format: "gif", Colors: 1}, want: "number of GIF colors 1 is out of range 2..256"},
+		{name: "depth16", options: Options{Format: "jpeg", Depth: Depth16}, want: "depth 16 is only supported by png and tiff, not by jpeg"},
+		{name: "depth_float", options: Options{Format: "tiff", Depth: DepthFloat}, want: "depth float is only supported by pfm, not by tiff"},
+		{name: "pfm_depth", options: Options{Format: "pfm", Depth: Depth8}, want: "pfm only supports depth float"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Encode(&bytes.Buffer{}, newTestImage(), tt.options)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("Encode() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode_depth(t *testing.T) {
+	src := image.NewNRGBA64(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA64(0, 0, color.NRGBA64{R: 0x1234, G: 0xfedc, B: 1, A: 0xffff})
+	src.SetNRGBA64(1, 0, color.NRGBA64{R: 0xffff, A: 0xffff})
+	tests := []struct {
+		options Options
+		want    color.NRGBA64
+	}{
+		{options: Options{Format: "png"}, want: color.NRGBA64{R: 0x1212, G: 0xfefe, A: 0xffff}},
+		{options: Options{Format: "png", Depth: Depth16}, want: color.NRGBA64{R: 0x1234, G: 0xfedc, B: 1, A: 0xffff}},
+		{options: Options{Format: "tiff", Depth: Depth16}, want: color.NRGBA64{R: 0x1234, G: 0xfedc, B: 1, A: 0xffff}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s%d", tt.options.Format, tt.options.EffectiveDepth()), func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := Encode(&buf, src, tt.options); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			img, _, err := image.Decode(&buf)
+			if err != nil {
+				t.Fatalf("image.Decode() error = %v", err)
+			}
+			if got := color.NRGBA64Model.Convert(img.At(0, 0)); got != tt.want {
+				t.Errorf("pixel = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPFM(t *testing.T) {
+	src := NewFloat(image.Rect(0, 0, 3, 2))
+	src.SetFloat(0, 0, -1.5, 0.25, 1000, 1)
+	src.SetFloat(2, 1, 0.5, 1, 0, 1)
+	buf := bytes.Buffer{}
+	if err := Encode(&buf, src, Options{Format: "pfm"}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if header := "PF\n3 2\n-1.0\n"; !strings.HasPrefix(buf.String(), header) || buf.Len() != len(header)+3*2*3*4 {
+		t.Fatalf("encoded %d bytes %q...", buf.Len(), buf.String()[:12])
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil || format != "pfm" || config.Width != 3 || config.Height != 2 {
+		t.Errorf("image.DecodeConfig() = %v, %s, %v", config, format, err)
+	}
+	img, _, err := image.Decode(&buf)
+	if err != nil {
+		t.Fatalf("image.Decode() error = %v", err)
+	}
+	float := img.(FloatImage)
+	for _, pt := range []image.Point{{0, 0}, {1, 0}, {2, 1}} {
+		r, g, b, _ := float.FloatAt(pt.X, pt.Y)
+		wantR, wantG, wantB, _ := src.FloatAt(pt.X, pt.Y)
+		if r != wantR || g != wantG || b != wantB {
+			t.Errorf("pixel %v = %v %v %v, want %v %v %v", pt, r, g, b, wantR, wantG, wantB)
+		}
+	}
+	if got := img.At(0, 0); got != (color.NRGBA64{G: 0x4000, B: 0xffff, A: 0xffff}) {
+		t.Errorf("At(0, 0) = %v, want clamped color", got)
+	}
+
+	big := bytes.NewBufferString("Pf\n1 1\n1.0\n\x3f\x80\x00\x00") // grayscale, big endian
+	if img, err = decodePFM(big); err != nil {
+		t.Fatalf("decodePFM() error = %v", err)
+	}
+	if r, g, b, a := img.(FloatImage).FloatAt(0, 0); r != 1 || g != 1 || b != 1 || a != 1 {
+		t.Errorf("grayscale pixel = %v %v %v %v, want 1 1 1 1", r, g, b, a)
+	}
+	if _, err := decodePFM(bytes.NewBufferString("PF\n2 1\n-1.0\n\x00")); err == nil {
+		t.Errorf("decodePFM() of truncated data succeeded")
+	}
+}
+
+func TestEncodeAnimation(t *testing.T) {
+	red := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	draw.Draw(red, red.Rect, image.NewUniform(color.NRGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	small := image.NewNRGBA(image.Rect(0, 0, 2, 3))
+	draw.Draw(small, small.Rect, image.NewUniform(color.NRGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+	frames := []image.Image{red, newTestImage(), small}
+	delays := []time.Duration{40 * time.Millisecond, time.Second, 1234 * time.Millisecond}
+
+	for _, dither := range []bool{false, true} {
+		buf := bytes.Buffer{}
+		if err := EncodeAnimation(&buf, frames, delays, Options{Format: "gif", Dither: dither}); err != nil {
+			t.Fatalf("EncodeAnimation() error = %v", err)
+		}
+		anim, err := gif.DecodeAll(&buf)
+		if err != nil {
+			t.Fatalf("gif.DecodeAll() error = %v", err)
+		}
+		if len(anim.Image) != 3 {
+			t.Fatalf("len(frames) = %d, want 3", len(anim.Image))
+		}
+		if got := fmt.Sprint(anim.Delay); got != "[4 100 123]" {
+			t.Errorf("delays = %s, want [4 100 123]", got)
+		}
+		if anim.Config.Width != 64 || anim.Config.Height != 64 || anim.LoopCount != 0 {
+			t.Errorf("config = %v, loop count = %d, want 64x64 looping forever", anim.Config, anim.LoopCount)
+		}
+		if got := anim.Image[0].At(3, 1); got != (color.RGBA{R: 255, A: 255}) {
+			t.Errorf("pixel of first frame = %v, want red", got)
+		}
+		if got := anim.Image[2].At(1, 2); got != (color.RGBA{B: 255, A: 255}) {
+			t.Errorf("pixel of last frame = %v, want blue", got)
+		}
+	}
+
+	if err := EncodeAnimation(&bytes.Buffer{}, frames, delays, Options{Format: "png"}); err == nil || err.Error() != "animations are only supported by gif, not by png" {
+		t.Errorf("EncodeAnimation() of png error = %v", err)
+	}
+	if err := EncodeAnimation(&bytes.Buffer{}, frames, delays[:1], Options{Format: "gif"}); err == nil {
+		t.Errorf("EncodeAnimation() with missing delays succeeded")
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "out.png", want: "png"},
+		{path: "dir.v2/out.JPG", want: "jpeg"},
+		{path: "out.jpeg", want: "jpeg"},
+		{path: "out.gif", want: "gif"},
+		{path: "out.bmp", want: "bmp"},
+		{path: "out.tif", want: "tiff"},
+		{path: "out", want: "png"},
+		{path: "out.webp", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := FormatOf(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FormatOf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("FormatOf() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMedianCut(t *testing.T) {
+	// a transparent pixel, 16 shades of red and blue
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 2))
+	for x := 0; x < 16; x++ {
+		img.SetNRGBA(x, 0, color.NRGBA{R: uint8(x * 16), A: 255})
+		img.SetNRGBA(x, 1, color.NRGBA{B: 255, A: 255})
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{})
+
+	p := MedianCut{}.Quantize(make(color.Palette, 0, 8), img)
+	if len(p) != 8 {
+		t.Fatalf("len(palette) = %d, want 8", len(p))
+	}
+	if p[0] != (color.RGBA{}) {
+		t.Errorf("palette[0] = %v, want transparent", p[0])
+	}
+	if blue := p.Convert(color.NRGBA{B: 255, A: 255}); blue != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("nearest palette color of blue = %v", blue)
+	}
+
+	p = MedianCut{}.Quantize(make(color.Palette, 0, 256), img)
+	if len(p) != 1+15+1 {
+		t.Errorf("len(palette) = %d, want %d", len(p), 17)
+	}
+}
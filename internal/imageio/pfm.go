@@ -0,0 +1,140 @@
+package imageio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+)
+
+// The Portable Float Map format stores RGB (PF) or grayscale (Pf) images as 32 bit floats.
+// The header consists of the magic, the width and height and a scale whose sign denotes
+// the byte order, negative for little endian. The rows follow from bottom to top.
+
+func init() {
+	image.RegisterFormat("pfm", "PF", decodePFM, decodePFMConfig)
+	image.RegisterFormat("pfm", "Pf", decodePFM, decodePFMConfig)
+}
+
+// encodePFM writes the RGB channels of img to w as little endian PFM. Alpha is dropped.
+func encodePFM(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", bounds.Dx(), bounds.Dy()); err != nil {
+		return err
+	}
+	row := make([]byte, bounds.Dx()*3*4)
+	for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
+		i := 0
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := floatAt(img, x, y)
+			for _, v := range []float32{r, g, b} {
+				binary.LittleEndian.PutUint32(row[i:], math.Float32bits(v))
+				i += 4
+			}
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type pfmHeader struct {
+	channels  int // 3 for PF, 1 for Pf
+	width     int
+	height    int
+	byteOrder binary.ByteOrder
+}
+
+func readPFMHeader(r *bufio.Reader) (pfmHeader, error) {
+	var fields [4]string
+	for i := range fields {
+		field, err := readPFMField(r)
+		if err != nil {
+			return pfmHeader{}, fmt.Errorf("pfm: invalid header: %s", err)
+		}
+		fields[i] = field
+	}
+	h := pfmHeader{channels: 3, byteOrder: binary.BigEndian}
+	switch fields[0] {
+	case "PF":
+	case "Pf":
+		h.channels = 1
+	default:
+		return pfmHeader{}, fmt.Errorf("pfm: invalid magic '%s'", fields[0])
+	}
+	var err error
+	if h.width, err = strconv.Atoi(fields[1]); err != nil || h.width <= 0 {
+		return pfmHeader{}, fmt.Errorf("pfm: invalid width '%s'", fields[1])
+	}
+	if h.height, err = strconv.Atoi(fields[2]); err != nil || h.height <= 0 {
+		return pfmHeader{}, fmt.Errorf("pfm: invalid height '%s'", fields[2])
+	}
+	scale, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil || scale == 0 {
+		return pfmHeader{}, fmt.Errorf("pfm: invalid scale '%s'", fields[3])
+	}
+	if scale < 0 {
+		h.byteOrder = binary.LittleEndian
+	}
+	return h, nil
+}
+
+// readPFMField reads a whitespace-terminated header field, consuming the terminating whitespace
+func readPFMField(r *bufio.Reader) (string, error) {
+	var field []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			if len(field) > 0 {
+				return string(field), nil
+			}
+		default:
+			field = append(field, c)
+		}
+	}
+}
+
+func decodePFMConfig(r io.Reader) (image.Config, error) {
+	h, err := readPFMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.NRGBA64Model, Width: h.width, Height: h.height}, nil
+}
+
+// decodePFM reads a PFM image into an opaque *Float
+func decodePFM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readPFMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	img := NewFloat(image.Rect(0, 0, h.width, h.height))
+	row := make([]byte, h.width*h.channels*4)
+	for y := h.height - 1; y >= 0; y-- {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("pfm: %s", err)
+		}
+		for x := 0; x < h.width; x++ {
+			var ch [3]float32
+			for c := 0; c < h.channels; c++ {
+				ch[c] = math.Float32frombits(h.byteOrder.Uint32(row[(x*h.channels+c)*4:]))
+			}
+			if h.channels == 1 {
+				ch[1], ch[2] = ch[0], ch[0]
+			}
+			img.SetFloat(x, y, ch[0], ch[1], ch[2], 1)
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,154 @@
+package imageio
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// MedianCut is a draw.Quantizer that builds a palette adapted to the colors of an image.
+// The opaque colors are put into a box that is repeatedly split at the median of its widest
+// channel until the palette is full, each box then contributes the mean of its colors.
+// Images with transparent pixels reserve one palette entry for transparency.
+type MedianCut struct{}
+
+// histogramBits is the number of bits per channel colors are reduced to before they are counted
+const histogramBits = 5
+
+// bucket counts the pixels whose colors are equal when reduced to histogramBits per channel
+type bucket struct {
+	key   [3]uint8 // the reduced channels
+	count int
+	sum   [3]int // the sums of the 8 bit channels of all counted pixels
+}
+
+type box []*bucket
+
+// Quantize appends up to cap(p)-len(p) colors to p that represent the colors of m
+func (MedianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	size := cap(p) - len(p)
+	if size <= 0 {
+		return p
+	}
+	buckets, transparent := histogram(m)
+	if transparent {
+		p = append(p, color.RGBA{})
+		size--
+	}
+	if size <= 0 || len(buckets) == 0 {
+		return p
+	}
+	boxes := []box{buckets}
+	for len(boxes) < size {
+		index, channel := widestBox(boxes)
+		if index < 0 {
+			break
+		}
+		lower, upper := boxes[index].split(channel)
+		boxes[index] = lower
+		boxes = append(boxes, upper)
+	}
+	for _, b := range boxes {
+		p = append(p, b.mean())
+	}
+	return p
+}
+
+// histogram counts the opaque colors of m and reports whether m has transparent pixels
+func histogram(m image.Image) (box, bool) {
+	index := make(map[[3]uint8]*bucket)
+	var buckets box
+	transparent := false
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				transparent = true
+				continue
+			}
+			key := [3]uint8{c.R >> (8 - histogramBits), c.G >> (8 - histogramBits), c.B >> (8 - histogramBits)}
+			b, ok := index[key]
+			if !ok {
+				b = &bucket{key: key}
+				index[key] = b
+				buckets = append(buckets, b)
+			}
+			b.count++
+			b.sum[0] += int(c.R)
+			b.sum[1] += int(c.G)
+			b.sum[2] += int(c.B)
+		}
+	}
+	return buckets, transparent
+}
+
+// widestBox returns the index of the box with the widest channel range that can be split and
+// that channel, weighting ranges by the pixel count of each box. Returns -1 if no box can be split.
+func widestBox(boxes []box) (int, int) {
+	bestIndex, bestChannel, bestScore := -1, 0, 0
+	for i, b := range boxes {
+		if len(b) < 2 {
+			continue
+		}
+		channel, width := b.widestChannel()
+		if score := width * b.count(); score > bestScore {
+			bestIndex, bestChannel, bestScore = i, channel, score
+		}
+	}
+	return bestIndex, bestChannel
+}
+
+func (b box) count() int {
+	n := 0
+	for _, bu := range b {
+		n += bu.count
+	}
+	return n
+}
+
+// widestChannel returns the channel with the greatest range of reduced values and that range
+func (b box) widestChannel() (int, int) {
+	channel, width := 0, 0
+	for ch := 0; ch < 3; ch++ {
+		min, max := b[0].key[ch], b[0].key[ch]
+		for _, bu := range b[1:] {
+			if bu.key[ch] < min {
+				min = bu.key[ch]
+			}
+			if bu.key[ch] > max {
+				max = bu.key[ch]
+			}
+		}
+		if int(max-min) > width {
+			channel, width = ch, int(max-min)
+		}
+	}
+	return channel, width
+}
+
+// split sorts b by channel and splits it at the median pixel into two non-empty boxes
+func (b box) split(channel int) (box, box) {
+	sort.Slice(b, func(i, j int) bool { return b[i].key[channel] < b[j].key[channel] })
+	half := b.count() / 2
+	n := 0
+	for i, bu := range b[:len(b)-1] {
+		n += bu.count
+		if n >= half {
+			return b[:i+1], b[i+1:]
+		}
+	}
+	return b[:len(b)-1], b[len(b)-1:]
+}
+
+// mean returns the mean color of all pixels counted in b
+func (b box) mean() color.Color {
+	n, sum := 0, [3]int{}
+	for _, bu := range b {
+		n += bu.count
+		for ch := range sum {
+			sum[ch] += bu.sum[ch]
+		}
+	}
+	return color.RGBA{R: uint8(sum[0] / n), G: uint8(sum[1] / n), B: uint8(sum[2] / n), A: 255}
+}
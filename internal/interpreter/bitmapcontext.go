@@ -6,6 +6,9 @@ import (
 )
 
 // BitmapContext is the surface the ylang interpreter works on.
+// The workers of a parallel loop call the methods reading pixels, sizes and the clip rect and
+// SetPixel concurrently, each worker setting different pixels, so these methods must be safe
+// for concurrent use unless Options.Threads is 1. The other methods are called by one goroutine.
 type BitmapContext interface {
 	GetPixel(x int, y int) lang.Color
 	SetPixel(x int, y int, color lang.Color)
@@ -27,3 +30,27 @@ type BitmapContext interface {
 	Log(message string)
 	InterpolatePixel(x float32, y float32) *lang.Color
 }
+
+// FrameRecorder is implemented by a BitmapContext that records animations. Scripts invoking
+// frame fail if the BitmapContext they are executed against does not implement it.
+type FrameRecorder interface {
+	Frame(delay int) // records the target image as frame of an animation, shown for delay milliseconds or the default delay if negative
+}
+
+// InputProvider is implemented by a BitmapContext that provides named input images besides the
+// source image. The Images constant is empty if the BitmapContext does not implement it.
+type InputProvider interface {
+	Inputs() map[string]InputImage // the named input images, which scripts access through the Images constant
+}
+
+// InputImage is a named image a script can read besides the source image of a BitmapContext
+type InputImage interface {
+	GetPixel(x int, y int) lang.Color
+	Width() int
+	Height() int
+	Convolute(x, y, width, height int, kernel []lang.Number) lang.Color
+	MapRed(x, y, width, height int, kernel []lang.Number) []lang.Number
+	MapGreen(x, y, width, height int, kernel []lang.Number) []lang.Number
+	MapBlue(x, y, width, height int, kernel []lang.Number) []lang.Number
+	MapAlpha(x, y, width, height int, kernel []lang.Number) []lang.Number
+}
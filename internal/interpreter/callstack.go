@@ -0,0 +1,96 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+)
+
+const scriptFrameName = "<script>"
+
+// callFrame records an active function invocation for error tracebacks
+type callFrame struct {
+	name     string
+	callSite lexer.Token // the invocation expression in the calling frame
+	module   int         // the index of the module the executing code belongs to
+	loopVars []loopVar   // the loop variables of all active loops in this frame, innermost last
+	caller   activation  // the variables of the calling frame, only recorded while debugging
+	fn       *Function   // the executing function, nil for the top-level code of a module
+}
+
+type loopVar struct {
+	ident string
+	val   Value
+}
+
+func (ir *interpreter) pushCallFrame(name string, callSite lexer.Token, module int) {
+	ir.callStack = append(ir.callStack, callFrame{name: name, callSite: callSite, module: module})
+}
+
+// currentModule returns the index of the module the executing code belongs to
+func (ir *interpreter) currentModule() int {
+	return ir.callStack[len(ir.callStack)-1].module
+}
+
+func (ir *interpreter) popCallFrame() {
+	ir.callStack = ir.callStack[:len(ir.callStack)-1]
+}
+
+func (ir *interpreter) pushLoopVar(ident string) {
+	frame := &ir.callStack[len(ir.callStack)-1]
+	frame.loopVars = append(frame.loopVars, loopVar{ident: ident})
+}
+
+func (ir *interpreter) setLoopVar(val Value) {
+	frame := &ir.callStack[len(ir.callStack)-1]
+	frame.loopVars[len(frame.loopVars)-1].val = val
+}
+
+func (ir *interpreter) popLoopVar() {
+	frame := &ir.callStack[len(ir.callStack)-1]
+	frame.loopVars = frame.loopVars[:len(frame.loopVars)-1]
+}
+
+// errorAt converts err into a *lang.Error pointing at tok, carrying the current call stack.
+// signals and errors that already are *lang.Error are returned unchanged.
+func (ir *interpreter) errorAt(err error, tok lexer.Token) error {
+	if isSignal(err) {
+		return err
+	}
+	if _, ok := err.(*lang.Error); ok {
+		return err
+	}
+	return &lang.Error{
+		File:  ir.modules[ir.currentModule()].name,
+		Line:  tok.LineNumber,
+		Col:   tok.Column,
+		Msg:   err.Error(),
+		Trace: ir.captureTrace(tok),
+		Err:   err,
+	}
+}
+
+// captureTrace returns the current call stack, outermost frame first.
+// tok is the position being executed in the innermost frame.
+func (ir *interpreter) captureTrace(tok lexer.Token) []lang.TraceFrame {
+	trace := make([]lang.TraceFrame, len(ir.callStack))
+	for i, frame := range ir.callStack {
+		line := tok.LineNumber
+		if i+1 < len(ir.callStack) {
+			line = ir.callStack[i+1].callSite.LineNumber
+		}
+		var vars []string
+		for _, v := range frame.loopVars {
+			if v.val != nil {
+				vars = append(vars, fmt.Sprintf("%s = %s", v.ident, formatValue(v.val, "", false)))
+			}
+		}
+		trace[i] = lang.TraceFrame{
+			File:     ir.modules[frame.module].name,
+			Func:     frame.name,
+			Line:     line,
+			LoopVars: vars,
+		}
+	}
+	return trace
+}
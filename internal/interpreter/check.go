@@ -0,0 +1,193 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Codes of the diagnostics reported by Check
+const (
+	CheckCompile     = "compile"     // the program does not compile
+	CheckArity       = "arity"       // a builtin function is invoked with the wrong number of arguments
+	CheckConstant    = "constant"    // a builtin constant is redeclared
+	CheckUnreachable = "unreachable" // a statement follows return, break or continue
+	CheckUnused      = "unused"      // a local variable is never read
+)
+
+// Check analyzes the resolved program for mistakes that would otherwise surface only at
+// runtime or not at all: builtin functions invoked with the wrong number of arguments,
+// declarations hiding builtin constants, unreachable statements and local variables
+// that are never read. Top-level declarations are not reported as unused because they
+// may be read by importing modules.
+// The diagnostics are sorted by position.
+func Check(program parser.Program) []lang.Diagnostic {
+	c := checker{
+		file:   program.File,
+		frames: []*parser.Frame{program.Frame},
+		used:   make(map[*parser.Var]bool),
+	}
+	c.stmtList(program.Stmts)
+	parser.InspectStmts(program.Stmts, c.visit)
+	for _, decl := range c.locals {
+		if !c.used[decl.v] {
+			c.report(decl.tok, lang.SeverityWarning, CheckUnused, "variable '%s' is declared but never used", decl.ident)
+		}
+	}
+	sort.SliceStable(c.diags, func(i, j int) bool {
+		a, b := c.diags[i], c.diags[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+	})
+	return c.diags
+}
+
+type checker struct {
+	file   string
+	frames []*parser.Frame // the frames of the functions being checked, innermost last
+	locals []declaration
+	used   map[*parser.Var]bool // the variables that are read
+	diags  []lang.Diagnostic
+}
+
+// declaration is a local variable declared by a DeclStmt
+type declaration struct {
+	tok   lexer.Token
+	ident string
+	v     *parser.Var
+}
+
+func (c *checker) report(tok lexer.Token, severity lang.Severity, code string, format string, args ...interface{}) {
+	c.diags = append(c.diags, lang.Diagnostic{
+		File:     c.file,
+		Line:     tok.LineNumber,
+		Col:      tok.Column,
+		Severity: severity,
+		Code:     code,
+		Msg:      fmt.Sprintf(format, args...),
+	})
+}
+
+// visit is invoked by parser.Inspect for each node of the program
+func (c *checker) visit(node parser.Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return false
+
+	case parser.DeclStmt:
+		if isConstantName(n.Ident) {
+			c.report(n.Token(), lang.SeverityError, CheckConstant, "cannot declare '%s', which is a builtin constant", n.Ident)
+		}
+		if n.Var.Kind == parser.LocalVar || n.Var.Kind == parser.CellVar {
+			c.locals = append(c.locals, declaration{tok: n.Token(), ident: n.Ident, v: n.Var})
+		}
+
+	case parser.IndexedAssignStmt:
+		c.use(n.Var)
+
+	case parser.ParallelForStmt:
+		for _, r := range n.Reductions {
+			c.use(r.Var)
+		}
+		c.stmtList(n.Stmts)
+
+	case parser.IfStmt:
+		c.stmtList(n.TrueStmts)
+		c.stmtList(n.FalseStmts)
+	case parser.ForStmt:
+		c.stmtList(n.Stmts)
+	case parser.ForRangeStmt:
+		c.stmtList(n.Stmts)
+	case parser.WhileStmt:
+		c.stmtList(n.Stmts)
+
+	case parser.IdentExpr:
+		c.use(n.Var)
+
+	case parser.InvokeExpr:
+		if n.Var != nil {
+			c.use(n.Var)
+		} else {
+			c.arity(n)
+		}
+
+	case parser.FunctionExpr:
+		c.stmtList(n.Body)
+		c.frames = append(c.frames, n.Frame)
+		parser.InspectStmts(n.Body, c.visit)
+		c.frames = c.frames[:len(c.frames)-1]
+		return false
+	}
+	return true
+}
+
+// use marks v as read. captured variables are traced back to the declaring function.
+func (c *checker) use(v *parser.Var) {
+	for depth := len(c.frames) - 1; v != nil && v.Kind == parser.CaptureVar && depth > 0; depth-- {
+		c.used[v] = true
+		v = c.frames[depth].Captures[v.Index]
+	}
+	if v != nil {
+		c.used[v] = true
+	}
+}
+
+// stmtList reports the statements following a return, break or continue statement
+func (c *checker) stmtList(stmts []parser.Statement) {
+	for i := 0; i < len(stmts)-1; i++ {
+		switch stmts[i].(type) {
+		case parser.ReturnStmt, parser.BreakStmt, parser.ContinueStmt:
+			c.report(stmts[i+1].Token(), lang.SeverityWarning, CheckUnreachable, "unreachable code")
+			return
+		}
+	}
+}
+
+// arity reports an invocation of a builtin function that matches none of its overloads in the number of arguments
+func (c *checker) arity(e parser.InvokeExpr) {
+	decls := functions[e.FuncName]
+	if decls == nil { // added by a host
+		return
+	}
+	counts := make([]int, 0, len(decls))
+	variadic := make(map[int]bool)
+	for _, decl := range decls {
+		count := len(decl.params)
+		if count > 0 && decl.params[count-1].Kind() == reflect.Slice {
+			count--
+			if len(e.Args) >= count {
+				return
+			}
+			variadic[count] = true
+		} else if len(e.Args) == count {
+			return
+		}
+		counts = append(counts, count)
+	}
+	sort.Ints(counts)
+	expected := make([]string, 0, len(counts))
+	for i, count := range counts {
+		if i > 0 && counts[i-1] == count {
+			continue
+		}
+		if variadic[count] {
+			expected = append(expected, fmt.Sprintf("at least %d", count))
+		} else {
+			expected = append(expected, fmt.Sprintf("%d", count))
+		}
+	}
+	c.report(e.Token(), lang.SeverityError, CheckArity, "wrong number of arguments for '%s': expected %s, got %d",
+		e.FuncName, strings.Join(expected, " or "), len(e.Args))
+}
+
+func isConstantName(ident string) bool {
+	for _, name := range constantNames {
+		if name == ident {
+			return true
+		}
+	}
+	return false
+}
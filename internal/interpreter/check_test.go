@@ -0,0 +1,85 @@
+package interpreter
+
+import (
+	"github.com/smackem/ylang/internal/lang"
+	"reflect"
+	"testing"
+)
+
+func Test_Check(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []lang.Diagnostic
+	}{
+		{
+			name: "clean",
+			src: `f := fn(a) {
+    x := a * 2
+    return fn() -> x
+}
+log(f(1)())`,
+			want: nil,
+		},
+		{
+			name: "arity",
+			src:  `c := rgb(1, 2)`,
+			want: []lang.Diagnostic{
+				{Line: 1, Col: 6, Severity: lang.SeverityError, Code: CheckArity, Msg: "wrong number of arguments for 'rgb': expected 1 or 3, got 2"},
+			},
+		},
+		{
+			name: "variadic",
+			src:  `l := list(3, 0) log(max(l))`,
+			want: nil,
+		},
+		{
+			name: "constant",
+			src:  `W := 100`,
+			want: []lang.Diagnostic{
+				{Line: 1, Col: 1, Severity: lang.SeverityError, Code: CheckConstant, Msg: "cannot declare 'W', which is a builtin constant"},
+			},
+		},
+		{
+			name: "unreachable",
+			src: `for i in 0..3 {
+    if i > 1 {
+        break
+        log(i)
+    }
+}
+return nil
+log(1)`,
+			want: []lang.Diagnostic{
+				{Line: 4, Col: 9, Severity: lang.SeverityWarning, Code: CheckUnreachable, Msg: "unreachable code"},
+				{Line: 8, Col: 1, Severity: lang.SeverityWarning, Code: CheckUnreachable, Msg: "unreachable code"},
+			},
+		},
+		{
+			name: "unused",
+			src: `top := 1
+f := fn() {
+    unused := 1
+    assigned := 2
+    assigned = 3
+    captured := 4
+    return fn() -> fn() -> captured
+}`,
+			want: []lang.Diagnostic{
+				{Line: 3, Col: 5, Severity: lang.SeverityWarning, Code: CheckUnused, Msg: "variable 'unused' is declared but never used"},
+				{Line: 4, Col: 5, Severity: lang.SeverityWarning, Code: CheckUnused, Msg: "variable 'assigned' is declared but never used"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := compile(tt.src, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Check(program); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -48,15 +48,17 @@ func Test_circle(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := compileAndInterpret(tt.src)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("compileAndInterpret() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("compileAndInterpret() =\n%#v\nwant\n%#v", got, tt.want)
-			}
-		})
+		for _, engine := range engines {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				got, err := compileAndInterpret(engine, tt.src)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("compileAndInterpret() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("compileAndInterpret() =\n%#v\nwant\n%#v", got, tt.want)
+				}
+			})
+		}
 	}
 }
@@ -0,0 +1,334 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"sort"
+)
+
+// Debugger controls the execution of a program executed with Debug
+type Debugger interface {
+	// Statement is called before each statement is executed. The execution is paused until
+	// Statement returns, state must not be used afterwards. A non-nil error stops the execution
+	// and is returned by Debug.
+	Statement(stmt parser.Statement, state *DebugState) error
+}
+
+// Debug executes the program against the specified bitmap like Interpret does, notifying
+// debugger before each statement is executed. Loops are never executed in parallel.
+func Debug(program parser.Program, bitmap BitmapContext, debugger Debugger) error {
+	ir := newInterpreter(bitmap)
+	ir.debug = &debugSession{debugger: debugger, stmts: program.Stmts}
+	return interpret(program, ir)
+}
+
+type debugSession struct {
+	debugger   Debugger
+	stmts      []parser.Statement // the top-level code of the main script
+	evaluating bool               // true while an expression is evaluated for the debugger
+}
+
+// debugFrame records the executing function and the variables of its caller in the innermost call frame
+func (ir *interpreter) debugFrame(caller activation, fn *Function) {
+	frame := &ir.callStack[len(ir.callStack)-1]
+	frame.caller, frame.fn = caller, fn
+}
+
+// DebugState gives access to the state of a paused program
+type DebugState struct {
+	ir  *interpreter
+	tok lexer.Token // the statement about to be executed
+}
+
+// StackFrame is an active function invocation of a paused program
+type StackFrame struct {
+	Name string // the name of the function, "<script>" or "<module>" for the top-level code
+	File string // the canonical name of the module or the file name of the main script
+	Line int    // the line being executed
+	Col  int    // the column being executed
+}
+
+// DebugVar is a named value shown by a debugger
+type DebugVar struct {
+	Name  string
+	Value Value
+}
+
+// DebugScope is a group of variables visible in a stack frame
+type DebugScope struct {
+	Name string // "Locals", "Closure" or "Globals"
+	Vars []DebugVar
+}
+
+// Depth returns the number of active call frames, including the top-level code of the main script
+// and of modules being imported.
+func (s *DebugState) Depth() int {
+	return len(s.ir.callStack)
+}
+
+// File returns the canonical name of the module executing the statement or the file name of the main script
+func (s *DebugState) File() string {
+	return s.ir.modules[s.ir.currentModule()].name
+}
+
+// Stack returns the active call frames, innermost first
+func (s *DebugState) Stack() []StackFrame {
+	callStack := s.ir.callStack
+	frames := make([]StackFrame, len(callStack))
+	tok := s.tok
+	for i := len(callStack) - 1; i >= 0; i-- {
+		frame := callStack[i]
+		frames[len(callStack)-1-i] = StackFrame{
+			Name: frame.name,
+			File: s.ir.modules[frame.module].name,
+			Line: tok.LineNumber,
+			Col:  tok.Column,
+		}
+		tok = frame.callSite
+	}
+	return frames
+}
+
+// Scopes returns the variables visible in the stack frame with the specified index as returned by Stack.
+// Variables that have not been declared yet are omitted.
+func (s *DebugState) Scopes(frameIndex int) ([]DebugScope, error) {
+	frame, vars, err := s.frame(frameIndex)
+	if err != nil {
+		return nil, err
+	}
+	locals := DebugScope{Name: "Locals"}
+	closure := DebugScope{Name: "Closure"}
+	for _, nv := range s.frameVars(frame) {
+		val := loadDebugVar(vars, nv.v)
+		if val == nil {
+			continue
+		}
+		if nv.v.Kind == parser.CaptureVar {
+			closure.Vars = append(closure.Vars, DebugVar{nv.ident, val})
+		} else {
+			locals.Vars = append(locals.Vars, DebugVar{nv.ident, val})
+		}
+	}
+	globals := DebugScope{Name: "Globals"}
+	mod := s.ir.modules[frame.module]
+	for i, ident := range mod.globalNames {
+		if i < len(mod.globals) && mod.globals[i] != nil {
+			globals.Vars = append(globals.Vars, DebugVar{ident, mod.globals[i]})
+		}
+	}
+	return []DebugScope{locals, closure, globals}, nil
+}
+
+// Evaluate evaluates the expression src in the stack frame with the specified index as returned by Stack.
+// The expression may refer to the variables of the frame, but must not declare functions.
+// Errors are returned as *lang.Error.
+func (s *DebugState) Evaluate(frameIndex int, src string) (Value, error) {
+	frame, vars, err := s.frame(frameIndex)
+	if err != nil {
+		return nil, &lang.Error{Msg: err.Error()}
+	}
+	tokens, err := lexer.Lex(src)
+	if err != nil {
+		return nil, err
+	}
+	input, err := parser.ParseInput(tokens)
+	if err != nil {
+		return nil, err
+	}
+	stmt, ok := input.Stmts[0].(parser.InvocationStmt)
+	if len(input.Stmts) != 1 || !ok {
+		return nil, &lang.Error{Msg: "expected an expression"}
+	}
+
+	visible := make(map[string]*parser.Var)
+	for i, ident := range s.ir.registry.allConstantNames() {
+		if i != lastRectConst {
+			visible[ident] = &parser.Var{Kind: parser.ConstantVar, Index: i}
+		}
+	}
+	for i, ident := range s.ir.modules[frame.module].globalNames {
+		visible[ident] = &parser.Var{Kind: parser.GlobalVar, Index: i}
+	}
+	for _, nv := range s.frameVars(frame) {
+		// of several variables with the same name in different blocks, prefer the one declared last
+		if loadDebugVar(vars, nv.v) != nil || visible[nv.ident] == nil {
+			visible[nv.ident] = nv.v
+		}
+	}
+	expr, size, err := parser.ResolveExpr(stmt.Invocation, visible, len(vars.frame), s.ir.registry.isBuiltin)
+	if err != nil {
+		return nil, err
+	}
+
+	ir := s.ir
+	outer := ir.activation()
+	vars.frame = append(append([]Value(nil), vars.frame...), make([]Value, size-len(vars.frame))...)
+	ir.restore(vars)
+	ir.debug.evaluating = true
+	defer func() {
+		ir.debug.evaluating = false
+		ir.restore(outer)
+	}()
+	val, err := ir.visitExpr(expr)
+	if err != nil {
+		return nil, lang.ErrorAt(err, 0, 0)
+	}
+	return val, nil
+}
+
+// frame returns the call frame with the specified index as returned by Stack and its variables
+func (s *DebugState) frame(frameIndex int) (callFrame, activation, error) {
+	callStack := s.ir.callStack
+	if frameIndex < 0 || frameIndex >= len(callStack) {
+		return callFrame{}, activation{}, fmt.Errorf("unknown stack frame %d", frameIndex)
+	}
+	i := len(callStack) - 1 - frameIndex
+	if frameIndex == 0 {
+		return callStack[i], s.ir.activation(), nil
+	}
+	return callStack[i], callStack[i+1].caller, nil
+}
+
+// namedVar is a variable of a frame and the identifier it is declared with
+type namedVar struct {
+	ident string
+	v     *parser.Var
+}
+
+// frameVars returns the parameters, local variables and captured variables of the code executed by frame
+func (s *DebugState) frameVars(frame callFrame) []namedVar {
+	if frame.fn != nil {
+		return functionVars(*frame.fn)
+	}
+	stmts := s.ir.debug.stmts
+	if frame.module != 0 {
+		stmts = s.ir.sources[s.ir.modules[frame.module].name].Stmts
+	}
+	return codeVars(nil, stmts)
+}
+
+func functionVars(fn Function) []namedVar {
+	var params []namedVar
+	if fn.frame != nil {
+		for i, v := range fn.frame.Params {
+			params = append(params, namedVar{fn.ParameterNames[i], v})
+		}
+	}
+	return codeVars(params, fn.Body)
+}
+
+// codeVars appends the local and captured variables referred to by stmts to vars in order of appearance.
+// functions declared by stmts are skipped since their variables belong to frames of their own.
+func codeVars(vars []namedVar, stmts []parser.Statement) []namedVar {
+	seen := make(map[parser.Var]bool)
+	for _, nv := range vars {
+		seen[*nv.v] = true
+	}
+	add := func(ident string, v *parser.Var) {
+		if v == nil || v.Kind == parser.GlobalVar || v.Kind == parser.ConstantVar || seen[*v] {
+			return
+		}
+		seen[*v] = true
+		vars = append(vars, namedVar{ident, v})
+	}
+	parser.InspectStmts(stmts, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case parser.DeclStmt:
+			add(n.Ident, n.Var)
+		case parser.AssignStmt:
+			add(n.Ident, n.Var)
+		case parser.IndexedAssignStmt:
+			add(n.Ident, n.Var)
+		case parser.ForStmt:
+			add(n.Ident, n.Var)
+		case parser.ParallelForStmt:
+			add(n.Ident, n.Var)
+			for _, r := range n.Reductions {
+				add(r.Ident, r.Var)
+			}
+		case parser.ForRangeStmt:
+			add(n.Ident, n.Var)
+		case parser.IdentExpr:
+			add(n.Ident, n.Var)
+		case parser.InvokeExpr:
+			add(n.FuncName, n.Var)
+		case parser.PipelineExpr:
+			add(lexer.TokenTypeName(lexer.TTDollar), n.Var)
+		case parser.FunctionExpr:
+			return false
+		}
+		return true
+	})
+	return vars
+}
+
+// loadDebugVar returns the value of v in the variables of a frame or nil if v has not been declared
+func loadDebugVar(vars activation, v *parser.Var) Value {
+	switch v.Kind {
+	case parser.LocalVar:
+		if v.Index < len(vars.frame) {
+			return vars.frame[v.Index]
+		}
+	case parser.CellVar:
+		if v.Index < len(vars.cells) && vars.cells[v.Index] != nil {
+			return vars.cells[v.Index].val
+		}
+	case parser.CaptureVar:
+		if v.Index < len(vars.captures) && vars.captures[v.Index] != nil {
+			return vars.captures[v.Index].val
+		}
+	}
+	return nil
+}
+
+// Members returns the components of a value shown by a debugger: the elements of lists, the entries of
+// hash maps, the rows of kernels, the captured variables of closures, the members of modules and the
+// properties of all other values except strings.
+func Members(val Value) []DebugVar {
+	var members []DebugVar
+	switch v := val.(type) {
+	case List:
+		for i, elem := range v.Elements {
+			members = append(members, DebugVar{fmt.Sprintf("[%d]", i), elem})
+		}
+	case HashMap:
+		for _, key := range v.sortedKeys() {
+			name := formatValue(key, "", false)
+			if _, ok := key.(Str); ok {
+				name = fmt.Sprintf("%q", name)
+			}
+			members = append(members, DebugVar{name, v[key]})
+		}
+	case Kernel:
+		for y := 0; y < v.Height && v.Width > 0; y++ {
+			row := List{Elements: make([]Value, v.Width)}
+			for x := range row.Elements {
+				row.Elements[x] = Number(v.Values[y*v.Width+x])
+			}
+			members = append(members, DebugVar{fmt.Sprintf("[%d]", y), row})
+		}
+	case Function:
+		for _, nv := range functionVars(v) {
+			if nv.v.Kind == parser.CaptureVar && nv.v.Index < len(v.captures) {
+				members = append(members, DebugVar{nv.ident, v.captures[nv.v.Index].val})
+			}
+		}
+		sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	case Module:
+		for i, ident := range v.mod.globalNames {
+			if i < len(v.mod.globals) && v.mod.globals[i] != nil {
+				members = append(members, DebugVar{ident, v.mod.globals[i]})
+			}
+		}
+	case Str:
+	default:
+		for _, ident := range propertyNames[val.RuntimeTypeName()] {
+			if prop, err := val.Property(ident); err == nil {
+				members = append(members, DebugVar{ident, prop})
+			}
+		}
+	}
+	return members
+}
@@ -0,0 +1,153 @@
+package interpreter
+
+import (
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// debugFunc is a Debugger that invokes a function before each statement
+type debugFunc func(stmt parser.Statement, state *DebugState) error
+
+func (f debugFunc) Statement(stmt parser.Statement, state *DebugState) error {
+	return f(stmt, state)
+}
+
+// formatVars formats variables as name=value pairs
+func formatVars(vars []DebugVar) string {
+	var pairs []string
+	for _, v := range vars {
+		pairs = append(pairs, v.Name+"="+v.Value.PrintStr())
+	}
+	return strings.Join(pairs, " ")
+}
+
+func Test_Debug(t *testing.T) {
+	src := `offset := 10
+make := fn(n) {
+    k := |1 2 3 4|
+    return fn(x) {
+        y := x + n
+        return y + offset
+    }
+}
+add := make(5)
+for i in 0 .. 2 {
+    log(add(i))
+}`
+	program, err := compile(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []int
+	var stack []StackFrame
+	var scopes, callerScopes []DebugScope
+	var evaluated Value
+	debugger := debugFunc(func(stmt parser.Statement, state *DebugState) error {
+		line := stmt.Token().LineNumber
+		lines = append(lines, line)
+		if line == 6 && stack == nil {
+			stack = state.Stack()
+			if scopes, err = state.Scopes(0); err != nil {
+				t.Fatal(err)
+			}
+			if callerScopes, err = state.Scopes(1); err != nil {
+				t.Fatal(err)
+			}
+			if evaluated, err = state.Evaluate(0, "y * 2 + offset + n"); err != nil {
+				t.Errorf("Evaluate() error = %s", err)
+			}
+			if _, err := state.Evaluate(0, "k"); err == nil {
+				t.Errorf("Evaluate() error = nil, want error for variable of other frame")
+			}
+		}
+		return nil
+	})
+	bitmap := newPixelBitmap(2, 2)
+	if err := Debug(program, bitmap, debugger); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 9, 3, 4, 10, 11, 5, 6, 11, 5, 6}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("statements = %v, want %v", lines, want)
+	}
+	if want := []string{"15", "16"}; !reflect.DeepEqual(bitmap.log, want) {
+		t.Errorf("log = %v, want %v", bitmap.log, want)
+	}
+
+	wantStack := []StackFrame{
+		{Name: "add", Line: 6, Col: 9},
+		{Name: scriptFrameName, Line: 11, Col: 9},
+	}
+	if !reflect.DeepEqual(stack, wantStack) {
+		t.Errorf("Stack() = %#v, want %#v", stack, wantStack)
+	}
+	gotScopes := make(map[string]string)
+	for _, scope := range scopes {
+		gotScopes[scope.Name] = formatVars(scope.Vars)
+	}
+	wantScopes := map[string]string{
+		"Locals":  "x=0 y=5",
+		"Closure": "n=5",
+		"Globals": "offset=10 make=fn(n) add=fn(x)",
+	}
+	if !reflect.DeepEqual(gotScopes, wantScopes) {
+		t.Errorf("Scopes(0) = %v, want %v", gotScopes, wantScopes)
+	}
+	if got := formatVars(callerScopes[0].Vars); got != "i=0" {
+		t.Errorf("Scopes(1) locals = %s, want i=0", got)
+	}
+	if evaluated != Number(25) {
+		t.Errorf("Evaluate() = %v, want 20", evaluated)
+	}
+}
+
+func Test_Debug_stop(t *testing.T) {
+	program, err := compile("a := 1\nb := 2\nc := 3", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	debugger := debugFunc(func(stmt parser.Statement, state *DebugState) error {
+		count++
+		if stmt.Token().LineNumber == 2 {
+			return errStopped
+		}
+		return nil
+	})
+	if err := Debug(program, newPixelBitmap(1, 1), debugger); err == nil || !strings.Contains(err.Error(), errStopped.Error()) {
+		t.Errorf("Debug() error = %v, want %s", err, errStopped)
+	}
+	if count != 2 {
+		t.Errorf("Debug() executed %d statements, want 2", count)
+	}
+}
+
+var errStopped = &stopError{}
+
+type stopError struct{}
+
+func (*stopError) Error() string { return "stopped" }
+
+func Test_Members(t *testing.T) {
+	tests := []struct {
+		name string
+		val  Value
+		want string
+	}{
+		{"list", List{Elements: []Value{Number(1), Str("a")}}, "[0]=1 [1]=a"},
+		{"hashmap", HashMap{Str("b"): Number(2), Str("a"): Boolean(true)}, `"a"=true "b"=2`},
+		{"kernel", Kernel{Width: 2, Height: 2, Values: []lang.Number{1, 2, 3, 4}}, "[0]=list(count: 2) [1]=list(count: 2)"},
+		{"point", Point{3, 4}, "x=3 y=4 mag=5"},
+		{"number", Number(1), ""},
+		{"string", Str("abc"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatVars(Members(tt.val)); got != tt.want {
+				t.Errorf("Members() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
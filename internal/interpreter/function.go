@@ -10,7 +10,20 @@ import (
 type Function struct {
 	ParameterNames []string
 	Body           []parser.Statement
-	closure        []scope
+	IsGenerator    bool
+	frame          *parser.Frame // the variables of the function
+	captures       []*cell       // the variables of enclosing functions the function refers to
+	module         int           // the index of the module the function was declared in
+	unit           *codeUnit     // the bytecode of the function body if executed by the virtual machine
+	address        int           // the index of the first instruction of the function body in unit
+}
+
+// runBody executes the statements of the function body
+func (f Function) runBody(ir *interpreter) error {
+	if f.unit != nil {
+		return ir.execute(f.unit, f.address)
+	}
+	return ir.visitStmtList(f.Body)
 }
 
 func (f Function) Compare(other Value) (Value, error) {
@@ -58,6 +71,9 @@ func (f Function) Property(ident string) (Value, error) {
 }
 
 func (f Function) PrintStr() string {
+	if f.IsGenerator {
+		return fmt.Sprintf("fn*(%s)", strings.Join(f.ParameterNames, ", "))
+	}
 	return fmt.Sprintf("fn(%s)", strings.Join(f.ParameterNames, ", "))
 }
 
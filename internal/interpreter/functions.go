@@ -14,25 +14,32 @@ import (
 type FunctionDecl struct {
 	body   func(ir *interpreter, values []Value) (Value, error)
 	params []reflect.Type
+	Pure   bool // the function may be invoked by the iterations of a parallel for loop, see NewFunctionDecl
 }
 
 func PrintFunctions() string {
+	var registry *Registry
+	return registry.PrintFunctions()
+}
+
+// FunctionNames returns the names of all builtin functions in alphabetical order
+func FunctionNames() []string {
 	names := make([]string, 0, len(functions))
 	for name := range functions {
 		names = append(names, name)
 	}
 	sort.Sort(sort.StringSlice(names))
-	buf := strings.Builder{}
-	for _, name := range names {
-		buf.WriteString(name)
-		buf.WriteString("\n")
-		for _, decl := range functions[name] {
-			buf.WriteString("  ")
-			buf.WriteString(signature(name, decl))
-			buf.WriteString("\n")
-		}
+	return names
+}
+
+// FunctionSignatures returns the signatures of all overloads of the builtin function name,
+// e.g. "fn clamp(Number, Number, Number)". Returns nil if there is no such function.
+func FunctionSignatures(name string) []string {
+	var sigs []string
+	for _, decl := range functions[name] {
+		sigs = append(sigs, signature(name, decl))
 	}
-	return buf.String()
+	return sigs
 }
 
 var numberType = reflect.TypeOf(Number(0))
@@ -41,6 +48,7 @@ var kernelType = reflect.TypeOf(Kernel{})
 var listType = reflect.TypeOf(List{})
 var colorType = reflect.TypeOf(Color{})
 var functionType = reflect.TypeOf(Function{})
+var generatorType = reflect.TypeOf(Generator{})
 var lineType = reflect.TypeOf(Line{})
 var rectType = reflect.TypeOf(Rect{})
 var polygonType = reflect.TypeOf(Polygon{})
@@ -48,6 +56,7 @@ var numberSliceType = reflect.TypeOf([]Number{})
 var pointSliceType = reflect.TypeOf([]Point{})
 var circleType = reflect.TypeOf(Circle{})
 var hsvType = reflect.TypeOf(ColorHsv{})
+var imageType = reflect.TypeOf(Image{})
 var valueType = reflect.TypeOf((*Value)(nil)).Elem()
 
 var functions map[string][]FunctionDecl
@@ -112,6 +121,10 @@ func initFunctions() {
 				body:   invokeConvolute,
 				params: []reflect.Type{pointType, kernelType},
 			},
+			{
+				body:   invokeConvoluteImage,
+				params: []reflect.Type{imageType, pointType, kernelType},
+			},
 		},
 		"blt": {
 			{
@@ -125,6 +138,16 @@ func initFunctions() {
 				params: []reflect.Type{},
 			},
 		},
+		"frame": {
+			{
+				body:   invokeFrame,
+				params: []reflect.Type{numberType},
+			},
+			{
+				body:   invokeFrame,
+				params: []reflect.Type{},
+			},
+		},
 		"recall": {
 			{
 				body:   invokeRecall,
@@ -144,30 +167,54 @@ func initFunctions() {
 				body:   invokeSortListFn,
 				params: []reflect.Type{listType, functionType},
 			},
+			{
+				body:   invokeSortGenerator,
+				params: []reflect.Type{generatorType},
+			},
+			{
+				body:   invokeSortGeneratorFn,
+				params: []reflect.Type{generatorType, functionType},
+			},
 		},
 		"fetchRed": {
 			{
 				body:   invokeFetchRed,
 				params: []reflect.Type{pointType, kernelType},
 			},
+			{
+				body:   invokeFetchRedImage,
+				params: []reflect.Type{imageType, pointType, kernelType},
+			},
 		},
 		"fetchGreen": {
 			{
 				body:   invokeFetchGreen,
 				params: []reflect.Type{pointType, kernelType},
 			},
+			{
+				body:   invokeFetchGreenImage,
+				params: []reflect.Type{imageType, pointType, kernelType},
+			},
 		},
 		"fetchBlue": {
 			{
 				body:   invokeFetchBlue,
 				params: []reflect.Type{pointType, kernelType},
 			},
+			{
+				body:   invokeFetchBlueImage,
+				params: []reflect.Type{imageType, pointType, kernelType},
+			},
 		},
 		"fetchAlpha": {
 			{
 				body:   invokeFetchAlpha,
 				params: []reflect.Type{pointType, kernelType},
 			},
+			{
+				body:   invokeFetchAlphaImage,
+				params: []reflect.Type{imageType, pointType, kernelType},
+			},
 		},
 		"sin": {
 			{
@@ -408,6 +455,10 @@ func initFunctions() {
 				body:   invokeSumKernel,
 				params: []reflect.Type{kernelType},
 			},
+			{
+				body:   invokeSumGenerator,
+				params: []reflect.Type{generatorType},
+			},
 		},
 		"outline": {
 			{
@@ -539,6 +590,13 @@ func invokeConvolute(ir *interpreter, args []Value) (Value, error) {
 	return Color(ir.bitmap.Convolute(posVal.X, posVal.Y, kernelVal.Width, kernelVal.Height, kernelVal.Values)), nil
 }
 
+func invokeConvoluteImage(ir *interpreter, args []Value) (Value, error) {
+	img := args[0].(Image)
+	posVal := args[1].(Point)
+	kernelVal := args[2].(Kernel)
+	return Color(img.img.Convolute(posVal.X, posVal.Y, kernelVal.Width, kernelVal.Height, kernelVal.Values)), nil
+}
+
 func invokeBlt(ir *interpreter, args []Value) (Value, error) {
 	rect := args[0].(Rect)
 	ir.bitmap.Blt(rect.Min.X, rect.Min.Y, rect.Max.X-rect.Min.X, rect.Max.Y-rect.Min.Y)
@@ -547,16 +605,31 @@ func invokeBlt(ir *interpreter, args []Value) (Value, error) {
 
 func invokeFlip(ir *interpreter, args []Value) (Value, error) {
 	imageID := ir.bitmap.Flip()
-	ir.assignBounds(false)
+	ir.assignBounds()
 	return Number(imageID), nil
 }
 
+func invokeFrame(ir *interpreter, args []Value) (Value, error) {
+	recorder, ok := ir.bitmap.(FrameRecorder)
+	if !ok {
+		return nil, fmt.Errorf("recording frames is not supported by this bitmap")
+	}
+	delay := -1
+	if len(args) > 0 {
+		if delay = int(args[0].(Number)); delay < 0 {
+			return nil, fmt.Errorf("invalid frame delay %d", delay)
+		}
+	}
+	recorder.Frame(delay)
+	return nil, nil
+}
+
 func invokeRecall(ir *interpreter, args []Value) (Value, error) {
 	imageID := args[0].(Number)
 	if err := ir.bitmap.Recall(int(imageID)); err != nil {
 		return nil, err
 	}
-	ir.assignBounds(false)
+	ir.assignBounds()
 	return nil, nil
 }
 
@@ -598,6 +671,22 @@ func invokeSortListFn(ir *interpreter, args []Value) (Value, error) {
 	return result, nil
 }
 
+func invokeSortGenerator(ir *interpreter, args []Value) (Value, error) {
+	list, err := collectList(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return invokeSortList(ir, []Value{list})
+}
+
+func invokeSortGeneratorFn(ir *interpreter, args []Value) (Value, error) {
+	list, err := collectList(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return invokeSortListFn(ir, []Value{list, args[1]})
+}
+
 func invokeFetchRed(ir *interpreter, args []Value) (Value, error) {
 	posVal := args[0].(Point)
 	kernelVal := args[1].(Kernel)
@@ -630,6 +719,42 @@ func invokeFetchAlpha(ir *interpreter, args []Value) (Value, error) {
 	return result, nil
 }
 
+func invokeFetchRedImage(ir *interpreter, args []Value) (Value, error) {
+	img := args[0].(Image)
+	posVal := args[1].(Point)
+	kernelVal := args[2].(Kernel)
+	result := kernelVal
+	result.Values = img.img.MapRed(posVal.X, posVal.Y, kernelVal.Width, kernelVal.Height, kernelVal.Values)
+	return result, nil
+}
+
+func invokeFetchGreenImage(ir *interpreter, args []Value) (Value, error) {
+	img := args[0].(Image)
+	posVal := args[1].(Point)
+	kernelVal := args[2].(Kernel)
+	result := kernelVal
+	result.Values = img.img.MapGreen(posVal.X, posVal.Y, kernelVal.Width, kernelVal.Height, kernelVal.Values)
+	return result, nil
+}
+
+func invokeFetchBlueImage(ir *interpreter, args []Value) (Value, error) {
+	img := args[0].(Image)
+	posVal := args[1].(Point)
+	kernelVal := args[2].(Kernel)
+	result := kernelVal
+	result.Values = img.img.MapBlue(posVal.X, posVal.Y, kernelVal.Width, kernelVal.Height, kernelVal.Values)
+	return result, nil
+}
+
+func invokeFetchAlphaImage(ir *interpreter, args []Value) (Value, error) {
+	img := args[0].(Image)
+	posVal := args[1].(Point)
+	kernelVal := args[2].(Kernel)
+	result := kernelVal
+	result.Values = img.img.MapAlpha(posVal.X, posVal.Y, kernelVal.Width, kernelVal.Height, kernelVal.Values)
+	return result, nil
+}
+
 func invokeSin(ir *interpreter, args []Value) (Value, error) {
 	return Number(math.Sin(float64(args[0].(Number)))), nil
 }
@@ -809,6 +934,9 @@ func invokeMinList(it *interpreter, args []Value) (Value, error) {
 func invokeList(it *interpreter, args []Value) (Value, error) {
 	count := args[0].(Number)
 	val := args[1].(Number)
+	if err := it.checkAllocation(float64(count)); err != nil {
+		return nil, err
+	}
 	values := make([]Value, int(count))
 	for i := range values {
 		values[i] = val
@@ -819,6 +947,9 @@ func invokeList(it *interpreter, args []Value) (Value, error) {
 func invokeListFn(it *interpreter, args []Value) (Value, error) {
 	count := args[0].(Number)
 	fn := args[1].(Function)
+	if err := it.checkAllocation(float64(count)); err != nil {
+		return nil, err
+	}
 	values := make([]Value, int(count))
 	fnArgs := make([]Value, 1)
 	for i := range values {
@@ -836,6 +967,9 @@ func invokeKernel(ir *interpreter, args []Value) (Value, error) {
 	width := args[0].(Number)
 	height := args[1].(Number)
 	val := args[2].(Number)
+	if err := ir.checkAllocation(float64(width) * float64(height)); err != nil {
+		return nil, err
+	}
 
 	values := make([]Number, int(width*height))
 	for i := range values {
@@ -849,6 +983,9 @@ func invokeKernelFn(ir *interpreter, args []Value) (Value, error) {
 	width := int(args[0].(Number))
 	height := int(args[1].(Number))
 	fn := args[2].(Function)
+	if err := ir.checkAllocation(float64(width) * float64(height)); err != nil {
+		return nil, err
+	}
 	values := make([]Number, width*height)
 	fnArgs := make([]Value, 2)
 
@@ -876,6 +1013,9 @@ func invokeKernelFn(ir *interpreter, args []Value) (Value, error) {
 func invokeGauss(ir *interpreter, args []Value) (Value, error) {
 	radius := int(args[0].(Number))
 	length := int(radius*2 + 1)
+	if err := ir.checkAllocation(float64(length) * float64(length)); err != nil {
+		return nil, err
+	}
 
 	values := make([]Number, int(length*length))
 	i := 0
@@ -905,6 +1045,9 @@ func invokeGauss(ir *interpreter, args []Value) (Value, error) {
 func invokeResize(ir *interpreter, args []Value) (Value, error) {
 	width := args[0].(Number)
 	height := args[1].(Number)
+	if err := ir.checkAllocation(float64(width) * float64(height)); err != nil {
+		return nil, err
+	}
 
 	ir.bitmap.ResizeTarget(int(width), int(height))
 
@@ -1093,6 +1236,23 @@ func invokeSumKernel(ir *interpreter, args []Value) (Value, error) {
 	return sum, nil
 }
 
+func invokeSumGenerator(ir *interpreter, args []Value) (Value, error) {
+	var sum Value
+	err := args[0].Iterate(func(v Value) error {
+		if sum == nil {
+			sum = v
+			return nil
+		}
+		var err error
+		sum, err = sum.Add(v)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
 func invokeOutlineRect(ir *interpreter, args []Value) (Value, error) {
 	rc := args[0].(Rect)
 	lines := make([]Value, 4)
@@ -1210,6 +1370,16 @@ func invokeInterpolate(ir *interpreter, args []Value) (Value, error) {
 	return Color(*color), nil
 }
 
+// collectList iterates over the given value and collects all visited values into a list
+func collectList(iterable Value) (List, error) {
+	var elements []Value
+	err := iterable.Iterate(func(v Value) error {
+		elements = append(elements, v)
+		return nil
+	})
+	return List{elements}, err
+}
+
 func convertNumbersToLangNumbers(numbers []Number) []lang.Number {
 	result := make([]lang.Number, len(numbers))
 	for i, n := range numbers {
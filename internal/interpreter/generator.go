@@ -0,0 +1,91 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/lexer"
+	"reflect"
+	"strings"
+)
+
+// Generator is the result of invoking a function that contains a yield statement.
+// The function body is not executed until the generator is iterated; each yielded
+// value is handed to the consumer before the body continues.
+type Generator struct {
+	name      string
+	callSite  lexer.Token
+	fn        Function
+	arguments []Value
+	ir        *interpreter
+}
+
+func (g Generator) Compare(other Value) (Value, error) {
+	return nil, nil
+}
+
+func (g Generator) Add(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator + %s Not supported", reflect.TypeOf(other))
+}
+
+func (g Generator) Sub(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator - %s Not supported", reflect.TypeOf(other))
+}
+
+func (g Generator) Mul(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator * %s Not supported", reflect.TypeOf(other))
+}
+
+func (g Generator) Div(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator / %s Not supported", reflect.TypeOf(other))
+}
+
+func (g Generator) Mod(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator %% %s Not supported", reflect.TypeOf(other))
+}
+
+func (g Generator) In(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator In %s Not supported", reflect.TypeOf(other))
+}
+
+func (g Generator) Neg() (Value, error) {
+	return nil, fmt.Errorf("type mismatch: -generator Not supported")
+}
+
+func (g Generator) Not() (Value, error) {
+	return nil, fmt.Errorf("type mismatch: Not generator Not supported")
+}
+
+func (g Generator) At(bitmap BitmapContext) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: @generator Not supported")
+}
+
+func (g Generator) Property(ident string) (Value, error) {
+	return baseProperty(g, ident)
+}
+
+func (g Generator) PrintStr() string {
+	return fmt.Sprintf("generator(%s)", strings.Join(g.fn.ParameterNames, ", "))
+}
+
+func (g Generator) Iterate(visit func(Value) error) error {
+	return g.ir.iterateGenerator(g, visit)
+}
+
+func (g Generator) Index(index Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator[Index] Not supported")
+}
+
+func (g Generator) IndexRange(lower, upper Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator[lower..upper] Not supported")
+}
+
+func (g Generator) IndexAssign(index Value, val Value) error {
+	return fmt.Errorf("type mismatch: generator[%s] Not supported", reflect.TypeOf(index))
+}
+
+func (g Generator) RuntimeTypeName() string {
+	return "generator"
+}
+
+func (g Generator) Concat(val Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: generator :: [%s] Not supported", reflect.TypeOf(val))
+}
@@ -0,0 +1,126 @@
+package interpreter
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+)
+
+// Image is a named input image, see InputProvider. Its pixels are read by
+// sampling or indexing it with a point: img@p or img[p]
+type Image struct {
+	Name string
+	img  InputImage
+}
+
+// NewImage returns the Image value of the input image img with the specified name
+func NewImage(name string, img InputImage) Image {
+	return Image{Name: name, img: img}
+}
+
+func (img Image) Compare(other Value) (Value, error) {
+	if r, ok := other.(Image); ok {
+		if img == r {
+			return Number(0), nil
+		}
+	}
+	return nil, nil
+}
+
+func (img Image) Add(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: image + %s Not supported", reflect.TypeOf(other))
+}
+
+func (img Image) Sub(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: image - %s Not supported", reflect.TypeOf(other))
+}
+
+func (img Image) Mul(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: image * %s Not supported", reflect.TypeOf(other))
+}
+
+func (img Image) Div(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: image / %s Not supported", reflect.TypeOf(other))
+}
+
+func (img Image) Mod(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: image %% %s Not supported", reflect.TypeOf(other))
+}
+
+func (img Image) In(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: image In %s Not supported", reflect.TypeOf(other))
+}
+
+func (img Image) Neg() (Value, error) {
+	return nil, fmt.Errorf("type mismatch: '-image' Not supported")
+}
+
+func (img Image) Not() (Value, error) {
+	return nil, fmt.Errorf("type mismatch: 'Not image' Not supported")
+}
+
+func (img Image) At(bitmap BitmapContext) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: @image Not supported, use image@point")
+}
+
+func (img Image) bounds() Rect {
+	return Rect{Max: image.Point{X: img.img.Width(), Y: img.img.Height()}}
+}
+
+func (img Image) Property(ident string) (Value, error) {
+	switch ident {
+	case "bounds":
+		return img.bounds(), nil
+	case "w", "width":
+		return Number(img.img.Width()), nil
+	case "h", "height":
+		return Number(img.img.Height()), nil
+	case "name":
+		return Str(img.Name), nil
+	}
+	return baseProperty(img, ident)
+}
+
+func (img Image) PrintStr() string {
+	return fmt.Sprintf("image(name:%s, w:%d, h:%d)", img.Name, img.img.Width(), img.img.Height())
+}
+
+func (img Image) Iterate(visit func(Value) error) error {
+	return fmt.Errorf("cannot iterate over image, use image.bounds")
+}
+
+func (img Image) Index(index Value) (Value, error) {
+	pt, ok := index.(Point)
+	if !ok {
+		return nil, fmt.Errorf("type mismatch: expected image[point] but found image[%s]", reflect.TypeOf(index))
+	}
+	if !image.Point(pt).In(image.Rectangle(img.bounds())) {
+		return nil, fmt.Errorf("point %s is out of the bounds of image '%s'", pt.PrintStr(), img.Name)
+	}
+	return Color(img.img.GetPixel(pt.X, pt.Y)), nil
+}
+
+func (img Image) IndexRange(lower, upper Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: image[lower..upper] Not supported")
+}
+
+func (img Image) IndexAssign(index Value, val Value) error {
+	return fmt.Errorf("type mismatch: image[%s] = %s Not supported, input images are read-only", reflect.TypeOf(index), reflect.TypeOf(val))
+}
+
+func (img Image) RuntimeTypeName() string {
+	return "image"
+}
+
+func (img Image) Concat(val Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: image :: %s Not supported", reflect.TypeOf(val))
+}
+
+// sample returns the pixel of the input image imgVal at the point ptVal: img@p
+func sample(imgVal Value, ptVal Value) (Value, error) {
+	img, ok := imgVal.(Image)
+	if _, isPoint := ptVal.(Point); !ok || !isPoint {
+		return nil, fmt.Errorf("type mismatch: expected image@point but found %s@%s", imgVal.RuntimeTypeName(), ptVal.RuntimeTypeName())
+	}
+	return img.Index(ptVal)
+}
@@ -0,0 +1,152 @@
+package interpreter
+
+import (
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testImage is an InputImage whose pixels have the red channel x*10 and the green channel y*10
+type testImage struct {
+	width, height int
+}
+
+func (img testImage) GetPixel(x int, y int) lang.Color {
+	return lang.NewRgba(lang.Number(x*10), lang.Number(y*10), 0, 255)
+}
+func (img testImage) Width() int  { return img.width }
+func (img testImage) Height() int { return img.height }
+
+// Convolute returns the pixel at x;y with the blue channel set to the sum of the kernel
+func (img testImage) Convolute(x, y, width, height int, kernel []lang.Number) lang.Color {
+	col := img.GetPixel(x, y)
+	for _, n := range kernel {
+		col.B += n
+	}
+	return col
+}
+func (img testImage) MapRed(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return img.mapChannel(kernel, img.GetPixel(x, y).R)
+}
+func (img testImage) MapGreen(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return img.mapChannel(kernel, img.GetPixel(x, y).G)
+}
+func (img testImage) MapBlue(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return img.mapChannel(kernel, img.GetPixel(x, y).B)
+}
+func (img testImage) MapAlpha(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return img.mapChannel(kernel, img.GetPixel(x, y).A)
+}
+
+// mapChannel multiplies each element of kernel with v
+func (img testImage) mapChannel(kernel []lang.Number, v lang.Number) []lang.Number {
+	result := make([]lang.Number, len(kernel))
+	for i, n := range kernel {
+		result[i] = n * v
+	}
+	return result
+}
+
+func TestImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		noInputs bool // the bitmap does not implement InputProvider
+		want     []string
+		wantErr  string
+	}{
+		{
+			name: "properties",
+			src:  `log(Images.bg.w, " ", Images.bg.h, " ", Images.bg.bounds, " ", Images.bg.name, " ", Images.count)`,
+			want: []string{"3 2 rect(x:0, y:0, w:3, h:2) bg 2"},
+		},
+		{
+			name:     "no_inputs",
+			src:      `log(Images.count)`,
+			noInputs: true,
+			want:     []string{"0"},
+		},
+		{
+			name: "sampling",
+			src: `bg := Images["bg"]
+log(bg[2;1].r, " ", bg[2;1].g, " ", bg == Images.bg, " ", bg == Images.fg)`,
+			want: []string{"20 10 true false"},
+		},
+		{
+			name: "sample",
+			src: `p := 2;1
+log(Images.bg@p, " ", Images.fg@(1;1).g, " ", Images.bg@p == Images.bg[p])`,
+			want: []string{"rgba(20,10,0:255) 10 true"},
+		},
+		{
+			name:    "sample_no_image",
+			src:     `c := Black@(0;0)`,
+			wantErr: "type mismatch: expected image@point but found color@point",
+		},
+		{
+			name: "convolute",
+			src: `k := |1 2
+      3 4|
+log(convolute(Images.fg, 1;0, k).b, " ", fetchRed(Images.fg, 1;0, k)[1], " ", fetchGreen(Images.bg, 0;1, k)[3])`,
+			want: []string{"10 20 40"},
+		},
+		{
+			name: "loop",
+			src: `sum := 0
+for p in Images.bg.bounds {
+    sum = sum + Images.bg[p].r
+}
+log(sum)`,
+			want: []string{"60"},
+		},
+		{
+			name:    "out_of_bounds",
+			src:     `c := Images.bg[3;0]`,
+			wantErr: "point 3;0 is out of the bounds of image 'bg'",
+		},
+		{
+			name:    "read_only",
+			src:     "bg := Images.bg\nbg[0;0] = Black",
+			wantErr: "input images are read-only",
+		},
+	}
+	for _, engine := range engines {
+		for _, tt := range tests {
+			t.Run(engine+"_"+tt.name, func(t *testing.T) {
+				tokens, err := lexer.Lex(tt.src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				program, err := parser.Parse(tokens, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				bitmap := newPixelBitmap(3, 2)
+				bitmap.inputs = map[string]InputImage{
+					"bg": testImage{width: 3, height: 2},
+					"fg": testImage{width: 2, height: 2},
+				}
+				var ctx BitmapContext = bitmap
+				if tt.noInputs {
+					ctx = struct{ BitmapContext }{bitmap}
+				}
+				err = execute(engine, program, ctx)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Errorf("execution error = %v, want %s", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("execution error = %v", err)
+				}
+				if !reflect.DeepEqual(bitmap.log, tt.want) {
+					t.Errorf("log = %q, want %q", bitmap.log, tt.want)
+				}
+			})
+		}
+	}
+}
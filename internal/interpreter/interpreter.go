@@ -1,6 +1,7 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
 	"github.com/smackem/ylang/internal/lang"
 	"github.com/smackem/ylang/internal/lexer"
@@ -16,25 +17,84 @@ func init() {
 	initFunctions()
 }
 
-func Interpret(program parser.Program, bitmap BitmapContext) error {
+// Interpret executes the program against the specified bitmap.
+// The program and all modules it imports must have been resolved with Resolve.
+// The execution is stopped if ctx is done or the program exceeds a limit of options.
+// Errors are returned as *lang.Error.
+func Interpret(ctx context.Context, program parser.Program, bitmap BitmapContext, options Options) error {
 	ir := newInterpreter(bitmap)
+	var cancel context.CancelFunc
+	ir.limits, cancel = newLimits(ctx, options)
+	defer cancel()
+	ir.threads = options.Threads
+	if err := ir.register(options.Registry, options.Constants); err != nil {
+		return err
+	}
+	if err := ir.setParams(options.Params, ParamNames(program)); err != nil {
+		return err
+	}
+	if options.Profile == nil {
+		return interpret(program, ir)
+	}
+	ir.profiler = options.Profile.newProfiler()
+	options.Profile.start = ir.profiler.last
+	err := interpret(program, ir)
+	ir.profiler.finish()
+	options.Profile.end = options.Profile.now()
+	return err
+}
+
+// interpret executes the resolved program with ir
+func interpret(program parser.Program, ir *interpreter) error {
+	if program.Frame == nil {
+		return &lang.Error{Msg: "the program has not been resolved"}
+	}
+	ir.modules[0].name = program.File
+	ir.modules[0].importNames = program.ImportNames
+	ir.sources = program.Modules
+	ir.enterModule(ir.modules[0], program.Globals, program.Frame)
 	if err := ir.visitStmtList(program.Stmts); err != nil {
 		if _, ok := err.(returnSignal); !ok { // return statement encountered
-			return err
+			return lang.ErrorAt(err, 0, 0)
 		}
 	}
 	return nil
 }
 
-type scope map[string]Value
+// Resolve resolves the variables of the program against the constants and builtin functions
+// of the interpreter, see parser.Resolve.
+func Resolve(program parser.Program) (parser.Program, error) {
+	return ResolveWith(program, nil)
+}
+
 type functionScope struct {
 	retval Value
+	yield  func(Value) error // non-nil if the function is executed as a generator
 }
 
 type interpreter struct {
-	idents         []scope
+	constants      []Value
+	globals        []Value // the top-level declarations of the executing module
+	frame          []Value // the local variables of the executing function
+	cells          []*cell // the local variables of the executing function captured by closures
+	captures       []*cell // the variables of enclosing functions captured by the executing function
 	bitmap         BitmapContext
 	functionScopes []functionScope
+	callStack      []callFrame
+	callSite       lexer.Token // the innermost invocation expression being evaluated
+	modules        []*module   // the main script and all modules imported so far, by module index
+	moduleIndex    map[string]int
+	sources        map[string]parser.Program // the compiled modules by canonical name
+	units          map[string]*codeUnit      // the bytecode of the modules by canonical name if executed by the virtual machine
+	stack          []Value                   // the evaluation stack of the virtual machine
+	worker         bool                      // true if the interpreter executes a part of a parallel loop
+	debug          *debugSession             // non-nil if the program is executed by Debug
+	limits         *limits                   // nil if the execution is not limited
+	steps          int                       // the number of steps the interpreter may execute before taking more from limits
+	profiler       *profiler                 // non-nil if the execution is profiled
+	registry       *Registry                 // the builtin functions and constants added by the host, may be nil
+	params         map[string]string         // the values of script parameters passed by the host
+	threads        int                       // the maximum number of workers of a parallel loop, 0 for GOMAXPROCS
 }
 
 type returnSignal string
@@ -44,109 +104,257 @@ func (rs returnSignal) Error() string {
 }
 
 const returnSig returnSignal = returnSignal("RET")
-const initialScopeCount int = 2
+
+type breakSignal string
+
+func (bs breakSignal) Error() string {
+	return string(bs)
+}
+
+const breakSig breakSignal = breakSignal("BRK")
+
+type continueSignal string
+
+func (cs continueSignal) Error() string {
+	return string(cs)
+}
+
+const continueSig continueSignal = continueSignal("CNT")
+
+// consumerError wraps an error returned by the consumer of a generator,
+// so that it passes through the generator body unchanged.
+type consumerError struct {
+	err error
+}
+
+func (ce consumerError) Error() string {
+	return ce.err.Error()
+}
+
+// isSignal returns true if err is Not a real error but used to unwind the stack.
+func isSignal(err error) bool {
+	switch err.(type) {
+	case returnSignal, breakSignal, continueSignal, consumerError:
+		return true
+	}
+	return false
+}
+
+// the indices of the constants assigned by the interpreter
+const (
+	lastRectConst = iota
+	boundsConst
+	widthConst
+	heightConst
+	imagesConst
+)
+
+// constantNames are the names of the predefined constants by index
+var constantNames = []string{lastRectIdent, "Bounds", "W", "H", "Images", "Black", "White", "Transparent", "Pi", "Rad2Deg", "Deg2Rad"}
+
+// ConstantNames returns the names of the predefined constants that are visible to scripts
+func ConstantNames() []string {
+	return append([]string(nil), constantNames[lastRectConst+1:]...)
+}
 
 //noinspection ALL
 func newInterpreter(bitmap BitmapContext) *interpreter {
 	ir := &interpreter{
-		idents: []scope{make(scope)},
-		bitmap: bitmap,
-	}
-	ir.newIdent(lastRectIdent, Rect{})
-	ir.newIdent("Black", Color(lang.NewRgba(0, 0, 0, 255)))
-	ir.newIdent("White", Color(lang.NewRgba(255, 255, 255, 255)))
-	ir.newIdent("Transparent", Color(lang.NewRgba(255, 255, 255, 0)))
-	ir.newIdent("Pi", Number(math.Pi))
-	ir.newIdent("Rad2Deg", Number(180/math.Pi))
-	ir.newIdent("Deg2Rad", Number(math.Pi/180))
+		constants: []Value{
+			Rect{},
+			nil,
+			nil,
+			nil,
+			HashMap{},
+			Color(lang.NewRgba(0, 0, 0, 255)),
+			Color(lang.NewRgba(255, 255, 255, 255)),
+			Color(lang.NewRgba(255, 255, 255, 0)),
+			Number(math.Pi),
+			Number(180 / math.Pi),
+			Number(math.Pi / 180),
+		},
+		bitmap:    bitmap,
+		callStack: []callFrame{{name: scriptFrameName}},
+	}
 	if bitmap != nil {
-		ir.assignBounds(true)
-		ir.newIdent("W", Number(bitmap.SourceWidth()))
-		ir.newIdent("H", Number(bitmap.SourceHeight()))
+		ir.assignBounds()
+		ir.constants[widthConst] = Number(bitmap.SourceWidth())
+		ir.constants[heightConst] = Number(bitmap.SourceHeight())
+		if provider, ok := bitmap.(InputProvider); ok {
+			images := ir.constants[imagesConst].(HashMap)
+			for name, img := range provider.Inputs() {
+				images[Str(name)] = NewImage(name, img)
+			}
+		}
 	}
-	ir.pushScope()
+	ir.modules = []*module{{}}
+	ir.moduleIndex = make(map[string]int)
 	return ir
 }
 
-func (ir *interpreter) pushScope() {
-	ir.idents = append(ir.idents, make(scope))
+// register makes the builtin functions and constants of registry available to the executed program.
+// constants replace the values of the constants of registry with the same names for this execution.
+func (ir *interpreter) register(registry *Registry, constants map[string]Value) error {
+	ir.registry = registry
+	if registry != nil {
+		ir.constants = append(ir.constants, registry.constants...)
+	}
+	for name, val := range constants {
+		index := -1
+		if registry != nil {
+			for i, ident := range registry.constantNames {
+				if ident == name {
+					index = len(constantNames) + i
+				}
+			}
+		}
+		if index < 0 {
+			return &lang.Error{Msg: fmt.Sprintf("unknown constant '%s'", name)}
+		}
+		if val == nil {
+			return &lang.Error{Msg: fmt.Sprintf("constant '%s' has no value", name)}
+		}
+		ir.constants[index] = val
+	}
+	return nil
 }
 
-func (ir *interpreter) popScope() {
-	ir.idents = ir.idents[:len(ir.idents)-1]
+// cell holds a variable that is shared between a function and the closures capturing it
+type cell struct {
+	val Value
 }
 
-func (ir *interpreter) pushFunctionScope(closure []scope) int {
-	ir.functionScopes = append(ir.functionScopes, functionScope{})
-	prevScopeCount := len(ir.idents)
-	ir.idents = append(ir.idents, closure...)
-	ir.pushScope()
-	return prevScopeCount
+// activation holds the variables of the executing function
+type activation struct {
+	globals  []Value
+	frame    []Value
+	cells    []*cell
+	captures []*cell
 }
 
-func (ir *interpreter) popFunctionScope(scopeCount int) {
-	ir.functionScopes = ir.functionScopes[:len(ir.functionScopes)-1]
-	ir.idents = ir.idents[:scopeCount]
+func (ir *interpreter) activation() activation {
+	return activation{globals: ir.globals, frame: ir.frame, cells: ir.cells, captures: ir.captures}
 }
 
-func (ir *interpreter) getReturnValue() Value {
-	if len(ir.functionScopes) <= 0 {
-		return nil
+func (ir *interpreter) restore(a activation) {
+	ir.globals, ir.frame, ir.cells, ir.captures = a.globals, a.frame, a.cells, a.captures
+}
+
+// enterFrame allocates the variables described by frame for the code of the module with the specified globals
+func (ir *interpreter) enterFrame(globals []Value, frame *parser.Frame, captures []*cell) {
+	ir.globals = globals
+	ir.frame = make([]Value, frame.Size)
+	ir.cells = nil
+	if frame.Cells > 0 {
+		ir.cells = make([]*cell, frame.Cells)
 	}
-	return ir.functionScopes[len(ir.functionScopes)-1].retval
+	ir.captures = captures
 }
 
-func (ir interpreter) findIdent(ident string) (Value, bool) {
-	last := len(ir.idents) - 1
-	for i := range ir.idents {
-		scope := ir.idents[last-i]
-		val, ok := scope[ident]
-		if ok {
-			return val, true
+// enterModule allocates the globals of mod and the variables of its top-level code
+func (ir *interpreter) enterModule(mod *module, globals []string, frame *parser.Frame) {
+	mod.globals = make([]Value, len(globals))
+	mod.globalNames = globals
+	ir.enterFrame(mod.globals, frame, nil)
+}
+
+// enterFunction allocates the variables of fn and passes the arguments.
+// Returns the variables of the caller.
+func (ir *interpreter) enterFunction(fn Function, arguments []Value) activation {
+	caller := ir.activation()
+	ir.enterFrame(ir.modules[fn.module].globals, fn.frame, fn.captures)
+	for i, param := range fn.frame.Params {
+		ir.declareVar(param, arguments[i])
+	}
+	return caller
+}
+
+// capture returns the cells of the executing function that are captured by a function with the specified frame
+func (ir *interpreter) capture(frame *parser.Frame) []*cell {
+	if len(frame.Captures) == 0 {
+		return nil
+	}
+	captures := make([]*cell, len(frame.Captures))
+	for i, v := range frame.Captures {
+		if v.Kind == parser.CellVar {
+			captures[i] = ir.cells[v.Index]
+		} else {
+			captures[i] = ir.captures[v.Index]
 		}
 	}
-	return nil, false
+	return captures
 }
 
-func (ir *interpreter) newIdent(ident string, val Value) error {
-	ir.idents[len(ir.idents)-1][ident] = val
-	return nil
+func (ir *interpreter) getReturnValue() Value {
+	if len(ir.functionScopes) <= 0 {
+		return nil
+	}
+	return ir.functionScopes[len(ir.functionScopes)-1].retval
 }
 
-func (ir *interpreter) removeIdent(ident string) {
-	delete(ir.idents[len(ir.idents)-1], ident)
+// loadVar returns the value of the variable v, which is named ident
+func (ir *interpreter) loadVar(v *parser.Var, ident string) (Value, error) {
+	var val Value
+	switch v.Kind {
+	case parser.LocalVar:
+		val = ir.frame[v.Index]
+	case parser.CellVar:
+		if c := ir.cells[v.Index]; c != nil {
+			val = c.val
+		}
+	case parser.CaptureVar:
+		val = ir.captures[v.Index].val
+	case parser.GlobalVar:
+		val = ir.globals[v.Index]
+	case parser.ConstantVar:
+		val = ir.constants[v.Index]
+	}
+	if val == nil {
+		return nil, fmt.Errorf("identifier '%s' is used before its declaration", ident)
+	}
+	return val, nil
 }
 
-func (ir *interpreter) assignIdent(ident string, val Value) error {
-	last := len(ir.idents) - 1
-	for i := range ir.idents {
-		scope := ir.idents[last-i]
-		_, ok := scope[ident]
-		if ok {
-			scope[ident] = val
-			return nil
-		}
+// storeVar assigns val to the variable v
+func (ir *interpreter) storeVar(v *parser.Var, val Value) {
+	switch v.Kind {
+	case parser.LocalVar:
+		ir.frame[v.Index] = val
+	case parser.CellVar:
+		ir.cells[v.Index].val = val
+	case parser.CaptureVar:
+		ir.captures[v.Index].val = val
+	case parser.GlobalVar:
+		ir.globals[v.Index] = val
+	case parser.ConstantVar:
+		ir.constants[v.Index] = val
 	}
-	return fmt.Errorf("identifier '%s' Not found", ident)
 }
 
-func (ir *interpreter) assignBounds(new Boolean) error {
-	bounds := Rect{image.Point{0, 0}, image.Point{ir.bitmap.SourceWidth(), ir.bitmap.SourceHeight()}}
-	if new {
-		return ir.newIdent("Bounds", bounds)
+// declareVar initializes the variable v with val. Cells are replaced by a new cell,
+// so that closures created before keep the previous variable.
+func (ir *interpreter) declareVar(v *parser.Var, val Value) {
+	switch v.Kind {
+	case parser.CellVar:
+		ir.cells[v.Index] = &cell{val: val}
+	case parser.CaptureVar:
+		ir.captures[v.Index] = &cell{val: val}
+	default:
+		ir.storeVar(v, val)
 	}
-	return ir.assignIdent("Bounds", bounds)
+}
+
+func (ir *interpreter) assignBounds() {
+	ir.constants[boundsConst] = Rect{image.Point{0, 0}, image.Point{ir.bitmap.SourceWidth(), ir.bitmap.SourceHeight()}}
 }
 
 func (ir *interpreter) visitStmtList(stmts []parser.Statement) error {
 	for _, s := range stmts {
 		if err := ir.visitStmt(s); err != nil {
-			if _, ok := err.(returnSignal); ok { // return statement encountered
+			if isSignal(err) { // return statement encountered or error from generator consumer
 				return err
 			}
-
-			tok := s.Token()
-			return fmt.Errorf("line %d near '%s': %s", tok.LineNumber, tok.Lexeme, err)
+			return ir.errorAt(err, s.Token())
 		}
 	}
 	return nil
@@ -155,31 +363,48 @@ func (ir *interpreter) visitStmtList(stmts []parser.Statement) error {
 const lastRectIdent string = "@:R" // this is safe because its Not a valid ident
 
 func (ir *interpreter) visitStmt(stmt parser.Statement) error {
+	if ir.debug != nil && !ir.debug.evaluating {
+		if err := ir.debug.debugger.Statement(stmt, &DebugState{ir: ir, tok: stmt.Token()}); err != nil {
+			return err
+		}
+	}
+	if err := ir.step(); err != nil {
+		return err
+	}
+	if ir.profiler != nil {
+		return ir.profiler.statement(ir, stmt)
+	}
+	return ir.visitStmtInner(stmt)
+}
+
+func (ir *interpreter) visitStmtInner(stmt parser.Statement) error {
 	switch s := stmt.(type) {
 	case parser.DeclStmt:
+		if s.Var.Kind == parser.CellVar {
+			ir.declareVar(s.Var, nil) // the function declared by s may capture itself
+		}
 		v, err := ir.visitExpr(s.Rhs)
 		if err != nil {
 			return err
 		}
-		err = ir.newIdent(s.Ident, v)
-		if err != nil {
-			return err
+		if s.Param {
+			if v, err = ir.paramValue(s.Ident, v); err != nil {
+				return err
+			}
 		}
+		ir.storeVar(s.Var, v)
 
 	case parser.AssignStmt:
 		v, err := ir.visitExpr(s.Rhs)
 		if err != nil {
 			return err
 		}
-		err = ir.assignIdent(s.Ident, v)
-		if err != nil {
-			return err
-		}
+		ir.storeVar(s.Var, v)
 
 	case parser.IndexedAssignStmt:
-		lval, ok := ir.findIdent(s.Ident)
-		if !ok {
-			return fmt.Errorf("unkown identifier '%s'", s.Ident)
+		lval, err := ir.loadVar(s.Var, s.Ident)
+		if err != nil {
+			return err
 		}
 		ival, err := ir.visitExpr(s.Index)
 		if err != nil {
@@ -198,19 +423,14 @@ func (ir *interpreter) visitStmt(stmt parser.Statement) error {
 		if err != nil {
 			return err
 		}
-		pos, ok := left.(Point)
-		if !ok {
+		if _, ok := left.(Point); !ok {
 			return fmt.Errorf("type mismatch: expected @point = color")
 		}
 		right, err := ir.visitExpr(s.Rhs)
 		if err != nil {
 			return err
 		}
-		color, ok := right.(Color)
-		if !ok {
-			return fmt.Errorf("type mismatch: expected @point = color")
-		}
-		ir.bitmap.SetPixel(pos.X, pos.Y, lang.Color(color))
+		return ir.setPixel(left, right)
 
 	case parser.InvocationStmt:
 		if _, err := ir.visitExpr(s.Invocation); err != nil {
@@ -227,13 +447,9 @@ func (ir *interpreter) visitStmt(stmt parser.Statement) error {
 			return fmt.Errorf("type mismatch: expected if(boolean)")
 		}
 		if b {
-			ir.pushScope()
-			defer ir.popScope()
 			return ir.visitStmtList(s.TrueStmts)
 		}
 		if s.FalseStmts != nil {
-			ir.pushScope()
-			defer ir.popScope()
 			return ir.visitStmtList(s.FalseStmts)
 		}
 
@@ -244,18 +460,34 @@ func (ir *interpreter) visitStmt(stmt parser.Statement) error {
 		}
 		rect, ok := collVal.(Rect)
 		if ok {
-			ir.assignIdent(lastRectIdent, rect)
+			ir.constants[lastRectConst] = rect
+			if parallel, err := ir.forInParallel(s, rect); parallel {
+				return err
+			}
 		}
-		ir.pushScope()
-		defer ir.popScope()
-		ir.newIdent(s.Ident, nil)
-		return collVal.Iterate(func(val Value) error {
-			ir.assignIdent(s.Ident, val)
-			if err := ir.visitStmtList(s.Stmts); err != nil {
+		ir.pushLoopVar(s.Ident)
+		defer ir.popLoopVar()
+		err = collVal.Iterate(func(val Value) error {
+			if err := ir.step(); err != nil {
 				return err
 			}
+			ir.declareVar(s.Var, val)
+			ir.setLoopVar(val)
+			if err := ir.visitStmtList(s.Stmts); err != nil {
+				if _, ok := err.(continueSignal); ok {
+					return nil
+				}
+				return err // breakSignal stops the iteration
+			}
 			return nil
 		})
+		if _, ok := err.(breakSignal); ok {
+			return nil
+		}
+		return err
+
+	case parser.ParallelForStmt:
+		return ir.visitParallelFor(s)
 
 	case parser.ForRangeStmt:
 		lowerVal, err := ir.visitExpr(s.Lower)
@@ -282,18 +514,30 @@ func (ir *interpreter) visitStmt(stmt parser.Statement) error {
 		if !ok {
 			return fmt.Errorf("type mismatch: expected upper number")
 		}
-		ir.pushScope()
-		defer ir.popScope()
-		ir.newIdent(s.Ident, nil)
+		ir.pushLoopVar(s.Ident)
+		defer ir.popLoopVar()
 		for n := lowerN; n < upperN; n += stepN {
-			ir.assignIdent(s.Ident, n)
+			if err := ir.step(); err != nil {
+				return err
+			}
+			ir.declareVar(s.Var, n)
+			ir.setLoopVar(n)
 			if err := ir.visitStmtList(s.Stmts); err != nil {
+				if _, ok := err.(breakSignal); ok {
+					break
+				}
+				if _, ok := err.(continueSignal); ok {
+					continue
+				}
 				return err
 			}
 		}
 
 	case parser.WhileStmt:
 		for {
+			if err := ir.step(); err != nil {
+				return err
+			}
 			condVal, err := ir.visitExpr(s.Cond)
 			if err != nil {
 				return err
@@ -307,39 +551,98 @@ func (ir *interpreter) visitStmt(stmt parser.Statement) error {
 			}
 			err = ir.visitStmtList(s.Stmts)
 			if err != nil {
+				if _, ok := err.(breakSignal); ok {
+					break
+				}
+				if _, ok := err.(continueSignal); ok {
+					continue
+				}
 				return err
 			}
 		}
 
 	case parser.YieldStmt:
-		return fmt.Errorf("yield Not yet implemented")
+		result, err := ir.visitExpr(s.Result)
+		if err != nil {
+			return err
+		}
+		return ir.yield(result)
 
 	case parser.LogStmt:
-		buf := strings.Builder{}
-		for _, expr := range s.Args {
+		args := make([]Value, len(s.Args))
+		for i, expr := range s.Args {
 			v, err := ir.visitExpr(expr)
 			if err != nil {
 				return err
 			}
-			buf.WriteString(formatValue(v, "", false))
+			args[i] = v
 		}
-		ir.bitmap.Log(buf.String())
+		ir.log(args)
+
+	case parser.BreakStmt:
+		return breakSig
+
+	case parser.ContinueStmt:
+		return continueSig
 
 	case parser.ReturnStmt:
 		result, err := ir.visitExpr(s.Result)
 		if err != nil {
 			return err
 		}
-		if len(ir.functionScopes) > 0 {
-			ir.functionScopes[len(ir.functionScopes)-1].retval = result
-			return returnSig
-		}
-		if _, isNil := result.(Nilval); isNil {
-			return returnSig
-		}
-		return fmt.Errorf("A script can only return 'nil' from root level")
+		return ir.returnValue(result)
+	}
+
+	return nil
+}
+
+// returnValue makes result the return value of the executing function.
+// returns returnSig on success.
+func (ir *interpreter) returnValue(result Value) error {
+	if len(ir.functionScopes) > 0 {
+		ir.functionScopes[len(ir.functionScopes)-1].retval = result
+		return returnSig
+	}
+	if _, isNil := result.(Nilval); isNil {
+		return returnSig
+	}
+	return fmt.Errorf("A script can only return 'nil' from root level")
+}
+
+// yield hands result to the consumer of the executing generator function
+func (ir *interpreter) yield(result Value) error {
+	if len(ir.functionScopes) == 0 || ir.functionScopes[len(ir.functionScopes)-1].yield == nil {
+		return fmt.Errorf("yield is only allowed inside a generator function")
+	}
+	return ir.functionScopes[len(ir.functionScopes)-1].yield(result)
+}
+
+func (ir *interpreter) log(args []Value) {
+	buf := strings.Builder{}
+	for _, v := range args {
+		buf.WriteString(formatValue(v, "", false))
 	}
+	ir.bitmap.Log(buf.String())
+}
+
+func (ir *interpreter) getPixel(val Value) (Value, error) {
+	pos, ok := val.(Point)
+	if !ok {
+		return nil, fmt.Errorf("type mismatch: expected @point, but found @%s", val.RuntimeTypeName())
+	}
+	return Color(ir.bitmap.GetPixel(pos.X, pos.Y)), nil
+}
 
+func (ir *interpreter) setPixel(left Value, right Value) error {
+	pos, ok := left.(Point)
+	if !ok {
+		return fmt.Errorf("type mismatch: expected @point = color")
+	}
+	color, ok := right.(Color)
+	if !ok {
+		return fmt.Errorf("type mismatch: expected @point = color")
+	}
+	ir.bitmap.SetPixel(pos.X, pos.Y, lang.Color(color))
 	return nil
 }
 
@@ -357,10 +660,77 @@ func (ir *interpreter) visitBinaryExpr(left parser.Expression, right parser.Expr
 	return visitor(leftVal, rightVal)
 }
 
+func compare(left Value, right Value, pred func(n Number) bool) Value {
+	cmp, _ := left.Compare(right)
+	if cmp == nil {
+		return Boolean(lang.FalseVal)
+	}
+	n, ok := cmp.(Number)
+	return Boolean(ok && pred(n))
+}
+
+func equal(left Value, right Value) (Value, error) {
+	return compare(left, right, func(n Number) bool { return n == 0 }), nil
+}
+
+func notEqual(left Value, right Value) (Value, error) {
+	cmp, _ := left.Compare(right)
+	if cmp == nil {
+		return Boolean(lang.TrueVal), nil
+	}
+	n, ok := cmp.(Number)
+	return Boolean(!ok || n != 0), nil
+}
+
+func greater(left Value, right Value) (Value, error) {
+	return compare(left, right, func(n Number) bool { return n > 0 }), nil
+}
+
+func greaterOrEqual(left Value, right Value) (Value, error) {
+	return compare(left, right, func(n Number) bool { return n >= 0 }), nil
+}
+
+func less(left Value, right Value) (Value, error) {
+	return compare(left, right, func(n Number) bool { return n < 0 }), nil
+}
+
+func lessOrEqual(left Value, right Value) (Value, error) {
+	return compare(left, right, func(n Number) bool { return n <= 0 }), nil
+}
+
+func makePoint(xVal Value, yVal Value) (Value, error) {
+	x, ok := xVal.(Number)
+	if !ok {
+		return nil, fmt.Errorf("type mismatch: expected pos(Number, Number)")
+	}
+	y, ok := yVal.(Number)
+	if !ok {
+		return nil, fmt.Errorf("type mismatch: expected pos(Number, Number)")
+	}
+	return Point{int(x + 0.5), int(y + 0.5)}, nil
+}
+
+func makeKernel(elements []Value) (Value, error) {
+	elementNumbers := make([]lang.Number, len(elements))
+	for i, element := range elements {
+		n, ok := element.(Number)
+		if !ok {
+			return nil, fmt.Errorf("type mismatch: kernel expr expects number elements")
+		}
+		elementNumbers[i] = lang.Number(n)
+	}
+	rootOfLen := int(math.Sqrt(float64(len(elementNumbers))))
+	return Kernel{
+		Values: elementNumbers,
+		Width:  rootOfLen,
+		Height: rootOfLen,
+	}, nil
+}
+
 func (ir *interpreter) visitExpr(expr parser.Expression) (Value, error) {
 	v, err := ir.visitExprInner(expr)
 	if err != nil {
-		return nil, err
+		return nil, ir.errorAt(err, expr.Token())
 	}
 	if v == nil {
 		return Nilval{}, nil
@@ -429,69 +799,25 @@ func (ir *interpreter) visitExprInner(expr parser.Expression) (Value, error) {
 		return Boolean(b), nil
 
 	case parser.EqExpr:
-		return ir.visitBinaryExpr(e.Left, e.Right, func(left Value, right Value) (Value, error) {
-			cmp, _ := left.Compare(right)
-			if cmp == nil {
-				return Boolean(lang.FalseVal), nil
-			}
-			n, ok := cmp.(Number)
-			return Boolean(ok && n == 0), nil
-		})
+		return ir.visitBinaryExpr(e.Left, e.Right, equal)
 
 	case parser.NeqExpr:
-		return ir.visitBinaryExpr(e.Left, e.Right, func(left Value, right Value) (Value, error) {
-			cmp, _ := left.Compare(right)
-			if cmp == nil {
-				return Boolean(lang.TrueVal), nil
-			}
-			n, ok := cmp.(Number)
-			return Boolean(!ok || n != 0), nil
-		})
+		return ir.visitBinaryExpr(e.Left, e.Right, notEqual)
 
 	case parser.GtExpr:
-		return ir.visitBinaryExpr(e.Left, e.Right, func(left Value, right Value) (Value, error) {
-			cmp, _ := left.Compare(right)
-			if cmp == nil {
-				return Boolean(lang.FalseVal), nil
-			}
-			n, ok := cmp.(Number)
-			return Boolean(ok && n > 0), nil
-		})
+		return ir.visitBinaryExpr(e.Left, e.Right, greater)
 
 	case parser.GeExpr:
-		return ir.visitBinaryExpr(e.Left, e.Right, func(left Value, right Value) (Value, error) {
-			cmp, _ := left.Compare(right)
-			if cmp == nil {
-				return Boolean(lang.FalseVal), nil
-			}
-			n, ok := cmp.(Number)
-			return Boolean(ok && n >= 0), nil
-		})
+		return ir.visitBinaryExpr(e.Left, e.Right, greaterOrEqual)
 
 	case parser.LtExpr:
-		return ir.visitBinaryExpr(e.Left, e.Right, func(left Value, right Value) (Value, error) {
-			cmp, _ := left.Compare(right)
-			if cmp == nil {
-				return Boolean(lang.FalseVal), nil
-			}
-			n, ok := cmp.(Number)
-			return Boolean(ok && n < 0), nil
-		})
+		return ir.visitBinaryExpr(e.Left, e.Right, less)
 
 	case parser.LeExpr:
-		return ir.visitBinaryExpr(e.Left, e.Right, func(left Value, right Value) (Value, error) {
-			cmp, _ := left.Compare(right)
-			if cmp == nil {
-				return Boolean(lang.FalseVal), nil
-			}
-			n, ok := cmp.(Number)
-			return Boolean(ok && n <= 0), nil
-		})
+		return ir.visitBinaryExpr(e.Left, e.Right, lessOrEqual)
 
 	case parser.ConcatExpr:
-		return ir.visitBinaryExpr(e.Left, e.Right, func(left Value, right Value) (Value, error) {
-			return left.Concat(right)
-		})
+		return ir.visitBinaryExpr(e.Left, e.Right, ir.concat)
 
 	case parser.AddExpr:
 		return ir.visitBinaryExpr(e.Left, e.Right, func(left Value, right Value) (Value, error) {
@@ -538,17 +864,7 @@ func (ir *interpreter) visitExprInner(expr parser.Expression) (Value, error) {
 		return leftVal.Not()
 
 	case parser.PosExpr:
-		return ir.visitBinaryExpr(e.X, e.Y, func(xVal Value, yVal Value) (Value, error) {
-			x, ok := xVal.(Number)
-			if !ok {
-				return nil, fmt.Errorf("type mismatch: expected pos(Number, Number)")
-			}
-			y, ok := yVal.(Number)
-			if !ok {
-				return nil, fmt.Errorf("type mismatch: expected pos(Number, Number)")
-			}
-			return Point{int(x + 0.5), int(y + 0.5)}, nil
-		})
+		return ir.visitBinaryExpr(e.X, e.Y, makePoint)
 
 	case parser.MemberExpr:
 		recvrVal, err := ir.visitExpr(e.Recvr)
@@ -583,38 +899,33 @@ func (ir *interpreter) visitExprInner(expr parser.Expression) (Value, error) {
 		}
 		return recvr.IndexRange(lower, upper)
 
-	case lang.Str:
-		return Str(e), nil
+	case parser.StrExpr:
+		return Str(e.Value), nil
 
-	case lang.Boolean:
-		return Boolean(e), nil
+	case parser.BoolExpr:
+		return Boolean(e.Value), nil
 
-	case lang.Number:
-		return Number(e), nil
+	case parser.NumberExpr:
+		return Number(e.Value), nil
 
-	case lang.Color:
-		return Color(e), nil
+	case parser.ColorExpr:
+		return Color(e.Value), nil
 
-	case lang.Nil:
-		return Nilval(e), nil
+	case parser.NilExpr:
+		return Nilval(lang.NilVal), nil
 
 	case parser.IdentExpr:
-		val, ok := ir.findIdent(string(e))
-		if !ok {
-			return nil, fmt.Errorf("identifier '%s' Not found", e)
-		}
-		return val, nil
+		return ir.loadVar(e.Var, e.Ident)
 
 	case parser.AtExpr:
 		val, err := ir.visitExpr(e.Inner)
 		if err != nil {
 			return nil, err
 		}
-		pos, ok := val.(Point)
-		if !ok {
-			return nil, fmt.Errorf("")
-		}
-		return Color(ir.bitmap.GetPixel(pos.X, pos.Y)), nil
+		return ir.getPixel(val)
+
+	case parser.SampleExpr:
+		return ir.visitBinaryExpr(e.Image, e.Point, sample)
 
 	case parser.InvokeExpr:
 		args := []Value{}
@@ -625,36 +936,67 @@ func (ir *interpreter) visitExprInner(expr parser.Expression) (Value, error) {
 			}
 			args = append(args, arg)
 		}
-		return ir.invokeFunc(e.FuncName, args)
+		outerCallSite := ir.callSite
+		ir.callSite = e.Token()
+		defer func() { ir.callSite = outerCallSite }()
+		return ir.invokeFunc(e.FuncName, e.Var, args)
 
 	case parser.KernelExpr:
-		elementNumbers := make([]lang.Number, len(e.Elements))
+		if err := ir.checkAllocation(float64(len(e.Elements))); err != nil {
+			return nil, err
+		}
+		elements := make([]Value, len(e.Elements))
 		for i, element := range e.Elements {
 			elementVal, err := ir.visitExpr(element)
 			if err != nil {
 				return nil, err
 			}
-			n, ok := elementVal.(Number)
-			if !ok {
-				return nil, fmt.Errorf("type mismatch: kernel expr expects number elements")
-			}
-			elementNumbers[i] = lang.Number(n)
+			elements[i] = elementVal
 		}
-		rootOfLen := int(math.Sqrt(float64(len(elementNumbers))))
-		return Kernel{
-			Values: elementNumbers,
-			Width:  rootOfLen,
-			Height: rootOfLen,
-		}, nil
+		return makeKernel(elements)
 
 	case parser.FunctionExpr:
 		return Function{
 			ParameterNames: e.ParameterNames,
 			Body:           e.Body,
-			closure:        ir.idents[2:], // omit constants and top scope - they are visible In any context
+			IsGenerator:    e.IsGenerator,
+			frame:          e.Frame,
+			captures:       ir.capture(e.Frame),
+			module:         ir.currentModule(),
 		}, nil
 
+	case parser.CallExpr:
+		callee, err := ir.visitExpr(e.Callee)
+		if err != nil {
+			return nil, err
+		}
+		args := []Value{}
+		for _, arg := range e.Args {
+			arg, err := ir.visitExpr(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		name := "<fn>"
+		switch c := e.Callee.(type) {
+		case parser.MemberExpr:
+			name = c.Member
+		case parser.IdentExpr:
+			name = c.Ident
+		}
+		outerCallSite := ir.callSite
+		ir.callSite = e.Token()
+		defer func() { ir.callSite = outerCallSite }()
+		return ir.invokeFunctionExpr(name, callee, args)
+
+	case parser.ImportExpr:
+		return ir.importModule(e.Path, e.Token())
+
 	case parser.HashMapExpr:
+		if err := ir.checkAllocation(float64(len(e.Entries))); err != nil {
+			return nil, err
+		}
 		h := make(HashMap)
 		for _, entry := range e.Entries {
 			key, err := ir.visitExpr(entry.Key)
@@ -670,6 +1012,9 @@ func (ir *interpreter) visitExprInner(expr parser.Expression) (Value, error) {
 		return h, nil
 
 	case parser.ListExpr:
+		if err := ir.checkAllocation(float64(len(e.Elements))); err != nil {
+			return nil, err
+		}
 		l := List{
 			Elements: make([]Value, len(e.Elements)),
 		}
@@ -687,31 +1032,26 @@ func (ir *interpreter) visitExprInner(expr parser.Expression) (Value, error) {
 		if err != nil {
 			return nil, err
 		}
-		pipelineValueIdent := lexer.TokenTypeName(lexer.TTDollar)
-		_ = ir.newIdent(pipelineValueIdent, left)
-		defer ir.removeIdent(pipelineValueIdent)
-		right, err := ir.visitExpr(e.Right)
-		if err != nil {
-			return nil, err
-		}
-		return right, nil
+		ir.declareVar(e.Var, left)
+		return ir.visitExpr(e.Right)
 	}
 
 	return nil, fmt.Errorf("unknown expression type %s", reflect.TypeOf(expr))
 }
 
-func (ir *interpreter) invokeFunc(name string, arguments []Value) (Value, error) {
-	val, ok, err := ir.invokeBuiltinFunction(name, arguments)
+// invokeFunc invokes the builtin function name or, if v is non-nil, the function stored in the variable v
+func (ir *interpreter) invokeFunc(name string, v *parser.Var, arguments []Value) (Value, error) {
+	if v == nil {
+		val, ok, err := ir.invokeBuiltinFunction(name, arguments)
+		if !ok && err == nil {
+			return nil, fmt.Errorf("unknown function '%s'", name)
+		}
+		return val, err
+	}
+	fval, err := ir.loadVar(v, name)
 	if err != nil {
 		return nil, err
 	}
-	if ok {
-		return val, nil
-	}
-	fval, ok := ir.findIdent(name)
-	if !ok {
-		return nil, fmt.Errorf("unknown identifier '%s'", name)
-	}
 	return ir.invokeFunctionExpr(name, fval, arguments)
 }
 
@@ -723,14 +1063,35 @@ func (ir *interpreter) invokeFunctionExpr(name string, val Value, arguments []Va
 	if len(arguments) != len(fn.ParameterNames) {
 		return nil, fmt.Errorf("%s is invoked with %d arguments, but is declared with %d parameters", name, len(arguments), len(fn.ParameterNames))
 	}
+	if fn.IsGenerator {
+		return Generator{
+			name:      name,
+			callSite:  ir.callSite,
+			fn:        fn,
+			arguments: append([]Value(nil), arguments...), // builtins may reuse the argument slice
+			ir:        ir,
+		}, nil
+	}
 
-	prevScopeCount := ir.pushFunctionScope(fn.closure)
-	defer ir.popFunctionScope(prevScopeCount)
-	for i, argument := range arguments {
-		ir.newIdent(fn.ParameterNames[i], argument)
+	if err := ir.checkCallDepth(); err != nil {
+		return nil, err
 	}
+	if ir.profiler != nil {
+		defer ir.profiler.leave(ir.profiler.enterFunction(ir.modules[fn.module].name, name))
+	}
+	caller := ir.enterFunction(fn, arguments)
+	ir.functionScopes = append(ir.functionScopes, functionScope{})
+	ir.pushCallFrame(name, ir.callSite, fn.module)
+	if ir.debug != nil {
+		ir.debugFrame(caller, &fn)
+	}
+	defer func() {
+		ir.popCallFrame()
+		ir.functionScopes = ir.functionScopes[:len(ir.functionScopes)-1]
+		ir.restore(caller)
+	}()
 
-	if err := ir.visitStmtList(fn.Body); err != nil {
+	if err := fn.runBody(ir); err != nil {
 		if _, ok := err.(returnSignal); !ok { // return statement encountered
 			return nil, err
 		}
@@ -738,14 +1099,68 @@ func (ir *interpreter) invokeFunctionExpr(name string, val Value, arguments []Va
 	return ir.getReturnValue(), nil
 }
 
+// iterateGenerator executes the body of the generator function, passing each yielded value to visit.
+// the generator body runs on its own variables and call stack, which are swapped with the
+// consumer's whenever a value is yielded.
+func (ir *interpreter) iterateGenerator(gen Generator, visit func(Value) error) error {
+	if err := ir.checkCallDepth(); err != nil {
+		return err
+	}
+	if ir.profiler != nil {
+		defer ir.profiler.leave(ir.profiler.enterFunction(ir.modules[gen.fn.module].name, gen.name))
+	}
+	consumerFunctionScopes, consumerCallStack := ir.functionScopes, ir.callStack
+	consumer := ir.enterFunction(gen.fn, gen.arguments)
+	defer func() {
+		ir.functionScopes, ir.callStack = consumerFunctionScopes, consumerCallStack
+		ir.restore(consumer)
+	}()
+
+	yield := func(val Value) error {
+		producerFunctionScopes, producerCallStack := ir.functionScopes, ir.callStack
+		producer := ir.activation()
+		ir.functionScopes, ir.callStack = consumerFunctionScopes, consumerCallStack
+		ir.restore(consumer)
+		err := visit(val)
+		ir.functionScopes, ir.callStack = producerFunctionScopes, producerCallStack
+		ir.restore(producer)
+		if err != nil {
+			return consumerError{err}
+		}
+		return nil
+	}
+
+	ir.functionScopes = []functionScope{{yield: yield}}
+	ir.callStack = append([]callFrame(nil), consumerCallStack...)
+	ir.pushCallFrame(gen.name, gen.callSite, gen.fn.module)
+	if ir.debug != nil {
+		ir.debugFrame(consumer, &gen.fn)
+	}
+
+	if err := gen.fn.runBody(ir); err != nil {
+		switch e := err.(type) {
+		case returnSignal: // return statement ends the iteration
+			return nil
+		case consumerError:
+			return e.err
+		}
+		return err
+	}
+	return nil
+}
+
 func (ir *interpreter) invokeBuiltinFunction(name string, arguments []Value) (Value, bool, error) {
-	fs, ok := functions[name]
-	if !ok {
+	fs := ir.registry.lookup(name)
+	if fs == nil {
 		return nil, false, nil
 	}
 	var err error
 	for _, f := range fs {
 		if err = validateArguments(arguments, f.params); err == nil {
+			if ir.profiler != nil {
+				val, err := ir.profiler.builtin(ir, name, f.body, arguments)
+				return val, true, err
+			}
 			val, err := f.body(ir, arguments)
 			return val, true, err
 		}
@@ -1,6 +1,8 @@
 package interpreter
 
 import (
+	"context"
+	"github.com/smackem/ylang/internal/emitter"
 	"github.com/smackem/ylang/internal/lang"
 	"github.com/smackem/ylang/internal/lexer"
 	"github.com/smackem/ylang/internal/parser"
@@ -9,23 +11,85 @@ import (
 	"testing"
 )
 
-func compileAndInterpret(src string) (scope, error) {
+// engines are the execution engines all interpreter tests are run against:
+// the syntax tree interpreter and the virtual machine running bytecode emitted by emitter.Emit
+var engines = []string{"interpreter", "vm"}
+
+// scope maps the names of variables to their values
+type scope map[string]Value
+
+// compile lexes, parses and resolves src
+func compile(src string, omitTokens bool) (parser.Program, error) {
 	tokens, err := lexer.Lex(src)
 	if err != nil {
-		return nil, err
+		return parser.Program{}, err
+	}
+	program, err := parser.Parse(tokens, omitTokens)
+	if err != nil {
+		return parser.Program{}, err
 	}
-	program, err := parser.Parse(tokens, true)
+	return Resolve(program)
+}
+
+// compileAndInterpret executes src with the specified engine and returns the
+// global variables of the script
+func compileAndInterpret(engine string, src string) (scope, error) {
+	program, err := compile(src, true)
 	if err != nil {
 		return nil, err
 	}
 	ir := newInterpreter(nil)
-	err = ir.visitStmtList(program.Stmts)
+	if engine == "vm" {
+		code := emitter.Emit(program)
+		unit := ir.loadCode(&code)
+		ir.enterModule(ir.modules[0], unit.globals, unit.frame)
+		err = ir.execute(unit, 0)
+	} else {
+		ir.enterModule(ir.modules[0], program.Globals, program.Frame)
+		err = ir.visitStmtList(program.Stmts)
+	}
 	if err != nil {
 		return nil, err
 	}
-	topScope := ir.idents[1]
-	delete(topScope, lastRectIdent)
-	return topScope, nil
+	globals := make(scope)
+	for i, ident := range program.Globals {
+		if val := ir.modules[0].globals[i]; val != nil {
+			globals[ident] = val
+		}
+	}
+	if engine == "vm" {
+		globals = withoutFunctionBodies(globals)
+	}
+	return globals, nil
+}
+
+// execute resolves program and runs it with the specified engine like Interpret or Run do
+func execute(engine string, program parser.Program, bitmap BitmapContext) error {
+	program, err := Resolve(program)
+	if err != nil {
+		return err
+	}
+	if engine == "vm" {
+		return Run(context.Background(), emitter.Emit(program), bitmap, Options{})
+	}
+	return Interpret(context.Background(), program, bitmap, Options{})
+}
+
+// withoutFunctionBodies returns a copy of s with all function bodies removed, which are
+// represented by syntax trees or bytecode depending on the engine.
+func withoutFunctionBodies(s scope) scope {
+	if s == nil {
+		return nil
+	}
+	result := make(scope)
+	for ident, val := range s {
+		if fn, ok := val.(Function); ok {
+			fn.Body, fn.unit, fn.address = nil, nil, 0
+			val = fn
+		}
+		result[ident] = val
+	}
+	return result
 }
 
 func Test_interpret(t *testing.T) {
@@ -46,9 +110,7 @@ func Test_interpret(t *testing.T) {
 			name: "multi_declaration",
 			src: `x := 1
 			      x := 2`,
-			want: scope{
-				"x": Number(2),
-			},
+			wantErr: true,
 		},
 		{
 			name: "rect",
@@ -415,10 +477,10 @@ func Test_interpret(t *testing.T) {
 					Body: []parser.Statement{
 						parser.ReturnStmt{
 							StmtBase: parser.StmtBase{},
-							Result:   lang.Number(123),
+							Result:   parser.NumberExpr{Value: 123},
 						},
 					},
-					closure: []scope{},
+					frame: &parser.Frame{},
 				},
 				"ret": Number(123),
 			},
@@ -433,10 +495,13 @@ func Test_interpret(t *testing.T) {
 					Body: []parser.Statement{
 						parser.ReturnStmt{
 							StmtBase: parser.StmtBase{},
-							Result:   parser.IdentExpr("x"),
+							Result:   parser.IdentExpr{Ident: "x", Var: &parser.Var{Kind: parser.LocalVar}},
 						},
 					},
-					closure: []scope{},
+					frame: &parser.Frame{
+						Size:   1,
+						Params: []*parser.Var{{Kind: parser.LocalVar}},
+					},
 				},
 				"ret": Number(5),
 			},
@@ -493,25 +558,303 @@ func Test_interpret(t *testing.T) {
 				"a": Str("a7"),
 			},
 		},
+		{
+			name: "generator_sum",
+			src: `total := 0
+				  if true {
+					  g := fn(n) {
+						  for i in 0..n {
+							  yield i
+						  }
+					  }
+					  total = sum(g(5))
+				  }`,
+			want: scope{
+				"total": Number(10),
+			},
+		},
+		{
+			name: "generator_lazy",
+			src: `trace := []
+				  if true {
+					  g := fn() {
+						  trace = trace :: "a"
+						  yield 1
+						  trace = trace :: "b"
+						  yield 2
+						  return nil
+						  trace = trace :: "c"
+					  }
+					  for x in g() {
+						  trace = trace :: x
+					  }
+				  }`,
+			want: scope{
+				"trace": List{
+					Elements: []Value{Str("a"), Number(1), Str("b"), Number(2)},
+				},
+			},
+		},
+		{
+			name: "generator_sort",
+			src: `s := []
+				  if true {
+					  g := fn() {
+						  yield 3
+						  yield 1
+						  yield 2
+					  }
+					  s = sort(g())
+				  }`,
+			want: scope{
+				"s": List{
+					Elements: []Value{Number(1), Number(2), Number(3)},
+				},
+			},
+		},
+		{
+			name: "generator_nested",
+			src: `s := []
+				  if true {
+					  inner := fn(n) {
+						  for i in 0..n {
+							  yield i
+						  }
+					  }
+					  outer := fn() {
+						  for i in inner(3) {
+							  yield i * 10
+						  }
+					  }
+					  for x in outer() {
+						  s = s :: x
+					  }
+				  }`,
+			want: scope{
+				"s": List{
+					Elements: []Value{Number(0), Number(10), Number(20)},
+				},
+			},
+		},
+		{
+			name: "for_break_continue",
+			src: `s := []
+				  for x in [1, 2, 3, 4, 5, 6] {
+					  if x == 2 {
+						  continue
+					  }
+					  if x == 5 {
+						  break
+					  }
+					  s = s :: x
+				  }`,
+			want: scope{
+				"s": List{
+					Elements: []Value{Number(1), Number(3), Number(4)},
+				},
+			},
+		},
+		{
+			name: "for_range_break_continue",
+			src: `n := 0
+				  for i in 0..100 {
+					  if i % 2 == 1 {
+						  continue
+					  }
+					  if i >= 10 {
+						  break
+					  }
+					  n = n + i
+				  }`,
+			want: scope{
+				"n": Number(20),
+			},
+		},
+		{
+			name: "while_break_continue",
+			src: `i := 0
+				  n := 0
+				  while true {
+					  i = i + 1
+					  if i == 3 {
+						  continue
+					  }
+					  if i > 5 {
+						  break
+					  }
+					  n = n + i
+				  }`,
+			want: scope{
+				"i": Number(6),
+				"n": Number(12),
+			},
+		},
+		{
+			name: "nested_loop_break",
+			src: `n := 0
+				  for i in 0..3 {
+					  for j in 0..3 {
+						  if j == 1 {
+							  break
+						  }
+						  n = n + 1
+					  }
+				  }`,
+			want: scope{
+				"n": Number(3),
+			},
+		},
+		{
+			name: "generator_break",
+			src: `trace := []
+				  if true {
+					  g := fn() {
+						  for i in 0..10 {
+							  trace = trace :: "p"
+							  yield i
+						  }
+					  }
+					  for x in g() {
+						  if x == 2 {
+							  break
+						  }
+						  trace = trace :: x
+					  }
+				  }`,
+			want: scope{
+				"trace": List{
+					Elements: []Value{Str("p"), Number(0), Str("p"), Number(1), Str("p")},
+				},
+			},
+		},
+		{
+			name: "generator_consumer_error",
+			src: `g := fn() {
+					  yield 1
+				  }
+				  for x in g() {
+					  y := x + true
+				  }`,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := compileAndInterpret(tt.src)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("compileAndInterpret() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("compileAndInterpret() =\n%#v\nwant\n%#v", got, tt.want)
-			}
-		})
+		for _, engine := range engines {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				got, err := compileAndInterpret(engine, tt.src)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("compileAndInterpret() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				want := tt.want
+				if engine == "vm" {
+					want = withoutFunctionBodies(want)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("compileAndInterpret() =\n%#v\nwant\n%#v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func Test_interpret_errorPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		wantLine int
+		wantCol  int
+	}{
+		{
+			name:     "binary_operator",
+			src:      "x := 1\ny := 2 + x + true",
+			wantLine: 2,
+			wantCol:  12,
+		},
+		{
+			name:     "unknown_ident",
+			src:      "x := 1\nif x > 0 {\n  y := [1, z]\n}",
+			wantLine: 3,
+			wantCol:  12,
+		},
+		{
+			name:     "builtin",
+			src:      "x := sqrt(1)\n  y := sqrt(\"a\")",
+			wantLine: 2,
+			wantCol:  8,
+		},
+		{
+			name:     "inside_function",
+			src:      "f := fn(a) {\n  return a.foo\n}\nx := f(1)",
+			wantLine: 2,
+			wantCol:  12,
+		},
+	}
+	for _, tt := range tests {
+		for _, engine := range engines {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				tokens, err := lexer.Lex(tt.src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				program, err := parser.Parse(tokens, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = execute(engine, program, nil)
+				lerr, ok := err.(*lang.Error)
+				if !ok {
+					t.Fatalf("execute() error = %v, want *lang.Error", err)
+				}
+				if lerr.Line != tt.wantLine || lerr.Col != tt.wantCol {
+					t.Errorf("execute() error at %d:%d, want %d:%d (%s)", lerr.Line, lerr.Col, tt.wantLine, tt.wantCol, lerr.Msg)
+				}
+			})
+		}
+	}
+}
+
+func Test_interpret_errorTrace(t *testing.T) {
+	src := `inner := fn(x) {
+    return x + true
+}
+outer := fn(x) -> inner(x * 2)
+for i in 0..10 {
+    for j in [1, 2] {
+        if i == 3 {
+            log(outer(i))
+        }
+    }
+}`
+	tokens, err := lexer.Lex(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := parser.Parse(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lang.TraceFrame{
+		{Func: "<script>", Line: 8, LoopVars: []string{"i = 3", "j = 1"}},
+		{Func: "outer", Line: 4},
+		{Func: "inner", Line: 2},
+	}
+	for _, engine := range engines {
+		err = execute(engine, program, nil)
+		lerr, ok := err.(*lang.Error)
+		if !ok {
+			t.Fatalf("%s: execute() error = %v, want *lang.Error", engine, err)
+		}
+		if !reflect.DeepEqual(lerr.Trace, want) {
+			t.Errorf("%s: execute() trace = %#v, want %#v", engine, lerr.Trace, want)
+		}
 	}
 }
 
 func Test_newInterpreter(t *testing.T) {
-	t.Run("scopeCount", func(t *testing.T) {
-		if got := newInterpreter(nil); len(got.idents) != initialScopeCount {
-			t.Errorf("interpreter initial scope count = %v, want %v", len(got.idents), initialScopeCount)
+	t.Run("constants", func(t *testing.T) {
+		if got := newInterpreter(nil); len(got.constants) != len(constantNames) {
+			t.Errorf("interpreter constant count = %v, want %v", len(got.constants), len(constantNames))
 		}
 	})
 }
@@ -0,0 +1,159 @@
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Options control the execution of a program by Interpret or Run and limit the resources it may use.
+// Zero limits impose no limit.
+type Options struct {
+	Profile       *Profile          // records the execution if non-nil, only supported by Interpret
+	Registry      *Registry         // the builtin functions and constants added by the host the program has been resolved with
+	Params        map[string]string // the values of script parameters by name, see ParseParam
+	Constants     map[string]Value  // the values of constants added to the Registry by name, replacing the values they have been added with
+	MaxSteps      int               // the maximum number of statements and loop iterations executed by Interpret or instructions executed by Run
+	Timeout       time.Duration     // the maximum wall-clock time of the execution
+	MaxAllocation int               // the maximum number of elements of a list, kernel or hashmap created by a literal, a concatenation or a builtin function and of pixels of a resized target
+	MaxCallDepth  int               // the maximum number of nested function invocations
+	Threads       int               // the maximum number of workers executing a parallel loop, 0 for GOMAXPROCS
+}
+
+// LimitError is the cause of the *lang.Error returned if a program exceeds a limit of its Options
+// or if its context is done. Use errors.As to detect it.
+type LimitError struct {
+	Msg string
+	Err error // the error of the context if it is done, nil otherwise
+}
+
+func (e *LimitError) Error() string {
+	return e.Msg
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// stepBatch is the number of steps an interpreter takes from the shared step budget at once.
+// the context is checked whenever a batch has been used up. since the workers of a parallel
+// loop hold a batch each, a program may be stopped up to stepBatch steps per worker early.
+const stepBatch = 1024
+
+// limits are shared by an interpreter and all workers it forks
+type limits struct {
+	done          <-chan struct{}
+	ctx           context.Context
+	maxSteps      int
+	remaining     int64 // the number of steps not yet taken by any interpreter, only used if maxSteps > 0
+	maxAllocation int
+	maxCallDepth  int
+}
+
+// newLimits returns the limits imposed by ctx and options, or nil if there are none.
+// the returned function releases the resources of the derived context and must be called
+// after the execution has finished.
+func newLimits(ctx context.Context, options Options) (*limits, context.CancelFunc) {
+	cancel := func() {}
+	if options.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+	}
+	if ctx.Done() == nil && options.MaxSteps == 0 && options.Timeout == 0 && options.MaxAllocation == 0 && options.MaxCallDepth == 0 {
+		return nil, cancel
+	}
+	return &limits{
+		done:          ctx.Done(),
+		ctx:           ctx,
+		maxSteps:      options.MaxSteps,
+		remaining:     int64(options.MaxSteps),
+		maxAllocation: options.MaxAllocation,
+		maxCallDepth:  options.MaxCallDepth,
+	}, cancel
+}
+
+// takeSteps returns the number of steps the calling interpreter may execute before
+// it has to call takeSteps again
+func (l *limits) takeSteps() (int, error) {
+	select {
+	case <-l.done:
+		return 0, &LimitError{Msg: fmt.Sprintf("execution stopped: %s", l.ctx.Err()), Err: l.ctx.Err()}
+	default:
+	}
+	if l.maxSteps == 0 {
+		return stepBatch, nil
+	}
+	for {
+		remaining := atomic.LoadInt64(&l.remaining)
+		if remaining <= 0 {
+			return 0, &LimitError{Msg: fmt.Sprintf("maximum number of steps (%d) exceeded", l.maxSteps)}
+		}
+		n := remaining
+		if n > stepBatch {
+			n = stepBatch
+		}
+		if atomic.CompareAndSwapInt64(&l.remaining, remaining, remaining-n) {
+			return int(n), nil
+		}
+	}
+}
+
+// step accounts for the execution of a statement, loop iteration or instruction
+func (ir *interpreter) step() error {
+	if ir.limits == nil {
+		return nil
+	}
+	if ir.steps > 0 {
+		ir.steps--
+		return nil
+	}
+	return ir.takeSteps()
+}
+
+// takeSteps takes the next batch of steps from the shared budget, of which the current step is executed
+func (ir *interpreter) takeSteps() error {
+	n, err := ir.limits.takeSteps()
+	if err != nil {
+		return err
+	}
+	ir.steps = n - 1
+	return nil
+}
+
+// releaseSteps returns the steps taken but not executed by the worker ir to the shared budget
+func (ir *interpreter) releaseSteps() {
+	if ir.limits != nil && ir.limits.maxSteps > 0 {
+		atomic.AddInt64(&ir.limits.remaining, int64(ir.steps))
+	}
+	ir.steps = 0
+}
+
+// checkAllocation returns an error if count elements exceed the maximum allocation
+func (ir *interpreter) checkAllocation(count float64) error {
+	if ir.limits == nil || ir.limits.maxAllocation == 0 || count <= float64(ir.limits.maxAllocation) {
+		return nil
+	}
+	return &LimitError{Msg: fmt.Sprintf("cannot allocate %.0f elements, the maximum is %d", count, ir.limits.maxAllocation)}
+}
+
+// concat returns left :: right after checking the length of the resulting list against the maximum allocation
+func (ir *interpreter) concat(left Value, right Value) (Value, error) {
+	if l, ok := left.(List); ok {
+		count := len(l.Elements) + 1
+		if r, ok := right.(List); ok {
+			count = len(l.Elements) + len(r.Elements)
+		}
+		if err := ir.checkAllocation(float64(count)); err != nil {
+			return nil, err
+		}
+	}
+	return left.Concat(right)
+}
+
+// checkCallDepth returns an error if invoking another function exceeds the maximum call depth
+func (ir *interpreter) checkCallDepth() error {
+	if ir.limits == nil || ir.limits.maxCallDepth == 0 || len(ir.callStack) <= ir.limits.maxCallDepth {
+		return nil
+	}
+	return &LimitError{Msg: fmt.Sprintf("maximum call depth (%d) exceeded", ir.limits.maxCallDepth)}
+}
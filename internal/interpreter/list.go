@@ -115,10 +115,10 @@ func (l List) RuntimeTypeName() string {
 func (l List) Concat(val Value) (Value, error) {
 	if r, ok := val.(List); ok {
 		return List{
-			Elements: append(l.Elements, r.Elements...),
+			Elements: append(l.Elements[:len(l.Elements):len(l.Elements)], r.Elements...),
 		}, nil
 	}
 	return List{
-		Elements: append(l.Elements, val),
+		Elements: append(l.Elements[:len(l.Elements):len(l.Elements)], val),
 	}, nil
 }
@@ -0,0 +1,158 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+)
+
+const moduleFrameName = "<module>"
+
+// module is a module that has been imported by the running program
+type module struct {
+	name        string            // the canonical name of the module, empty for the main script
+	globals     []Value           // the top-level declarations of the module by index
+	globalNames []string          // the names of the top-level declarations by index
+	importNames map[string]string // maps the import paths used in the module to canonical module names
+}
+
+// importModule evaluates the module imported with path on first import and returns its namespace.
+// subsequent imports of the same module return the cached namespace.
+// tok is the position of the import expression.
+func (ir *interpreter) importModule(path string, tok lexer.Token) (Value, error) {
+	name, ok := ir.modules[ir.currentModule()].importNames[path]
+	if !ok {
+		return nil, fmt.Errorf("module '%s' not found", path)
+	}
+	if index, ok := ir.moduleIndex[name]; ok {
+		return Module{name: name, mod: ir.modules[index]}, nil
+	}
+	var importNames map[string]string
+	var globals []string
+	var frame *parser.Frame
+	var run func() error
+	if unit, ok := ir.units[name]; ok {
+		importNames, globals, frame = unit.importNames, unit.globals, unit.frame
+		run = func() error { return ir.execute(unit, 0) }
+	} else if prog, ok := ir.sources[name]; ok {
+		importNames, globals, frame = prog.ImportNames, prog.Globals, prog.Frame
+		run = func() error { return ir.visitStmtList(prog.Stmts) }
+	} else {
+		return nil, fmt.Errorf("module '%s' not found", name)
+	}
+
+	mod := &module{name: name, importNames: importNames}
+	index := len(ir.modules)
+	ir.modules = append(ir.modules, mod)
+	ir.moduleIndex[name] = index
+
+	importer, outerFunctionScopes := ir.activation(), ir.functionScopes
+	ir.enterModule(mod, globals, frame)
+	ir.functionScopes = nil
+	ir.pushCallFrame(moduleFrameName, tok, index)
+	if ir.debug != nil {
+		ir.debugFrame(importer, nil)
+	}
+	err := run()
+	ir.popCallFrame()
+	ir.restore(importer)
+	ir.functionScopes = outerFunctionScopes
+
+	if err != nil {
+		if _, ok := err.(returnSignal); !ok { // return statement ends the module
+			return nil, err
+		}
+	}
+	return Module{name: name, mod: mod}, nil
+}
+
+// Module is the namespace of an imported module. Its properties are the
+// top-level declarations of the module.
+type Module struct {
+	name string
+	mod  *module
+}
+
+func (m Module) Compare(other Value) (Value, error) {
+	if r, ok := other.(Module); ok && m.name == r.name {
+		return Number(0), nil
+	}
+	return nil, nil
+}
+
+func (m Module) Add(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module + %s Not supported", reflect.TypeOf(other))
+}
+
+func (m Module) Sub(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module - %s Not supported", reflect.TypeOf(other))
+}
+
+func (m Module) Mul(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module * %s Not supported", reflect.TypeOf(other))
+}
+
+func (m Module) Div(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module / %s Not supported", reflect.TypeOf(other))
+}
+
+func (m Module) Mod(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module %% %s Not supported", reflect.TypeOf(other))
+}
+
+func (m Module) In(other Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module In %s Not supported", reflect.TypeOf(other))
+}
+
+func (m Module) Neg() (Value, error) {
+	return nil, fmt.Errorf("type mismatch: -module Not supported")
+}
+
+func (m Module) Not() (Value, error) {
+	return nil, fmt.Errorf("type mismatch: Not module Not supported")
+}
+
+func (m Module) At(bitmap BitmapContext) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: @module Not supported")
+}
+
+func (m Module) Property(ident string) (Value, error) {
+	for i, name := range m.mod.globalNames {
+		if name == ident && m.mod.globals[i] != nil {
+			return m.mod.globals[i], nil
+		}
+	}
+	if v, err := baseProperty(m, ident); err == nil {
+		return v, nil
+	}
+	return nil, fmt.Errorf("module '%s' has no member '%s'", m.name, ident)
+}
+
+func (m Module) PrintStr() string {
+	return fmt.Sprintf("module(%s)", m.name)
+}
+
+func (m Module) Iterate(visit func(Value) error) error {
+	return fmt.Errorf("cannot Iterate over module")
+}
+
+func (m Module) Index(index Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module[Index] Not supported")
+}
+
+func (m Module) IndexRange(lower, upper Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module[lower..upper] Not supported")
+}
+
+func (m Module) IndexAssign(index Value, val Value) error {
+	return fmt.Errorf("type mismatch: module[%s] Not supported", reflect.TypeOf(index))
+}
+
+func (m Module) RuntimeTypeName() string {
+	return "module"
+}
+
+func (m Module) Concat(val Value) (Value, error) {
+	return nil, fmt.Errorf("type mismatch: module :: [%s] Not supported", reflect.TypeOf(val))
+}
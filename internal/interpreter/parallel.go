@@ -0,0 +1,1185 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// minParallelPixels is the minimum number of points a rect must contain
+// for a for loop over it to be distributed across multiple workers automatically
+const minParallelPixels = 4096
+
+// impureFunctions are the builtin functions that modify state shared between
+// loop iterations or depend on the order of execution
+var impureFunctions = map[string]bool{
+	"blt":    true,
+	"flip":   true,
+	"frame":  true,
+	"recall": true,
+	"random": true,
+	"resize": true,
+	"plot":   true,
+	"clip":   true,
+}
+
+// reduction is a variable of a parallel for loop of which each worker has a private copy.
+// the copies are merged into the variable when all workers are done.
+type reduction struct {
+	ident string
+	v     *parser.Var
+	val   Value
+	merge Value // the merge function, nil to merge with +
+}
+
+// forInParallel executes the for loop s over rect with multiple workers if the loop body
+// allows it. Returns false if the loop must be executed serially.
+func (ir *interpreter) forInParallel(s parser.ForStmt, rect Rect) (bool, error) {
+	threads := ir.workerCount()
+	height := rect.Max.Y - rect.Min.Y
+	if ir.worker || ir.debug != nil || threads < 2 || height < 2 || (rect.Max.X-rect.Min.X)*height < minParallelPixels {
+		return false, nil
+	}
+	if checkParallel(ir, s, nil) != nil {
+		return false, nil
+	}
+	return true, ir.runParallel(s, partition(rect, threads), nil)
+}
+
+// visitParallelFor executes a parallel for statement, distributing the elements of
+// the collection across the workers.
+func (ir *interpreter) visitParallelFor(s parser.ParallelForStmt) error {
+	collVal, err := ir.visitExpr(s.Collection)
+	if err != nil {
+		return err
+	}
+	reductions := make([]reduction, len(s.Reductions))
+	for i, r := range s.Reductions {
+		val, err := ir.loadVar(r.Var, r.Ident)
+		if err != nil {
+			return err
+		}
+		if err := checkReduction(r.Ident, val); err != nil {
+			return err
+		}
+		reductions[i] = reduction{ident: r.Ident, v: r.Var, val: val}
+		if r.Merge == nil {
+			continue
+		}
+		if ident, ok := r.Merge.(parser.IdentExpr); ok && ident.Var == nil { // builtin function
+			reductions[i].merge = Str(ident.Ident)
+			continue
+		}
+		if reductions[i].merge, err = ir.visitExpr(r.Merge); err != nil {
+			return err
+		}
+		if err := checkMerge(r.Ident, reductions[i].merge); err != nil {
+			return err
+		}
+	}
+	if err := checkParallel(ir, s.ForStmt, s.Reductions); err != nil {
+		return err
+	}
+
+	if rect, ok := collVal.(Rect); ok {
+		ir.constants[lastRectConst] = rect
+	}
+	var parts []Value
+	if ir.worker || ir.debug != nil { // a debugged program is executed by a single worker
+		parts = []Value{collVal}
+	} else {
+		parts = partition(collVal, ir.workerCount())
+	}
+	if parts == nil {
+		var elements []Value
+		if err := collVal.Iterate(func(val Value) error {
+			elements = append(elements, val)
+			return nil
+		}); err != nil {
+			return err
+		}
+		parts = partition(List{Elements: elements}, ir.workerCount())
+	}
+	return ir.runParallel(s.ForStmt, parts, reductions)
+}
+
+// workerCount returns the number of workers executing a parallel loop
+func (ir *interpreter) workerCount() int {
+	if n := runtime.GOMAXPROCS(0); ir.threads <= 0 || ir.threads > n {
+		return n
+	}
+	return ir.threads
+}
+
+// partition splits rects into bands of rows and lists into consecutive chunks.
+// returns nil for all other values.
+func partition(collVal Value, n int) []Value {
+	switch coll := collVal.(type) {
+	case Rect:
+		height := coll.Max.Y - coll.Min.Y
+		if n > height {
+			n = height
+		}
+		parts := make([]Value, n)
+		for i := range parts {
+			band := coll
+			band.Min.Y = coll.Min.Y + i*height/n
+			band.Max.Y = coll.Min.Y + (i+1)*height/n
+			parts[i] = band
+		}
+		return parts
+	case List:
+		if n > len(coll.Elements) {
+			n = len(coll.Elements)
+		}
+		parts := make([]Value, n)
+		for i := range parts {
+			parts[i] = List{Elements: coll.Elements[i*len(coll.Elements)/n : (i+1)*len(coll.Elements)/n]}
+		}
+		return parts
+	}
+	return nil
+}
+
+// runParallel executes the for loop s for each of the parts on a separate worker, each with its own variables.
+// the private copies of the reduction variables are merged after all workers have finished.
+func (ir *interpreter) runParallel(s parser.ForStmt, parts []Value, reductions []reduction) error {
+	errs := make([]error, len(parts))
+	workers := make([]*interpreter, len(parts))
+	wg := sync.WaitGroup{}
+	for i, part := range parts {
+		worker := ir.fork()
+		for _, r := range reductions {
+			worker.declareVar(r.v, initialValue(r.val, r.merge != nil))
+		}
+		workers[i] = worker
+		wg.Add(1)
+		go func(i int, part Value) {
+			defer wg.Done()
+			errs[i] = worker.forInPart(s, part)
+			worker.releaseSteps()
+			if worker.profiler != nil {
+				worker.profiler.finish()
+			}
+		}(i, part)
+	}
+	if ir.profiler != nil {
+		ir.profiler.flush(ir.profiler.profile.now())
+	}
+	wg.Wait()
+	if ir.profiler != nil {
+		ir.profiler.skip() // the time of the workers is recorded by their own profilers
+	}
+
+	// report the error serial execution would have encountered first
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, r := range reductions {
+		var err error
+		val := r.val
+		for i, worker := range workers {
+			result, _ := worker.loadVar(r.v, r.ident)
+			if r.merge == nil {
+				val, err = mergeAdd(val, result)
+			} else if i == 0 {
+				val = result
+			} else {
+				val, err = ir.invokeMerge(r.merge, val, result)
+			}
+			if err != nil {
+				return fmt.Errorf("error merging reduction variable '%s': %s", r.ident, err)
+			}
+		}
+		ir.storeVar(r.v, val)
+	}
+	return nil
+}
+
+func (ir *interpreter) forInPart(s parser.ForStmt, part Value) error {
+	ir.pushLoopVar(s.Ident)
+	return part.Iterate(func(val Value) error {
+		if err := ir.step(); err != nil {
+			return err
+		}
+		ir.declareVar(s.Var, val)
+		ir.setLoopVar(val)
+		if err := ir.visitStmtList(s.Stmts); err != nil {
+			if _, ok := err.(continueSignal); ok {
+				return nil
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// fork returns a worker interpreter that shares the bitmap, the modules and the cells with ir.
+// the worker has its own copies of the constants, so that loops executed by the worker can record the
+// last iterated rect, and of the local variables and the globals of the executing module, so that it
+// can declare local variables and hold private copies of reduction variables.
+func (ir *interpreter) fork() *interpreter {
+	modules := append([]*module(nil), ir.modules...)
+	mod := *ir.modules[ir.currentModule()]
+	mod.globals = append([]Value(nil), ir.globals...)
+	modules[ir.currentModule()] = &mod
+
+	callStack := make([]callFrame, len(ir.callStack))
+	for i, frame := range ir.callStack {
+		frame.loopVars = append([]loopVar(nil), frame.loopVars...)
+		callStack[i] = frame
+	}
+
+	return &interpreter{
+		constants:      append([]Value(nil), ir.constants...),
+		globals:        mod.globals,
+		frame:          append([]Value(nil), ir.frame...),
+		cells:          append([]*cell(nil), ir.cells...),
+		captures:       append([]*cell(nil), ir.captures...),
+		bitmap:         ir.bitmap,
+		functionScopes: append([]functionScope(nil), ir.functionScopes...),
+		callStack:      callStack,
+		callSite:       ir.callSite,
+		modules:        modules,
+		moduleIndex:    ir.moduleIndex,
+		sources:        ir.sources,
+		units:          ir.units,
+		worker:         true,
+		debug:          ir.debug,
+		limits:         ir.limits,
+		profiler:       ir.profiler.fork(),
+		registry:       ir.registry,
+	}
+}
+
+// checkReduction returns an error if val cannot be the value of the reduction variable ident
+func checkReduction(ident string, val Value) error {
+	switch val.(type) {
+	case Number, Kernel, List, HashMap:
+		return nil
+	}
+	return fmt.Errorf("type mismatch: reduction variable '%s' must be a number, kernel, list or hashmap, but is a %s", ident, val.RuntimeTypeName())
+}
+
+// checkMerge returns an error if merge is no function that can merge the copies of the reduction variable ident
+func checkMerge(ident string, merge Value) error {
+	if fn, ok := merge.(Function); !ok || len(fn.ParameterNames) != 2 {
+		return fmt.Errorf("type mismatch: merge function of '%s' must be a function with two parameters", ident)
+	}
+	return nil
+}
+
+// initialValue returns the value the copy of a reduction variable with the value val starts with on each
+// worker: the value itself if the copies are merged with a function, otherwise its zero value
+func initialValue(val Value, merge bool) Value {
+	if merge {
+		return copyValue(val)
+	}
+	return zeroValue(val)
+}
+
+// zeroValue returns the value a reduction variable merged with + starts with on each worker
+func zeroValue(val Value) Value {
+	switch v := val.(type) {
+	case Kernel:
+		return Kernel{Width: v.Width, Height: v.Height, Values: make([]lang.Number, len(v.Values))}
+	case List:
+		return List{}
+	case HashMap:
+		return HashMap{}
+	}
+	return Number(0)
+}
+
+// copyValue returns a copy of the reduction variable val that can be modified independently of val
+func copyValue(val Value) Value {
+	switch v := val.(type) {
+	case Kernel:
+		v.Values = append([]lang.Number(nil), v.Values...)
+		return v
+	case List:
+		return List{Elements: append([]Value(nil), v.Elements...)}
+	case HashMap:
+		h := make(HashMap, len(v))
+		for key, val := range v {
+			h[key] = val
+		}
+		return h
+	}
+	return val
+}
+
+// mergeAdd merges the result of a worker into a reduction variable: numbers and kernels are added,
+// lists are concatenated and hashmaps are merged, merging the values of equal keys the same way.
+// The values of a hashmap must be numbers, kernels, lists or hashmaps, so that the merge yields the
+// same result no matter how many workers executed the loop.
+func mergeAdd(val Value, result Value) (Value, error) {
+	switch v := val.(type) {
+	case Kernel:
+		r, ok := result.(Kernel)
+		if !ok || r.Width != v.Width || r.Height != v.Height {
+			return nil, fmt.Errorf("type mismatch: expected kernel of size %dx%d", v.Width, v.Height)
+		}
+		sum := copyValue(v).(Kernel)
+		for i, n := range r.Values {
+			sum.Values[i] += n
+		}
+		return sum, nil
+	case List:
+		return v.Concat(result)
+	case HashMap:
+		r, ok := result.(HashMap)
+		if !ok {
+			return nil, fmt.Errorf("type mismatch: expected hashmap, found %s", reflect.TypeOf(result))
+		}
+		merged := copyValue(v).(HashMap)
+		for _, key := range r.sortedKeys() {
+			rval := r[key]
+			switch rval.(type) {
+			case Number, Kernel, List, HashMap:
+			default:
+				return nil, fmt.Errorf("type mismatch: value of key %v must be a number, kernel, list or hashmap, but is a %s", key, rval.RuntimeTypeName())
+			}
+			if mval, ok := merged[key]; ok {
+				sum, err := mergeAdd(mval, rval)
+				if err != nil {
+					return nil, err
+				}
+				rval = sum
+			}
+			merged[key] = rval
+		}
+		return merged, nil
+	}
+	return val.Add(result)
+}
+
+// invokeMerge invokes the merge function of a reduction variable, which is either the name of a builtin function or a Function
+func (ir *interpreter) invokeMerge(merge Value, a Value, b Value) (Value, error) {
+	if name, ok := merge.(Str); ok {
+		return ir.invokeFunc(string(name), nil, []Value{a, b})
+	}
+	return ir.invokeFunctionExpr("<merge_fn>", merge, []Value{a, b})
+}
+
+// checkParallel returns nil if the iterations of the for loop s are independent of each
+// other: the loop body (including all functions it invokes) may read any variable, declare
+// and assign locals, modify the reduction variables and write the pixel at the loop variable,
+// but must not modify outer variables, produce output or touch the bitmap otherwise.
+func checkParallel(ir *interpreter, s parser.ForStmt, reductions []parser.Reduction) error {
+	la := newLoopAnalysis(ir, ir.registry, s, reductions)
+	la.module = ir.currentModule()
+	return la.stmts(s.Stmts)
+}
+
+// CheckParallelLoops checks the bodies of all parallel for loops of the resolved program and the
+// modules it imports before the program is executed, so that the interpreter and the virtual machine,
+// which executes parallel loops serially, reject the same programs. The functions invoked by a loop
+// are looked up by the declarations of the variables they are called through and, for parameters,
+// by the arguments of all calls of the function. modules are the compiled modules imported by the
+// program by canonical name, see parser.Program.ImportNames.
+func CheckParallelLoops(program parser.Program, modules map[string]parser.Program, registry *Registry) error {
+	decls := &declarations{
+		vars:     make(map[*parser.Var]binding),
+		params:   make(map[*parser.Var]param),
+		assigned: make(map[*parser.Var]bool),
+		read:     make(map[*parser.Var]bool),
+		calls:    make(map[*parser.Var][]call),
+		funcs:    make(map[*parser.Frame]*parser.Var),
+		exports:  make(map[*parser.Var]export),
+		parents:  make(map[*parser.Frame]*parser.Frame),
+		programs: make(map[*parser.Frame]*parser.Program),
+		modules:  modules,
+	}
+	var loops []parallelLoop
+	decls.collect(&program, program.Stmts, program.Frame, &loops)
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		module := modules[name]
+		for _, stmt := range module.Stmts {
+			if decl, ok := stmt.(parser.DeclStmt); ok {
+				decls.exports[decl.Var] = export{module: name, ident: decl.Ident}
+			}
+		}
+		decls.collect(&module, module.Stmts, module.Frame, &loops)
+	}
+	for _, loop := range loops {
+		la := newLoopAnalysis(nil, registry, loop.stmt.ForStmt, loop.stmt.Reductions)
+		la.decls = decls
+		la.frame = loop.frame
+		if err := la.stmts(loop.stmt.Stmts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// declarations holds the declarations and calls of the variables of a program and its modules,
+// so that the functions invoked by parallel loops can be checked before the program is executed
+type declarations struct {
+	vars     map[*parser.Var]binding
+	params   map[*parser.Var]param
+	assigned map[*parser.Var]bool              // the variables assigned after their declaration
+	read     map[*parser.Var]bool              // the variables read other than by calling them
+	calls    map[*parser.Var][]call            // the calls of the variables
+	members  []member                          // the members of modules that are read or called
+	funcs    map[*parser.Frame]*parser.Var     // the variables declared with function literals by frame
+	exports  map[*parser.Var]export            // the top-level variables of the modules
+	parents  map[*parser.Frame]*parser.Frame   // the frames of the functions enclosing function literals
+	programs map[*parser.Frame]*parser.Program // the programs declaring the functions
+	modules  map[string]parser.Program
+}
+
+// binding is a variable declared with rhs in the function with the given frame
+type binding struct {
+	rhs   parser.Expression
+	frame *parser.Frame
+}
+
+// param is the parameter with the given index of the function literal with the given frame
+type param struct {
+	frame *parser.Frame
+	index int
+}
+
+// call passes args to a function in the function with the given frame
+type call struct {
+	args  []parser.Expression
+	frame *parser.Frame
+}
+
+// member is a member of the module recvr refers to, which is called with args if call is set
+type member struct {
+	recvr *parser.Var
+	ident string
+	frame *parser.Frame
+	call  bool
+	args  []parser.Expression
+}
+
+// export is the top-level variable ident of a module
+type export struct {
+	module string
+	ident  string
+}
+
+// parallelLoop is a parallel for loop in the function with the given frame
+type parallelLoop struct {
+	stmt  parser.ParallelForStmt
+	frame *parser.Frame
+}
+
+// collect records the declarations and calls of stmts, which are part of the function with the
+// given frame, and appends the parallel loops of stmts to loops.
+func (d *declarations) collect(program *parser.Program, stmts []parser.Statement, frame *parser.Frame, loops *[]parallelLoop) {
+	d.programs[frame] = program
+	var visit func(node parser.Node) bool
+	visitArgs := func(args []parser.Expression) {
+		for _, arg := range args {
+			parser.Inspect(arg, visit)
+		}
+	}
+	visit = func(node parser.Node) bool {
+		switch n := node.(type) {
+		case parser.DeclStmt:
+			d.vars[n.Var] = binding{rhs: n.Rhs, frame: frame}
+			if fn, ok := n.Rhs.(parser.FunctionExpr); ok {
+				d.funcs[fn.Frame] = n.Var
+			}
+		case parser.AssignStmt:
+			d.assigned[d.root(n.Var, frame)] = true
+		case parser.IdentExpr:
+			if n.Var != nil { // the merge function of a reduction may be a builtin function
+				d.read[d.root(n.Var, frame)] = true
+			}
+		case parser.InvokeExpr:
+			if n.Var != nil {
+				v := d.root(n.Var, frame)
+				d.calls[v] = append(d.calls[v], call{args: n.Args, frame: frame})
+			}
+		case parser.CallExpr:
+			switch callee := n.Callee.(type) {
+			case parser.IdentExpr:
+				v := d.root(callee.Var, frame)
+				d.calls[v] = append(d.calls[v], call{args: n.Args, frame: frame})
+				visitArgs(n.Args)
+				return false
+			case parser.MemberExpr:
+				if recvr, ok := callee.Recvr.(parser.IdentExpr); ok {
+					d.members = append(d.members, member{recvr: recvr.Var, ident: callee.Member, frame: frame, call: true, args: n.Args})
+					parser.Inspect(recvr, visit)
+					visitArgs(n.Args)
+					return false
+				}
+			}
+		case parser.MemberExpr:
+			if recvr, ok := n.Recvr.(parser.IdentExpr); ok {
+				d.members = append(d.members, member{recvr: recvr.Var, ident: n.Member, frame: frame})
+			}
+		case parser.ParallelForStmt:
+			*loops = append(*loops, parallelLoop{stmt: n, frame: frame})
+		case parser.FunctionExpr:
+			d.parents[n.Frame] = frame
+			for i, p := range n.Frame.Params {
+				d.params[p] = param{frame: n.Frame, index: i}
+			}
+			d.collect(program, n.Body, n.Frame, loops)
+			return false
+		}
+		return true
+	}
+	parser.InspectStmts(stmts, visit)
+}
+
+// root returns the variable of the declaring function the variable v of the function with the given
+// frame refers to, which is v itself unless v is captured
+func (d *declarations) root(v *parser.Var, frame *parser.Frame) *parser.Var {
+	v, _ = d.rootFrame(v, frame)
+	return v
+}
+
+// rootFrame returns the root of v like root and the frame of the function declaring it
+func (d *declarations) rootFrame(v *parser.Var, frame *parser.Frame) (*parser.Var, *parser.Frame) {
+	for v.Kind == parser.CaptureVar && frame != nil {
+		v, frame = frame.Captures[v.Index], d.parents[frame]
+	}
+	return v, frame
+}
+
+// lookup returns the expressions the variable v of the function with the given frame may have been
+// initialized with: its declaration or, for parameters, the arguments passed to them. Returns false if
+// the value of v is not known before the program is executed because v is assigned after its declaration,
+// is a parameter of a function that is not only called directly, or is another variable like a loop
+// variable. Globals declared by the previous inputs of an interactive session have a binding without rhs:
+// they are checked by the interpreter when the loop is executed.
+func (d *declarations) lookup(v *parser.Var, frame *parser.Frame) ([]binding, bool) {
+	var bindings []binding
+	ok := d.values(v, frame, make(map[*parser.Var]bool), &bindings)
+	return bindings, ok
+}
+
+func (d *declarations) values(v *parser.Var, frame *parser.Frame, seen map[*parser.Var]bool, bindings *[]binding) bool {
+	v, frame = d.rootFrame(v, frame)
+	if seen[v] {
+		return true // the values of recursive calls are added by the outer call
+	}
+	seen[v] = true
+	if d.assigned[v] {
+		return false
+	}
+	if decl, ok := d.vars[v]; ok {
+		return d.expr(decl.rhs, decl.frame, seen, bindings)
+	}
+	if p, ok := d.params[v]; ok {
+		return d.arguments(p, seen, bindings)
+	}
+	if v.Kind != parser.GlobalVar {
+		return false
+	}
+	*bindings = append(*bindings, binding{})
+	return true
+}
+
+// expr adds the expressions the expression e of the function with the given frame may evaluate to
+func (d *declarations) expr(e parser.Expression, frame *parser.Frame, seen map[*parser.Var]bool, bindings *[]binding) bool {
+	switch e := e.(type) {
+	case parser.IdentExpr:
+		if e.Var != nil {
+			return d.values(e.Var, frame, seen, bindings)
+		}
+	case parser.MemberExpr:
+		if recvr, ok := e.Recvr.(parser.IdentExpr); ok {
+			return d.member(member{recvr: recvr.Var, ident: e.Member, frame: frame}, func(v *parser.Var, module parser.Program) bool {
+				return d.values(v, module.Frame, seen, bindings)
+			})
+		}
+	}
+	*bindings = append(*bindings, binding{rhs: e, frame: frame})
+	return true
+}
+
+// arguments adds the arguments passed to the parameter p
+func (d *declarations) arguments(p param, seen map[*parser.Var]bool, bindings *[]binding) bool {
+	fn, ok := d.funcs[p.frame]
+	if !ok || d.read[fn] {
+		return false // the function may be called anywhere
+	}
+	calls := d.calls[fn]
+	if exp, ok := d.exports[fn]; ok {
+		for _, m := range d.members {
+			if m.ident != exp.ident {
+				continue
+			}
+			if !d.member(m, func(v *parser.Var, module parser.Program) bool {
+				if v == fn && m.call {
+					calls = append(calls, call{args: m.args, frame: m.frame})
+				}
+				return v != fn || m.call
+			}) {
+				return false
+			}
+		}
+	}
+	for _, c := range calls {
+		if p.index < len(c.args) && !d.expr(c.args[p.index], c.frame, seen, bindings) {
+			return false
+		}
+	}
+	return true
+}
+
+// member invokes visit for each top-level variable of a module the member m may refer to.
+// Returns false if the module is not known or visit returns false.
+func (d *declarations) member(m member, visit func(v *parser.Var, module parser.Program) bool) bool {
+	recvrs, ok := d.lookup(m.recvr, m.frame)
+	if !ok {
+		return false
+	}
+	for _, recvr := range recvrs {
+		imp, ok := recvr.rhs.(parser.ImportExpr)
+		if !ok {
+			return false
+		}
+		module, ok := d.modules[d.programs[recvr.frame].ImportNames[imp.Path]]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, stmt := range module.Stmts {
+			if decl, ok := stmt.(parser.DeclStmt); ok && decl.Ident == m.ident {
+				if !visit(decl.Var, module) {
+					return false
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func newLoopAnalysis(ir *interpreter, registry *Registry, s parser.ForStmt, reductions []parser.Reduction) *loopAnalysis {
+	la := &loopAnalysis{
+		ir:         ir,
+		registry:   registry,
+		loopVar:    s.Var,
+		loopIdent:  s.Ident,
+		private:    map[*parser.Var]bool{s.Var: true},
+		reductions: make(map[*parser.Var]bool),
+		visiting:   make(map[*parser.Frame]bool),
+	}
+	for _, r := range reductions {
+		la.reductions[r.Var] = true
+	}
+	return la
+}
+
+// loopAnalysis finds out whether the variables referred to by a loop body are private
+// to the iteration or shared with other iterations.
+type loopAnalysis struct {
+	ir         *interpreter // nil if the program is checked before it is executed
+	registry   *Registry    // the builtin functions added by the host
+	loopVar    *parser.Var
+	loopIdent  string
+	private    map[*parser.Var]bool // the variables declared in the loop body
+	reductions map[*parser.Var]bool
+	funcs      []analysisFunc         // the functions being analyzed, innermost last
+	visiting   map[*parser.Frame]bool // the functions declared outside of the loop being analyzed
+	module     int                    // the module of the loop
+	loopDepth  int                    // the number of loops nested in the analyzed loop or function
+	decls      *declarations          // the declarations of the program, only used if ir is nil
+	frame      *parser.Frame          // the frame of the function containing the loop, only used if ir is nil
+}
+
+// analysisFunc is a function invoked by the loop body. Each invocation has its own frame,
+// so the locals of the function are private.
+type analysisFunc struct {
+	frame    *parser.Frame // the variables of the function
+	shared   bool          // the function is declared outside of the loop, so its captured variables are shared
+	captures []*cell       // the captured variables of a shared function, nil if ir is nil
+	module   int
+}
+
+// variable describes the variable a Var refers to at the position being analyzed
+type variable struct {
+	private   bool  // the variable belongs to the iteration
+	reduction bool  // the variable is a reduction variable
+	val       Value // the value of a shared variable, nil if not initialized yet
+}
+
+func (la *loopAnalysis) lookup(v *parser.Var) variable {
+	return la.lookupAt(len(la.funcs), v)
+}
+
+// lookupAt looks up v in the function at depth, with depth 0 being the loop body
+func (la *loopAnalysis) lookupAt(depth int, v *parser.Var) variable {
+	if la.reductions[v] {
+		return variable{private: true, reduction: true}
+	}
+	if la.ir == nil {
+		return la.lookupStatic(depth, v)
+	}
+	switch v.Kind {
+	case parser.ConstantVar:
+		return variable{val: la.ir.constants[v.Index]}
+	case parser.GlobalVar:
+		module := la.module
+		if depth > 0 {
+			module = la.funcs[depth-1].module
+		}
+		return variable{val: la.ir.modules[module].globals[v.Index]}
+	case parser.LocalVar:
+		if depth > 0 || la.private[v] {
+			return variable{private: true}
+		}
+		return variable{val: la.ir.frame[v.Index]}
+	case parser.CellVar:
+		if depth > 0 || la.private[v] {
+			return variable{private: true}
+		}
+		if c := la.ir.cells[v.Index]; c != nil {
+			return variable{val: c.val}
+		}
+		return variable{}
+	case parser.CaptureVar:
+		if depth == 0 {
+			return variable{val: la.ir.captures[v.Index].val}
+		}
+		if fn := la.funcs[depth-1]; !fn.shared {
+			return la.lookupAt(depth-1, fn.frame.Captures[v.Index])
+		}
+		return variable{val: la.funcs[depth-1].captures[v.Index].val}
+	}
+	return variable{}
+}
+
+// lookupStatic looks up v in the function at depth if the values of the shared variables are unknown
+func (la *loopAnalysis) lookupStatic(depth int, v *parser.Var) variable {
+	switch v.Kind {
+	case parser.LocalVar, parser.CellVar:
+		return variable{private: depth > 0 || la.private[v]}
+	case parser.CaptureVar:
+		if depth > 0 && !la.funcs[depth-1].shared {
+			return la.lookupStatic(depth-1, la.funcs[depth-1].frame.Captures[v.Index])
+		}
+	}
+	return variable{}
+}
+
+// currentFrame returns the frame of the function being analyzed
+func (la *loopAnalysis) currentFrame() *parser.Frame {
+	if len(la.funcs) > 0 {
+		return la.funcs[len(la.funcs)-1].frame
+	}
+	return la.frame
+}
+
+// errorAt returns an error describing why the loop cannot be executed in parallel
+func (la *loopAnalysis) errorAt(tok lexer.Token, format string, args ...interface{}) error {
+	if la.ir == nil {
+		return &lang.Error{
+			File: la.decls.programs[la.currentFrame()].File,
+			Line: tok.LineNumber,
+			Col:  tok.Column,
+			Msg:  "parallel for: " + fmt.Sprintf(format, args...),
+		}
+	}
+	module := la.module
+	if len(la.funcs) > 0 {
+		module = la.funcs[len(la.funcs)-1].module
+	}
+	return &lang.Error{
+		File: la.ir.modules[module].name,
+		Line: tok.LineNumber,
+		Col:  tok.Column,
+		Msg:  "parallel for: " + fmt.Sprintf(format, args...),
+	}
+}
+
+func (la *loopAnalysis) stmts(stmts []parser.Statement) error {
+	for _, stmt := range stmts {
+		if err := la.stmt(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (la *loopAnalysis) stmt(stmt parser.Statement) error {
+	tok := stmt.Token()
+	switch s := stmt.(type) {
+	case parser.DeclStmt:
+		la.private[s.Var] = true
+		return la.expr(s.Rhs)
+
+	case parser.AssignStmt:
+		if s.Var == la.loopVar {
+			return la.errorAt(tok, "cannot assign the loop variable '%s'", s.Ident)
+		}
+		if err := la.expr(s.Rhs); err != nil {
+			return err
+		}
+		if !la.lookup(s.Var).private {
+			return la.errorAt(tok, "cannot assign '%s', which is declared outside of the loop and is no reduction variable", s.Ident)
+		}
+		return nil
+
+	case parser.IndexedAssignStmt:
+		if err := la.exprs([]parser.Expression{s.Index, s.Rhs}); err != nil {
+			return err
+		}
+		if !la.lookup(s.Var).private {
+			return la.errorAt(tok, "cannot modify '%s', which is no reduction variable", s.Ident)
+		}
+		return nil
+
+	case parser.PixelAssignStmt:
+		ident, ok := s.Lhs.(parser.IdentExpr)
+		if !ok || ident.Var != la.loopVar {
+			return la.errorAt(tok, "only the pixel at the loop variable '@%s' can be assigned", la.loopIdent)
+		}
+		return la.expr(s.Rhs)
+
+	case parser.InvocationStmt:
+		return la.expr(s.Invocation)
+
+	case parser.IfStmt:
+		if err := la.expr(s.Cond); err != nil {
+			return err
+		}
+		if err := la.stmts(s.TrueStmts); err != nil {
+			return err
+		}
+		return la.stmts(s.FalseStmts)
+
+	case parser.ForStmt:
+		if err := la.expr(s.Collection); err != nil {
+			return err
+		}
+		return la.loop(s.Var, s.Stmts)
+
+	case parser.ParallelForStmt:
+		if err := la.expr(s.Collection); err != nil {
+			return err
+		}
+		return la.loop(s.Var, s.Stmts)
+
+	case parser.ForRangeStmt:
+		if err := la.exprs([]parser.Expression{s.Lower, s.Upper, s.Step}); err != nil {
+			return err
+		}
+		return la.loop(s.Var, s.Stmts)
+
+	case parser.WhileStmt:
+		if err := la.expr(s.Cond); err != nil {
+			return err
+		}
+		return la.loop(nil, s.Stmts)
+
+	case parser.YieldStmt:
+		if len(la.funcs) == 0 {
+			return la.errorAt(tok, "yield is not allowed")
+		}
+		return la.expr(s.Result)
+
+	case parser.ReturnStmt:
+		if len(la.funcs) == 0 {
+			return la.errorAt(tok, "return is not allowed")
+		}
+		return la.expr(s.Result)
+
+	case parser.BreakStmt:
+		if la.loopDepth == 0 {
+			return la.errorAt(tok, "break is not allowed")
+		}
+		return nil
+
+	case parser.ContinueStmt:
+		return nil
+
+	case parser.LogStmt:
+		return la.errorAt(tok, "log is not allowed because the order of the output is undefined")
+	}
+	return la.errorAt(tok, "unsupported statement %s", reflect.TypeOf(stmt))
+}
+
+// loop checks the body of a nested loop with the loop variable v, which is nil for while loops
+func (la *loopAnalysis) loop(v *parser.Var, stmts []parser.Statement) error {
+	if v != nil {
+		la.private[v] = true
+	}
+	la.loopDepth++
+	defer func() { la.loopDepth-- }()
+	return la.stmts(stmts)
+}
+
+func (la *loopAnalysis) exprs(exprs []parser.Expression) error {
+	for _, expr := range exprs {
+		if err := la.expr(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (la *loopAnalysis) expr(expr parser.Expression) error {
+	switch e := expr.(type) {
+	case parser.TernaryExpr:
+		return la.exprs([]parser.Expression{e.Cond, e.TrueResult, e.FalseResult})
+	case parser.OrExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.AndExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.EqExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.NeqExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.GtExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.GeExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.LtExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.LeExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.ConcatExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.AddExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.SubExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.MulExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.DivExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.ModExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.InExpr:
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+	case parser.PosExpr:
+		return la.exprs([]parser.Expression{e.X, e.Y})
+	case parser.NegExpr:
+		return la.expr(e.Inner)
+	case parser.NotExpr:
+		return la.expr(e.Inner)
+	case parser.AtExpr:
+		return la.expr(e.Inner)
+	case parser.SampleExpr:
+		return la.exprs([]parser.Expression{e.Image, e.Point})
+
+	case parser.MemberExpr:
+		ident, ok := e.Recvr.(parser.IdentExpr)
+		if !ok {
+			return la.expr(e.Recvr)
+		}
+		if err := la.ident(e.Recvr.Token(), ident.Var, ident.Ident); err != nil {
+			return err
+		}
+		// the members of modules are shared
+		if la.ir == nil {
+			bindings, _ := la.bindings(e)
+			return la.declared(bindings)
+		}
+		if m, ok := la.lookup(ident.Var).val.(Module); ok {
+			if val, err := m.Property(e.Member); err == nil {
+				return la.value(e.Token(), e.Member, val)
+			}
+		}
+		return nil
+
+	case parser.IndexExpr:
+		return la.exprs([]parser.Expression{e.Recvr, e.Index})
+	case parser.IndexRangeExpr:
+		return la.exprs([]parser.Expression{e.Recvr, e.Lower, e.Upper})
+
+	case parser.IdentExpr:
+		return la.ident(e.Token(), e.Var, e.Ident)
+
+	case parser.InvokeExpr:
+		if err := la.exprs(e.Args); err != nil {
+			return err
+		}
+		if e.Var == nil { // builtin function
+			if !la.registry.isPure(e.FuncName) {
+				return la.errorAt(e.Token(), "function '%s' is not allowed", e.FuncName)
+			}
+			return nil
+		}
+		if !la.staticCallee(parser.IdentExpr{Ident: e.FuncName, Var: e.Var}) {
+			return la.errorAt(e.Token(), "cannot call a function that is not known before the loop is executed")
+		}
+		return la.ident(e.Token(), e.Var, e.FuncName)
+
+	case parser.CallExpr:
+		if !la.staticCallee(e.Callee) {
+			return la.errorAt(e.Token(), "cannot call a function that is not known before the loop is executed")
+		}
+		if err := la.expr(e.Callee); err != nil {
+			return err
+		}
+		return la.exprs(e.Args)
+
+	case parser.KernelExpr:
+		return la.exprs(e.Elements)
+	case parser.ListExpr:
+		return la.exprs(e.Elements)
+	case parser.HashMapExpr:
+		for _, entry := range e.Entries {
+			if err := la.exprs([]parser.Expression{entry.Key, entry.Value}); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case parser.FunctionExpr:
+		return la.function(analysisFunc{frame: e.Frame, module: la.currentModule()}, e.Body)
+
+	case parser.PipelineExpr:
+		la.private[e.Var] = true
+		return la.exprs([]parser.Expression{e.Left, e.Right})
+
+	case parser.StrExpr, parser.BoolExpr, parser.NumberExpr, parser.ColorExpr, parser.NilExpr:
+		return nil
+
+	case parser.ImportExpr:
+		return la.errorAt(e.Token(), "import is not allowed")
+	}
+	return la.errorAt(expr.Token(), "unsupported expression %s", reflect.TypeOf(expr))
+}
+
+func (la *loopAnalysis) currentModule() int {
+	if len(la.funcs) > 0 {
+		return la.funcs[len(la.funcs)-1].module
+	}
+	return la.module
+}
+
+// staticCallee returns true if the function invoked by a call of callee can be determined
+// by the analysis: a variable, a function literal or a function exported by a module. Functions
+// fetched from lists, hashmaps or returned by other functions are never analyzed. If the program
+// is checked before it is executed, all values the variable may refer to must be function literals.
+func (la *loopAnalysis) staticCallee(callee parser.Expression) bool {
+	switch e := callee.(type) {
+	case parser.FunctionExpr:
+		return true
+	case parser.IdentExpr:
+		return la.ir != nil || knownFunction(la.bindings(e))
+	case parser.MemberExpr:
+		ident, ok := e.Recvr.(parser.IdentExpr)
+		if !ok {
+			return false
+		}
+		if la.ir == nil {
+			return knownFunction(la.bindings(e))
+		}
+		_, ok = la.lookup(ident.Var).val.(Module)
+		return ok
+	}
+	return false
+}
+
+// knownFunction returns true if all bindings found by declarations.lookup are functions
+func knownFunction(bindings []binding, ok bool) bool {
+	for _, b := range bindings {
+		switch b.rhs.(type) {
+		case parser.FunctionExpr, nil:
+		default:
+			return false
+		}
+	}
+	return ok
+}
+
+// bindings returns the expressions the variable or module member expr read by the function being
+// analyzed may have been initialized with, see declarations.lookup. Only used if ir is nil.
+func (la *loopAnalysis) bindings(expr parser.Expression) ([]binding, bool) {
+	var bindings []binding
+	ok := la.decls.expr(expr, la.currentFrame(), make(map[*parser.Var]bool), &bindings)
+	return bindings, ok
+}
+
+// ident checks a read of the variable v named ident
+func (la *loopAnalysis) ident(tok lexer.Token, v *parser.Var, ident string) error {
+	result := la.lookup(v)
+	if result.private {
+		return nil
+	}
+	if la.ir == nil {
+		bindings, _ := la.decls.lookup(v, la.currentFrame())
+		return la.declared(bindings)
+	}
+	if result.val == nil {
+		return nil
+	}
+	return la.value(tok, ident, result.val)
+}
+
+// declared checks the function literals a shared variable that is read by the loop body
+// may refer to if the program is checked before it is executed
+func (la *loopAnalysis) declared(bindings []binding) error {
+	for _, b := range bindings {
+		if fn, ok := b.rhs.(parser.FunctionExpr); ok {
+			if err := la.shared(analysisFunc{frame: fn.Frame, shared: true}, fn.Body); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// value checks a shared value that is read by the loop body
+func (la *loopAnalysis) value(tok lexer.Token, ident string, val Value) error {
+	switch v := val.(type) {
+	case Function:
+		return la.sharedFunction(tok, v)
+	case Generator:
+		// generators are bound to the interpreter they have been created by
+		return la.errorAt(tok, "'%s' is a generator created outside of the loop", ident)
+	}
+	return nil
+}
+
+// sharedFunction checks the body of a function declared outside of the loop
+// as if it were invoked at the current position.
+func (la *loopAnalysis) sharedFunction(tok lexer.Token, fn Function) error {
+	if fn.unit != nil {
+		return la.errorAt(tok, "functions compiled to bytecode are not supported")
+	}
+	return la.shared(analysisFunc{frame: fn.frame, shared: true, captures: fn.captures, module: fn.module}, fn.Body)
+}
+
+// shared checks the body of the function fn declared outside of the loop
+func (la *loopAnalysis) shared(fn analysisFunc, body []parser.Statement) error {
+	if la.visiting[fn.frame] {
+		return nil // recursive invocation
+	}
+	la.visiting[fn.frame] = true
+	defer delete(la.visiting, fn.frame)
+	return la.function(fn, body)
+}
+
+func (la *loopAnalysis) function(fn analysisFunc, body []parser.Statement) error {
+	loopDepth := la.loopDepth
+	la.loopDepth = 0
+	la.funcs = append(la.funcs, fn)
+	defer func() {
+		la.funcs = la.funcs[:len(la.funcs)-1]
+		la.loopDepth = loopDepth
+	}()
+	return la.stmts(body)
+}
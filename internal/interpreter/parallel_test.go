@@ -0,0 +1,679 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// pixelBitmap is a BitmapContext with a gradient as source and a separate target
+type pixelBitmap struct {
+	BitmapContext
+	width, height int
+	target        []lang.Color
+	log           []string
+	inputs        map[string]InputImage
+}
+
+func newPixelBitmap(width, height int) *pixelBitmap {
+	return &pixelBitmap{width: width, height: height, target: make([]lang.Color, width*height)}
+}
+
+func (b *pixelBitmap) GetPixel(x int, y int) lang.Color {
+	return lang.NewRgba(lang.Number(x%256), lang.Number(y%256), lang.Number((x+y)%256), 255)
+}
+func (b *pixelBitmap) SetPixel(x int, y int, color lang.Color) { b.target[y*b.width+x] = color }
+func (b *pixelBitmap) SourceWidth() int                        { return b.width }
+func (b *pixelBitmap) SourceHeight() int                       { return b.height }
+func (b *pixelBitmap) TargetWidth() int                        { return b.width }
+func (b *pixelBitmap) TargetHeight() int                       { return b.height }
+func (b *pixelBitmap) Log(message string)                      { b.log = append(b.log, message) }
+func (b *pixelBitmap) Convolute(x, y, width, height int, kernel []lang.Number) lang.Color {
+	return b.GetPixel(x, y)
+}
+func (b *pixelBitmap) Inputs() map[string]InputImage { return b.inputs }
+
+func Test_checkParallel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{
+			name: "invert",
+			src:  `for p in Bounds { @p = -@p }`,
+			want: true,
+		},
+		{
+			name: "locals",
+			src: `for p in Bounds {
+    c := @p
+    if c.r > 10 { c = rgb(c.r, 0, 0) }
+    for i in 0..3 { c = c + rgb(i, i, i) }
+    @p = c
+}`,
+			want: true,
+		},
+		{
+			name: "read_outer",
+			src: `k := |1 1 1 1 1 1 1 1 1|
+for p in Bounds { @p = convolute(p, k) }`,
+			want: true,
+		},
+		{
+			name: "pure_function",
+			src: `f := fn(c) { x := c * 0.5 return x }
+for p in Bounds { @p = f(@p) }`,
+			want: true,
+		},
+		{
+			name: "continue",
+			src:  `for p in Bounds { if p.x == 0 { continue } @p = @p }`,
+			want: true,
+		},
+		{
+			name: "assign_outer",
+			src: `n := 0
+for p in Bounds { n = n + 1 }`,
+			want: false,
+		},
+		{
+			name: "function_assigns_outer",
+			src: `n := 0
+f := fn() { n = n + 1 }
+for p in Bounds { f() }`,
+			want: false,
+		},
+		{
+			name: "closure_state",
+			src: `counter := fn() {
+    n := 0
+    return fn() { n = n + 1 return n }
+}
+next := counter()
+for p in Bounds { @p = rgb(next(), 0, 0) }`,
+			want: false,
+		},
+		{
+			name: "write_other_pixel",
+			src:  `for p in Bounds { @(p + 1;0) = @p }`,
+			want: false,
+		},
+		{
+			name: "log",
+			src:  `for p in Bounds { log(p) }`,
+			want: false,
+		},
+		{
+			name: "break",
+			src:  `for p in Bounds { if p.x > 10 { break } }`,
+			want: false,
+		},
+		{
+			name: "impure_builtin",
+			src:  `for p in Bounds { @p = rgb(random(0, 255), 0, 0) }`,
+			want: false,
+		},
+		{
+			name: "indexed_assign",
+			src: `l := [1, 2]
+for p in Bounds { l[0] = p.x }`,
+			want: false,
+		},
+		{
+			name: "shadowing_local",
+			src: `x := 0
+for p in Bounds { y := x x := p.x }`,
+			want: true,
+		},
+		{
+			name: "function_from_list",
+			src: `n := 0
+fs := [fn() { n = n + 1 }]
+for p in Bounds { x := fs[0]() }`,
+			want: false,
+		},
+		{
+			name: "function_from_hashmap",
+			src: `n := 0
+h := {f: fn() { n = n + 1 }}
+for p in Bounds { h.f() }`,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := compile(tt.src, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ir := newInterpreter(newPixelBitmap(8, 8))
+			ir.enterModule(ir.modules[0], program.Globals, program.Frame)
+			last := len(program.Stmts) - 1
+			if err := ir.visitStmtList(program.Stmts[:last]); err != nil {
+				t.Fatal(err)
+			}
+			err = checkParallel(ir, program.Stmts[last].(parser.ForStmt), nil)
+			if got := err == nil; got != tt.want {
+				t.Errorf("checkParallel() error = %v, want parallelizable = %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_forInParallel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "invert",
+			src:  `for p in Bounds { @p = -@p }`,
+		},
+		{
+			name: "functions_and_locals",
+			src: `mirror := fn(pt) -> (W - pt.x - 1);pt.y
+for p in Bounds {
+    c := @mirror(p)
+    for i in 0..p.y % 4 { c = c + rgb(1, 1, 1) }
+    @p = c.r > 100 ? c : Black
+}`,
+		},
+		{
+			name: "sub_rect",
+			src: `for p in rect(3, 5, 70, 60) {
+    @p = rgb(p.x, p.y, 0)
+}`,
+		},
+		{
+			name: "function_from_list",
+			src: `n := 0
+fs := [fn() { n = n + 1 return n % 256 }]
+for p in Bounds { @p = rgb(fs[0](), 0, 0) }`,
+		},
+		{
+			name: "function_from_hashmap",
+			src: `n := 0
+h := {f: fn() { n = n + 1 return n % 256 }}
+for p in Bounds { @p = rgb(h.f(), 0, 0) }`,
+		},
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := compile(tt.src, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runtime.GOMAXPROCS(1)
+			serial := newPixelBitmap(80, 70)
+			if err := Interpret(context.Background(), program, serial, Options{}); err != nil {
+				t.Fatalf("Interpret() serial error = %v", err)
+			}
+			runtime.GOMAXPROCS(4)
+			parallel := newPixelBitmap(80, 70)
+			if err := Interpret(context.Background(), program, parallel, Options{}); err != nil {
+				t.Fatalf("Interpret() parallel error = %v", err)
+			}
+			if !reflect.DeepEqual(serial.target, parallel.target) {
+				t.Errorf("Interpret() parallel result differs from serial result")
+			}
+		})
+	}
+}
+
+func Test_forInParallel_error(t *testing.T) {
+	src := `for p in Bounds {
+    c := @p
+    if p.y >= 20 { c = c + true }
+    @p = c
+}`
+	program, err := compile(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	err = Interpret(context.Background(), program, newPixelBitmap(80, 70), Options{})
+	lerr, ok := err.(*lang.Error)
+	if !ok {
+		t.Fatalf("Interpret() error = %v, want *lang.Error", err)
+	}
+	want := []lang.TraceFrame{{Func: scriptFrameName, Line: 3, LoopVars: []string{"p = 0;20"}}}
+	if lerr.Line != 3 || !reflect.DeepEqual(lerr.Trace, want) {
+		t.Errorf("Interpret() error = %s, trace = %#v, want error at line 3 with trace %#v", lerr, lerr.Trace, want)
+	}
+}
+
+func Test_forInParallel_maxSteps(t *testing.T) {
+	// 1 step for the loop statement and 2 steps for each of the 80x70 iterations.
+	// the workers may be stopped early by the steps the other workers hold.
+	src := `for p in Bounds {
+    @p = rgb(p.x, p.y, 0)
+}`
+	const steps = 1 + 80*70*2 + 4*stepBatch
+	program, err := compile(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	if err := Interpret(context.Background(), program, newPixelBitmap(80, 70), Options{MaxSteps: steps}); err != nil {
+		t.Errorf("Interpret() error = %v, want no error with %d steps", err, steps)
+	}
+	err = Interpret(context.Background(), program, newPixelBitmap(80, 70), Options{MaxSteps: 80 * 70})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("Interpret() error = %v, want LimitError with %d steps", err, 80*70)
+	}
+}
+
+func Test_parallelFor(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "pixels",
+			src: `parallel for p in Bounds { @p = -@p }
+log(Bounds.w)`,
+			want: []string{"80"},
+		},
+		{
+			name: "number",
+			src: `n := 10
+parallel for p in Bounds reduce n {
+    if p.x == 0 { n = n + 1 }
+}
+log(n)`,
+			want: []string{"80"},
+		},
+		{
+			name: "kernel",
+			src: `hist := kernel(4, 1, 1)
+parallel for p in Bounds reduce hist {
+    i := p.x % 4
+    hist[i] = hist[i] + 1
+}
+log(hist[0], " ", hist[3])`,
+			want: []string{"1401 1401"},
+		},
+		{
+			name: "list_keeps_order",
+			src: `l := [0]
+parallel for x in [1, 2, 3, 4, 5, 6, 7, 8, 9] reduce l {
+    l = l :: x * 10
+}
+log(l[0], " ", l[1], " ", l[9], " ", l.count)`,
+			want: []string{"0 10 90 10"},
+		},
+		{
+			name: "hashmap",
+			src: `h := {}
+parallel for x in [1, 2, 3, 4, 5, 6, 7, 8] reduce h {
+    key := x % 2 == 0 ? "even" : "odd"
+    h[key] = h[key] == nil ? 1 : h[key] + 1
+}
+log(h["even"], " ", h["odd"])`,
+			want: []string{"4 4"},
+		},
+		{
+			name: "hashmap_grouping",
+			src: `h := {}
+parallel for x in [1, 2, 3, 4, 5, 6, 7, 8] reduce h {
+    key := x % 2 == 0 ? "even" : "odd"
+    h[key] = h[key] == nil ? [x] : h[key] :: x
+}
+log(h["even"][0], h["even"][1], h["even"][2], h["even"][3], " ", h["odd"].count)`,
+			want: []string{"2468 4"},
+		},
+		{
+			name: "modify_local",
+			src: `n := 0
+parallel for x in [1, 2, 3, 4] reduce n {
+    l := [0, 0]
+    l[1] = x
+    n = n + l[0] + l[1]
+}
+log(n)`,
+			want: []string{"10"},
+		},
+		{
+			name: "builtin_merge",
+			src: `m := 0
+parallel for p in Bounds reduce m: max {
+    m = max(m, p.x + p.y)
+}
+log(m)`,
+			want: []string{"148"},
+		},
+		{
+			name: "function_merge",
+			src: `lo := 1000
+parallel for p in Bounds reduce lo: fn(a, b) -> a < b ? a : b {
+    lo = p.y < lo ? p.y : lo
+}
+log(lo)`,
+			want: []string{"0"},
+		},
+		{
+			name: "reduction_in_function",
+			src: `count := fn(rc) {
+    n := 0
+    parallel for p in rc reduce n { n = n + 1 }
+    return n
+}
+log(count(Bounds))`,
+			want: []string{"5600"},
+		},
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for _, tt := range tests {
+		for _, engine := range engines {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				program, err := compile(tt.src, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				bitmap := newPixelBitmap(80, 70)
+				if err := execute(engine, program, bitmap); err != nil {
+					t.Fatalf("execute() error = %v", err)
+				}
+				if !reflect.DeepEqual(bitmap.log, tt.want) {
+					t.Errorf("execute() log = %#v, want %#v", bitmap.log, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func Test_checkParallelLoops(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "reduction",
+			src: `c := 0
+parallel for p in rect(0, 0, 100, 100) reduce c { x := p.x c = c + x }`,
+		},
+		{
+			name: "assign_outer",
+			src: `c := 0
+n := 0
+parallel for p in rect(0, 0, 100, 100) reduce c { c = c + 1 n = n + 1 }`,
+			want: "3:61: parallel for: cannot assign 'n', which is declared outside of the loop and is no reduction variable",
+		},
+		{
+			name: "modify_local",
+			src: `parallel for p in Bounds {
+    k := kernel(2, 2, 0)
+    k[0] = p.x
+    h := {}
+    h["x"] = p.x
+    @p = rgb(k[0], h["x"], 0)
+}`,
+		},
+		{
+			name: "modify_outer",
+			src: `h := {}
+parallel for p in Bounds { h["x"] = p.x }`,
+			want: "2:28: parallel for: cannot modify 'h', which is no reduction variable",
+		},
+		{
+			name: "assign_outer_in_literal",
+			src: `n := 0
+parallel for p in Bounds { f := fn() { n = 1 } f() }`,
+			want: "2:40: parallel for: cannot assign 'n'",
+		},
+		{
+			name: "assign_captured",
+			src: `count := fn() {
+    n := 0
+    parallel for p in Bounds { n = n + 1 }
+    return n
+}`,
+			want: "3:32: parallel for: cannot assign 'n'",
+		},
+		{
+			name: "function_from_list",
+			src: `fs := [fn() -> 1]
+parallel for p in Bounds { x := fs[0]() }`,
+			want: "2:38: parallel for: cannot call a function that is not known before the loop is executed",
+		},
+		{
+			name: "outer_function",
+			src: `n := 0
+inc := fn() { n = n + 1 }
+parallel for p in Bounds { inc() }`,
+			want: "2:15: parallel for: cannot assign 'n'",
+		},
+		{
+			name: "pure_outer_function",
+			src: `half := fn(c) { x := c * 0.5 return x }
+twice := fn(c) -> half(c) * 4
+parallel for p in Bounds { @p = twice(@p) }`,
+		},
+		{
+			name: "recursive_function",
+			src: `fib := fn(n) -> n < 2 ? n : fib(n - 1) + fib(n - 2)
+parallel for p in Bounds { @p = rgb(fib(p.x % 10), 0, 0) }`,
+		},
+		{
+			name: "captured_function",
+			src: `count := fn() {
+    n := 0
+    inc := fn() { n = n + 1 }
+    parallel for p in Bounds { inc() }
+    return n
+}`,
+			want: "3:19: parallel for: cannot assign 'n'",
+		},
+		{
+			name: "alias",
+			src: `n := 0
+inc := fn() { n = n + 1 }
+f := inc
+parallel for p in Bounds { f() }`,
+			want: "2:15: parallel for: cannot assign 'n'",
+		},
+		{
+			name: "assigned_function",
+			src: `f := fn() -> 1
+f = fn() -> 2
+parallel for p in Bounds { x := f() }`,
+			want: "3:33: parallel for: cannot call a function that is not known before the loop is executed",
+		},
+		{
+			name: "returned_function",
+			src: `counter := fn() {
+    n := 0
+    return fn() { n = n + 1 return n }
+}
+next := counter()
+parallel for p in Bounds { x := next() }`,
+			want: "6:33: parallel for: cannot call a function that is not known before the loop is executed",
+		},
+		{
+			name: "parameter",
+			src: `apply := fn(f) {
+    parallel for p in Bounds { @p = f(@p) }
+}
+apply(fn(c) -> -c)
+apply(fn(c) -> c * 0.5)`,
+		},
+		{
+			name: "impure_argument",
+			src: `apply := fn(f) {
+    parallel for p in Bounds { @p = f(@p) }
+}
+apply(fn(c) -> -c)
+apply(fn(c) -> rgb(random(0, 255), 0, 0))`,
+			want: "5:20: parallel for: function 'random' is not allowed",
+		},
+		{
+			name: "unknown_argument",
+			src: `apply := fn(f) {
+    parallel for p in Bounds { @p = f(@p) }
+}
+fs := [fn(c) -> -c]
+apply(fs[0])`,
+			want: "2:37: parallel for: cannot call a function that is not known before the loop is executed",
+		},
+		{
+			name: "escaping_function",
+			src: `apply := fn(f) {
+    parallel for p in Bounds { @p = f(@p) }
+}
+g := apply
+g(fn(c) -> -c)`,
+			want: "2:37: parallel for: cannot call a function that is not known before the loop is executed",
+		},
+		{
+			name: "impure_builtin",
+			src:  `parallel for p in Bounds { @p = rgb(random(0, 255), 0, 0) }`,
+			want: "1:37: parallel for: function 'random' is not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compile(tt.src, false)
+			if tt.want == "" && err != nil {
+				t.Errorf("compile() error = %v, want nil", err)
+			}
+			if tt.want != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.want)) {
+				t.Errorf("compile() error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parallelFor_threads(t *testing.T) {
+	src := `merges := 0
+n := 0
+parallel for p in Bounds reduce n: fn(a, b) { merges = merges + 1 return a + b } {
+    n = n + 1
+}
+log(n, " ", merges)`
+	tests := []struct {
+		threads int
+		want    string
+	}{
+		{threads: 0, want: "5600 3"},
+		{threads: 1, want: "5600 0"},
+		{threads: 2, want: "5600 1"},
+		{threads: 8, want: "5600 3"},
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	program, err := compile(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		bitmap := newPixelBitmap(80, 70)
+		if err := Interpret(context.Background(), program, bitmap, Options{Threads: tt.threads}); err != nil {
+			t.Fatalf("Interpret() error = %v", err)
+		}
+		if len(bitmap.log) != 1 || bitmap.log[0] != tt.want {
+			t.Errorf("Interpret() with %d threads log = %#v, want %s", tt.threads, bitmap.log, tt.want)
+		}
+	}
+}
+
+func Test_parallelFor_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "assign_outer",
+			src: `n := 0
+m := 0
+parallel for p in Bounds reduce m {
+    n = n + 1
+}`,
+			want: "4:5: parallel for: cannot assign 'n', which is declared outside of the loop and is no reduction variable",
+		},
+		{
+			name: "modify_outer",
+			src: `k := kernel(2, 2, 0)
+parallel for p in Bounds {
+    k[0] = 1
+}`,
+			want: "3:5: parallel for: cannot modify 'k', which is no reduction variable",
+		},
+		{
+			name: "assign_outer_in_function",
+			src: `n := 0
+inc := fn() {
+    n = n + 1
+}
+parallel for p in Bounds { inc() }`,
+			want: "3:5: parallel for: cannot assign 'n'",
+		},
+		{
+			name: "function_from_list",
+			src: `n := 0
+fs := [fn() { n = n + 1 }]
+parallel for p in Bounds { x := fs[0]() }`,
+			want: "3:38: parallel for: cannot call a function that is not known before the loop is executed",
+		},
+		{
+			name: "function_from_hashmap",
+			src: `n := 0
+h := {f: fn() { n = n + 1 }}
+parallel for p in Bounds { h.f() }`,
+			want: "3:31: parallel for: cannot call a function that is not known before the loop is executed",
+		},
+		{
+			name: "other_pixel",
+			src:  `parallel for p in Bounds { @(0;0) = @p }`,
+			want: "1:28: parallel for: only the pixel at the loop variable '@p' can be assigned",
+		},
+		{
+			name: "log",
+			src:  `parallel for p in Bounds { log(p) }`,
+			want: "1:28: parallel for: log is not allowed",
+		},
+		{
+			name: "reduction_type",
+			src: `c := #ff0000
+parallel for p in Bounds reduce c { }`,
+			want: "2:1: type mismatch: reduction variable 'c' must be a number, kernel, list or hashmap, but is a color",
+		},
+		{
+			name: "merge_function",
+			src: `n := 0
+parallel for p in Bounds reduce n: 1 { }`,
+			want: "2:1: type mismatch: merge function of 'n' must be a function with two parameters",
+		},
+		{
+			name: "hashmap_value_type",
+			src: `seen := {}
+parallel for p in Bounds reduce seen {
+    seen[p.x] = true
+}`,
+			want: "2:1: error merging reduction variable 'seen': type mismatch: value of key 0 must be a number, kernel, list or hashmap, but is a boolean",
+		},
+	}
+	for _, tt := range tests {
+		for _, engine := range engines {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				// loop bodies are checked when the program is compiled as far as possible
+				program, err := compile(tt.src, false)
+				if err == nil {
+					err = execute(engine, program, newPixelBitmap(8, 8))
+				}
+				if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+					t.Errorf("execute() error = %v, want %s", err, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ParseParam parses the value of a script parameter passed as text by the host. The text is
+// parsed as boolean (true or false), number, color (#rrggbb or #rrggbb:aa), point (x;y)
+// or else taken as string.
+func ParseParam(s string) Value {
+	switch s {
+	case "true":
+		return Boolean(true)
+	case "false":
+		return Boolean(false)
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return Number(n)
+	}
+	if strings.HasPrefix(s, "#") {
+		if tokens, err := lexer.Lex(s); err == nil && len(tokens) > 0 && tokens[0].Type == lexer.TTColor && tokens[0].Lexeme == s {
+			return Color(tokens[0].ParseColor())
+		}
+	}
+	if parts := strings.Split(s, ";"); len(parts) == 2 {
+		x, errX := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		y, errY := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if errX == nil && errY == nil {
+			return Point{int(x + 0.5), int(y + 0.5)}
+		}
+	}
+	return Str(s)
+}
+
+// setParams sets the values of the script parameters passed by the host. Returns an error if params
+// holds a value for a parameter not contained in names.
+func (ir *interpreter) setParams(params map[string]string, names []string) error {
+	for name := range params {
+		declared := false
+		for _, ident := range names {
+			declared = declared || ident == name
+		}
+		if !declared {
+			return &lang.Error{Msg: fmt.Sprintf("unknown parameter '%s'", name)}
+		}
+	}
+	ir.params = params
+	return nil
+}
+
+// ParamNames returns the names of the parameters declared by the program
+func ParamNames(program parser.Program) []string {
+	names := make([]string, len(program.Params))
+	for i, decl := range program.Params {
+		names[i] = decl.Ident
+	}
+	return names
+}
+
+// paramValue returns the value the host passed for the parameter name, or def if no value has been passed.
+// A string parameter takes the passed text as is, all other parameters take the value parsed by
+// ParseParam, which must have the type of def unless def is nil.
+func (ir *interpreter) paramValue(name string, def Value) (Value, error) {
+	s, ok := ir.params[name]
+	if !ok {
+		return def, nil
+	}
+	if _, isStr := def.(Str); isStr {
+		return Str(s), nil
+	}
+	v := ParseParam(s)
+	if _, isNil := def.(Nilval); !isNil && def != nil && reflect.TypeOf(v) != reflect.TypeOf(def) {
+		return nil, fmt.Errorf("invalid value '%s' for parameter '%s' of type %s", s, name, def.RuntimeTypeName())
+	}
+	return v, nil
+}
@@ -0,0 +1,105 @@
+package interpreter
+
+import (
+	"context"
+	"github.com/smackem/ylang/internal/emitter"
+	"github.com/smackem/ylang/internal/lang"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseParam(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Value
+	}{
+		{s: "true", want: Boolean(true)},
+		{s: "false", want: Boolean(false)},
+		{s: "200", want: Number(200)},
+		{s: "-0.5", want: Number(-0.5)},
+		{s: "#ff8000", want: Color(lang.NewRgba(255, 128, 0, 255))},
+		{s: "#ff8000:80", want: Color(lang.NewRgba(255, 128, 0, 128))},
+		{s: "10;20", want: Point{10, 20}},
+		{s: "1.6; 2", want: Point{2, 2}},
+		{s: "hello", want: Str("hello")},
+		{s: "#zz", want: Str("#zz")},
+		{s: "1;2;3", want: Str("1;2;3")},
+		{s: "", want: Str("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := ParseParam(tt.s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseParam() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParams(t *testing.T) {
+	src := `param threshold := 200
+param tint := #000000
+param origin := 0;0
+param label := "none"
+param verbose := false
+param mask := nil
+log(threshold, " ", tint, " ", origin, " ", label, " ", verbose, " ", mask)`
+	tests := []struct {
+		name    string
+		params  map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			want: "200 rgba(0,0,0:255) 0;0 none false nil",
+		},
+		{
+			name:   "values",
+			params: map[string]string{"threshold": "50", "tint": "#ff0000", "origin": "3;4", "label": "12", "verbose": "true", "mask": "1;2"},
+			want:   "50 rgba(255,0,0:255) 3;4 12 true 1;2",
+		},
+		{
+			name:    "unknown",
+			params:  map[string]string{"limit": "1"},
+			wantErr: "unknown parameter 'limit'",
+		},
+		{
+			name:    "type_mismatch",
+			params:  map[string]string{"threshold": "high"},
+			wantErr: "1:1: invalid value 'high' for parameter 'threshold' of type number",
+		},
+	}
+	program, err := compile(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ParamNames(program); !reflect.DeepEqual(got, []string{"threshold", "tint", "origin", "label", "verbose", "mask"}) {
+		t.Errorf("ParamNames() = %v", got)
+	}
+	for _, tt := range tests {
+		for _, engine := range engines {
+			t.Run(engine+"/"+tt.name, func(t *testing.T) {
+				bitmap := newPixelBitmap(1, 1)
+				options := Options{Params: tt.params}
+				if engine == "vm" {
+					err = Run(context.Background(), emitter.Emit(program), bitmap, options)
+				} else {
+					err = Interpret(context.Background(), program, bitmap, options)
+				}
+				if tt.wantErr != "" {
+					if err == nil || !strings.HasSuffix(err.Error(), tt.wantErr) {
+						t.Errorf("execution error = %v, want %s", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("execution error = %v", err)
+				}
+				if !reflect.DeepEqual(bitmap.log, []string{tt.want}) {
+					t.Errorf("log = %q, want %q", bitmap.log, tt.want)
+				}
+			})
+		}
+	}
+}
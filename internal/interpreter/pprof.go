@@ -0,0 +1,156 @@
+package interpreter
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WritePprof writes the call graph of the recorded execution to w in the gzip-compressed protocol
+// buffer format read by `go tool pprof`. Each sample is a ylang call stack with the number of times
+// its innermost statement or builtin function has been executed and the time spent there.
+// see https://github.com/google/pprof/blob/master/proto/profile.proto
+func (p *Profile) WritePprof(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	table := []string{""}
+	stringIndex := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		index, ok := stringIndex[s]
+		if !ok {
+			index = int64(len(table))
+			table = append(table, s)
+			stringIndex[s] = index
+		}
+		return index
+	}
+	functionIDs := make(map[funcKey]uint64)
+	locationIDs := make(map[location]uint64)
+	var functions, locations protobuf
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var samples protobuf
+	for _, key := range keys {
+		sample := p.samples[key]
+		ids := make([]uint64, len(sample.stack))
+		for i, loc := range sample.stack {
+			id, ok := locationIDs[loc]
+			if !ok {
+				fkey := funcKey{file: loc.file, name: loc.fn}
+				fid, ok := functionIDs[fkey]
+				if !ok {
+					fid = uint64(len(functionIDs) + 1)
+					functionIDs[fkey] = fid
+					functions.message(5, func(b *protobuf) {
+						b.uint64(1, fid)
+						name := strings.Trim(loc.fn, "<>") // pprof strips <...> from function names
+						b.int64(2, str(name))
+						b.int64(3, str(name))
+						b.int64(4, str(loc.file))
+					})
+				}
+				id = uint64(len(locationIDs) + 1)
+				locationIDs[loc] = id
+				locations.message(4, func(b *protobuf) {
+					b.uint64(1, id)
+					b.message(4, func(b *protobuf) {
+						b.uint64(1, fid)
+						b.int64(2, int64(loc.line))
+					})
+				})
+			}
+			ids[i] = id
+		}
+		samples.message(2, func(b *protobuf) {
+			b.packed(1, ids)
+			b.packed(2, []uint64{uint64(sample.hits), uint64(sample.nanos)})
+		})
+	}
+
+	var b protobuf
+	valueType := func(tag int, typ string, unit string) {
+		b.message(tag, func(b *protobuf) {
+			b.int64(1, str(typ))
+			b.int64(2, str(unit))
+		})
+	}
+	valueType(1, "hits", "count")
+	valueType(1, "time", "nanoseconds")
+	b.buf = append(b.buf, samples.buf...)
+	b.buf = append(b.buf, locations.buf...)
+	b.buf = append(b.buf, functions.buf...)
+	valueType(11, "time", "nanoseconds")
+	timeNanos, durationNanos := p.start.UnixNano(), int64(p.end.Sub(p.start))
+	for _, s := range table {
+		b.string(6, s)
+	}
+	b.int64(9, timeNanos)
+	b.int64(10, durationNanos)
+	b.int64(12, 1)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protobuf encodes the fields of a protocol buffer message
+type protobuf struct {
+	buf []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.buf = append(b.buf, byte(x)|0x80)
+		x >>= 7
+	}
+	b.buf = append(b.buf, byte(x))
+}
+
+func (b *protobuf) key(tag int, wireType int) {
+	b.varint(uint64(tag)<<3 | uint64(wireType))
+}
+
+func (b *protobuf) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(tag, 0)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+// string encodes s even if it is empty, which is required for the string table
+func (b *protobuf) string(tag int, s string) {
+	b.key(tag, 2)
+	b.varint(uint64(len(s)))
+	b.buf = append(b.buf, s...)
+}
+
+func (b *protobuf) packed(tag int, xs []uint64) {
+	var packed protobuf
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.key(tag, 2)
+	b.varint(uint64(len(packed.buf)))
+	b.buf = append(b.buf, packed.buf...)
+}
+
+func (b *protobuf) message(tag int, encode func(b *protobuf)) {
+	var msg protobuf
+	encode(&msg)
+	b.key(tag, 2)
+	b.varint(uint64(len(msg.buf)))
+	b.buf = append(b.buf, msg.buf...)
+}
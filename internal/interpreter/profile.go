@@ -0,0 +1,428 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/parser"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Profile records how often and how long the statements, functions and builtin functions of a program
+// are executed. Pass it with Options to Interpret, then write the results with WriteReport or WritePprof.
+// The time spent by the workers of parallel loops is summed up. A Profile records a single execution.
+type Profile struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	start    time.Time
+	end      time.Time
+	lines    map[lineKey]*lineStats
+	funcs    map[funcKey]*callStats
+	builtins map[string]*callStats
+	samples  map[string]*profileSample // by stack, see stackKey
+}
+
+// NewProfile returns an empty Profile
+func NewProfile() *Profile {
+	return &Profile{
+		now:      time.Now,
+		lines:    make(map[lineKey]*lineStats),
+		funcs:    make(map[funcKey]*callStats),
+		builtins: make(map[string]*callStats),
+		samples:  make(map[string]*profileSample),
+	}
+}
+
+type lineKey struct {
+	file string
+	line int
+}
+
+type lineStats struct {
+	hits   int64
+	self   time.Duration // the time spent executing the statement, excluding nested statements and functions
+	total  time.Duration // the time spent executing the statement, including nested statements and functions
+	active int           // the number of executions in progress, recursive executions count once
+}
+
+type funcKey struct {
+	file string
+	name string
+}
+
+type callStats struct {
+	calls  int64
+	total  time.Duration
+	active int
+}
+
+// location is a position in the ylang call graph
+type location struct {
+	fn   string // the name of the function or "<script>" for the top-level code
+	file string
+	line int // 0 for builtin functions
+}
+
+// profileSample is the time spent at a particular stack of locations
+type profileSample struct {
+	stack []location // innermost first
+	hits  int64
+	nanos int64
+}
+
+// profileNode is a node of the tree of the call stacks encountered by a profiler
+type profileNode struct {
+	loc      location
+	children map[location]*profileNode
+	stats    *lineStats // the statistics of the statement at loc, nil for builtin functions
+	hits     int64
+	nanos    int64
+}
+
+func (n *profileNode) child(loc location) *profileNode {
+	child, ok := n.children[loc]
+	if !ok {
+		if n.children == nil {
+			n.children = make(map[location]*profileNode)
+		}
+		child = &profileNode{loc: loc}
+		n.children[loc] = child
+	}
+	return child
+}
+
+// profiler records the execution of an interpreter into its own statistics, which are
+// merged into the shared Profile when the interpreter has finished.
+type profiler struct {
+	profile  *Profile
+	lines    map[lineKey]*lineStats
+	funcs    map[funcKey]*callStats
+	builtins map[string]*callStats
+	root     profileNode
+	cur      *profileNode // the node the time since last is attributed to
+	last     time.Time
+}
+
+func (p *Profile) newProfiler() *profiler {
+	pr := &profiler{
+		profile:  p,
+		lines:    make(map[lineKey]*lineStats),
+		funcs:    make(map[funcKey]*callStats),
+		builtins: make(map[string]*callStats),
+		last:     p.now(),
+	}
+	pr.cur = &pr.root
+	return pr
+}
+
+// flush attributes the time since the last flush to the current node
+func (pr *profiler) flush(now time.Time) {
+	d := now.Sub(pr.last)
+	pr.cur.nanos += int64(d)
+	if pr.cur.stats != nil {
+		pr.cur.stats.self += d
+	}
+	pr.last = now
+}
+
+// skip excludes the time since the last flush from the profile
+func (pr *profiler) skip() {
+	pr.last = pr.profile.now()
+}
+
+// statement executes stmt with ir, recording its execution
+func (pr *profiler) statement(ir *interpreter, stmt parser.Statement) error {
+	line := stmt.Token().LineNumber
+	node := &pr.root
+	for i, frame := range ir.callStack {
+		loc := location{fn: frame.name, file: ir.modules[frame.module].name, line: line}
+		if i < len(ir.callStack)-1 {
+			loc.line = ir.callStack[i+1].callSite.LineNumber
+		}
+		node = node.child(loc)
+	}
+	if node.stats == nil {
+		key := lineKey{file: node.loc.file, line: line}
+		node.stats = pr.lines[key]
+		if node.stats == nil {
+			node.stats = &lineStats{}
+			pr.lines[key] = node.stats
+		}
+	}
+	stats := node.stats
+	stats.hits++
+	node.hits++
+
+	start := pr.profile.now()
+	pr.flush(start)
+	outer := pr.cur
+	pr.cur = node
+	stats.active++
+	err := ir.visitStmtInner(stmt)
+	end := pr.profile.now()
+	pr.flush(end)
+	pr.cur = outer
+	stats.active--
+	if stats.active == 0 {
+		stats.total += end.Sub(start)
+	}
+	return err
+}
+
+// enterFunction records the invocation of the function name declared in file and returns
+// the time it has been invoked, to be passed to leave
+func (pr *profiler) enterFunction(file string, name string) (*callStats, time.Time) {
+	key := funcKey{file: file, name: name}
+	stats := pr.funcs[key]
+	if stats == nil {
+		stats = &callStats{}
+		pr.funcs[key] = stats
+	}
+	stats.calls++
+	stats.active++
+	return stats, pr.profile.now()
+}
+
+func (pr *profiler) leave(stats *callStats, start time.Time) {
+	stats.active--
+	if stats.active == 0 {
+		stats.total += pr.profile.now().Sub(start)
+	}
+}
+
+// builtin invokes the builtin function name, recording its execution
+func (pr *profiler) builtin(ir *interpreter, name string, body func(ir *interpreter, values []Value) (Value, error), arguments []Value) (Value, error) {
+	stats := pr.builtins[name]
+	if stats == nil {
+		stats = &callStats{}
+		pr.builtins[name] = stats
+	}
+	stats.calls++
+	stats.active++
+	start := pr.profile.now()
+	pr.flush(start)
+	outer := pr.cur
+	pr.cur = outer.child(location{fn: name})
+	pr.cur.hits++
+	val, err := body(ir, arguments)
+	end := pr.profile.now()
+	pr.flush(end)
+	pr.cur = outer
+	stats.active--
+	if stats.active == 0 {
+		stats.total += end.Sub(start)
+	}
+	return val, err
+}
+
+// fork returns a profiler for a worker of the interpreter profiled by pr
+func (pr *profiler) fork() *profiler {
+	if pr == nil {
+		return nil
+	}
+	return pr.profile.newProfiler()
+}
+
+// finish merges the statistics recorded by pr into its profile
+func (pr *profiler) finish() {
+	p := pr.profile
+	pr.flush(p.now())
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, stats := range pr.lines {
+		merged := p.lines[key]
+		if merged == nil {
+			merged = &lineStats{}
+			p.lines[key] = merged
+		}
+		merged.hits += stats.hits
+		merged.self += stats.self
+		merged.total += stats.total
+	}
+	mergeCalls := func(merged *callStats, stats *callStats) *callStats {
+		if merged == nil {
+			merged = &callStats{}
+		}
+		merged.calls += stats.calls
+		merged.total += stats.total
+		return merged
+	}
+	for key, stats := range pr.funcs {
+		p.funcs[key] = mergeCalls(p.funcs[key], stats)
+	}
+	for name, stats := range pr.builtins {
+		p.builtins[name] = mergeCalls(p.builtins[name], stats)
+	}
+	var visit func(node *profileNode, stack []location)
+	visit = func(node *profileNode, stack []location) {
+		if node.hits > 0 || node.nanos > 0 {
+			key := stackKey(stack)
+			sample := p.samples[key]
+			if sample == nil {
+				sample = &profileSample{stack: append([]location(nil), stack...)}
+				p.samples[key] = sample
+			}
+			sample.hits += node.hits
+			sample.nanos += node.nanos
+		}
+		for _, child := range node.children {
+			visit(child, append([]location{child.loc}, stack...))
+		}
+	}
+	for _, child := range pr.root.children {
+		visit(child, []location{child.loc})
+	}
+}
+
+func stackKey(stack []location) string {
+	buf := strings.Builder{}
+	for _, loc := range stack {
+		fmt.Fprintf(&buf, "%s\x00%s\x00%d\x01", loc.fn, loc.file, loc.line)
+	}
+	return buf.String()
+}
+
+// hotSpots is the number of statements listed as hot spots by WriteReport
+const hotSpots = 10
+
+// WriteReport writes a report of the recorded execution to w: the statements that took the most time,
+// the functions and builtin functions sorted by time, and the source code of the executed files
+// annotated with the hits and times of their statements. source returns the source code of the script
+// or module with the specified file name; the report lacks the source code if source is nil or fails.
+func (p *Profile) WriteReport(w io.Writer, source func(file string) (string, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	buf := strings.Builder{}
+	fmt.Fprintf(&buf, "Total time: %s\n", formatProfileDuration(p.end.Sub(p.start)))
+
+	files := make(map[string][]string)
+	var fileNames []string
+	keys := make([]lineKey, 0, len(p.lines))
+	for key := range p.lines {
+		keys = append(keys, key)
+		if _, ok := files[key.file]; !ok {
+			files[key.file] = nil
+			fileNames = append(fileNames, key.file)
+		}
+	}
+	sort.Strings(fileNames)
+	for _, file := range fileNames {
+		if source == nil {
+			continue
+		}
+		if src, err := source(file); err == nil {
+			lines := strings.Split(src, "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimRight(line, "\r")
+			}
+			files[file] = lines
+		}
+	}
+	sourceLine := func(key lineKey) string {
+		lines := files[key.file]
+		if key.line < 1 || key.line > len(lines) {
+			return ""
+		}
+		return strings.TrimSpace(lines[key.line-1])
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := p.lines[keys[i]], p.lines[keys[j]]
+		if a.self != b.self {
+			return a.self > b.self
+		}
+		if keys[i].file != keys[j].file {
+			return keys[i].file < keys[j].file
+		}
+		return keys[i].line < keys[j].line
+	})
+	buf.WriteString("\nHot spots:\n")
+	fmt.Fprintf(&buf, "%10s %12s %12s  %s\n", "hits", "self", "total", "statement")
+	for i, key := range keys {
+		if i == hotSpots {
+			break
+		}
+		stats := p.lines[key]
+		fmt.Fprintf(&buf, "%10d %12s %12s  %s:%d  %s\n", stats.hits, formatProfileDuration(stats.self),
+			formatProfileDuration(stats.total), profileFileName(key.file), key.line, sourceLine(key))
+	}
+
+	if len(p.funcs) > 0 {
+		funcs := make([]funcKey, 0, len(p.funcs))
+		for key := range p.funcs {
+			funcs = append(funcs, key)
+		}
+		sort.Slice(funcs, func(i, j int) bool {
+			a, b := p.funcs[funcs[i]], p.funcs[funcs[j]]
+			if a.total != b.total {
+				return a.total > b.total
+			}
+			if funcs[i].name != funcs[j].name {
+				return funcs[i].name < funcs[j].name
+			}
+			return funcs[i].file < funcs[j].file
+		})
+		buf.WriteString("\nFunctions:\n")
+		fmt.Fprintf(&buf, "%10s %12s  %s\n", "calls", "total", "function")
+		for _, key := range funcs {
+			stats := p.funcs[key]
+			fmt.Fprintf(&buf, "%10d %12s  %s (%s)\n", stats.calls, formatProfileDuration(stats.total), key.name, profileFileName(key.file))
+		}
+	}
+
+	if len(p.builtins) > 0 {
+		names := make([]string, 0, len(p.builtins))
+		for name := range p.builtins {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			a, b := p.builtins[names[i]], p.builtins[names[j]]
+			if a.total != b.total {
+				return a.total > b.total
+			}
+			return names[i] < names[j]
+		})
+		buf.WriteString("\nBuiltin functions:\n")
+		fmt.Fprintf(&buf, "%10s %12s  %s\n", "calls", "total", "function")
+		for _, name := range names {
+			stats := p.builtins[name]
+			fmt.Fprintf(&buf, "%10d %12s  %s\n", stats.calls, formatProfileDuration(stats.total), name)
+		}
+	}
+
+	for _, file := range fileNames {
+		lines := files[file]
+		if lines == nil {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%s:\n", profileFileName(file))
+		fmt.Fprintf(&buf, "%10s %12s %12s\n", "hits", "self", "total")
+		for i, line := range lines {
+			if i == len(lines)-1 && line == "" {
+				break
+			}
+			if stats, ok := p.lines[lineKey{file: file, line: i + 1}]; ok {
+				fmt.Fprintf(&buf, "%10d %12s %12s %5d  %s\n", stats.hits, formatProfileDuration(stats.self),
+					formatProfileDuration(stats.total), i+1, line)
+			} else {
+				fmt.Fprintf(&buf, "%36s %5d  %s\n", "", i+1, line)
+			}
+		}
+	}
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+func formatProfileDuration(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
+
+// profileFileName returns the name of file shown in reports, which is empty for scripts without name
+func profileFileName(file string) string {
+	if file == "" {
+		return "<script>"
+	}
+	return file
+}
@@ -0,0 +1,117 @@
+package interpreter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestProfile returns a Profile whose clock advances by one millisecond whenever it is read
+func newTestProfile() *Profile {
+	profile := NewProfile()
+	var ticks int64
+	profile.now = func() time.Time {
+		return time.Unix(0, atomic.AddInt64(&ticks, 1)*int64(time.Millisecond))
+	}
+	return profile
+}
+
+func TestProfile(t *testing.T) {
+	src := `square := fn(x) {
+    return x * x
+}
+sum := 0
+for i in 0..4 {
+    sum = sum + square(sqrt(i))
+}
+`
+	program, err := compile(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := newTestProfile()
+	if err := Interpret(context.Background(), program, nil, Options{Profile: profile}); err != nil {
+		t.Fatalf("Interpret() error = %v", err)
+	}
+
+	wantHits := map[int]int64{2: 4, 4: 1, 5: 1, 6: 4}
+	for line, want := range wantHits {
+		stats := profile.lines[lineKey{line: line}]
+		if stats == nil || stats.hits != want {
+			t.Errorf("hits of line %d = %v, want %d", line, stats, want)
+		}
+	}
+	if stats := profile.funcs[funcKey{name: "square"}]; stats == nil || stats.calls != 4 || stats.total <= 0 {
+		t.Errorf("stats of square = %v, want 4 calls", stats)
+	}
+	if stats := profile.builtins["sqrt"]; stats == nil || stats.calls != 4 || stats.total <= 0 {
+		t.Errorf("stats of sqrt = %v, want 4 calls", stats)
+	}
+	if loop, body := profile.lines[lineKey{line: 5}], profile.lines[lineKey{line: 6}]; loop.total <= body.total {
+		t.Errorf("total of loop = %v, want more than total of its body = %v", loop.total, body.total)
+	}
+
+	buf := bytes.Buffer{}
+	if err := profile.WriteReport(&buf, func(file string) (string, error) { return src, nil }); err != nil {
+		t.Fatalf("WriteReport() error = %v", err)
+	}
+	report := buf.String()
+	for _, want := range []string{
+		"Total time: ",
+		"Hot spots:",
+		"<script>:6  sum = sum + square(sqrt(i))",
+		"         4     12.000ms  square (<script>)\n",
+		"sqrt\n",
+		"    1  square := fn(x) {\n",
+		"    2      return x * x\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("WriteReport() = %s\nwant it to contain %q", report, want)
+		}
+	}
+
+	buf.Reset()
+	if err := profile.WritePprof(&buf); err != nil {
+		t.Fatalf("WritePprof() error = %v", err)
+	}
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("WritePprof() wrote no gzip stream: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"square", "sqrt", "script", "nanoseconds"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("WritePprof() lacks %q", want)
+		}
+	}
+}
+
+func TestProfile_parallel(t *testing.T) {
+	src := `for p in Bounds {
+    @p = rgb(p.x, p.y, 0)
+}`
+	program, err := compile(src, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	profile := newTestProfile()
+	if err := Interpret(context.Background(), program, newPixelBitmap(80, 70), Options{Profile: profile}); err != nil {
+		t.Fatalf("Interpret() error = %v", err)
+	}
+	if stats := profile.lines[lineKey{line: 2}]; stats == nil || stats.hits != 80*70 {
+		t.Errorf("stats of loop body = %v, want %d hits", stats, 80*70)
+	}
+	if stats := profile.builtins["rgb"]; stats == nil || stats.calls != 80*70 {
+		t.Errorf("stats of rgb = %v, want %d calls", stats, 80*70)
+	}
+}
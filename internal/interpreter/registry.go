@@ -0,0 +1,238 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Registry holds builtin functions, constants and value types a host adds to the predefined ones.
+// Scripts using them must be resolved with ResolveWith and executed with the same Registry in their
+// Options. Several interpreters may share a Registry, but it must not be modified while they use it.
+// All methods accept a nil Registry, which holds nothing but the predefined builtins and constants.
+type Registry struct {
+	functions     map[string][]FunctionDecl
+	constantNames []string
+	constants     []Value
+	types         map[string]reflect.Type
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		functions: make(map[string][]FunctionDecl),
+		types:     make(map[string]reflect.Type),
+	}
+}
+
+// ValueType is the parameter type of builtin functions accepting any value
+var ValueType = valueType
+
+// NewFunctionDecl returns an overload of a builtin function, which is invoked with the arguments
+// of an invocation if they match params. The last parameter may be a slice type like []Number,
+// which matches any number of trailing arguments of its element type. body receives the bitmap
+// the script is executed against.
+//
+// body may be invoked concurrently by programs sharing the registry, so it must be safe for
+// concurrent use. A declaration is impure unless its Pure field is set: impure functions are
+// not allowed in the body of a parallel for loop. Set Pure only if body neither modifies the
+// bitmap nor any other state shared between invocations and does not depend on their order,
+// since a pure function may be invoked concurrently by the workers of a parallel loop.
+func NewFunctionDecl(body func(bitmap BitmapContext, args []Value) (Value, error), params ...reflect.Type) FunctionDecl {
+	return FunctionDecl{
+		body: func(ir *interpreter, args []Value) (Value, error) {
+			return body(ir.bitmap, args)
+		},
+		params: params,
+	}
+}
+
+// AddFunction adds decl as an overload of the builtin function name. Overloads are tried in the
+// order they have been added. The predefined builtin functions cannot be overloaded.
+func (r *Registry) AddFunction(name string, decl FunctionDecl) error {
+	if !isIdent(name) {
+		return fmt.Errorf("'%s' is not a valid function name", name)
+	}
+	if _, ok := functions[name]; ok {
+		return fmt.Errorf("'%s' is a predefined builtin function", name)
+	}
+	if decl.body == nil {
+		return fmt.Errorf("function '%s' has no body", name)
+	}
+	for i, param := range decl.params {
+		typ := param
+		if typ.Kind() == reflect.Slice {
+			if i < len(decl.params)-1 {
+				return fmt.Errorf("%s: only the last parameter may be variadic", signature(name, decl))
+			}
+			typ = typ.Elem()
+		}
+		if !r.isType(typ) {
+			return fmt.Errorf("%s: unknown parameter type %s", signature(name, decl), typ)
+		}
+	}
+	r.functions[name] = append(r.functions[name], decl)
+	return nil
+}
+
+// AddConstant adds the global constant name with the value val
+func (r *Registry) AddConstant(name string, val Value) error {
+	if !isIdent(name) {
+		return fmt.Errorf("'%s' is not a valid constant name", name)
+	}
+	for _, ident := range r.allConstantNames() {
+		if ident == name {
+			return fmt.Errorf("constant '%s' is already defined", name)
+		}
+	}
+	if val == nil {
+		return fmt.Errorf("constant '%s' has no value", name)
+	}
+	r.constantNames = append(r.constantNames, name)
+	r.constants = append(r.constants, val)
+	return nil
+}
+
+// AddType adds the type of val, which implements Value outside of this package, so that it can be
+// used as parameter type of builtin functions. The type is listed by its Go type name.
+func (r *Registry) AddType(val Value) error {
+	typ := reflect.TypeOf(val)
+	if typ == nil || typ.Name() == "" {
+		return fmt.Errorf("type of %v has no name", val)
+	}
+	if r.isType(typ) {
+		return fmt.Errorf("type %s is already defined", typ)
+	}
+	if other, ok := r.types[typ.Name()]; ok {
+		return fmt.Errorf("type %s has the same name as %s", typ, other)
+	}
+	r.types[typ.Name()] = typ
+	return nil
+}
+
+// isType returns true if typ is a value type that is predefined or has been added to r
+func (r *Registry) isType(typ reflect.Type) bool {
+	if typ == valueType || typ.PkgPath() == valueType.PkgPath() && typ.Implements(valueType) {
+		return true
+	}
+	return r != nil && r.types[typ.Name()] == typ
+}
+
+// lookup returns the overloads of the builtin function name
+func (r *Registry) lookup(name string) []FunctionDecl {
+	if decls, ok := functions[name]; ok || r == nil {
+		return decls
+	}
+	return r.functions[name]
+}
+
+func (r *Registry) isBuiltin(name string) bool {
+	return r.lookup(name) != nil
+}
+
+// isPure returns true if the builtin function name may be invoked by the iterations of a parallel
+// for loop: if it is predefined and not impure or if all overloads added to r are Pure
+func (r *Registry) isPure(name string) bool {
+	if _, ok := functions[name]; ok {
+		return !impureFunctions[name]
+	}
+	decls := r.lookup(name)
+	for _, decl := range decls {
+		if !decl.Pure {
+			return false
+		}
+	}
+	return len(decls) > 0
+}
+
+// allConstantNames returns the names of all constants by index as they are passed to the resolver
+func (r *Registry) allConstantNames() []string {
+	if r == nil {
+		return constantNames
+	}
+	return append(append([]string(nil), constantNames...), r.constantNames...)
+}
+
+// ResolveWith resolves the variables of the program against the constants and builtin functions
+// of the interpreter and those added to registry, see parser.Resolve. The bodies of the parallel for
+// loops of a program that imports no modules are checked with CheckParallelLoops.
+func ResolveWith(program parser.Program, registry *Registry) (parser.Program, error) {
+	program, errs := ResolveAllWith(program, registry)
+	if len(errs) > 0 {
+		return parser.Program{}, errs[0]
+	}
+	if len(program.Imports) > 0 {
+		return program, nil
+	}
+	if err := CheckParallelLoops(program, nil, registry); err != nil {
+		return parser.Program{}, err
+	}
+	return program, nil
+}
+
+// ResolveAllWith resolves the program like ResolveWith, but continues after an error and returns
+// all errors, see parser.ResolveAll. The parallel for loops are not checked.
+func ResolveAllWith(program parser.Program, registry *Registry) (parser.Program, []error) {
+	return parser.ResolveAll(program, registry.allConstantNames(), registry.isBuiltin)
+}
+
+// PrintFunctions lists the signatures of all builtin functions followed by the constants
+// and types added to the registry
+func (r *Registry) PrintFunctions() string {
+	buf := strings.Builder{}
+	for _, name := range r.FunctionNames() {
+		buf.WriteString(name)
+		buf.WriteString("\n")
+		for _, decl := range r.lookup(name) {
+			buf.WriteString("  ")
+			buf.WriteString(signature(name, decl))
+			buf.WriteString("\n")
+		}
+	}
+	if r == nil {
+		return buf.String()
+	}
+	names := append([]string(nil), r.constantNames...)
+	sort.Strings(names)
+	for _, name := range names {
+		for i, ident := range r.constantNames {
+			if ident == name {
+				fmt.Fprintf(&buf, "%s\n  const %s %s\n", name, name, reflect.TypeOf(r.constants[i]).Name())
+			}
+		}
+	}
+	names = names[:0]
+	for name := range r.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s\n  type %s\n", name, name)
+	}
+	return buf.String()
+}
+
+// FunctionNames returns the names of all builtin functions, including those added to r, in alphabetical order
+func (r *Registry) FunctionNames() []string {
+	names := FunctionNames()
+	if r == nil {
+		return names
+	}
+	for name := range r.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isIdent(name string) bool {
+	for i, c := range name {
+		if !unicode.IsLetter(c) && c != '_' && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return name != ""
+}
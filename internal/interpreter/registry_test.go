@@ -0,0 +1,260 @@
+package interpreter
+
+import (
+	"context"
+	"github.com/smackem/ylang/internal/emitter"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	r := NewRegistry()
+	scale := func(bitmap BitmapContext, args []Value) (Value, error) {
+		return args[0].(Number) * args[1].(Number), nil
+	}
+	sum := func(bitmap BitmapContext, args []Value) (Value, error) {
+		total := Number(0)
+		for _, arg := range args {
+			total += arg.(Number)
+		}
+		return total, nil
+	}
+	width := func(bitmap BitmapContext, args []Value) (Value, error) {
+		return Number(bitmap.SourceWidth()), nil
+	}
+	pureScale := NewFunctionDecl(scale, numberType, numberType)
+	pureScale.Pure = true
+	for _, err := range []error{
+		r.AddFunction("scale", pureScale),
+		r.AddFunction("total", NewFunctionDecl(sum, numberSliceType)),
+		r.AddFunction("sourceWidth", NewFunctionDecl(width)),
+		r.AddConstant("Factor", Number(3)),
+		r.AddConstant("Table", HashMap{Str("a"): Number(1)}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRegistry_execute(t *testing.T) {
+	registry := newTestRegistry(t)
+	src := `a := scale(Factor, 2)
+b := total(1, 2, 3)
+c := Table.a
+d := sourceWidth()
+log(a, " ", b, " ", c, " ", d)`
+	tokens, err := lexer.Lex(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := parser.Parse(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Resolve(program); err == nil {
+		t.Fatalf("Resolve() without registry succeeded")
+	}
+	program, err = ResolveWith(program, registry)
+	if err != nil {
+		t.Fatalf("ResolveWith() error = %v", err)
+	}
+	for _, engine := range engines {
+		t.Run(engine, func(t *testing.T) {
+			bitmap := newPixelBitmap(5, 4)
+			options := Options{Registry: registry}
+			if engine == "vm" {
+				err = Run(context.Background(), emitter.Emit(program), bitmap, options)
+			} else {
+				err = Interpret(context.Background(), program, bitmap, options)
+			}
+			if err != nil {
+				t.Fatalf("execution error = %v", err)
+			}
+			if want := []string{"6 6 1 5"}; !reflect.DeepEqual(bitmap.log, want) {
+				t.Errorf("log = %v, want %v", bitmap.log, want)
+			}
+		})
+	}
+}
+
+func TestRegistry_constants(t *testing.T) {
+	registry := newTestRegistry(t)
+	tokens, err := lexer.Lex(`log(Factor, " ", Table.a)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := parser.Parse(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if program, err = ResolveWith(program, registry); err != nil {
+		t.Fatalf("ResolveWith() error = %v", err)
+	}
+	tests := []struct {
+		constants map[string]Value
+		want      string
+		wantErr   string
+	}{
+		{constants: nil, want: "3 1"},
+		{constants: map[string]Value{"Factor": Str("x")}, want: "x 1"},
+		{constants: map[string]Value{"Pi": Number(3)}, wantErr: "unknown constant 'Pi'"},
+		{constants: map[string]Value{"Factor": nil}, wantErr: "constant 'Factor' has no value"},
+	}
+	for _, engine := range engines {
+		for _, tt := range tests {
+			bitmap := newPixelBitmap(1, 1)
+			options := Options{Registry: registry, Constants: tt.constants}
+			if engine == "vm" {
+				err = Run(context.Background(), emitter.Emit(program), bitmap, options)
+			} else {
+				err = Interpret(context.Background(), program, bitmap, options)
+			}
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("%s: execution error = %v, want %s", engine, err, tt.wantErr)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: execution error = %v", engine, err)
+			}
+			if got := strings.Join(bitmap.log, ""); got != tt.want {
+				t.Errorf("%s: log = %s, want %s", engine, got, tt.want)
+			}
+		}
+	}
+	// the values the constants have been added with are kept
+	if registry.constants[0] != Number(3) {
+		t.Errorf("registry constant Factor = %v, want 3", registry.constants[0])
+	}
+}
+
+func TestRegistry_parallel(t *testing.T) {
+	registry := newTestRegistry(t)
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "pure",
+			src: `c := 0
+parallel for p in rect(0, 0, 10, 10) reduce c { c = c + scale(p.x, 2) }
+log(c)`,
+		},
+		{
+			name: "impure",
+			src: `c := 0
+parallel for p in rect(0, 0, 10, 10) reduce c { c = c + total(p.x, 2) }
+log(c)`,
+			want: "2:57: parallel for: function 'total' is not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexer.Lex(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			program, err := parser.Parse(tokens, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			program, err = ResolveWith(program, registry)
+			if tt.want != "" {
+				if err == nil || err.Error() != tt.want {
+					t.Errorf("ResolveWith() error = %v, want %s", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveWith() error = %v", err)
+			}
+			bitmap := newPixelBitmap(5, 4)
+			if err := Interpret(context.Background(), program, bitmap, Options{Registry: registry}); err != nil {
+				t.Fatalf("Interpret() error = %v", err)
+			}
+			if want := []string{"900"}; !reflect.DeepEqual(bitmap.log, want) {
+				t.Errorf("log = %v, want %v", bitmap.log, want)
+			}
+		})
+	}
+}
+
+func TestRegistry_errors(t *testing.T) {
+	noop := func(bitmap BitmapContext, args []Value) (Value, error) { return nil, nil }
+	tests := []struct {
+		name string
+		add  func(r *Registry) error
+		want string
+	}{
+		{
+			name: "predefined_function",
+			add:  func(r *Registry) error { return r.AddFunction("rgb", NewFunctionDecl(noop)) },
+			want: "'rgb' is a predefined builtin function",
+		},
+		{
+			name: "invalid_name",
+			add:  func(r *Registry) error { return r.AddFunction("a-b", NewFunctionDecl(noop)) },
+			want: "'a-b' is not a valid function name",
+		},
+		{
+			name: "unknown_type",
+			add:  func(r *Registry) error { return r.AddFunction("f", NewFunctionDecl(noop, reflect.TypeOf(0))) },
+			want: "fn f(int): unknown parameter type int",
+		},
+		{
+			name: "variadic",
+			add: func(r *Registry) error {
+				return r.AddFunction("f", NewFunctionDecl(noop, numberSliceType, numberType))
+			},
+			want: "fn f(Number..., Number): only the last parameter may be variadic",
+		},
+		{
+			name: "predefined_constant",
+			add:  func(r *Registry) error { return r.AddConstant("Pi", Number(3)) },
+			want: "constant 'Pi' is already defined",
+		},
+		{
+			name: "duplicate_constant",
+			add:  func(r *Registry) error { return r.AddConstant("Factor", Number(3)) },
+			want: "constant 'Factor' is already defined",
+		},
+		{
+			name: "predefined_type",
+			add:  func(r *Registry) error { return r.AddType(Number(0)) },
+			want: "type interpreter.Number is already defined",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.add(newTestRegistry(t))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistry_PrintFunctions(t *testing.T) {
+	got := newTestRegistry(t).PrintFunctions()
+	for _, want := range []string{
+		"rgb\n  fn rgb(Number, Number, Number)\n",
+		"scale\n  fn scale(Number, Number)\n",
+		"total\n  fn total(Number...)\n",
+		"Factor\n  const Factor Number\n",
+		"Table\n  const Table HashMap\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("PrintFunctions() lacks %q", want)
+		}
+	}
+	if strings.Contains(PrintFunctions(), "scale") {
+		t.Errorf("PrintFunctions() lists functions of a registry")
+	}
+}
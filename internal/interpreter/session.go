@@ -0,0 +1,103 @@
+package interpreter
+
+import (
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/parser"
+)
+
+// Session executes the inputs of an interactive session one after another.
+// All inputs share the interpreter, so that the globals declared by an input
+// are visible to the following inputs.
+type Session struct {
+	ir *interpreter
+}
+
+// NewSession returns a Session working on the specified bitmap.
+func NewSession(bitmap BitmapContext) *Session {
+	ir := newInterpreter(bitmap)
+	ir.sources = make(map[string]parser.Program)
+	ir.modules[0].importNames = make(map[string]string)
+	return &Session{ir: ir}
+}
+
+// Globals returns the names of the globals declared by the inputs executed so far.
+// They must be passed to the resolver as predeclared globals of the next input.
+func (s *Session) Globals() []string {
+	return append([]string(nil), s.ir.modules[0].globalNames...)
+}
+
+// Execute executes an input, which must have been resolved with the Globals of the session.
+// If the input ends with an expression statement, the value of the expression is returned
+// formatted like the log statement does - otherwise the result is empty.
+// Errors are returned as *lang.Error.
+func (s *Session) Execute(input parser.Program) (string, error) {
+	if input.Frame == nil {
+		return "", &lang.Error{Msg: "the input has not been resolved"}
+	}
+	ir := s.ir
+	mod := ir.modules[0]
+	for path, name := range input.ImportNames {
+		mod.importNames[path] = name
+	}
+	for name, prog := range input.Modules {
+		if _, ok := ir.sources[name]; !ok {
+			ir.sources[name] = prog
+		}
+	}
+	for len(mod.globals) < len(input.Globals) {
+		mod.globals = append(mod.globals, nil)
+	}
+	mod.globalNames = input.Globals
+	// the bitmap may have been changed between inputs, e.g. to undo an input
+	if ir.bitmap != nil {
+		ir.assignBounds()
+	}
+	// an error in the previous input may have left the interpreter in a function
+	ir.functionScopes = nil
+	ir.callStack = []callFrame{{name: scriptFrameName}}
+	ir.enterFrame(mod.globals, input.Frame, nil)
+
+	stmts := input.Stmts
+	var exprStmt *parser.InvocationStmt
+	if n := len(stmts); n > 0 {
+		if stmt, ok := stmts[n-1].(parser.InvocationStmt); ok {
+			stmts, exprStmt = stmts[:n-1], &stmt
+		}
+	}
+	if err := ir.visitStmtList(stmts); err != nil {
+		if _, ok := err.(returnSignal); ok { // return statement ends the input
+			return "", nil
+		}
+		return "", lang.ErrorAt(err, 0, 0)
+	}
+	if exprStmt == nil {
+		return "", nil
+	}
+	val, err := ir.visitExpr(exprStmt.Invocation)
+	if err != nil {
+		return "", lang.ErrorAt(ir.errorAt(err, exprStmt.Token()), 0, 0)
+	}
+	if val == nil {
+		return "", nil
+	}
+	return formatValue(val, "", false), nil
+}
+
+// ModifiesBitmap returns true if executing the resolved input may modify the bitmap: if it assigns
+// pixels, invokes an impure builtin function like flip or plot, imports a module or invokes a function
+// declared by a script or registered by the host, whose body is not analyzed. Hosts may save the bitmap only before inputs
+// that modify it, e.g. to undo them.
+func ModifiesBitmap(input parser.Program) bool {
+	modifies := false
+	parser.InspectStmts(input.Stmts, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case parser.PixelAssignStmt, parser.CallExpr, parser.ImportExpr:
+			modifies = true
+		case parser.InvokeExpr:
+			_, builtin := functions[n.FuncName]
+			modifies = n.Var != nil || !builtin || impureFunctions[n.FuncName]
+		}
+		return !modifies
+	})
+	return modifies
+}
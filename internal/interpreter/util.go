@@ -8,6 +8,33 @@ import (
 
 var falseVal = Boolean(false)
 
+// propertyNames are the names of the properties each runtime type supports in addition to
+// the properties of baseProperty. aliases like "red" for "r" are omitted.
+var propertyNames = map[string][]string{
+	"circle":  {"center", "radius", "bounds"},
+	"color":   {"r", "g", "b", "a", "r01", "g01", "b01", "a01", "i", "i01"},
+	"hashmap": {"count"},
+	"hsv":     {"h", "s", "v"},
+	"image":   {"bounds", "w", "h", "name"},
+	"kernel":  {"w", "h", "count"},
+	"line":    {"p1", "p2", "dx", "dy", "len"},
+	"list":    {"count"},
+	"point":   {"x", "y", "mag"},
+	"polygon": {"bounds", "vertices"},
+	"rect":    {"x", "y", "w", "h", "right", "bottom"},
+	"string":  {"len"},
+}
+
+// PropertyNames returns the names of the properties of all runtime types that have
+// properties, keyed by type name
+func PropertyNames() map[string][]string {
+	result := make(map[string][]string, len(propertyNames))
+	for typeName, names := range propertyNames {
+		result[typeName] = append([]string(nil), names...)
+	}
+	return result
+}
+
 func baseProperty(val Value, ident string) (Value, error) {
 	switch ident {
 	case "__type":
@@ -673,3 +673,34 @@ func TestPosition_property(t *testing.T) {
 		})
 	}
 }
+
+func Test_propertyNames(t *testing.T) {
+	values := []Value{
+		Circle{Center: Point{1, 2}, Radius: 3},
+		Color(lang.NewRgba(1, 2, 3, 4)),
+		HashMap{},
+		ColorHsv{},
+		NewImage("bg", testImage{width: 3, height: 2}),
+		Kernel{Width: 1, Height: 1, Values: []lang.Number{1}},
+		Line{Point1: Point{0, 0}, Point2: Point{3, 4}},
+		List{},
+		Point{1, 2},
+		Polygon{Vertices: []Point{{0, 0}, {1, 0}, {0, 1}}},
+		Rect{Min: image.Point{0, 0}, Max: image.Point{2, 2}},
+		Str("abc"),
+	}
+	if len(values) != len(propertyNames) {
+		t.Fatalf("got %d values, want one for each of the %d types in propertyNames", len(values), len(propertyNames))
+	}
+	for _, val := range values {
+		names, ok := propertyNames[val.RuntimeTypeName()]
+		if !ok {
+			t.Errorf("propertyNames lacks type %s", val.RuntimeTypeName())
+		}
+		for _, name := range names {
+			if _, err := val.Property(name); err != nil {
+				t.Errorf("%s.Property(%s) error = %v", val.RuntimeTypeName(), name, err)
+			}
+		}
+	}
+}
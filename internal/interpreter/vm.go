@@ -0,0 +1,512 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/smackem/ylang/internal/emitter"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+)
+
+// Run executes the bytecode emitted by emitter.Emit against the specified bitmap.
+// The bytecode is executed by a stack machine with the same semantics as Interpret.
+// A non-nil error is always of type *lang.Error.
+func Run(ctx context.Context, code emitter.Program, bitmap BitmapContext, options Options) error {
+	if options.Profile != nil {
+		return &lang.Error{Msg: "profiling is not supported by the virtual machine"}
+	}
+	ir := newInterpreter(bitmap)
+	var cancel context.CancelFunc
+	ir.limits, cancel = newLimits(ctx, options)
+	defer cancel()
+	if err := ir.register(options.Registry, options.Constants); err != nil {
+		return err
+	}
+	if err := ir.setParams(options.Params, code.Params); err != nil {
+		return err
+	}
+	unit := ir.loadCode(&code)
+	ir.enterModule(ir.modules[0], unit.globals, unit.frame)
+	if err := ir.execute(unit, 0); err != nil {
+		if _, ok := err.(returnSignal); !ok { // return statement encountered
+			return lang.ErrorAt(err, 0, 0)
+		}
+	}
+	return nil
+}
+
+// codeUnit is the bytecode of a module, prepared for execution
+type codeUnit struct {
+	code        []instruction
+	functions   []*emitter.Function
+	frames      []*parser.Frame // the variables of the functions by index
+	frame       *parser.Frame   // the variables of the top-level code
+	globals     []string
+	importNames map[string]string
+}
+
+type instruction struct {
+	op  emitter.OpCode
+	n   int    // the integer argument
+	str string // the string argument
+	val Value  // the value pushed by PUSH
+	tok lexer.Token
+}
+
+// loadCode prepares the main program and all modules of code for execution
+// and returns the code unit of the main program.
+func (ir *interpreter) loadCode(code *emitter.Program) *codeUnit {
+	ir.modules[0].name = code.File
+	ir.modules[0].importNames = code.ImportNames
+	ir.units = make(map[string]*codeUnit)
+	for name, module := range code.Modules {
+		ir.units[name] = newCodeUnit(module)
+	}
+	return newCodeUnit(code)
+}
+
+func newCodeUnit(code *emitter.Program) *codeUnit {
+	unit := &codeUnit{
+		code:        make([]instruction, len(code.Instructions)),
+		functions:   code.Functions,
+		frames:      make([]*parser.Frame, len(code.Functions)),
+		frame:       &parser.Frame{Size: int(code.FrameSize), Cells: int(code.CellCount)},
+		globals:     code.Globals,
+		importNames: code.ImportNames,
+	}
+	for i, f := range code.Functions {
+		unit.frames[i] = &parser.Frame{
+			Size:     int(f.FrameSize),
+			Cells:    int(f.CellCount),
+			Params:   newVars(f.Params),
+			Captures: newVars(f.Captures),
+		}
+	}
+	for i, instr := range code.Instructions {
+		unit.code[i] = instruction{
+			op:  instr.Opcode,
+			n:   int(instr.Integer),
+			str: instr.GetStr(),
+			tok: lexer.Token{LineNumber: int(instr.Line), Column: int(instr.Column)},
+		}
+		if instr.Opcode != emitter.OpCode_PUSH {
+			continue
+		}
+		switch arg := instr.Arg.(type) {
+		case *emitter.Instruction_Float:
+			unit.code[i].val = Number(arg.Float)
+		case *emitter.Instruction_Str:
+			unit.code[i].val = Str(arg.Str)
+		case *emitter.Instruction_Boolean:
+			unit.code[i].val = Boolean(arg.Boolean)
+		case *emitter.Instruction_Color:
+			unit.code[i].val = Color(lang.NewRgba(
+				lang.Number(arg.Color>>24&0xff),
+				lang.Number(arg.Color>>16&0xff),
+				lang.Number(arg.Color>>8&0xff),
+				lang.Number(arg.Color&0xff)))
+		default:
+			unit.code[i].val = Nilval(lang.NilVal)
+		}
+	}
+	return unit
+}
+
+func newVars(vars []*emitter.Variable) []*parser.Var {
+	if len(vars) == 0 {
+		return nil
+	}
+	result := make([]*parser.Var, len(vars))
+	for i, v := range vars {
+		result[i] = &parser.Var{Kind: parser.VarKind(v.Kind), Index: int(v.Index)}
+	}
+	return result
+}
+
+func (ir *interpreter) push(val Value) {
+	if val == nil {
+		val = Nilval{}
+	}
+	ir.stack = append(ir.stack, val)
+}
+
+func (ir *interpreter) pop() Value {
+	last := len(ir.stack) - 1
+	val := ir.stack[last]
+	ir.stack = ir.stack[:last]
+	return val
+}
+
+// popN pops the topmost n values and returns them in the order they have been pushed
+func (ir *interpreter) popN(n int) []Value {
+	vals := make([]Value, n)
+	copy(vals, ir.stack[len(ir.stack)-n:])
+	ir.stack = ir.stack[:len(ir.stack)-n]
+	return vals
+}
+
+// execute runs the code of unit starting at address until an END instruction is reached.
+// like visitStmtList, it returns signals unchanged.
+func (ir *interpreter) execute(unit *codeUnit, address int) error {
+	base := len(ir.stack)
+	for pc := address; ; {
+		if err := ir.step(); err != nil {
+			ir.stack = ir.stack[:base]
+			return ir.errorAt(err, unit.code[pc].tok)
+		}
+		instr := &unit.code[pc]
+		pc++
+		var err error
+
+		switch instr.op {
+		case emitter.OpCode_NOP:
+
+		case emitter.OpCode_PUSH:
+			ir.push(instr.val)
+
+		case emitter.OpCode_POP:
+			ir.pop()
+
+		case emitter.OpCode_NEW_CELL:
+			ir.cells[instr.n] = &cell{}
+
+		case emitter.OpCode_LOAD:
+			err = ir.pushVar(ir.frame[instr.n], instr.str)
+		case emitter.OpCode_LOAD_CELL:
+			err = ir.pushVar(ir.cells[instr.n].val, instr.str)
+		case emitter.OpCode_LOAD_CAPTURE:
+			err = ir.pushVar(ir.captures[instr.n].val, instr.str)
+		case emitter.OpCode_LOAD_GLOBAL:
+			err = ir.pushVar(ir.globals[instr.n], instr.str)
+		case emitter.OpCode_LOAD_CONST:
+			err = ir.pushVar(ir.constants[instr.n], instr.str)
+
+		case emitter.OpCode_STORE:
+			ir.frame[instr.n] = ir.pop()
+		case emitter.OpCode_STORE_CELL:
+			ir.cells[instr.n].val = ir.pop()
+		case emitter.OpCode_STORE_CAPTURE:
+			ir.captures[instr.n].val = ir.pop()
+		case emitter.OpCode_STORE_GLOBAL:
+			ir.globals[instr.n] = ir.pop()
+		case emitter.OpCode_STORE_CONST:
+			ir.constants[instr.n] = ir.pop()
+
+		case emitter.OpCode_PARAM:
+			var v Value
+			if v, err = ir.paramValue(instr.str, ir.pop()); err == nil {
+				ir.push(v)
+			}
+
+		case emitter.OpCode_STORE_AT:
+			rval, ival, lval := ir.pop(), ir.pop(), ir.pop()
+			err = lval.IndexAssign(ival, rval)
+
+		case emitter.OpCode_SET_PIXEL:
+			right, left := ir.pop(), ir.pop()
+			err = ir.setPixel(left, right)
+
+		case emitter.OpCode_CALL:
+			args := ir.popN(instr.n)
+			outerCallSite := ir.callSite
+			ir.callSite = instr.tok
+			var val Value
+			val, err = ir.invokeFunc(instr.str, nil, args)
+			ir.callSite = outerCallSite
+			ir.push(val)
+
+		case emitter.OpCode_CALL_MEMBER:
+			args := ir.popN(instr.n)
+			callee := ir.pop()
+			outerCallSite := ir.callSite
+			ir.callSite = instr.tok
+			var val Value
+			val, err = ir.invokeFunctionExpr(instr.str, callee, args)
+			ir.callSite = outerCallSite
+			ir.push(val)
+
+		case emitter.OpCode_BR:
+			pc = instr.n
+
+		case emitter.OpCode_BR_FALSE:
+			b, ok := ir.pop().(Boolean)
+			if !ok {
+				err = errors.New(instr.str)
+				break
+			}
+			if !b {
+				pc = instr.n
+			}
+
+		case emitter.OpCode_LOG:
+			ir.log(ir.popN(instr.n))
+
+		case emitter.OpCode_RET:
+			err = ir.returnValue(ir.pop())
+
+		case emitter.OpCode_OR, emitter.OpCode_AND:
+			b, ok := ir.pop().(Boolean)
+			if !ok {
+				err = fmt.Errorf("type mismatch: expected bool")
+				break
+			}
+			if bool(b) == (instr.op == emitter.OpCode_OR) {
+				ir.push(b)
+				pc = instr.n
+			}
+
+		case emitter.OpCode_BOOL:
+			if _, ok := ir.stack[len(ir.stack)-1].(Boolean); !ok {
+				err = fmt.Errorf("type mismatch: expected bool")
+			}
+
+		case emitter.OpCode_EQ:
+			err = ir.binaryOp(equal)
+		case emitter.OpCode_NEQ:
+			err = ir.binaryOp(notEqual)
+		case emitter.OpCode_GT:
+			err = ir.binaryOp(greater)
+		case emitter.OpCode_GE:
+			err = ir.binaryOp(greaterOrEqual)
+		case emitter.OpCode_LT:
+			err = ir.binaryOp(less)
+		case emitter.OpCode_LE:
+			err = ir.binaryOp(lessOrEqual)
+		case emitter.OpCode_CONCAT:
+			err = ir.binaryOp(ir.concat)
+		case emitter.OpCode_ADD:
+			err = ir.binaryOp(Value.Add)
+		case emitter.OpCode_SUB:
+			err = ir.binaryOp(Value.Sub)
+		case emitter.OpCode_MUL:
+			err = ir.binaryOp(Value.Mul)
+		case emitter.OpCode_DIV:
+			err = ir.binaryOp(Value.Div)
+		case emitter.OpCode_MOD:
+			err = ir.binaryOp(Value.Mod)
+		case emitter.OpCode_IN:
+			err = ir.binaryOp(Value.In)
+		case emitter.OpCode_MK_POINT:
+			err = ir.binaryOp(makePoint)
+		case emitter.OpCode_INDEX:
+			err = ir.binaryOp(Value.Index)
+		case emitter.OpCode_SAMPLE:
+			err = ir.binaryOp(sample)
+
+		case emitter.OpCode_NEG:
+			err = ir.unaryOp(Value.Neg)
+		case emitter.OpCode_NOT:
+			err = ir.unaryOp(Value.Not)
+		case emitter.OpCode_GET_PIXEL:
+			err = ir.unaryOp(ir.getPixel)
+
+		case emitter.OpCode_MEMBER:
+			err = ir.unaryOp(func(recvr Value) (Value, error) {
+				return recvr.Property(instr.str)
+			})
+
+		case emitter.OpCode_INDEX_RANGE:
+			upper, lower, recvr := ir.pop(), ir.pop(), ir.pop()
+			var val Value
+			val, err = recvr.IndexRange(lower, upper)
+			ir.push(val)
+
+		case emitter.OpCode_MK_KERNEL:
+			if err = ir.checkAllocation(float64(instr.n)); err != nil {
+				break
+			}
+			var val Value
+			val, err = makeKernel(ir.popN(instr.n))
+			ir.push(val)
+
+		case emitter.OpCode_MK_HASHMAP:
+			if err = ir.checkAllocation(float64(instr.n)); err != nil {
+				break
+			}
+			entries := ir.popN(instr.n * 2)
+			h := make(HashMap)
+			for i := 0; i < len(entries); i += 2 {
+				h[entries[i]] = entries[i+1]
+			}
+			ir.push(h)
+
+		case emitter.OpCode_MK_LIST:
+			if err = ir.checkAllocation(float64(instr.n)); err != nil {
+				break
+			}
+			ir.push(List{Elements: ir.popN(instr.n)})
+
+		case emitter.OpCode_MK_FUNCTION:
+			f, frame := unit.functions[instr.n], unit.frames[instr.n]
+			ir.push(Function{
+				ParameterNames: f.ParameterNames,
+				IsGenerator:    f.IsGenerator,
+				frame:          frame,
+				captures:       ir.capture(frame),
+				module:         ir.currentModule(),
+				unit:           unit,
+				address:        int(f.Address),
+			})
+
+		case emitter.OpCode_IMPORT:
+			var val Value
+			val, err = ir.importModule(instr.str, instr.tok)
+			ir.push(val)
+
+		case emitter.OpCode_FOR_IN:
+			err = ir.executeForIn(unit, pc, instr.str, ir.pop())
+			pc = instr.n
+
+		case emitter.OpCode_END:
+			ir.stack = ir.stack[:base]
+			return nil
+
+		case emitter.OpCode_BREAK:
+			ir.stack = ir.stack[:base]
+			return breakSig
+
+		case emitter.OpCode_RANGE_INIT:
+			err = ir.initRange(instr.str)
+
+		case emitter.OpCode_RANGE_NEXT:
+			top := len(ir.stack) - 1
+			n, upper := ir.stack[top].(Number), ir.stack[top-2].(Number)
+			if n >= upper {
+				pc = instr.n
+				break
+			}
+			ir.push(n)
+			ir.setLoopVar(n)
+
+		case emitter.OpCode_RANGE_STEP:
+			top := len(ir.stack) - 1
+			ir.stack[top] = ir.stack[top].(Number) + ir.stack[top-1].(Number)
+
+		case emitter.OpCode_RANGE_END:
+			ir.stack = ir.stack[:len(ir.stack)-3]
+			ir.popLoopVar()
+
+		case emitter.OpCode_YIELD:
+			err = ir.yield(ir.pop())
+
+		case emitter.OpCode_REDUCE_BEGIN:
+			err = ir.beginReduction(instr.str, instr.n)
+
+		case emitter.OpCode_REDUCE_END:
+			err = ir.endReduction(instr.str, instr.n)
+
+		default:
+			err = fmt.Errorf("unknown opcode %s", instr.op)
+		}
+
+		if err != nil {
+			ir.stack = ir.stack[:base]
+			return ir.errorAt(err, instr.tok)
+		}
+	}
+}
+
+// pushVar pushes the value of the variable named ident
+func (ir *interpreter) pushVar(val Value, ident string) error {
+	if val == nil {
+		return fmt.Errorf("identifier '%s' is used before its declaration", ident)
+	}
+	ir.stack = append(ir.stack, val)
+	return nil
+}
+
+func (ir *interpreter) binaryOp(op func(left Value, right Value) (Value, error)) error {
+	right, left := ir.pop(), ir.pop()
+	val, err := op(left, right)
+	ir.push(val)
+	return err
+}
+
+func (ir *interpreter) unaryOp(op func(val Value) (Value, error)) error {
+	val, err := op(ir.pop())
+	ir.push(val)
+	return err
+}
+
+// beginReduction prepares the reduction variable ident of a parallel for loop, which the virtual machine
+// executes like a single worker of the interpreter, see runParallel. merge is 1 if the merge function is
+// on top of the stack, 2 for a builtin merge function and 0 to merge with +.
+func (ir *interpreter) beginReduction(ident string, merge int) error {
+	var mergeVal Value
+	if merge == 1 {
+		mergeVal = ir.pop()
+	}
+	val, coll := ir.pop(), ir.pop()
+	if err := checkReduction(ident, val); err != nil {
+		return err
+	}
+	if merge == 1 {
+		if err := checkMerge(ident, mergeVal); err != nil {
+			return err
+		}
+	}
+	ir.push(val)
+	ir.push(coll)
+	ir.push(initialValue(val, merge != 0))
+	return nil
+}
+
+// endReduction merges the result of a parallel for loop into the value of the reduction variable ident
+func (ir *interpreter) endReduction(ident string, merge int) error {
+	result, val := ir.pop(), ir.pop()
+	if merge != 0 {
+		ir.push(result)
+		return nil
+	}
+	val, err := mergeAdd(val, result)
+	if err != nil {
+		return fmt.Errorf("error merging reduction variable '%s': %s", ident, err)
+	}
+	ir.push(val)
+	return nil
+}
+
+// executeForIn executes the block starting at address for each element of coll,
+// which is pushed before the block is executed. the block is terminated by an END instruction.
+func (ir *interpreter) executeForIn(unit *codeUnit, address int, ident string, coll Value) error {
+	if rect, ok := coll.(Rect); ok {
+		ir.constants[lastRectConst] = rect
+	}
+	ir.pushLoopVar(ident)
+	defer ir.popLoopVar()
+	err := coll.Iterate(func(val Value) error {
+		// the block pops the element into the loop variable, so the stack is
+		// restored afterwards. generators yield with their own operands on the stack.
+		base := len(ir.stack)
+		ir.push(val)
+		ir.setLoopVar(val)
+		err := ir.execute(unit, address) // continue branches to the END of the block
+		ir.stack = ir.stack[:base]
+		return err
+	})
+	if _, ok := err.(breakSignal); ok {
+		return nil
+	}
+	return err
+}
+
+// initRange validates the lower bound, upper bound and step of a range loop on top of the stack.
+// ident is the name of the loop variable.
+func (ir *interpreter) initRange(ident string) error {
+	top := len(ir.stack) - 1
+	if _, ok := ir.stack[top-2].(Number); !ok {
+		return fmt.Errorf("type mismatch: expected lower number")
+	}
+	if _, ok := ir.stack[top-1].(Number); !ok {
+		return fmt.Errorf("type mismatch: expected upper number")
+	}
+	if _, ok := ir.stack[top].(Number); !ok {
+		return fmt.Errorf("type mismatch: expected upper number")
+	}
+	// reorder from lower, upper, step to upper, step, n
+	ir.stack[top-2], ir.stack[top-1], ir.stack[top] = ir.stack[top-1], ir.stack[top], ir.stack[top-2]
+	ir.pushLoopVar(ident)
+	return nil
+}
@@ -0,0 +1,26 @@
+package lang
+
+import "fmt"
+
+// Severity classifies a Diagnostic
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // the program fails to compile or fails at runtime when the code is reached
+	SeverityWarning Severity = "warning" // the code is valid, but probably not what was intended
+)
+
+// Diagnostic is a problem found in a ylang program by static analysis.
+// Line and Col are 1-based, Line is 0 if the position is unknown.
+type Diagnostic struct {
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line"`
+	Col      int      `json:"col"`
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"` // identifies the check that reported the diagnostic, e.g. "unused"
+	Msg      string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s [%s]", (&Error{File: d.File, Line: d.Line, Col: d.Col, Msg: string(d.Severity)}).Error(), d.Msg, d.Code)
+}
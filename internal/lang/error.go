@@ -0,0 +1,112 @@
+package lang
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is an error that occurred while compiling or executing a ylang program.
+// Line and Col are 1-based and denote the start of the offending source span.
+// Line is 0 if the position is unknown.
+type Error struct {
+	File  string
+	Line  int
+	Col   int
+	Msg   string
+	Trace []TraceFrame // the call stack at the time of a runtime error, outermost call first
+	Err   error        // the error the Error has been created from, nil if none
+}
+
+// TraceFrame describes a function call that was active when a runtime error occurred.
+type TraceFrame struct {
+	File     string
+	Func     string   // the name of the invoked function or "<script>" for the top level
+	Line     int      // the line that was executing in this frame
+	LoopVars []string // the values of the active loop variables in this frame, formatted as "ident = value"
+}
+
+func (e *Error) Error() string {
+	buf := strings.Builder{}
+	if e.File != "" {
+		buf.WriteString(e.File)
+		buf.WriteString(":")
+	}
+	if e.Line > 0 {
+		buf.WriteString(fmt.Sprintf("%d:%d: ", e.Line, e.Col))
+	} else if e.File != "" {
+		buf.WriteString(" ")
+	}
+	buf.WriteString(e.Msg)
+	return buf.String()
+}
+
+// Unwrap returns the error the Error has been created from
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Traceback formats the call stack of a runtime error like Python does,
+// the innermost call last. The error message itself is not included.
+// Returns an empty string if the error carries no trace.
+func (e *Error) Traceback() string {
+	if len(e.Trace) == 0 {
+		return ""
+	}
+	buf := strings.Builder{}
+	buf.WriteString("Traceback (most recent call last):\n")
+	for _, frame := range e.Trace {
+		file := frame.File
+		if file == "" {
+			file = e.File
+		}
+		if file != "" {
+			buf.WriteString(fmt.Sprintf("  File \"%s\", line %d, in %s\n", file, frame.Line, frame.Func))
+		} else {
+			buf.WriteString(fmt.Sprintf("  line %d, in %s\n", frame.Line, frame.Func))
+		}
+		if len(frame.LoopVars) > 0 {
+			buf.WriteString("    where ")
+			buf.WriteString(strings.Join(frame.LoopVars, ", "))
+			buf.WriteString("\n")
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Excerpt returns the source line the error points at, followed by a line
+// with a caret below the offending column. src is the source code of e.File.
+// Returns an empty string if the position is unknown.
+func (e *Error) Excerpt(src string) string {
+	if e.Line <= 0 {
+		return ""
+	}
+	lines := strings.Split(src, "\n")
+	if e.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[e.Line-1], "\r")
+	buf := strings.Builder{}
+	buf.WriteString(line)
+	buf.WriteString("\n")
+	for i, r := range []rune(line) {
+		if i >= e.Col-1 {
+			break
+		}
+		if r == '\t' {
+			buf.WriteRune('\t')
+		} else {
+			buf.WriteRune(' ')
+		}
+	}
+	buf.WriteString("^")
+	return buf.String()
+}
+
+// ErrorAt returns err as *Error. If err is not already an *Error,
+// it is wrapped into one pointing at line and col.
+func ErrorAt(err error, line, col int) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Line: line, Col: col, Msg: err.Error(), Err: err}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // TokenType represents the type of Token
@@ -63,6 +64,13 @@ const (
 	TTColonColon
 	TTWhile
 	TTDollar
+	TTBreak
+	TTContinue
+	TTImport
+	TTParallel
+	TTReduce
+	TTParam
+	TTComment
 	TTEOF
 )
 
@@ -117,6 +125,13 @@ var tokenTypeNames = []string{
 	"::",
 	"while",
 	"$",
+	"break",
+	"continue",
+	"import",
+	"parallel",
+	"reduce",
+	"param",
+	"comment",
 	"eof",
 }
 
@@ -129,6 +144,7 @@ type Token struct {
 	Type       TokenType
 	Lexeme     string
 	LineNumber int
+	Column     int // 1-based, counted in runes
 }
 
 func (t Token) String() string {
@@ -177,41 +193,78 @@ func (t Token) ParseColor() lang.Color {
 // Lex walks the specified string and returns an array of lexed Tokens
 // or an non-nil error if the input could not be lexed.
 func Lex(src string) ([]Token, error) {
+	tokens, _, err := lex(src, false)
+	return tokens, err
+}
+
+// LexWithComments works like Lex, but also returns the comments as trivia that is
+// not part of the token stream. The comments are tokens of type TTComment, their
+// lexemes span from the leading '//' to the end of the line.
+func LexWithComments(src string) (tokens []Token, comments []Token, err error) {
+	return lex(src, true)
+}
+
+func lex(src string, keepComments bool) ([]Token, []Token, error) {
 	tokens := []Token{}
+	var comments []Token
 	lineNumber := 1
-	isNotSpace := func(r rune) bool {
-		if r == '\n' {
-			lineNumber++
-		}
-		return unicode.IsSpace(r) == false
-	}
+	lineStart := 0 // index of the first character of the current line
 
 	for index := 0; index < len(src); {
-		slice := src[index:]
-		spaceCount := strings.IndexFunc(slice, isNotSpace)
-		if spaceCount < 0 {
+		for index < len(src) {
+			r, size := utf8.DecodeRuneInString(src[index:])
+			if unicode.IsSpace(r) == false {
+				break
+			}
+			index += size
+			if r == '\n' {
+				lineNumber++
+				lineStart = index
+			}
+		}
+		if index >= len(src) {
 			break // eof
 		}
-		index += spaceCount
-		slice = src[index:]
+		slice := src[index:]
 
 		if strings.HasPrefix(slice, "//") {
-			for src[index] != '\n' {
+			start := index
+			for index < len(src) && src[index] != '\n' {
 				index++
 			}
+			if keepComments {
+				comments = append(comments, Token{
+					Type:       TTComment,
+					Lexeme:     strings.TrimRight(src[start:index], "\r"),
+					LineNumber: lineNumber,
+					Column:     utf8.RuneCountInString(src[lineStart:start]) + 1,
+				})
+			}
 			continue
 		}
 
 		if token, lexemeLen := match(slice); lexemeLen >= 0 {
 			token.LineNumber = lineNumber
+			token.Column = utf8.RuneCountInString(src[lineStart:index]) + 1
 			tokens = append(tokens, token)
 			index += lexemeLen
 		} else {
-			return tokens, fmt.Errorf("Error lexing '%s'", slice)
+			return tokens, comments, &lang.Error{
+				Line: lineNumber,
+				Col:  utf8.RuneCountInString(src[lineStart:index]) + 1,
+				Msg:  fmt.Sprintf("Error lexing '%s'", firstLine(slice)),
+			}
 		}
 	}
 
-	return tokens, nil
+	return tokens, comments, nil
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
 }
 
 func match(src string) (Token, int) {
@@ -243,21 +296,27 @@ func makeMatcher(pattern string, lookup tokenLookup) matcher {
 }
 
 var keywordTokens = map[string]TokenType{
-	"and":    TTAnd,
-	"or":     TTOr,
-	"not":    TTNot,
-	"for":    TTFor,
-	"in":     TTIn,
-	"yield":  TTYield,
-	"if":     TTIf,
-	"else":   TTElse,
-	"true":   TTTrue,
-	"false":  TTFalse,
-	"log":    TTLog,
-	"fn":     TTFn,
-	"return": TTReturn,
-	"nil":    TTNil,
-	"while":  TTWhile,
+	"and":      TTAnd,
+	"or":       TTOr,
+	"not":      TTNot,
+	"for":      TTFor,
+	"in":       TTIn,
+	"yield":    TTYield,
+	"if":       TTIf,
+	"else":     TTElse,
+	"true":     TTTrue,
+	"false":    TTFalse,
+	"log":      TTLog,
+	"fn":       TTFn,
+	"return":   TTReturn,
+	"nil":      TTNil,
+	"while":    TTWhile,
+	"break":    TTBreak,
+	"continue": TTContinue,
+	"import":   TTImport,
+	"parallel": TTParallel,
+	"reduce":   TTReduce,
+	"param":    TTParam,
 }
 
 func lookupKeyword(lexeme string) TokenType {
@@ -21,16 +21,19 @@ func Test_lex(t *testing.T) {
 					Type:       TTIdent,
 					Lexeme:     "x",
 					LineNumber: 1,
+					Column:     1,
 				},
 				Token{
 					Type:       TTColonEq,
 					Lexeme:     ":=",
 					LineNumber: 1,
+					Column:     3,
 				},
 				Token{
 					Type:       TTNumber,
 					Lexeme:     "1",
 					LineNumber: 1,
+					Column:     6,
 				},
 			},
 		},
@@ -42,16 +45,19 @@ func Test_lex(t *testing.T) {
 					Type:       TTIdent,
 					Lexeme:     "a",
 					LineNumber: 1,
+					Column:     1,
 				},
 				Token{
 					Type:       TTIdent,
 					Lexeme:     "b",
 					LineNumber: 2,
+					Column:     1,
 				},
 				Token{
 					Type:       TTIdent,
 					Lexeme:     "c",
 					LineNumber: 3,
+					Column:     1,
 				},
 			},
 		},
@@ -63,11 +69,13 @@ func Test_lex(t *testing.T) {
 					Type:       TTNumber,
 					Lexeme:     "12.5",
 					LineNumber: 1,
+					Column:     1,
 				},
 				Token{
 					Type:       TTNumber,
 					Lexeme:     "999",
 					LineNumber: 1,
+					Column:     6,
 				},
 			},
 		},
@@ -79,16 +87,19 @@ func Test_lex(t *testing.T) {
 					Type:       TTIdent,
 					Lexeme:     "x",
 					LineNumber: 1,
+					Column:     1,
 				},
 				Token{
 					Type:       TTIdent,
 					Lexeme:     "y",
 					LineNumber: 2,
+					Column:     1,
 				},
 				Token{
 					Type:       TTIdent,
 					Lexeme:     "z",
 					LineNumber: 3,
+					Column:     1,
 				},
 			},
 		},
@@ -100,11 +111,13 @@ func Test_lex(t *testing.T) {
 					Type:       TTString,
 					Lexeme:     "\"\"",
 					LineNumber: 1,
+					Column:     1,
 				},
 				Token{
 					Type:       TTString,
 					Lexeme:     "\"hepp\"",
 					LineNumber: 1,
+					Column:     4,
 				},
 			},
 		},
@@ -116,11 +129,13 @@ func Test_lex(t *testing.T) {
 					Type:       TTColor,
 					Lexeme:     "#1a2b3c",
 					LineNumber: 1,
+					Column:     1,
 				},
 				Token{
 					Type:       TTColor,
 					Lexeme:     "#1F2E3D:c0",
 					LineNumber: 1,
+					Column:     9,
 				},
 			},
 		},
@@ -132,26 +147,31 @@ func Test_lex(t *testing.T) {
 					Type:       TTNumber,
 					Lexeme:     "1",
 					LineNumber: 1,
+					Column:     1,
 				},
 				Token{
 					Type:       TTPipe,
 					Lexeme:     "|",
 					LineNumber: 1,
+					Column:     3,
 				},
 				Token{
 					Type:       TTDollar,
 					Lexeme:     "$",
 					LineNumber: 1,
+					Column:     5,
 				},
 				Token{
 					Type:       TTPlus,
 					Lexeme:     "+",
 					LineNumber: 1,
+					Column:     7,
 				},
 				Token{
 					Type:       TTNumber,
 					Lexeme:     "2",
 					LineNumber: 1,
+					Column:     9,
 				},
 			},
 		},
@@ -163,61 +183,85 @@ func Test_lex(t *testing.T) {
 					Type:       TTFor,
 					Lexeme:     "for",
 					LineNumber: 1,
+					Column:     1,
 				},
 				Token{
 					Type:       TTIdent,
 					Lexeme:     "pos",
 					LineNumber: 1,
+					Column:     5,
 				},
 				Token{
 					Type:       TTIn,
 					Lexeme:     "in",
 					LineNumber: 1,
+					Column:     9,
 				},
 				Token{
 					Type:       TTIdent,
 					Lexeme:     "IMAGE",
 					LineNumber: 1,
+					Column:     12,
 				},
 				Token{
 					Type:       TTLBrace,
 					Lexeme:     "{",
 					LineNumber: 1,
+					Column:     18,
 				},
 				Token{
 					Type:       TTAt,
 					Lexeme:     "@",
 					LineNumber: 2,
+					Column:     5,
 				},
 				Token{
 					Type:       TTIdent,
 					Lexeme:     "pos",
 					LineNumber: 2,
+					Column:     6,
 				},
 				Token{
 					Type:       TTEq,
 					Lexeme:     "=",
 					LineNumber: 2,
+					Column:     10,
 				},
 				Token{
 					Type:       TTMinus,
 					Lexeme:     "-",
 					LineNumber: 2,
+					Column:     12,
 				},
 				Token{
 					Type:       TTAt,
 					Lexeme:     "@",
 					LineNumber: 2,
+					Column:     13,
 				},
 				Token{
 					Type:       TTIdent,
 					Lexeme:     "pos",
 					LineNumber: 2,
+					Column:     14,
 				},
 				Token{
 					Type:       TTRBrace,
 					Lexeme:     "}",
 					LineNumber: 3,
+					Column:     1,
+				},
+			},
+		},
+		{
+			name: "comment_at_eof",
+			src:  "x // comment",
+			want: []Token{
+				Token{
+					Type:       TTIdent,
+					Lexeme:     "x",
+					LineNumber: 1,
+					Column:     1,
 				},
 			},
 		},
@@ -236,6 +280,35 @@ func Test_lex(t *testing.T) {
 	}
 }
 
+func Test_lex_errorPosition(t *testing.T) {
+	_, err := Lex("x := 1\n  y := ~")
+	lerr, ok := err.(*lang.Error)
+	if !ok {
+		t.Fatalf("Lex() error = %v, want *lang.Error", err)
+	}
+	if lerr.Line != 2 || lerr.Col != 8 {
+		t.Errorf("Lex() error at %d:%d, want 2:8", lerr.Line, lerr.Col)
+	}
+}
+
+func Test_lexWithComments(t *testing.T) {
+	tokens, comments, err := LexWithComments("// header\r\nx := 1 // one\n  //indented")
+	if err != nil {
+		t.Fatalf("LexWithComments() error = %v", err)
+	}
+	if len(tokens) != 3 {
+		t.Errorf("LexWithComments() returned %d tokens, want 3", len(tokens))
+	}
+	want := []Token{
+		{Type: TTComment, Lexeme: "// header", LineNumber: 1, Column: 1},
+		{Type: TTComment, Lexeme: "// one", LineNumber: 2, Column: 8},
+		{Type: TTComment, Lexeme: "//indented", LineNumber: 3, Column: 3},
+	}
+	if !reflect.DeepEqual(comments, want) {
+		t.Errorf("LexWithComments() comments = %v, want %v", comments, want)
+	}
+}
+
 func Test_token_parseColor(t *testing.T) {
 	tests := []struct {
 		name string
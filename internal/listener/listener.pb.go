@@ -30,18 +30,22 @@ const (
 	ProcessImageResponse_UNDEFINED ProcessImageResponse_CompilationResult = 0
 	ProcessImageResponse_OK        ProcessImageResponse_CompilationResult = 1
 	ProcessImageResponse_ERROR     ProcessImageResponse_CompilationResult = 2
+	// the script has been stopped because it exceeded an execution limit or the request has been cancelled
+	ProcessImageResponse_ABORTED ProcessImageResponse_CompilationResult = 3
 )
 
 var ProcessImageResponse_CompilationResult_name = map[int32]string{
 	0: "UNDEFINED",
 	1: "OK",
 	2: "ERROR",
+	3: "ABORTED",
 }
 
 var ProcessImageResponse_CompilationResult_value = map[string]int32{
 	"UNDEFINED": 0,
 	"OK":        1,
 	"ERROR":     2,
+	"ABORTED":   3,
 }
 
 func (x ProcessImageResponse_CompilationResult) String() string {
@@ -49,15 +53,32 @@ func (x ProcessImageResponse_CompilationResult) String() string {
 }
 
 func (ProcessImageResponse_CompilationResult) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_f75aade3a9f7de9c, []int{1, 0}
+	return fileDescriptor_f75aade3a9f7de9c, []int{2, 0}
 }
 
 type ProcessImageRequest struct {
-	SourceCode           string   `protobuf:"bytes,1,opt,name=sourceCode,proto3" json:"sourceCode,omitempty"`
-	ImageDataPng         []byte   `protobuf:"bytes,2,opt,name=imageDataPng,proto3" json:"imageDataPng,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	SourceCode string `protobuf:"bytes,1,opt,name=sourceCode,proto3" json:"sourceCode,omitempty"`
+	// the source image in any supported format, despite the name
+	ImageDataPng []byte `protobuf:"bytes,2,opt,name=imageDataPng,proto3" json:"imageDataPng,omitempty"`
+	// the modules that may be imported by sourceCode, keyed by slash-separated path
+	Modules map[string]string `protobuf:"bytes,3,rep,name=modules,proto3" json:"modules,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// a program compiled with 'ylang -compile'. if set, sourceCode and modules are ignored
+	CompiledProgram []byte `protobuf:"bytes,4,opt,name=compiledProgram,proto3" json:"compiledProgram,omitempty"`
+	// the values of the script parameters by name, parsed like the values of 'ylang -param'
+	Params map[string]string `protobuf:"bytes,5,rep,name=params,proto3" json:"params,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// the format of the target image: png, jpeg, gif, bmp, tiff or pfm. if set, the target image
+	// is returned in imageData of the response, otherwise as png in imageDataPng
+	Format string `protobuf:"bytes,6,opt,name=format,proto3" json:"format,omitempty"`
+	// the quality of jpeg target images from 1 to 100, 0 for the default
+	Quality int32 `protobuf:"varint,7,opt,name=quality,proto3" json:"quality,omitempty"`
+	// the channel depth of the target image: 8, 16 (png and tiff) or float (pfm), empty for the lowest depth of the format
+	Depth string `protobuf:"bytes,8,opt,name=depth,proto3" json:"depth,omitempty"`
+	// additional images, which scripts access by name through the Images constant. an image may be
+	// split across the messages of the request stream, the chunks of images with the same name are joined
+	Images               []*NamedImage `protobuf:"bytes,9,rep,name=images,proto3" json:"images,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
 }
 
 func (m *ProcessImageRequest) Reset()         { *m = ProcessImageRequest{} }
@@ -99,21 +120,123 @@ func (m *ProcessImageRequest) GetImageDataPng() []byte {
 	return nil
 }
 
+func (m *ProcessImageRequest) GetModules() map[string]string {
+	if m != nil {
+		return m.Modules
+	}
+	return nil
+}
+
+func (m *ProcessImageRequest) GetCompiledProgram() []byte {
+	if m != nil {
+		return m.CompiledProgram
+	}
+	return nil
+}
+
+func (m *ProcessImageRequest) GetParams() map[string]string {
+	if m != nil {
+		return m.Params
+	}
+	return nil
+}
+
+func (m *ProcessImageRequest) GetFormat() string {
+	if m != nil {
+		return m.Format
+	}
+	return ""
+}
+
+func (m *ProcessImageRequest) GetQuality() int32 {
+	if m != nil {
+		return m.Quality
+	}
+	return 0
+}
+
+func (m *ProcessImageRequest) GetDepth() string {
+	if m != nil {
+		return m.Depth
+	}
+	return ""
+}
+
+func (m *ProcessImageRequest) GetImages() []*NamedImage {
+	if m != nil {
+		return m.Images
+	}
+	return nil
+}
+
+type NamedImage struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// the image in any supported format
+	ImageData            []byte   `protobuf:"bytes,2,opt,name=imageData,proto3" json:"imageData,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *NamedImage) Reset()         { *m = NamedImage{} }
+func (m *NamedImage) String() string { return proto.CompactTextString(m) }
+func (*NamedImage) ProtoMessage()    {}
+func (*NamedImage) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f75aade3a9f7de9c, []int{1}
+}
+
+func (m *NamedImage) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_NamedImage.Unmarshal(m, b)
+}
+func (m *NamedImage) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_NamedImage.Marshal(b, m, deterministic)
+}
+func (m *NamedImage) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_NamedImage.Merge(m, src)
+}
+func (m *NamedImage) XXX_Size() int {
+	return xxx_messageInfo_NamedImage.Size(m)
+}
+func (m *NamedImage) XXX_DiscardUnknown() {
+	xxx_messageInfo_NamedImage.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_NamedImage proto.InternalMessageInfo
+
+func (m *NamedImage) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *NamedImage) GetImageData() []byte {
+	if m != nil {
+		return m.ImageData
+	}
+	return nil
+}
+
 type ProcessImageResponse struct {
-	Result               ProcessImageResponse_CompilationResult `protobuf:"varint,1,opt,name=result,proto3,enum=listener.ProcessImageResponse_CompilationResult" json:"result,omitempty"`
-	Message              string                                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
-	ImageDataPng         []byte                                 `protobuf:"bytes,3,opt,name=imageDataPng,proto3" json:"imageDataPng,omitempty"`
-	LogOutput            string                                 `protobuf:"bytes,4,opt,name=logOutput,proto3" json:"logOutput,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}                               `json:"-"`
-	XXX_unrecognized     []byte                                 `json:"-"`
-	XXX_sizecache        int32                                  `json:"-"`
+	Result  ProcessImageResponse_CompilationResult `protobuf:"varint,1,opt,name=result,proto3,enum=listener.ProcessImageResponse_CompilationResult" json:"result,omitempty"`
+	Message string                                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	// the target image as png if the request specifies no format
+	ImageDataPng []byte `protobuf:"bytes,3,opt,name=imageDataPng,proto3" json:"imageDataPng,omitempty"`
+	LogOutput    string `protobuf:"bytes,4,opt,name=logOutput,proto3" json:"logOutput,omitempty"`
+	// the format of imageData, as specified by the request
+	Format string `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`
+	// the target image in the format specified by the request
+	ImageData            []byte   `protobuf:"bytes,6,opt,name=imageData,proto3" json:"imageData,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *ProcessImageResponse) Reset()         { *m = ProcessImageResponse{} }
 func (m *ProcessImageResponse) String() string { return proto.CompactTextString(m) }
 func (*ProcessImageResponse) ProtoMessage()    {}
 func (*ProcessImageResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_f75aade3a9f7de9c, []int{1}
+	return fileDescriptor_f75aade3a9f7de9c, []int{2}
 }
 
 func (m *ProcessImageResponse) XXX_Unmarshal(b []byte) error {
@@ -162,35 +285,66 @@ func (m *ProcessImageResponse) GetLogOutput() string {
 	return ""
 }
 
+func (m *ProcessImageResponse) GetFormat() string {
+	if m != nil {
+		return m.Format
+	}
+	return ""
+}
+
+func (m *ProcessImageResponse) GetImageData() []byte {
+	if m != nil {
+		return m.ImageData
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("listener.ProcessImageResponse_CompilationResult", ProcessImageResponse_CompilationResult_name, ProcessImageResponse_CompilationResult_value)
 	proto.RegisterType((*ProcessImageRequest)(nil), "listener.ProcessImageRequest")
+	proto.RegisterMapType((map[string]string)(nil), "listener.ProcessImageRequest.ModulesEntry")
+	proto.RegisterMapType((map[string]string)(nil), "listener.ProcessImageRequest.ParamsEntry")
+	proto.RegisterType((*NamedImage)(nil), "listener.NamedImage")
 	proto.RegisterType((*ProcessImageResponse)(nil), "listener.ProcessImageResponse")
 }
 
 func init() { proto.RegisterFile("listener.proto", fileDescriptor_f75aade3a9f7de9c) }
 
 var fileDescriptor_f75aade3a9f7de9c = []byte{
-	// 304 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x91, 0xcf, 0x4e, 0xc2, 0x40,
-	0x10, 0xc6, 0x59, 0x54, 0x74, 0x47, 0x24, 0xb8, 0x7a, 0x68, 0x88, 0x12, 0xd2, 0x13, 0x5e, 0x1a,
-	0x82, 0xf1, 0x05, 0xf8, 0x63, 0x24, 0x1a, 0x20, 0x6b, 0x3c, 0x70, 0x73, 0xc5, 0xc9, 0xa6, 0xb1,
-	0xdd, 0xad, 0x9d, 0xed, 0xc1, 0xe7, 0xf6, 0x05, 0x0c, 0x1b, 0x41, 0x48, 0xd5, 0xe3, 0x7c, 0x99,
-	0x6f, 0xe6, 0xf7, 0xcd, 0x40, 0x23, 0x89, 0xc9, 0xa1, 0xc1, 0x3c, 0xca, 0x72, 0xeb, 0xac, 0x38,
-	0x5a, 0xd7, 0xe1, 0x02, 0xce, 0xe6, 0xb9, 0x5d, 0x22, 0xd1, 0x24, 0x55, 0x1a, 0x25, 0xbe, 0x17,
-	0x48, 0x4e, 0xb4, 0x01, 0xc8, 0x16, 0xf9, 0x12, 0x87, 0xf6, 0x15, 0x03, 0xd6, 0x61, 0x5d, 0x2e,
-	0xb7, 0x14, 0x11, 0x42, 0x3d, 0x5e, 0xf5, 0x8f, 0x94, 0x53, 0x73, 0xa3, 0x83, 0x6a, 0x87, 0x75,
-	0xeb, 0x72, 0x47, 0x0b, 0x3f, 0x19, 0x9c, 0xef, 0xce, 0xa6, 0xcc, 0x1a, 0x42, 0x71, 0x07, 0xb5,
-	0x1c, 0xa9, 0x48, 0x9c, 0x1f, 0xdc, 0xe8, 0xf7, 0xa2, 0x0d, 0xde, 0x6f, 0xfd, 0xd1, 0xd0, 0xa6,
-	0x59, 0x9c, 0x28, 0x17, 0x5b, 0x23, 0xbd, 0x4f, 0x7e, 0xfb, 0x45, 0x00, 0x87, 0x29, 0x12, 0x29,
-	0x8d, 0x9e, 0x80, 0xcb, 0x75, 0x59, 0x02, 0xdc, 0x2b, 0x03, 0x8a, 0x0b, 0xe0, 0x89, 0xd5, 0xb3,
-	0xc2, 0x65, 0x85, 0x0b, 0xf6, 0xbd, 0xff, 0x47, 0x08, 0x6f, 0xe0, 0xb4, 0xb4, 0x58, 0x9c, 0x00,
-	0x7f, 0x9a, 0x8e, 0xc6, 0xb7, 0x93, 0xe9, 0x78, 0xd4, 0xac, 0x88, 0x1a, 0x54, 0x67, 0xf7, 0x4d,
-	0x26, 0x38, 0x1c, 0x8c, 0xa5, 0x9c, 0xc9, 0x66, 0xb5, 0xff, 0x0c, 0xdc, 0xd3, 0xaf, 0x92, 0x88,
-	0x47, 0xa8, 0x6f, 0x27, 0x12, 0x97, 0x7f, 0x25, 0xf5, 0x57, 0x6f, 0xb5, 0xff, 0x3f, 0x44, 0x58,
-	0xe9, 0xb2, 0x1e, 0x1b, 0x5c, 0x41, 0xcb, 0xa0, 0x8b, 0x28, 0x55, 0xcb, 0x37, 0x4c, 0xa3, 0x8f,
-	0x44, 0x19, 0xbd, 0x31, 0x0e, 0x8e, 0x17, 0x0f, 0xca, 0xe8, 0xf9, 0xea, 0xcd, 0xf4, 0x52, 0xf3,
-	0xef, 0xbe, 0xfe, 0x0a, 0x00, 0x00, 0xff, 0xff, 0x7d, 0xb3, 0x5a, 0xda, 0x00, 0x02, 0x00, 0x00,
+	// 518 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0x4f, 0x6f, 0xd3, 0x4e,
+	0x10, 0xad, 0xe3, 0xc6, 0xa9, 0x27, 0xf9, 0xf5, 0x17, 0x86, 0x08, 0xad, 0xa2, 0x52, 0x45, 0x39,
+	0xb9, 0x08, 0x59, 0x55, 0xb8, 0x40, 0x0f, 0x48, 0x4d, 0x13, 0x44, 0x05, 0x24, 0xd1, 0x02, 0x07,
+	0x6e, 0x2c, 0xc9, 0x62, 0xa2, 0x7a, 0xbd, 0xee, 0xee, 0x1a, 0x29, 0x5f, 0x8c, 0xef, 0xc6, 0x0d,
+	0x79, 0x6d, 0xe7, 0x4f, 0x5b, 0x22, 0x71, 0xf3, 0x8c, 0xe6, 0xf9, 0xbd, 0x79, 0x6f, 0xb4, 0x70,
+	0x1c, 0x2f, 0xb5, 0xe1, 0x09, 0x57, 0x61, 0xaa, 0xa4, 0x91, 0x78, 0x54, 0xd5, 0xfd, 0xdf, 0x2e,
+	0x3c, 0x9e, 0x29, 0x39, 0xe7, 0x5a, 0x5f, 0x0b, 0x16, 0x71, 0xca, 0x6f, 0x33, 0xae, 0x0d, 0x9e,
+	0x02, 0x68, 0x99, 0xa9, 0x39, 0xbf, 0x92, 0x0b, 0x4e, 0x9c, 0x9e, 0x13, 0xf8, 0x74, 0xab, 0x83,
+	0x7d, 0x68, 0x2d, 0xf3, 0xf9, 0x11, 0x33, 0x6c, 0x96, 0x44, 0xa4, 0xd6, 0x73, 0x82, 0x16, 0xdd,
+	0xe9, 0xe1, 0x08, 0x1a, 0x42, 0x2e, 0xb2, 0x98, 0x6b, 0xe2, 0xf6, 0xdc, 0xa0, 0x39, 0x78, 0x16,
+	0xae, 0x75, 0x3c, 0xc0, 0x19, 0x7e, 0x28, 0x86, 0xc7, 0x89, 0x51, 0x2b, 0x5a, 0x41, 0x31, 0x80,
+	0xff, 0xe7, 0x52, 0xa4, 0xcb, 0x98, 0x2f, 0x66, 0x4a, 0x46, 0x8a, 0x09, 0x72, 0x68, 0xc9, 0xee,
+	0xb6, 0xf1, 0x12, 0xbc, 0x94, 0x29, 0x26, 0x34, 0xa9, 0x5b, 0xba, 0xb3, 0xfd, 0x74, 0x33, 0x3b,
+	0x5b, 0xb0, 0x95, 0x40, 0x7c, 0x02, 0xde, 0x77, 0xa9, 0x04, 0x33, 0xc4, 0xb3, 0x2b, 0x97, 0x15,
+	0x12, 0x68, 0xdc, 0x66, 0x2c, 0x5e, 0x9a, 0x15, 0x69, 0xf4, 0x9c, 0xa0, 0x4e, 0xab, 0x12, 0x3b,
+	0x50, 0x5f, 0xf0, 0xd4, 0xfc, 0x20, 0x47, 0x16, 0x50, 0x14, 0xf8, 0x1c, 0x3c, 0x6b, 0x85, 0x26,
+	0xbe, 0x95, 0xd2, 0xd9, 0x48, 0x99, 0x30, 0xc1, 0x17, 0x85, 0x90, 0x72, 0xa6, 0x7b, 0x01, 0xad,
+	0xed, 0xdd, 0xb1, 0x0d, 0xee, 0x0d, 0x5f, 0x95, 0xae, 0xe7, 0x9f, 0x39, 0xcb, 0x4f, 0x16, 0x67,
+	0xdc, 0xfa, 0xec, 0xd3, 0xa2, 0xb8, 0xa8, 0xbd, 0x74, 0xba, 0xaf, 0xa0, 0xb9, 0xb5, 0xc8, 0xbf,
+	0x40, 0xfb, 0xaf, 0x01, 0x36, 0x62, 0x10, 0xe1, 0x30, 0x61, 0xa2, 0xca, 0xda, 0x7e, 0xe3, 0x09,
+	0xf8, 0xeb, 0x44, 0xcb, 0x88, 0x37, 0x8d, 0xfe, 0xaf, 0x1a, 0x74, 0x76, 0x8d, 0xd5, 0xa9, 0x4c,
+	0x34, 0xc7, 0xb7, 0xe0, 0x29, 0xae, 0xb3, 0xd8, 0xd8, 0x9f, 0x1d, 0x0f, 0xce, 0xff, 0x16, 0x44,
+	0x31, 0x1f, 0x5e, 0xd9, 0x20, 0x99, 0x59, 0xca, 0x84, 0x5a, 0x1c, 0x2d, 0xf1, 0xb9, 0xef, 0x82,
+	0x6b, 0xcd, 0xa2, 0x4a, 0x7e, 0x55, 0xde, 0x3b, 0x40, 0xf7, 0x81, 0x03, 0x3c, 0x01, 0x3f, 0x96,
+	0xd1, 0x34, 0x33, 0x69, 0x66, 0xec, 0xd1, 0xf8, 0x74, 0xd3, 0xd8, 0xca, 0xba, 0xbe, 0x93, 0xf5,
+	0xce, 0xd2, 0xde, 0xdd, 0xa5, 0x87, 0xf0, 0xe8, 0x9e, 0x5c, 0xfc, 0x0f, 0xfc, 0xcf, 0x93, 0xd1,
+	0xf8, 0xcd, 0xf5, 0x64, 0x3c, 0x6a, 0x1f, 0xa0, 0x07, 0xb5, 0xe9, 0xbb, 0xb6, 0x83, 0x3e, 0xd4,
+	0xc7, 0x94, 0x4e, 0x69, 0xbb, 0x86, 0x4d, 0x68, 0x5c, 0x0e, 0xa7, 0xf4, 0xd3, 0x78, 0xd4, 0x76,
+	0x07, 0x5f, 0xc1, 0xb7, 0x06, 0xe4, 0x66, 0xe0, 0x47, 0x68, 0x6d, 0x9b, 0x82, 0x4f, 0xf7, 0x5e,
+	0x6d, 0xf7, 0x74, 0xbf, 0x97, 0xfd, 0x83, 0xc0, 0x39, 0x77, 0x86, 0x67, 0xd0, 0x4d, 0xb8, 0x09,
+	0xb5, 0x60, 0xf3, 0x1b, 0x2e, 0xc2, 0x55, 0xcc, 0x92, 0x68, 0x0d, 0x1c, 0x36, 0xbf, 0xbc, 0x67,
+	0x49, 0x34, 0xcb, 0x9f, 0x02, 0xfd, 0xcd, 0xb3, 0x4f, 0xc2, 0x8b, 0x3f, 0x03, 0x00, 0x5c, 0xd4,
+	0x53, 0x1f, 0x24, 0x04, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -0,0 +1,527 @@
+package lsp
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/parser"
+	"github.com/smackem/ylang/internal/program"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// document is a script opened in the editor. Positions in the document are
+// counted in runes, which equals the UTF-16 code units of the protocol for
+// all characters of the basic multilingual plane.
+type document struct {
+	uri    string
+	path   string // the file path of the document, passed to the module resolver
+	lines  []string
+	tokens []lexer.Token // nil if the text cannot be lexed
+	// the fields below are only set if the text compiles
+	program *parser.Program
+	refs    []reference                 // the identifiers referring to variables
+	decls   map[*parser.Var]lexer.Token // the identifiers declaring variables
+}
+
+// reference is an identifier that refers to a variable. variables captured from enclosing
+// functions are represented by the variable of the declaring function.
+type reference struct {
+	tok lexer.Token
+	v   *parser.Var
+}
+
+func newDocument(uri string, text string) *document {
+	doc := &document{
+		uri:   uri,
+		path:  uriToPath(uri),
+		lines: strings.Split(text, "\n"),
+	}
+	tokens, err := lexer.Lex(text)
+	if err != nil {
+		return doc
+	}
+	doc.tokens = tokens
+	prog, err := parser.Parse(tokens, false)
+	if err != nil {
+		return doc
+	}
+	if prog, err = interpreter.Resolve(prog); err != nil {
+		return doc
+	}
+	doc.program = &prog
+	doc.index()
+	return doc
+}
+
+// uriToPath returns the file path of a file URI or the uri itself for other schemes
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/") // /C:/dir/file.ylang
+	}
+	return filepath.FromSlash(path)
+}
+
+// index collects the references to and declarations of the variables of the program
+func (doc *document) index() {
+	doc.decls = make(map[*parser.Var]lexer.Token)
+	frames := []*parser.Frame{doc.program.Frame}
+	var opened []bool // for each node being inspected, whether it pushed a frame
+	ref := func(tok lexer.Token, v *parser.Var) {
+		for depth := len(frames) - 1; v != nil && v.Kind == parser.CaptureVar && depth > 0; depth-- {
+			v = frames[depth].Captures[v.Index]
+		}
+		if v != nil {
+			doc.refs = append(doc.refs, reference{tok: tok, v: v})
+		}
+	}
+	decl := func(tok lexer.Token, v *parser.Var) {
+		doc.decls[v] = tok
+		ref(tok, v)
+	}
+	parser.InspectStmts(doc.program.Stmts, func(node parser.Node) bool {
+		if node == nil {
+			if opened[len(opened)-1] {
+				frames = frames[:len(frames)-1]
+			}
+			opened = opened[:len(opened)-1]
+			return false
+		}
+		pushed := false
+		switch n := node.(type) {
+		case parser.DeclStmt:
+			decl(n.Token(), n.Var)
+		case parser.AssignStmt:
+			ref(n.Token(), n.Var)
+		case parser.IndexedAssignStmt:
+			ref(n.Token(), n.Var)
+		case parser.ForStmt:
+			doc.declLoopVar(n.Token(), n.Var, decl)
+		case parser.ParallelForStmt:
+			doc.declLoopVar(n.Token(), n.Var, decl)
+		case parser.ForRangeStmt:
+			doc.declLoopVar(n.Token(), n.Var, decl)
+		case parser.PipelineExpr:
+			decl(n.Token(), n.Var)
+		case parser.IdentExpr:
+			ref(n.Token(), n.Var)
+		case parser.InvokeExpr:
+			ref(n.Token(), n.Var)
+		case parser.FunctionExpr:
+			for i, tok := range doc.paramTokens(n.Token()) {
+				if i < len(n.Frame.Params) {
+					doc.decls[n.Frame.Params[i]] = tok
+					doc.refs = append(doc.refs, reference{tok: tok, v: n.Frame.Params[i]})
+				}
+			}
+			frames = append(frames, n.Frame)
+			pushed = true
+		}
+		opened = append(opened, pushed)
+		return true
+	})
+}
+
+// declLoopVar declares the loop variable of the loop statement starting with stmtTok
+func (doc *document) declLoopVar(stmtTok lexer.Token, v *parser.Var, decl func(lexer.Token, *parser.Var)) {
+	for i := doc.tokenIndex(stmtTok); i >= 0 && i < len(doc.tokens); i++ {
+		if doc.tokens[i].Type == lexer.TTIdent {
+			decl(doc.tokens[i], v)
+			return
+		}
+	}
+}
+
+// paramTokens returns the parameters of the function literal starting with fnTok
+func (doc *document) paramTokens(fnTok lexer.Token) []lexer.Token {
+	var params []lexer.Token
+	i := doc.tokenIndex(fnTok)
+	if i < 0 || i+1 >= len(doc.tokens) || doc.tokens[i+1].Type != lexer.TTLParen {
+		return nil
+	}
+	for i += 2; i < len(doc.tokens) && doc.tokens[i].Type != lexer.TTRParen; i++ {
+		if doc.tokens[i].Type == lexer.TTIdent {
+			params = append(params, doc.tokens[i])
+		}
+	}
+	return params
+}
+
+// tokenIndex returns the index of tok in doc.tokens or -1
+func (doc *document) tokenIndex(tok lexer.Token) int {
+	i := sort.Search(len(doc.tokens), func(i int) bool {
+		t := doc.tokens[i]
+		return t.LineNumber > tok.LineNumber || t.LineNumber == tok.LineNumber && t.Column >= tok.Column
+	})
+	if i < len(doc.tokens) && doc.tokens[i].LineNumber == tok.LineNumber && doc.tokens[i].Column == tok.Column {
+		return i
+	}
+	return -1
+}
+
+// tokenAt returns the index of the token at pos or -1
+func (doc *document) tokenAt(pos Position) int {
+	for i, tok := range doc.tokens {
+		if tok.LineNumber-1 == pos.Line && tok.Column-1 <= pos.Character && pos.Character < tok.Column-1+utf8.RuneCountInString(tok.Lexeme) {
+			return i
+		}
+	}
+	return -1
+}
+
+// tokenRange returns the range covered by tok
+func tokenRange(tok lexer.Token) Range {
+	start := Position{Line: tok.LineNumber - 1, Character: tok.Column - 1}
+	end := start
+	end.Character += utf8.RuneCountInString(tok.Lexeme)
+	return Range{Start: start, End: end}
+}
+
+func (doc *document) location(tok lexer.Token) *Location {
+	return &Location{URI: doc.uri, Range: tokenRange(tok)}
+}
+
+// diagnostics checks the document like `ylang check` does
+func (doc *document) diagnostics(resolver program.Resolver) []Diagnostic {
+	text := strings.Join(doc.lines, "\n")
+	diags := []Diagnostic{}
+	for _, d := range program.Check(doc.path, text, resolver) {
+		if d.File != doc.path && d.File != "" {
+			continue // reported for an imported module
+		}
+		r := Range{Start: Position{Line: d.Line - 1, Character: d.Col - 1}}
+		if d.Line <= 0 {
+			r.Start = Position{}
+		}
+		r.End = r.Start
+		r.End.Character++
+		if i := doc.tokenIndex(lexer.Token{LineNumber: d.Line, Column: d.Col}); i >= 0 {
+			r = tokenRange(doc.tokens[i])
+		}
+		diags = append(diags, Diagnostic{
+			Range:    r,
+			Severity: severities[d.Severity],
+			Code:     d.Code,
+			Source:   "ylang",
+			Message:  d.Msg,
+		})
+	}
+	return diags
+}
+
+// declaration returns the token declaring the variable referred to by the identifier at tokens[i]
+func (doc *document) declaration(i int) (lexer.Token, bool) {
+	tok := doc.tokens[i]
+	if doc.program != nil {
+		for _, ref := range doc.refs {
+			if ref.tok.LineNumber == tok.LineNumber && ref.tok.Column == tok.Column {
+				decl, ok := doc.decls[ref.v]
+				return decl, ok
+			}
+		}
+		return lexer.Token{}, false
+	}
+	// the text does not compile: look for the closest declaration with the same name, preferring preceding ones
+	var found []lexer.Token
+	for _, decl := range doc.declaredIdents(len(doc.tokens)) {
+		if decl.Lexeme == tok.Lexeme {
+			found = append(found, decl)
+		}
+	}
+	if len(found) == 0 {
+		return lexer.Token{}, false
+	}
+	closest := found[0]
+	for _, decl := range found[1:] {
+		if doc.tokenIndex(decl) <= i {
+			closest = decl
+		}
+	}
+	return closest, true
+}
+
+// declaredIdents returns the identifiers declared by the first n tokens, which are
+// variables, loop variables and function parameters.
+func (doc *document) declaredIdents(n int) []lexer.Token {
+	var idents []lexer.Token
+	for i := 0; i < n && i < len(doc.tokens); i++ {
+		tok := doc.tokens[i]
+		switch tok.Type {
+		case lexer.TTIdent:
+			if i+1 < len(doc.tokens) && doc.tokens[i+1].Type == lexer.TTColonEq {
+				idents = append(idents, tok)
+			}
+		case lexer.TTFor:
+			if i+1 < n && doc.tokens[i+1].Type == lexer.TTIdent {
+				idents = append(idents, doc.tokens[i+1])
+			}
+		case lexer.TTFn:
+			for _, param := range doc.paramTokens(tok) {
+				if doc.tokenIndex(param) < n {
+					idents = append(idents, param)
+				}
+			}
+		}
+	}
+	return idents
+}
+
+func (doc *document) definition(pos Position) *Location {
+	i := doc.tokenAt(pos)
+	if i < 0 || doc.tokens[i].Type != lexer.TTIdent {
+		return nil
+	}
+	if decl, ok := doc.declaration(i); ok {
+		return doc.location(decl)
+	}
+	return nil
+}
+
+func (doc *document) hover(pos Position) *Hover {
+	i := doc.tokenAt(pos)
+	if i < 0 || doc.tokens[i].Type != lexer.TTIdent {
+		return nil
+	}
+	tok := doc.tokens[i]
+	r := tokenRange(tok)
+	markdown := func(format string, args ...interface{}) *Hover {
+		return &Hover{Contents: MarkupContent{Kind: "markdown", Value: fmt.Sprintf(format, args...)}, Range: &r}
+	}
+	if i > 0 && doc.tokens[i-1].Type == lexer.TTDot {
+		if types := propertyTypes(tok.Lexeme); len(types) > 0 {
+			return markdown("property `%s` of %s", tok.Lexeme, strings.Join(types, ", "))
+		}
+		return nil
+	}
+	if decl, ok := doc.declaration(i); ok {
+		return markdown("```ylang\n%s\n```", strings.TrimSpace(doc.line(decl.LineNumber)))
+	}
+	if sigs := interpreter.FunctionSignatures(tok.Lexeme); sigs != nil {
+		return markdown("```ylang\n%s\n```\nbuiltin function", strings.Join(sigs, "\n"))
+	}
+	if doc, ok := constantDocs[tok.Lexeme]; ok {
+		return markdown("constant `%s` - %s", tok.Lexeme, doc)
+	}
+	return nil
+}
+
+// line returns the source line with the specified 1-based number
+func (doc *document) line(number int) string {
+	if number < 1 || number > len(doc.lines) {
+		return ""
+	}
+	return strings.TrimRight(doc.lines[number-1], "\r")
+}
+
+// constantDocs describes the constants predefined by the interpreter
+var constantDocs = map[string]string{
+	"Bounds":      "a rectangle containing the bounds of the input image",
+	"W":           "the width of the input image",
+	"H":           "the height of the input image",
+	"Images":      "a hashmap of the named images passed besides the input image, e.g. Images.background",
+	"Black":       "the color black #000000",
+	"White":       "the color white #ffffff",
+	"Transparent": "transparent white #ffffff:00",
+	"Pi":          "the mathematical constant pi in 32 bit resolution",
+	"Rad2Deg":     "the factor to convert radians into degrees",
+	"Deg2Rad":     "the factor to convert degrees to radians",
+}
+
+// propertyTypes returns the names of the types that have the property name
+func propertyTypes(name string) []string {
+	var types []string
+	for typeName, names := range interpreter.PropertyNames() {
+		for _, n := range names {
+			if n == name {
+				types = append(types, typeName)
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+func (doc *document) complete(pos Position, resolver program.Resolver) []CompletionItem {
+	line := []rune(doc.line(pos.Line + 1))
+	start := pos.Character
+	if start > len(line) {
+		start = len(line)
+	}
+	for start > 0 && isIdentRune(line[start-1]) {
+		start--
+	}
+	if start > 0 && line[start-1] == '.' {
+		recvrStart := start - 1
+		for recvrStart > 0 && isIdentRune(line[recvrStart-1]) {
+			recvrStart--
+		}
+		return doc.completeMember(string(line[recvrStart:start-1]), resolver)
+	}
+
+	items := []CompletionItem{}
+	seen := make(map[string]bool)
+	add := func(item CompletionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+	// declarations closer to the cursor shadow earlier ones
+	n := sort.Search(len(doc.tokens), func(i int) bool {
+		t := doc.tokens[i]
+		return t.LineNumber-1 > pos.Line || t.LineNumber-1 == pos.Line && t.Column-1 >= start
+	})
+	idents := doc.declaredIdents(n)
+	for i := len(idents) - 1; i >= 0; i-- {
+		add(CompletionItem{Label: idents[i].Lexeme, Kind: CompletionKindVariable, Detail: strings.TrimSpace(doc.line(idents[i].LineNumber))})
+	}
+	for _, name := range interpreter.ConstantNames() {
+		add(CompletionItem{Label: name, Kind: CompletionKindConstant, Detail: constantDocs[name]})
+	}
+	for _, name := range interpreter.FunctionNames() {
+		add(CompletionItem{Label: name, Kind: CompletionKindFunction, Detail: strings.Join(interpreter.FunctionSignatures(name), "\n")})
+	}
+	return items
+}
+
+// completeMember returns the members of the value of recvr: the top-level declarations
+// of an imported module or the properties of all types.
+func (doc *document) completeMember(recvr string, resolver program.Resolver) []CompletionItem {
+	items := []CompletionItem{}
+	if module := doc.importedModule(recvr, resolver); module != nil {
+		for _, stmt := range module.Stmts {
+			if decl, ok := stmt.(parser.DeclStmt); ok {
+				kind := CompletionKindVariable
+				if _, ok := decl.Rhs.(parser.FunctionExpr); ok {
+					kind = CompletionKindFunction
+				}
+				items = append(items, CompletionItem{Label: decl.Ident, Kind: kind, Detail: "module member"})
+			}
+		}
+		return items
+	}
+	properties := interpreter.PropertyNames()
+	var names []string
+	for _, typeNames := range properties {
+		for _, name := range typeNames {
+			if len(propertyTypes(name)) > 0 && !containsStr(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		items = append(items, CompletionItem{Label: name, Kind: CompletionKindProperty, Detail: strings.Join(propertyTypes(name), ", ")})
+	}
+	return items
+}
+
+// importedModule parses the module that is imported into the variable ident
+// with a declaration like `ident := import "path"`. returns nil if there is none.
+func (doc *document) importedModule(ident string, resolver program.Resolver) *parser.Program {
+	if resolver == nil {
+		return nil
+	}
+	for i := 0; i+3 < len(doc.tokens); i++ {
+		if doc.tokens[i].Lexeme != ident || doc.tokens[i+1].Type != lexer.TTColonEq ||
+			doc.tokens[i+2].Type != lexer.TTImport || doc.tokens[i+3].Type != lexer.TTString {
+			continue
+		}
+		name, err := resolver.Resolve(doc.path, doc.tokens[i+3].ParseString())
+		if err != nil {
+			return nil
+		}
+		src, err := resolver.Load(name)
+		if err != nil {
+			return nil
+		}
+		tokens, err := lexer.Lex(src)
+		if err != nil {
+			return nil
+		}
+		module, err := parser.Parse(tokens, false)
+		if err != nil {
+			return nil
+		}
+		return &module
+	}
+	return nil
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func containsStr(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// symbols returns the top-level declarations of the document and the declarations
+// in the functions they hold. Returns no symbols if the document does not compile.
+func (doc *document) symbols() []DocumentSymbol {
+	if doc.program == nil {
+		return []DocumentSymbol{}
+	}
+	end := lexer.Token{LineNumber: len(doc.lines), Column: len([]rune(doc.line(len(doc.lines)))) + 1}
+	return doc.stmtSymbols(doc.program.Stmts, end)
+}
+
+// stmtSymbols returns the symbols declared by stmts, which end before the token end
+func (doc *document) stmtSymbols(stmts []parser.Statement, end lexer.Token) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	for i, stmt := range stmts {
+		decl, ok := stmt.(parser.DeclStmt)
+		if !ok {
+			continue
+		}
+		stmtEnd := end
+		if i+1 < len(stmts) {
+			stmtEnd = stmts[i+1].Token()
+		}
+		symbol := DocumentSymbol{
+			Name:           decl.Ident,
+			Kind:           SymbolKindVariable,
+			Range:          Range{Start: tokenRange(decl.Token()).Start, End: doc.endBefore(stmtEnd)},
+			SelectionRange: tokenRange(decl.Token()),
+		}
+		if unicode.IsUpper([]rune(decl.Ident)[0]) {
+			symbol.Kind = SymbolKindConstant
+		}
+		if fn, ok := decl.Rhs.(parser.FunctionExpr); ok {
+			symbol.Kind = SymbolKindFunction
+			symbol.Detail = "fn(" + strings.Join(fn.ParameterNames, ", ") + ")"
+			symbol.Children = doc.stmtSymbols(fn.Body, stmtEnd)
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// endBefore returns the end of the last token preceding tok
+func (doc *document) endBefore(tok lexer.Token) Position {
+	i := doc.tokenIndex(tok)
+	if i < 0 {
+		i = len(doc.tokens)
+	}
+	for i--; i >= 0; i-- {
+		if doc.tokens[i].Type != lexer.TTEOF {
+			return tokenRange(doc.tokens[i]).End
+		}
+	}
+	return Position{}
+}
@@ -0,0 +1,219 @@
+package lsp
+
+import (
+	"github.com/smackem/ylang/internal/program"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSrc = `m := import "lib.ylang"
+x := 1
+f := fn(a, b) {
+    y := a + x
+    return y * b
+}
+for p in Bounds {
+    @p = f(p.x, 2)
+}
+`
+
+var testModules = program.BundleResolver{
+	"lib.ylang": `twice := fn(v) -> v * 2
+Answer := 42`,
+}
+
+func Test_document_definition(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		pos  Position
+		want *Location
+	}{
+		{
+			name: "global",
+			src:  testSrc,
+			pos:  Position{Line: 3, Character: 13},
+			want: &Location{URI: "file:///test.ylang", Range: Range{Start: Position{1, 0}, End: Position{1, 1}}},
+		},
+		{
+			name: "parameter",
+			src:  testSrc,
+			pos:  Position{Line: 4, Character: 15},
+			want: &Location{URI: "file:///test.ylang", Range: Range{Start: Position{2, 11}, End: Position{2, 12}}},
+		},
+		{
+			name: "loop_variable",
+			src:  testSrc,
+			pos:  Position{Line: 7, Character: 11},
+			want: &Location{URI: "file:///test.ylang", Range: Range{Start: Position{6, 4}, End: Position{6, 5}}},
+		},
+		{
+			name: "shadowing",
+			src: `v := 1
+f := fn() {
+    v := 2
+    return v
+}`,
+			pos:  Position{Line: 3, Character: 11},
+			want: &Location{URI: "file:///test.ylang", Range: Range{Start: Position{2, 4}, End: Position{2, 5}}},
+		},
+		{
+			name: "not_compiling",
+			src: `v := 1
+log(v +)`,
+			pos:  Position{Line: 1, Character: 4},
+			want: &Location{URI: "file:///test.ylang", Range: Range{Start: Position{0, 0}, End: Position{0, 1}}},
+		},
+		{
+			name: "builtin",
+			src:  testSrc,
+			pos:  Position{Line: 6, Character: 10},
+			want: nil,
+		},
+		{
+			name: "no_ident",
+			src:  testSrc,
+			pos:  Position{Line: 1, Character: 2},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := newDocument("file:///test.ylang", tt.src)
+			if got := doc.definition(tt.pos); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("definition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_document_hover(t *testing.T) {
+	tests := []struct {
+		name string
+		pos  Position
+		want string
+	}{
+		{
+			name: "variable",
+			pos:  Position{Line: 3, Character: 13},
+			want: "```ylang\nx := 1\n```",
+		},
+		{
+			name: "constant",
+			pos:  Position{Line: 6, Character: 11},
+			want: "constant `Bounds` - a rectangle containing the bounds of the input image",
+		},
+		{
+			name: "property",
+			pos:  Position{Line: 7, Character: 13},
+			want: "property `x` of point, rect",
+		},
+		{
+			name: "keyword",
+			pos:  Position{Line: 6, Character: 0},
+			want: "",
+		},
+	}
+	doc := newDocument("file:///test.ylang", testSrc+"log(rgb(1))")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if hover := doc.hover(tt.pos); hover != nil {
+				got = hover.Contents.Value
+			}
+			if got != tt.want {
+				t.Errorf("hover() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	t.Run("builtin", func(t *testing.T) {
+		hover := doc.hover(Position{Line: 9, Character: 5})
+		if hover == nil || !strings.Contains(hover.Contents.Value, "fn rgb(Number)") {
+			t.Errorf("hover() = %v, want signatures of rgb", hover)
+		}
+	})
+}
+
+func Test_document_complete(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		pos     Position
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "variables_in_scope",
+			src:     testSrc,
+			pos:     Position{Line: 4, Character: 11},
+			want:    []string{"y", "a", "b", "f", "x", "m", "Bounds", "rgb"},
+			notWant: []string{"p"},
+		},
+		{
+			name: "module_members",
+			src:  testSrc + "m.",
+			pos:  Position{Line: 9, Character: 2},
+			want: []string{"twice", "Answer"},
+		},
+		{
+			name:    "properties",
+			src:     testSrc + "x.",
+			pos:     Position{Line: 9, Character: 2},
+			want:    []string{"x", "y", "r", "bounds"},
+			notWant: []string{"rgb", "m"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := newDocument("file:///test.ylang", tt.src)
+			labels := make(map[string]bool)
+			for _, item := range doc.complete(tt.pos, testModules) {
+				labels[item.Label] = true
+			}
+			for _, label := range tt.want {
+				if !labels[label] {
+					t.Errorf("complete() lacks %s", label)
+				}
+			}
+			for _, label := range tt.notWant {
+				if labels[label] {
+					t.Errorf("complete() contains %s", label)
+				}
+			}
+		})
+	}
+}
+
+func Test_document_symbols(t *testing.T) {
+	doc := newDocument("file:///test.ylang", testSrc)
+	var names []string
+	for _, sym := range doc.symbols() {
+		names = append(names, sym.Name)
+		for _, child := range sym.Children {
+			names = append(names, sym.Name+"."+child.Name)
+		}
+	}
+	if want := []string{"m", "x", "f", "f.y"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("symbols() = %v, want %v", names, want)
+	}
+	if got := doc.symbols()[2].Range; got != (Range{Start: Position{2, 0}, End: Position{5, 1}}) {
+		t.Errorf("range of f = %v", got)
+	}
+}
+
+func Test_document_diagnostics(t *testing.T) {
+	doc := newDocument("file:///test.ylang", "m := import \"lib.ylang\"\nc := rgb(1, 2)\nlog(c, m)")
+	want := []Diagnostic{
+		{
+			Range:    Range{Start: Position{1, 5}, End: Position{1, 8}},
+			Severity: SeverityError,
+			Code:     "arity",
+			Source:   "ylang",
+			Message:  "wrong number of arguments for 'rgb': expected 1 or 3, got 2",
+		},
+	}
+	if got := doc.diagnostics(testModules); !reflect.DeepEqual(got, want) {
+		t.Errorf("diagnostics() = %v, want %v", got, want)
+	}
+}
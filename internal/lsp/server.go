@@ -0,0 +1,180 @@
+// Package lsp implements a Language Server Protocol server for ylang scripts,
+// providing diagnostics, hover, completion, go-to-definition and document symbols.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/program"
+	"io"
+)
+
+// Server is a language server communicating over a pair of streams like stdin and stdout.
+// Requests are handled one after another in the order they arrive.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	resolver program.Resolver
+	docs     map[string]*document // the open documents by URI
+	shutdown bool
+}
+
+// NewServer returns a Server that reads requests from in and writes responses to out.
+// Modules imported by the checked scripts are loaded with resolver.
+func NewServer(in io.Reader, out io.Writer, resolver program.Resolver) *Server {
+	return &Server{
+		in:       bufio.NewReader(in),
+		out:      out,
+		resolver: resolver,
+		docs:     make(map[string]*document),
+	}
+}
+
+// Serve handles requests until the client sends the exit notification or closes the input stream.
+func (s *Server) Serve() error {
+	for {
+		content, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			if err := writeMessage(s.out, newResponse(nil, nil, &responseError{Code: codeParseError, Message: err.Error()})); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handle(req)
+		if req.ID == nil { // notification
+			continue
+		}
+		if err := writeMessage(s.out, newResponse(req.ID, result, rerr)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(req request) (interface{}, *responseError) {
+	if s.shutdown && req.ID != nil {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server has been shut down"}
+	}
+	switch req.Method {
+	case "initialize":
+		return InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:       1,
+				HoverProvider:          true,
+				CompletionProvider:     CompletionOptions{TriggerCharacters: []string{"."}},
+				DefinitionProvider:     true,
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: ServerInfo{Name: "ylang"},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			return nil, s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.publish(params.TextDocument.URI, []Diagnostic{})
+
+	case "textDocument/hover":
+		doc, pos, rerr := s.position(req.Params)
+		if doc == nil {
+			return nil, rerr
+		}
+		return doc.hover(pos), nil
+
+	case "textDocument/completion":
+		doc, pos, rerr := s.position(req.Params)
+		if doc == nil {
+			return nil, rerr
+		}
+		return doc.complete(pos, s.resolver), nil
+
+	case "textDocument/definition":
+		doc, pos, rerr := s.position(req.Params)
+		if doc == nil {
+			return nil, rerr
+		}
+		return doc.definition(pos), nil
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return []DocumentSymbol{}, nil
+		}
+		return doc.symbols(), nil
+	}
+	if req.ID != nil {
+		return nil, &responseError{Code: codeMethodNotFound, Message: "method '" + req.Method + "' not supported"}
+	}
+	return nil, nil // unsupported notifications like initialized or $/cancelRequest are ignored
+}
+
+// position decodes the parameters of a request for a position in an open document.
+// returns nil if the document is not open.
+func (s *Server) position(params json.RawMessage) (*document, Position, *responseError) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, Position{}, invalidParams(err)
+	}
+	return s.docs[p.TextDocument.URI], p.Position, nil
+}
+
+// update analyzes the new text of the document at uri and publishes its diagnostics
+func (s *Server) update(uri string, text string) *responseError {
+	doc := newDocument(uri, text)
+	s.docs[uri] = doc
+	return s.publish(uri, doc.diagnostics(s.resolver))
+}
+
+func (s *Server) publish(uri string, diags []Diagnostic) *responseError {
+	if err := writeMessage(s.out, newNotification("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diags})); err != nil {
+		return &responseError{Code: codeInvalidRequest, Message: err.Error()}
+	}
+	return nil
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
+
+// severities maps the severity of ylang diagnostics to the LSP DiagnosticSeverity
+var severities = map[lang.Severity]int{
+	lang.SeverityError:   SeverityError,
+	lang.SeverityWarning: SeverityWarning,
+}
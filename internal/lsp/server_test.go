@@ -0,0 +1,73 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestServer_Serve(t *testing.T) {
+	const uri = "file:///test.ylang"
+	var in bytes.Buffer
+	for _, msg := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"` + uri + `","languageId":"ylang","version":1,"text":"x := rgb(1, 2)"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/definition","params":{"textDocument":{"uri":"` + uri + `"},"position":{"line":0,"character":5}}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"` + uri + `"},"contentChanges":[{"text":"x := 1\nlog(x)"}]}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/definition","params":{"textDocument":{"uri":"` + uri + `"},"position":{"line":1,"character":4}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/formatting","params":{}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+	} {
+		_, _ = fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	var out bytes.Buffer
+	if err := NewServer(&in, &out, nil).Serve(); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+
+	var got []string
+	r := bufio.NewReader(&out)
+	for {
+		content, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		var msg struct {
+			ID     *int
+			Method string
+			Result json.RawMessage
+			Error  *responseError
+		}
+		if err := json.Unmarshal(content, &msg); err != nil {
+			t.Fatalf("invalid message %s: %v", content, err)
+		}
+		switch {
+		case msg.Method != "":
+			got = append(got, msg.Method)
+		case msg.Error != nil:
+			got = append(got, fmt.Sprintf("%d: error %d", *msg.ID, msg.Error.Code))
+		case *msg.ID == 1:
+			got = append(got, "1: initialized")
+		default:
+			got = append(got, fmt.Sprintf("%d: %s", *msg.ID, msg.Result))
+		}
+	}
+	want := []string{
+		"1: initialized",
+		"textDocument/publishDiagnostics",
+		"2: null",
+		"textDocument/publishDiagnostics",
+		`3: {"uri":"file:///test.ylang","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}}}`,
+		fmt.Sprintf("4: error %d", codeMethodNotFound),
+		"5: null",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Serve() wrote\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
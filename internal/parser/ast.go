@@ -1,6 +1,9 @@
 package parser
 
-import "github.com/smackem/ylang/internal/lexer"
+import (
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+)
 
 type astNode interface {
 }
@@ -12,11 +15,21 @@ type Statement interface {
 
 type Expression interface {
 	astNode
+	Token() lexer.Token
 }
 
 // Program is the complete, parsed ylang program.
 type Program struct {
-	Stmts []Statement
+	Stmts   []Statement
+	Imports []ImportExpr // all import expressions in order of appearance
+	Params  []DeclStmt   // all parameter declarations in order of appearance
+	// the fields below are filled by program.Compile
+	File        string             // the canonical name of the module, empty for the main script
+	ImportNames map[string]string  // maps the import paths of Imports to canonical module names
+	Modules     map[string]Program // all transitively imported modules by canonical name, only set on the main script
+	// the fields below are filled by Resolve
+	Frame   *Frame   // the variables of the top-level code, nil if the program has not been resolved
+	Globals []string // the names of the top-level declarations by index
 }
 
 //////////////////////////////////////////////// statements
@@ -33,12 +46,15 @@ type DeclStmt struct {
 	StmtBase
 	Ident string
 	Rhs   Expression
+	Param bool // true if the declaration is a script parameter, whose value may be passed by the host
+	Var   *Var
 }
 
 type AssignStmt struct {
 	StmtBase
 	Ident string
 	Rhs   Expression
+	Var   *Var
 }
 
 type IndexedAssignStmt struct {
@@ -46,6 +62,7 @@ type IndexedAssignStmt struct {
 	Ident string
 	Index Expression
 	Rhs   Expression
+	Var   *Var
 }
 
 type PixelAssignStmt struct {
@@ -71,6 +88,21 @@ type ForStmt struct {
 	Ident      string
 	Collection Expression
 	Stmts      []Statement
+	Var        *Var // the loop variable
+}
+
+// ParallelForStmt is a for loop whose iterations are distributed across multiple workers
+type ParallelForStmt struct {
+	ForStmt
+	Reductions []Reduction
+}
+
+// Reduction is a variable modified by a parallel for loop. each worker modifies a private copy
+// of the variable, the copies are merged when the loop has finished.
+type Reduction struct {
+	Ident string
+	Merge Expression // the function merging two copies, nil to merge with +
+	Var   *Var
 }
 
 type ForRangeStmt struct {
@@ -80,6 +112,7 @@ type ForRangeStmt struct {
 	Upper Expression
 	Step  Expression
 	Stmts []Statement
+	Var   *Var // the loop variable
 }
 
 type WhileStmt struct {
@@ -103,20 +136,46 @@ type ReturnStmt struct {
 	Result Expression
 }
 
+type BreakStmt struct {
+	StmtBase
+}
+
+type ContinueStmt struct {
+	StmtBase
+}
+
 //////////////////////////////////////////////// expressions
 
+// ExprBase holds the token an expression starts with - or, for binary expressions, the operator token.
+type ExprBase struct {
+	tok lexer.Token
+}
+
+func (expr ExprBase) Token() lexer.Token {
+	return expr.tok
+}
+
 type TernaryExpr struct {
+	ExprBase
 	Cond        Expression
 	TrueResult  Expression
 	FalseResult Expression
 }
 
 type BinaryExpr struct {
+	ExprBase
 	Left  Expression
 	Right Expression
 }
 
-type PipelineExpr BinaryExpr
+// PipelineExpr evaluates Right with the pipeline value '$' bound to the result of Left
+type PipelineExpr struct {
+	ExprBase
+	Left  Expression
+	Right Expression
+	Var   *Var // the variable holding the pipeline value
+}
+
 type OrExpr BinaryExpr
 type AndExpr BinaryExpr
 type EqExpr BinaryExpr
@@ -136,6 +195,7 @@ type ModExpr BinaryExpr
 type InExpr BinaryExpr
 
 type UnaryExpr struct {
+	ExprBase
 	Inner Expression
 }
 
@@ -143,44 +203,103 @@ type NegExpr UnaryExpr
 type NotExpr UnaryExpr
 
 type PosExpr struct {
+	ExprBase
 	X Expression
 	Y Expression
 }
 
 type MemberExpr struct {
+	ExprBase
 	Recvr  Expression
 	Member string
 }
 
 type IndexExpr struct {
+	ExprBase
 	Recvr Expression
 	Index Expression
 }
 
 type IndexRangeExpr struct {
+	ExprBase
 	Recvr Expression
 	Lower Expression
 	Upper Expression
 }
 
-type IdentExpr string
+type IdentExpr struct {
+	ExprBase
+	Ident string
+	Var   *Var
+}
+
 type AtExpr UnaryExpr
 
+// SampleExpr reads the pixel at Point of the input image Image evaluates to, e.g. img@p
+type SampleExpr struct {
+	ExprBase
+	Image Expression
+	Point Expression
+}
+
+type NumberExpr struct {
+	ExprBase
+	Value lang.Number
+}
+
+type StrExpr struct {
+	ExprBase
+	Value lang.Str
+}
+
+type BoolExpr struct {
+	ExprBase
+	Value lang.Boolean
+}
+
+type ColorExpr struct {
+	ExprBase
+	Value lang.Color
+}
+
+type NilExpr struct {
+	ExprBase
+}
+
 type InvokeExpr struct {
+	ExprBase
 	FuncName string
 	Args     []Expression
+	Var      *Var // the variable holding the invoked function, nil for builtin functions
+}
+
+// CallExpr invokes the function an arbitrary expression evaluates to, e.g. module.function(x)
+type CallExpr struct {
+	ExprBase
+	Callee Expression
+	Args   []Expression
+}
+
+type ImportExpr struct {
+	ExprBase
+	Path string
 }
 
 type KernelExpr struct {
+	ExprBase
 	Elements []Expression
 }
 
 type FunctionExpr struct {
+	ExprBase
 	ParameterNames []string
 	Body           []Statement
+	IsGenerator    bool   // true if Body contains a yield statement
+	Frame          *Frame // the variables of the function, filled by Resolve
 }
 
 type HashMapExpr struct {
+	ExprBase
 	Entries []HashEntryExpr
 }
 
@@ -190,5 +309,35 @@ type HashEntryExpr struct {
 }
 
 type ListExpr struct {
+	ExprBase
 	Elements []Expression
 }
+
+//////////////////////////////////////////////// variables
+
+// VarKind tells where the value of a variable is stored at runtime
+type VarKind int
+
+const (
+	UnresolvedVar VarKind = iota
+	LocalVar              // a slot in the frame of the executing function
+	CellVar               // a cell of the executing function, shared with the closures capturing the variable
+	CaptureVar            // a cell of an enclosing function captured by the executing function
+	GlobalVar             // a top-level declaration of the module
+	ConstantVar           // a constant predefined by the interpreter
+)
+
+// Var is the storage location of a variable, assigned by Resolve.
+// Within a function, all nodes referring to the same variable share the same Var.
+type Var struct {
+	Kind  VarKind
+	Index int // the index of the slot, cell, capture, global or constant
+}
+
+// Frame describes the variables of a function or the top-level code of a module
+type Frame struct {
+	Size     int    // the number of local slots
+	Cells    int    // the number of cells
+	Params   []*Var // the parameters, which are passed in the first local slots
+	Captures []*Var // the captured variables by capture index, as seen by the enclosing function
+}
@@ -6,22 +6,68 @@ import (
 	"github.com/smackem/ylang/internal/lexer"
 	"math"
 	"unicode"
+	"unicode/utf8"
 )
 
 func Parse(input []lexer.Token, omitTokenInfo bool) (Program, error) {
 	parser := parser{input: input, index: 0, omitTokenInfo: omitTokenInfo}
-	program, err := parser.parseProgram()
+	stmts, err := parser.parseProgram()
 	if err != nil {
+		if _, ok := err.(*lang.Error); ok { // already positioned by errorAt
+			return Program{}, err
+		}
 		tok := parser.current()
-		return Program{nil}, fmt.Errorf("at line %d, near '%s': %s", tok.LineNumber, tok.Lexeme, err)
+		if tok.Type == lexer.TTEOF && len(input) > 0 {
+			tok = input[len(input)-1] // point at the last token instead of nowhere
+		}
+		return Program{}, &lang.Error{
+			Line: tok.LineNumber,
+			Col:  tok.Column,
+			Msg:  fmt.Sprintf("near '%s': %s", tok.Lexeme, err),
+		}
+	}
+	return Program{Stmts: stmts, Imports: parser.imports, Params: parser.params}, nil
+}
+
+// ParseInput parses the input of an interactive session, which is a statement list
+// optionally followed by an expression. The expression becomes an InvocationStmt,
+// whose value is the result of the input.
+func ParseInput(input []lexer.Token) (Program, error) {
+	program, err := Parse(input, false)
+	if err == nil {
+		return program, nil
+	}
+	// find the statements preceding the trailing expression
+	p := parser{input: input, index: 0}
+	var stmts []Statement
+	for p.current().Type != lexer.TTEOF {
+		start := p.index
+		stmt, stmtErr := p.parseStmt()
+		if stmtErr != nil {
+			p.index = start
+			break
+		}
+		stmts = append(stmts, stmt)
 	}
-	return Program{program}, nil
+	p.stmtStart = p.current()
+	expr, exprErr := p.parseExpr()
+	if exprErr != nil || p.current().Type != lexer.TTEOF {
+		return Program{}, err // report the error of the statement parser
+	}
+	stmts = append(stmts, InvocationStmt{p.makeStmtBase(), expr})
+	return Program{Stmts: stmts, Imports: p.imports, Params: p.params}, nil
 }
 
 type parser struct {
 	input         []lexer.Token
 	index         int
 	omitTokenInfo bool
+	stmtStart     lexer.Token // the first token of the statement being parsed
+	generators    []bool      // one entry per enclosing function, true if the function contains yield
+	loopDepth     int         // the number of loops enclosing the current statement within the current function
+	imports       []ImportExpr
+	params        []DeclStmt
+	inKernel      bool // true while parsing the elements of a kernel literal, where '(' starts a new element
 }
 
 func (p parser) current() lexer.Token {
@@ -31,6 +77,13 @@ func (p parser) current() lexer.Token {
 	return p.input[p.index]
 }
 
+func (p parser) previous() lexer.Token {
+	if p.index <= 0 || p.index > len(p.input) {
+		return lexer.EmptyToken
+	}
+	return p.input[p.index-1]
+}
+
 func (p *parser) next() lexer.Token {
 	tok := p.current()
 	p.index++
@@ -53,11 +106,27 @@ func (p *parser) expect(tt lexer.TokenType) (lexer.Token, error) {
 	return tok, nil
 }
 
+// errorAt returns an error positioned at tok for errors detected after tok has been consumed
+func (p *parser) errorAt(tok lexer.Token, format string, args ...interface{}) error {
+	return &lang.Error{
+		Line: tok.LineNumber,
+		Col:  tok.Column,
+		Msg:  fmt.Sprintf("near '%s': %s", tok.Lexeme, fmt.Sprintf(format, args...)),
+	}
+}
+
 func (p *parser) makeStmtBase() StmtBase {
 	if p.omitTokenInfo {
 		return StmtBase{}
 	}
-	return StmtBase{p.current()}
+	return StmtBase{p.stmtStart}
+}
+
+func (p *parser) makeExprBase(tok lexer.Token) ExprBase {
+	if p.omitTokenInfo {
+		return ExprBase{}
+	}
+	return ExprBase{tok}
 }
 
 func (p *parser) parseProgram() (stmts []Statement, err error) {
@@ -85,16 +154,22 @@ func (p *parser) parseStmtList(terminator lexer.TokenType) (stmts []Statement, e
 }
 
 func (p *parser) parseStmt() (stmt Statement, err error) {
+	outerStmtStart := p.stmtStart
+	defer func() { p.stmtStart = outerStmtStart }()
+	p.stmtStart = p.current()
+
 	tok := p.next()
 	switch tok.Type {
 	case lexer.TTIdent:
-		stmt, err = p.parseIdentStmt(tok.Lexeme)
+		stmt, err = p.parseIdentStmt(tok)
 	case lexer.TTAt:
 		stmt, err = p.parsePixelAssign()
 	case lexer.TTIf:
 		stmt, err = p.parseIf()
 	case lexer.TTFor:
 		stmt, err = p.parseFor()
+	case lexer.TTParallel:
+		stmt, err = p.parseParallelFor()
 	case lexer.TTWhile:
 		stmt, err = p.parseWhile()
 	case lexer.TTYield:
@@ -103,13 +178,20 @@ func (p *parser) parseStmt() (stmt Statement, err error) {
 		stmt, err = p.parseLog()
 	case lexer.TTReturn:
 		stmt, err = p.parseReturn()
+	case lexer.TTBreak:
+		stmt, err = p.parseBreak()
+	case lexer.TTContinue:
+		stmt, err = p.parseContinue()
+	case lexer.TTParam:
+		stmt, err = p.parseParam()
 	default:
 		stmt, err = nil, fmt.Errorf("unexpected Token at statement begin: '%s'", tok)
 	}
 	return
 }
 
-func (p *parser) parseIdentStmt(ident string) (Statement, error) {
+func (p *parser) parseIdentStmt(identTok lexer.Token) (Statement, error) {
+	ident := identTok.Lexeme
 	tok := p.next()
 	switch tok.Type {
 	case lexer.TTColonEq:
@@ -119,25 +201,56 @@ func (p *parser) parseIdentStmt(ident string) (Statement, error) {
 	case lexer.TTLBracket:
 		return p.parseIndexedAssign(ident)
 	case lexer.TTLParen:
-		return p.parseInvocation(ident)
+		return p.parseInvocation(identTok)
+	case lexer.TTDot:
+		p.index -= 2 // re-parse as expression, e.g. module.function(x)
+		return p.parseCallStmt()
 	}
 	return nil, fmt.Errorf("unexpected Token '%s' - expected %s or %s", tok, lexer.TokenTypeName(lexer.TTColonEq), lexer.TokenTypeName(lexer.TTEq))
 }
 
-func (p *parser) parseInvocation(ident string) (Statement, error) {
-	invocation, err := p.parseInvocationAtom(ident)
+func (p *parser) parseInvocation(identTok lexer.Token) (Statement, error) {
+	invocation, err := p.parseInvocationAtom(identTok)
 	if err != nil {
 		return nil, err
 	}
 	return InvocationStmt{p.makeStmtBase(), invocation}, nil
 }
 
+func (p *parser) parseCallStmt() (Statement, error) {
+	expr, err := p.parseMoleculeExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := expr.(CallExpr); !ok {
+		return nil, fmt.Errorf("expression used as statement must be an invocation")
+	}
+	return InvocationStmt{p.makeStmtBase(), expr}, nil
+}
+
 func (p *parser) parseDeclaration(ident string) (Statement, error) {
 	rhs, err := p.parseExpr()
 	if err != nil {
 		return nil, err
 	}
-	return DeclStmt{p.makeStmtBase(), ident, rhs}, nil
+	return DeclStmt{StmtBase: p.makeStmtBase(), Ident: ident, Rhs: rhs}, nil
+}
+
+func (p *parser) parseParam() (Statement, error) {
+	identTok, err := p.expect(lexer.TTIdent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(lexer.TTColonEq); err != nil {
+		return nil, err
+	}
+	rhs, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	stmt := DeclStmt{StmtBase: p.makeStmtBase(), Ident: identTok.Lexeme, Rhs: rhs, Param: true}
+	p.params = append(p.params, stmt)
+	return stmt, nil
 }
 
 func (p *parser) parseAssign(ident string) (Statement, error) {
@@ -148,7 +261,7 @@ func (p *parser) parseAssign(ident string) (Statement, error) {
 	if err != nil {
 		return nil, err
 	}
-	return AssignStmt{p.makeStmtBase(), ident, rhs}, nil
+	return AssignStmt{StmtBase: p.makeStmtBase(), Ident: ident, Rhs: rhs}, nil
 }
 
 func (p *parser) parseIndexedAssign(ident string) (Statement, error) {
@@ -166,7 +279,7 @@ func (p *parser) parseIndexedAssign(ident string) (Statement, error) {
 	if err != nil {
 		return nil, err
 	}
-	return IndexedAssignStmt{p.makeStmtBase(), ident, indexExpr, rhs}, nil
+	return IndexedAssignStmt{StmtBase: p.makeStmtBase(), Ident: ident, Index: indexExpr, Rhs: rhs}, nil
 }
 
 func (p *parser) parsePixelAssign() (Statement, error) {
@@ -250,7 +363,7 @@ func (p *parser) parseFor() (Statement, error) {
 	var upper Expression
 	var step Expression
 	if p.current().Type == lexer.TTDotDot {
-		p.next()
+		dotDotTok := p.next()
 		upper, err = p.parseExpr()
 		if err != nil {
 			return nil, err
@@ -264,30 +377,82 @@ func (p *parser) parseFor() (Statement, error) {
 				return nil, err
 			}
 		} else {
-			step = lang.Number(1)
+			step = NumberExpr{p.makeExprBase(dotDotTok), lang.Number(1)}
 		}
 	}
 
-	if _, err := p.expect(lexer.TTLBrace); err != nil {
+	stmts, err := p.parseLoopBody()
+	if err != nil {
 		return nil, err
 	}
-	stmts, err := p.parseStmtList(lexer.TTRBrace)
+
+	if upper != nil {
+		return ForRangeStmt{
+			StmtBase: p.makeStmtBase(),
+			Ident:    identTok.Lexeme,
+			Lower:    collection,
+			Upper:    upper,
+			Step:     step,
+			Stmts:    stmts}, nil
+	}
+	return ForStmt{StmtBase: p.makeStmtBase(), Ident: identTok.Lexeme, Collection: collection, Stmts: stmts}, nil
+}
+
+func (p *parser) parseParallelFor() (Statement, error) {
+	if _, err := p.expect(lexer.TTFor); err != nil {
+		return nil, err
+	}
+	identTok, err := p.expect(lexer.TTIdent)
 	if err != nil {
 		return nil, err
 	}
-	if _, err := p.expect(lexer.TTRBrace); err != nil {
+	if _, err := p.expect(lexer.TTIn); err != nil {
+		return nil, err
+	}
+	collection, err := p.parseExpr()
+	if err != nil {
 		return nil, err
 	}
+	if p.current().Type == lexer.TTDotDot {
+		return nil, fmt.Errorf("parallel for does not support ranges, iterate over a list or rect instead")
+	}
 
-	if upper != nil {
-		return ForRangeStmt{
-			Ident: identTok.Lexeme,
-			Lower: collection,
-			Upper: upper,
-			Step:  step,
-			Stmts: stmts}, nil
+	var reductions []Reduction
+	if p.current().Type == lexer.TTReduce {
+		p.next()
+		for {
+			reductionTok, err := p.expect(lexer.TTIdent)
+			if err != nil {
+				return nil, err
+			}
+			if reductionTok.Lexeme == identTok.Lexeme {
+				return nil, fmt.Errorf("the loop variable '%s' cannot be a reduction variable", identTok.Lexeme)
+			}
+			for _, r := range reductions {
+				if r.Ident == reductionTok.Lexeme {
+					return nil, fmt.Errorf("duplicate reduction variable '%s'", r.Ident)
+				}
+			}
+			reduction := Reduction{Ident: reductionTok.Lexeme}
+			if p.current().Type == lexer.TTColon {
+				p.next()
+				if reduction.Merge, err = p.parseExpr(); err != nil {
+					return nil, err
+				}
+			}
+			reductions = append(reductions, reduction)
+			if p.current().Type != lexer.TTComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	stmts, err := p.parseLoopBody()
+	if err != nil {
+		return nil, err
 	}
-	return ForStmt{p.makeStmtBase(), identTok.Lexeme, collection, stmts}, nil
+	return ParallelForStmt{ForStmt{StmtBase: p.makeStmtBase(), Ident: identTok.Lexeme, Collection: collection, Stmts: stmts}, reductions}, nil
 }
 
 func (p *parser) parseWhile() (Statement, error) {
@@ -295,20 +460,48 @@ func (p *parser) parseWhile() (Statement, error) {
 	if err != nil {
 		return nil, err
 	}
+	stmts, err := p.parseLoopBody()
+	if err != nil {
+		return nil, err
+	}
+	return WhileStmt{p.makeStmtBase(), cond, stmts}, nil
+}
+
+func (p *parser) parseLoopBody() ([]Statement, error) {
 	if _, err := p.expect(lexer.TTLBrace); err != nil {
 		return nil, err
 	}
+	p.loopDepth++
 	stmts, err := p.parseStmtList(lexer.TTRBrace)
+	p.loopDepth--
 	if err != nil {
 		return nil, err
 	}
 	if _, err := p.expect(lexer.TTRBrace); err != nil {
 		return nil, err
 	}
-	return WhileStmt{p.makeStmtBase(), cond, stmts}, nil
+	return stmts, nil
+}
+
+func (p *parser) parseBreak() (Statement, error) {
+	if p.loopDepth == 0 {
+		return nil, p.errorAt(p.stmtStart, "break is only allowed inside a loop")
+	}
+	return BreakStmt{p.makeStmtBase()}, nil
+}
+
+func (p *parser) parseContinue() (Statement, error) {
+	if p.loopDepth == 0 {
+		return nil, p.errorAt(p.stmtStart, "continue is only allowed inside a loop")
+	}
+	return ContinueStmt{p.makeStmtBase()}, nil
 }
 
 func (p *parser) parseYield() (Statement, error) {
+	if len(p.generators) == 0 {
+		return nil, fmt.Errorf("yield is only allowed inside a function")
+	}
+	p.generators[len(p.generators)-1] = true
 	result, err := p.parseExpr()
 	if err != nil {
 		return nil, err
@@ -362,7 +555,7 @@ func (p *parser) parseExpr() (Expression, error) {
 	}
 
 	if p.current().Type == lexer.TTQMark {
-		p.next()
+		qmarkTok := p.next()
 		trueResult, err := p.parsePipelineExpr()
 		if err != nil {
 			return nil, err
@@ -374,7 +567,7 @@ func (p *parser) parseExpr() (Expression, error) {
 		if err != nil {
 			return nil, err
 		}
-		return TernaryExpr{cond, trueResult, falseResult}, nil
+		return TernaryExpr{p.makeExprBase(qmarkTok), cond, trueResult, falseResult}, nil
 	}
 
 	return cond, nil
@@ -387,12 +580,12 @@ func (p *parser) parsePipelineExpr() (Expression, error) {
 	}
 
 	if p.current().Type == lexer.TTPipe {
-		p.next()
+		opTok := p.next()
 		right, err := p.parsePipelineExpr()
 		if err != nil {
 			return nil, err
 		}
-		return PipelineExpr{left, right}, nil
+		return PipelineExpr{ExprBase: p.makeExprBase(opTok), Left: left, Right: right}, nil
 	}
 
 	return left, nil
@@ -407,12 +600,12 @@ func (p *parser) parseOrExpr() (Expression, error) {
 	for {
 		switch p.current().Type {
 		case lexer.TTOr:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseAndExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = OrExpr{left, right}
+			left = OrExpr{p.makeExprBase(opTok), left, right}
 		default:
 			return left, nil
 		}
@@ -428,12 +621,12 @@ func (p *parser) parseAndExpr() (Expression, error) {
 	for {
 		switch p.current().Type {
 		case lexer.TTAnd:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseCondExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = AndExpr{left, right}
+			left = AndExpr{p.makeExprBase(opTok), left, right}
 		default:
 			return left, nil
 		}
@@ -448,47 +641,47 @@ func (p *parser) parseCondExpr() (Expression, error) {
 
 	switch p.current().Type {
 	case lexer.TTEqEq:
-		p.next()
+		opTok := p.next()
 		right, err := p.parseConcatExpr()
 		if err != nil {
 			return nil, err
 		}
-		return EqExpr{left, right}, nil
+		return EqExpr{p.makeExprBase(opTok), left, right}, nil
 	case lexer.TTNeq:
-		p.next()
+		opTok := p.next()
 		right, err := p.parseConcatExpr()
 		if err != nil {
 			return nil, err
 		}
-		return NeqExpr{left, right}, nil
+		return NeqExpr{p.makeExprBase(opTok), left, right}, nil
 	case lexer.TTGt:
-		p.next()
+		opTok := p.next()
 		right, err := p.parseConcatExpr()
 		if err != nil {
 			return nil, err
 		}
-		return GtExpr{left, right}, nil
+		return GtExpr{p.makeExprBase(opTok), left, right}, nil
 	case lexer.TTGe:
-		p.next()
+		opTok := p.next()
 		right, err := p.parseConcatExpr()
 		if err != nil {
 			return nil, err
 		}
-		return GeExpr{left, right}, nil
+		return GeExpr{p.makeExprBase(opTok), left, right}, nil
 	case lexer.TTLt:
-		p.next()
+		opTok := p.next()
 		right, err := p.parseConcatExpr()
 		if err != nil {
 			return nil, err
 		}
-		return LtExpr{left, right}, nil
+		return LtExpr{p.makeExprBase(opTok), left, right}, nil
 	case lexer.TTLe:
-		p.next()
+		opTok := p.next()
 		right, err := p.parseConcatExpr()
 		if err != nil {
 			return nil, err
 		}
-		return LeExpr{left, right}, nil
+		return LeExpr{p.makeExprBase(opTok), left, right}, nil
 	}
 	return left, nil
 }
@@ -502,12 +695,12 @@ func (p *parser) parseConcatExpr() (Expression, error) {
 	for {
 		switch p.current().Type {
 		case lexer.TTColonColon:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseTupleExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = ConcatExpr{left, right}
+			left = ConcatExpr{p.makeExprBase(opTok), left, right}
 		default:
 			return left, nil
 		}
@@ -521,12 +714,12 @@ func (p *parser) parseTupleExpr() (Expression, error) {
 	}
 
 	if p.current().Type == lexer.TTSemicolon {
-		p.next()
+		opTok := p.next()
 		right, err := p.parseTermExpr()
 		if err != nil {
 			return nil, err
 		}
-		return PosExpr{X: left, Y: right}, nil
+		return PosExpr{ExprBase: p.makeExprBase(opTok), X: left, Y: right}, nil
 	}
 
 	return left, nil
@@ -541,26 +734,26 @@ func (p *parser) parseTermExpr() (Expression, error) {
 	for {
 		switch p.current().Type {
 		case lexer.TTPlus:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseProductExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = AddExpr{left, right}
+			left = AddExpr{p.makeExprBase(opTok), left, right}
 		case lexer.TTMinus:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseProductExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = SubExpr{left, right}
+			left = SubExpr{p.makeExprBase(opTok), left, right}
 		case lexer.TTIn:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseProductExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = InExpr{left, right}
+			left = InExpr{p.makeExprBase(opTok), left, right}
 		default:
 			return left, nil
 		}
@@ -576,26 +769,26 @@ func (p *parser) parseProductExpr() (Expression, error) {
 	for {
 		switch p.current().Type {
 		case lexer.TTStar:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseMoleculeExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = MulExpr{left, right}
+			left = MulExpr{p.makeExprBase(opTok), left, right}
 		case lexer.TTSlash:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseMoleculeExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = DivExpr{left, right}
+			left = DivExpr{p.makeExprBase(opTok), left, right}
 		case lexer.TTPercent:
-			p.next()
+			opTok := p.next()
 			right, err := p.parseMoleculeExpr()
 			if err != nil {
 				return nil, err
 			}
-			left = ModExpr{left, right}
+			left = ModExpr{p.makeExprBase(opTok), left, right}
 		default:
 			return left, nil
 		}
@@ -605,19 +798,19 @@ func (p *parser) parseProductExpr() (Expression, error) {
 func (p *parser) parseMoleculeExpr() (Expression, error) {
 	switch p.current().Type {
 	case lexer.TTMinus:
-		p.next()
+		opTok := p.next()
 		inner, err := p.parseMoleculeExpr()
 		if err != nil {
 			return nil, err
 		}
-		return NegExpr{inner}, nil
+		return NegExpr{p.makeExprBase(opTok), inner}, nil
 	case lexer.TTNot:
-		p.next()
+		opTok := p.next()
 		inner, err := p.parseMoleculeExpr()
 		if err != nil {
 			return nil, err
 		}
-		return NotExpr{inner}, nil
+		return NotExpr{p.makeExprBase(opTok), inner}, nil
 	}
 
 	atom, err := p.parseAtom()
@@ -633,9 +826,9 @@ func (p *parser) parseMoleculeExpr() (Expression, error) {
 			if err != nil {
 				return nil, err
 			}
-			atom = MemberExpr{Recvr: atom, Member: memberTok.Lexeme}
+			atom = MemberExpr{ExprBase: p.makeExprBase(memberTok), Recvr: atom, Member: memberTok.Lexeme}
 		case lexer.TTLBracket:
-			p.next()
+			bracketTok := p.next()
 			index, err := p.parseExpr()
 			if err != nil {
 				return nil, err
@@ -646,19 +839,47 @@ func (p *parser) parseMoleculeExpr() (Expression, error) {
 				if err != nil {
 					return nil, err
 				}
-				atom = IndexRangeExpr{Recvr: atom, Lower: index, Upper: upper}
+				atom = IndexRangeExpr{ExprBase: p.makeExprBase(bracketTok), Recvr: atom, Lower: index, Upper: upper}
 			} else {
-				atom = IndexExpr{Recvr: atom, Index: index}
+				atom = IndexExpr{ExprBase: p.makeExprBase(bracketTok), Recvr: atom, Index: index}
 			}
 			if _, err := p.expect(lexer.TTRBracket); err != nil {
 				return nil, err
 			}
+		case lexer.TTLParen:
+			if p.inKernel {
+				return atom, nil
+			}
+			parenTok := p.next()
+			args, err := p.parseInvocationArgs()
+			if err != nil {
+				return nil, err
+			}
+			atom = CallExpr{ExprBase: p.makeExprBase(parenTok), Callee: atom, Args: args}
+		case lexer.TTAt:
+			// img@p samples an image only if the @ follows the image without whitespace,
+			// otherwise it starts a pixel assignment like @p = c
+			if !p.adjacent() {
+				return atom, nil
+			}
+			atTok := p.next()
+			point, err := p.parseAtom()
+			if err != nil {
+				return nil, err
+			}
+			atom = SampleExpr{ExprBase: p.makeExprBase(atTok), Image: atom, Point: point}
 		default:
 			return atom, nil
 		}
 	}
 }
 
+// adjacent returns true if the current token directly follows the previous token
+func (p *parser) adjacent() bool {
+	prev, tok := p.previous(), p.current()
+	return prev.LineNumber == tok.LineNumber && prev.Column+utf8.RuneCountInString(prev.Lexeme) == tok.Column
+}
+
 func (p *parser) parseAtom() (Expression, error) {
 	tok := p.next()
 	switch tok.Type {
@@ -667,21 +888,21 @@ func (p *parser) parseAtom() (Expression, error) {
 	case lexer.TTAt:
 		return p.parseAtAtom()
 	case lexer.TTIdent:
-		return p.parseIdentAtom(tok.Lexeme)
+		return p.parseIdentAtom(tok)
 	case lexer.TTNumber:
-		return tok.ParseNumber(), nil
+		return NumberExpr{p.makeExprBase(tok), tok.ParseNumber()}, nil
 	case lexer.TTString:
-		return lang.Str(tok.ParseString()), nil
+		return StrExpr{p.makeExprBase(tok), lang.Str(tok.ParseString())}, nil
 	case lexer.TTTrue:
-		return lang.TrueVal, nil
+		return BoolExpr{p.makeExprBase(tok), lang.TrueVal}, nil
 	case lexer.TTFalse:
-		return lang.FalseVal, nil
+		return BoolExpr{p.makeExprBase(tok), lang.FalseVal}, nil
 	case lexer.TTNil:
-		return lang.NilVal, nil
+		return NilExpr{p.makeExprBase(tok)}, nil
 	case lexer.TTDollar:
-		return IdentExpr(tok.Lexeme), nil
+		return IdentExpr{ExprBase: p.makeExprBase(tok), Ident: tok.Lexeme}, nil
 	case lexer.TTColor:
-		return tok.ParseColor(), nil
+		return ColorExpr{p.makeExprBase(tok), tok.ParseColor()}, nil
 	case lexer.TTPipe:
 		return p.parseKernelAtom()
 	case lexer.TTFn:
@@ -690,12 +911,17 @@ func (p *parser) parseAtom() (Expression, error) {
 		return p.parseHashMap()
 	case lexer.TTLBracket:
 		return p.parseList()
+	case lexer.TTImport:
+		return p.parseImport()
 	}
 	return nil, fmt.Errorf("unexpected symbol '%s'", tok.Lexeme)
 }
 
 func (p *parser) parseParenAtom() (Expression, error) {
+	outerInKernel := p.inKernel
+	p.inKernel = false
 	inner, err := p.parseExpr()
+	p.inKernel = outerInKernel
 	if err != nil {
 		return nil, err
 	}
@@ -706,28 +932,39 @@ func (p *parser) parseParenAtom() (Expression, error) {
 }
 
 func (p *parser) parseAtAtom() (Expression, error) {
+	base := p.makeExprBase(p.previous())
 	inner, err := p.parseAtom()
 	if err != nil {
 		return nil, err
 	}
-	return AtExpr{inner}, nil
+	return AtExpr{base, inner}, nil
 }
-func (p *parser) parseIdentAtom(ident string) (Expression, error) {
 
+func (p *parser) parseIdentAtom(identTok lexer.Token) (Expression, error) {
 	if p.current().Type == lexer.TTLParen {
 		p.next()
-		return p.parseInvocationAtom(ident)
+		return p.parseInvocationAtom(identTok)
 	}
-	return IdentExpr(ident), nil
+	return IdentExpr{ExprBase: p.makeExprBase(identTok), Ident: identTok.Lexeme}, nil
 }
 
-func (p *parser) parseInvocationAtom(ident string) (Expression, error) {
+func (p *parser) parseInvocationAtom(identTok lexer.Token) (Expression, error) {
+	args, err := p.parseInvocationArgs()
+	if err != nil {
+		return nil, err
+	}
+	return InvokeExpr{ExprBase: p.makeExprBase(identTok), FuncName: identTok.Lexeme, Args: args}, nil
+}
+
+// parseInvocationArgs parses the arguments of an invocation after the opening parenthesis
+func (p *parser) parseInvocationArgs() ([]Expression, error) {
 	var args []Expression
 	var err error
-	if p.current().Type == lexer.TTRParen {
-		args = nil
-	} else {
+	if p.current().Type != lexer.TTRParen {
+		outerInKernel := p.inKernel
+		p.inKernel = false
 		args, err = p.parseArgumentList()
+		p.inKernel = outerInKernel
 		if err != nil {
 			return nil, err
 		}
@@ -735,10 +972,22 @@ func (p *parser) parseInvocationAtom(ident string) (Expression, error) {
 	if _, err := p.expect(lexer.TTRParen); err != nil {
 		return nil, err
 	}
-	return InvokeExpr{FuncName: ident, Args: args}, nil
+	return args, nil
+}
+
+func (p *parser) parseImport() (Expression, error) {
+	importTok := p.previous()
+	pathTok, err := p.expect(lexer.TTString)
+	if err != nil {
+		return nil, err
+	}
+	expr := ImportExpr{ExprBase: p.makeExprBase(importTok), Path: pathTok.ParseString()}
+	p.imports = append(p.imports, expr)
+	return expr, nil
 }
 
 func (p *parser) parseKernelAtom() (Expression, error) {
+	base := p.makeExprBase(p.previous())
 	elements := []Expression{}
 	for {
 		if p.current().Type == lexer.TTPipe {
@@ -747,9 +996,11 @@ func (p *parser) parseKernelAtom() (Expression, error) {
 				return nil, fmt.Errorf("kernel defined in kernel expression must be quadratic")
 			}
 			p.next()
-			return KernelExpr{elements}, nil
+			return KernelExpr{base, elements}, nil
 		}
+		p.inKernel = true
 		element, err := p.parseMoleculeExpr()
+		p.inKernel = false
 		if err != nil {
 			return nil, err
 		}
@@ -758,6 +1009,7 @@ func (p *parser) parseKernelAtom() (Expression, error) {
 }
 
 func (p *parser) parseFunctionDef() (Expression, error) {
+	base := p.makeExprBase(p.previous())
 	if _, err := p.expect(lexer.TTLParen); err != nil {
 		return nil, err
 	}
@@ -776,16 +1028,21 @@ func (p *parser) parseFunctionDef() (Expression, error) {
 	}
 
 	if p.current().Type == lexer.TTArrow {
-		p.next()
+		arrowTok := p.next()
 		expr, err := p.parseExpr()
 		if err != nil {
 			return nil, err
 		}
+		stmtBase := StmtBase{}
+		if !p.omitTokenInfo {
+			stmtBase.tok = arrowTok
+		}
 		return FunctionExpr{
+			ExprBase:       base,
 			ParameterNames: parameterNames,
 			Body: []Statement{
 				ReturnStmt{
-					StmtBase: p.makeStmtBase(),
+					StmtBase: stmtBase,
 					Result:   expr,
 				},
 			},
@@ -795,7 +1052,13 @@ func (p *parser) parseFunctionDef() (Expression, error) {
 	if _, err := p.expect(lexer.TTLBrace); err != nil {
 		return nil, err
 	}
+	p.generators = append(p.generators, false)
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0 // loops outside of the function cannot be left from inside
 	body, err := p.parseStmtList(lexer.TTRBrace)
+	p.loopDepth = outerLoopDepth
+	isGenerator := p.generators[len(p.generators)-1]
+	p.generators = p.generators[:len(p.generators)-1]
 	if err != nil {
 		return nil, err
 	}
@@ -804,8 +1067,10 @@ func (p *parser) parseFunctionDef() (Expression, error) {
 	}
 
 	return FunctionExpr{
+		ExprBase:       base,
 		ParameterNames: parameterNames,
 		Body:           body,
+		IsGenerator:    isGenerator,
 	}, nil
 }
 
@@ -829,7 +1094,7 @@ func (p *parser) parseIdentList() ([]string, error) {
 }
 
 func (p *parser) parseHashMap() (Expression, error) {
-	hashMap := HashMapExpr{}
+	hashMap := HashMapExpr{ExprBase: p.makeExprBase(p.previous())}
 	for {
 		var key Expression
 		var err error
@@ -841,7 +1106,7 @@ func (p *parser) parseHashMap() (Expression, error) {
 
 		tok := p.current()
 		if tok.Type == lexer.TTIdent {
-			key = lang.Str(tok.Lexeme)
+			key = StrExpr{p.makeExprBase(tok), lang.Str(tok.Lexeme)}
 			p.next()
 		} else {
 			key, err = p.parseExpr()
@@ -874,7 +1139,7 @@ func (p *parser) parseHashMap() (Expression, error) {
 }
 
 func (p *parser) parseList() (Expression, error) {
-	list := ListExpr{}
+	list := ListExpr{ExprBase: p.makeExprBase(p.previous())}
 	for {
 		if p.current().Type == lexer.TTRBracket {
 			p.next()
@@ -58,6 +58,36 @@ func Test_parse_syntax(t *testing.T) {
 			src:     "x := 1 x = 2 x = 3",
 			wantErr: false,
 		},
+		{
+			name:    "yield_in_function",
+			src:     "f := fn() { yield 1 }",
+			wantErr: false,
+		},
+		{
+			name:    "yield_outside_function",
+			src:     "yield 1",
+			wantErr: true,
+		},
+		{
+			name:    "break_in_loops",
+			src:     "for x in coll { if x > 1 { break } } while true { continue } for i in 0..10 { break }",
+			wantErr: false,
+		},
+		{
+			name:    "break_outside_loop",
+			src:     "if x > 1 { break }",
+			wantErr: true,
+		},
+		{
+			name:    "continue_outside_loop",
+			src:     "continue",
+			wantErr: true,
+		},
+		{
+			name:    "break_in_function_inside_loop",
+			src:     "for x in coll { f := fn() { break } }",
+			wantErr: true,
+		},
 		{
 			name:    "parameter_list",
 			src:     "log(1, 2, 3, 4)",
@@ -83,6 +113,51 @@ func Test_parse_syntax(t *testing.T) {
 			src:     "NUM := 1 NUM = 2",
 			wantErr: true,
 		},
+		{
+			name:    "import",
+			src:     `m := import "lib.ylang" m.f(1) log(m.g(2)(3))`,
+			wantErr: false,
+		},
+		{
+			name:    "import_without_path",
+			src:     "m := import lib",
+			wantErr: true,
+		},
+		{
+			name:    "member_without_call_as_stmt",
+			src:     `m := import "lib.ylang" m.f`,
+			wantErr: true,
+		},
+		{
+			name:    "kernel_paren_elements",
+			src:     "k := |(1) (2) (3) (4)|",
+			wantErr: false,
+		},
+		{
+			name:    "parallel_for",
+			src:     "parallel for p in Bounds { @p = -@p }",
+			wantErr: false,
+		},
+		{
+			name:    "parallel_for_reduce",
+			src:     "parallel for p in Bounds reduce n, m: max, h: fn(a, b) -> a { n = n + 1 }",
+			wantErr: false,
+		},
+		{
+			name:    "parallel_for_range",
+			src:     "parallel for i in 0 .. 10 { }",
+			wantErr: true,
+		},
+		{
+			name:    "parallel_for_duplicate_reduction",
+			src:     "parallel for p in Bounds reduce n, n { }",
+			wantErr: true,
+		},
+		{
+			name:    "parallel_for_reduce_loop_variable",
+			src:     "parallel for p in Bounds reduce p { }",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -108,7 +183,7 @@ func Test_parse_ast(t *testing.T) {
 				DeclStmt{
 					StmtBase: StmtBase{},
 					Ident:    "x",
-					Rhs:      lang.Number(1),
+					Rhs:      NumberExpr{Value: 1},
 				},
 			},
 		},
@@ -125,7 +200,7 @@ func Test_parse_ast(t *testing.T) {
 							InvokeExpr{
 								FuncName: "a",
 								Args: []Expression{
-									lang.Number(1),
+									NumberExpr{Value: 1},
 								},
 							},
 						},
@@ -133,8 +208,44 @@ func Test_parse_ast(t *testing.T) {
 				},
 				PixelAssignStmt{
 					StmtBase: StmtBase{},
-					Lhs:      IdentExpr("p"),
-					Rhs:      lang.Number(2),
+					Lhs:      IdentExpr{Ident: "p"},
+					Rhs:      NumberExpr{Value: 2},
+				},
+			},
+		},
+		{
+			name: "sample",
+			src:  "x := img@(1;2).r",
+			want: []Statement{
+				DeclStmt{
+					Ident: "x",
+					Rhs: MemberExpr{
+						Member: "r",
+						Recvr: SampleExpr{
+							Image: IdentExpr{Ident: "img"},
+							Point: PosExpr{
+								X: NumberExpr{Value: 1},
+								Y: NumberExpr{Value: 2},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "sample_then_pixel_assign",
+			src:  "x := img@p\n@p = x",
+			want: []Statement{
+				DeclStmt{
+					Ident: "x",
+					Rhs: SampleExpr{
+						Image: IdentExpr{Ident: "img"},
+						Point: IdentExpr{Ident: "p"},
+					},
+				},
+				PixelAssignStmt{
+					Lhs: IdentExpr{Ident: "p"},
+					Rhs: IdentExpr{Ident: "x"},
 				},
 			},
 		},
@@ -145,8 +256,8 @@ func Test_parse_ast(t *testing.T) {
 				IndexedAssignStmt{
 					StmtBase: StmtBase{},
 					Ident:    "x",
-					Index:    lang.Number(1),
-					Rhs:      lang.Number(2),
+					Index:    NumberExpr{Value: 1},
+					Rhs:      NumberExpr{Value: 2},
 				},
 			},
 		},
@@ -162,7 +273,7 @@ func Test_parse_ast(t *testing.T) {
 						Body: []Statement{
 							ReturnStmt{
 								StmtBase: StmtBase{},
-								Result:   lang.Number(1),
+								Result:   NumberExpr{Value: 1},
 							},
 						},
 					},
@@ -181,9 +292,29 @@ func Test_parse_ast(t *testing.T) {
 						Body: []Statement{
 							ReturnStmt{
 								StmtBase: StmtBase{},
-								Result:   lang.Number(5),
+								Result:   NumberExpr{Value: 5},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "generatorDecl",
+			src:  "f := fn() { yield 1 }",
+			want: []Statement{
+				DeclStmt{
+					StmtBase: StmtBase{},
+					Ident:    "f",
+					Rhs: FunctionExpr{
+						ParameterNames: nil,
+						Body: []Statement{
+							YieldStmt{
+								StmtBase: StmtBase{},
+								Result:   NumberExpr{Value: 1},
 							},
 						},
+						IsGenerator: true,
 					},
 				},
 			},
@@ -194,7 +325,7 @@ func Test_parse_ast(t *testing.T) {
 			want: []Statement{
 				ReturnStmt{
 					StmtBase: StmtBase{},
-					Result:   lang.Number(100),
+					Result:   NumberExpr{Value: 100},
 				},
 			},
 		},
@@ -205,13 +336,13 @@ func Test_parse_ast(t *testing.T) {
 				LogStmt{
 					StmtBase: StmtBase{},
 					Args: []Expression{
-						lang.Number(1),
+						NumberExpr{Value: 1},
 					},
 				},
 				LogStmt{
 					StmtBase: StmtBase{},
 					Args: []Expression{
-						lang.Number(2),
+						NumberExpr{Value: 2},
 					},
 				},
 			},
@@ -223,9 +354,9 @@ func Test_parse_ast(t *testing.T) {
 				LogStmt{
 					StmtBase: StmtBase{},
 					Args: []Expression{
-						lang.Number(1),
-						lang.Number(2),
-						lang.Number(3),
+						NumberExpr{Value: 1},
+						NumberExpr{Value: 2},
+						NumberExpr{Value: 3},
 					},
 				},
 			},
@@ -249,7 +380,7 @@ func Test_parse_ast(t *testing.T) {
 					StmtBase: StmtBase{},
 					Ident:    "l",
 					Rhs: ListExpr{
-						Elements: []Expression{lang.Number(1), lang.Number(2), lang.Number(3)},
+						Elements: []Expression{NumberExpr{Value: 1}, NumberExpr{Value: 2}, NumberExpr{Value: 3}},
 					},
 				},
 			},
@@ -262,7 +393,7 @@ func Test_parse_ast(t *testing.T) {
 					StmtBase: StmtBase{},
 					Ident:    "l",
 					Rhs: ListExpr{
-						Elements: []Expression{lang.Number(1)},
+						Elements: []Expression{NumberExpr{Value: 1}},
 					},
 				},
 			},
@@ -278,8 +409,8 @@ func Test_parse_ast(t *testing.T) {
 						Member: "r",
 						Recvr: AtExpr{
 							Inner: PosExpr{
-								X: lang.Number(1),
-								Y: lang.Number(2),
+								X: NumberExpr{Value: 1},
+								Y: NumberExpr{Value: 2},
 							},
 						},
 					},
@@ -296,10 +427,10 @@ func Test_parse_ast(t *testing.T) {
 					Rhs: MemberExpr{
 						Member: "m",
 						Recvr: IndexExpr{
-							Recvr: IdentExpr("k"),
+							Recvr: IdentExpr{Ident: "k"},
 							Index: PosExpr{
-								X: lang.Number(1),
-								Y: lang.Number(2),
+								X: NumberExpr{Value: 1},
+								Y: NumberExpr{Value: 2},
 							},
 						},
 					},
@@ -316,11 +447,11 @@ func Test_parse_ast(t *testing.T) {
 					Rhs: ConcatExpr{
 						Left: ConcatExpr{
 							Left: ListExpr{
-								Elements: []Expression{lang.Number(1)},
+								Elements: []Expression{NumberExpr{Value: 1}},
 							},
-							Right: lang.Number(2),
+							Right: NumberExpr{Value: 2},
 						},
-						Right: lang.Number(3),
+						Right: NumberExpr{Value: 3},
 					},
 				},
 			},
@@ -333,9 +464,9 @@ func Test_parse_ast(t *testing.T) {
 					StmtBase: StmtBase{},
 					Ident:    "s",
 					Rhs: IndexRangeExpr{
-						Recvr: IdentExpr("ls"),
-						Lower: lang.Number(1),
-						Upper: lang.Number(10),
+						Recvr: IdentExpr{Ident: "ls"},
+						Lower: NumberExpr{Value: 1},
+						Upper: NumberExpr{Value: 10},
 					},
 				},
 			},
@@ -349,10 +480,10 @@ func Test_parse_ast(t *testing.T) {
 					Ident:    "x",
 					Rhs: SubExpr{
 						Left: AddExpr{
-							Left:  lang.Number(1),
-							Right: lang.Number(3),
+							Left:  NumberExpr{Value: 1},
+							Right: NumberExpr{Value: 3},
 						},
-						Right: lang.Number(2),
+						Right: NumberExpr{Value: 2},
 					},
 				},
 			},
@@ -366,10 +497,10 @@ func Test_parse_ast(t *testing.T) {
 					Ident:    "x",
 					Rhs: MulExpr{
 						Left: DivExpr{
-							Left:  lang.Number(16),
-							Right: lang.Number(4),
+							Left:  NumberExpr{Value: 16},
+							Right: NumberExpr{Value: 4},
 						},
-						Right: lang.Number(2),
+						Right: NumberExpr{Value: 2},
 					},
 				},
 			},
@@ -387,7 +518,7 @@ func Test_parse_ast(t *testing.T) {
 								Member: "member2",
 								Recvr: MemberExpr{
 									Member: "member1",
-									Recvr:  IdentExpr("x"),
+									Recvr:  IdentExpr{Ident: "x"},
 								},
 							},
 						},
@@ -401,7 +532,7 @@ func Test_parse_ast(t *testing.T) {
 			want: []Statement{
 				LogStmt{
 					StmtBase: StmtBase{},
-					Args:     []Expression{lang.NewRgba(0xff, 0xee, 0x44, 0x0f)},
+					Args:     []Expression{ColorExpr{Value: lang.NewRgba(0xff, 0xee, 0x44, 0x0f)}},
 				},
 			},
 		},
@@ -431,7 +562,7 @@ func Test_parse_ast(t *testing.T) {
 								InvokeExpr{
 									FuncName: "map_b",
 									Args: []Expression{
-										lang.Number(1),
+										NumberExpr{Value: 1},
 									},
 								},
 							},
@@ -446,11 +577,11 @@ func Test_parse_ast(t *testing.T) {
 			want: []Statement{
 				IfStmt{
 					StmtBase: StmtBase{},
-					Cond:     lang.TrueVal,
+					Cond:     BoolExpr{Value: true},
 					TrueStmts: []Statement{
 						LogStmt{
 							StmtBase: StmtBase{},
-							Args:     []Expression{lang.Number(1)},
+							Args:     []Expression{NumberExpr{Value: 1}},
 						},
 					},
 					FalseStmts: nil,
@@ -464,13 +595,13 @@ func Test_parse_ast(t *testing.T) {
 				ForRangeStmt{
 					StmtBase: StmtBase{},
 					Ident:    "x",
-					Lower:    lang.Number(0),
-					Step:     lang.Number(1),
-					Upper:    lang.Number(10),
+					Lower:    NumberExpr{Value: 0},
+					Step:     NumberExpr{Value: 1},
+					Upper:    NumberExpr{Value: 10},
 					Stmts: []Statement{
 						LogStmt{
 							StmtBase: StmtBase{},
-							Args:     []Expression{lang.Number(1)},
+							Args:     []Expression{NumberExpr{Value: 1}},
 						},
 					},
 				},
@@ -483,13 +614,13 @@ func Test_parse_ast(t *testing.T) {
 				ForRangeStmt{
 					StmtBase: StmtBase{},
 					Ident:    "x",
-					Lower:    lang.Number(0),
-					Step:     lang.Number(2),
-					Upper:    lang.Number(10),
+					Lower:    NumberExpr{Value: 0},
+					Step:     NumberExpr{Value: 2},
+					Upper:    NumberExpr{Value: 10},
 					Stmts: []Statement{
 						LogStmt{
 							StmtBase: StmtBase{},
-							Args:     []Expression{lang.Number(1)},
+							Args:     []Expression{NumberExpr{Value: 1}},
 						},
 					},
 				},
@@ -502,11 +633,11 @@ func Test_parse_ast(t *testing.T) {
 				ForStmt{
 					StmtBase:   StmtBase{},
 					Ident:      "x",
-					Collection: IdentExpr("coll"),
+					Collection: IdentExpr{Ident: "coll"},
 					Stmts: []Statement{
 						LogStmt{
 							StmtBase: StmtBase{},
-							Args:     []Expression{lang.Number(1)},
+							Args:     []Expression{NumberExpr{Value: 1}},
 						},
 					},
 				},
@@ -518,17 +649,17 @@ func Test_parse_ast(t *testing.T) {
 			want: []Statement{
 				IfStmt{
 					StmtBase: StmtBase{},
-					Cond:     lang.TrueVal,
+					Cond:     BoolExpr{Value: true},
 					TrueStmts: []Statement{
 						LogStmt{
 							StmtBase: StmtBase{},
-							Args:     []Expression{lang.Number(1)},
+							Args:     []Expression{NumberExpr{Value: 1}},
 						},
 					},
 					FalseStmts: []Statement{
 						LogStmt{
 							StmtBase: StmtBase{},
-							Args:     []Expression{lang.Number(2)},
+							Args:     []Expression{NumberExpr{Value: 2}},
 						},
 					},
 				},
@@ -540,21 +671,21 @@ func Test_parse_ast(t *testing.T) {
 			want: []Statement{
 				IfStmt{
 					StmtBase: StmtBase{},
-					Cond:     lang.TrueVal,
+					Cond:     BoolExpr{Value: true},
 					TrueStmts: []Statement{
 						LogStmt{
 							StmtBase: StmtBase{},
-							Args:     []Expression{lang.Number(1)},
+							Args:     []Expression{NumberExpr{Value: 1}},
 						},
 					},
 					FalseStmts: []Statement{
 						IfStmt{
 							StmtBase: StmtBase{},
-							Cond:     lang.FalseVal,
+							Cond:     BoolExpr{Value: false},
 							TrueStmts: []Statement{
 								LogStmt{
 									StmtBase: StmtBase{},
-									Args:     []Expression{lang.Number(2)},
+									Args:     []Expression{NumberExpr{Value: 2}},
 								},
 							},
 							FalseStmts: nil,
@@ -569,27 +700,27 @@ func Test_parse_ast(t *testing.T) {
 			want: []Statement{
 				IfStmt{
 					StmtBase: StmtBase{},
-					Cond:     lang.TrueVal,
+					Cond:     BoolExpr{Value: true},
 					TrueStmts: []Statement{
 						LogStmt{
 							StmtBase: StmtBase{},
-							Args:     []Expression{lang.Number(1)},
+							Args:     []Expression{NumberExpr{Value: 1}},
 						},
 					},
 					FalseStmts: []Statement{
 						IfStmt{
 							StmtBase: StmtBase{},
-							Cond:     lang.FalseVal,
+							Cond:     BoolExpr{Value: false},
 							TrueStmts: []Statement{
 								LogStmt{
 									StmtBase: StmtBase{},
-									Args:     []Expression{lang.Number(2)},
+									Args:     []Expression{NumberExpr{Value: 2}},
 								},
 							},
 							FalseStmts: []Statement{
 								LogStmt{
 									StmtBase: StmtBase{},
-									Args:     []Expression{lang.Number(3)},
+									Args:     []Expression{NumberExpr{Value: 3}},
 								},
 							},
 						},
@@ -602,10 +733,10 @@ func Test_parse_ast(t *testing.T) {
 			src:  "while true { log(1) }",
 			want: []Statement{
 				WhileStmt{
-					Cond: lang.TrueVal,
+					Cond: BoolExpr{Value: true},
 					Stmts: []Statement{
 						LogStmt{
-							Args: []Expression{lang.Number(1)},
+							Args: []Expression{NumberExpr{Value: 1}},
 						},
 					},
 				},
@@ -617,12 +748,12 @@ func Test_parse_ast(t *testing.T) {
 			want: []Statement{
 				WhileStmt{
 					Cond: LtExpr{
-						Left:  IdentExpr("x"),
-						Right: IdentExpr("y"),
+						Left:  IdentExpr{Ident: "x"},
+						Right: IdentExpr{Ident: "y"},
 					},
 					Stmts: []Statement{
 						LogStmt{
-							Args: []Expression{lang.Number(100)},
+							Args: []Expression{NumberExpr{Value: 100}},
 						},
 					},
 				},
@@ -635,20 +766,20 @@ func Test_parse_ast(t *testing.T) {
 				LogStmt{
 					Args: []Expression{
 						PipelineExpr{
-							Left: lang.Number(1),
+							Left: NumberExpr{Value: 1},
 							Right: PipelineExpr{
 								Left: AddExpr{
-									Left:  IdentExpr("$"),
-									Right: lang.Number(1),
+									Left:  IdentExpr{Ident: "$"},
+									Right: NumberExpr{Value: 1},
 								},
 								Right: PipelineExpr{
 									Left: AddExpr{
-										Left:  IdentExpr("$"),
-										Right: lang.Number(2),
+										Left:  IdentExpr{Ident: "$"},
+										Right: NumberExpr{Value: 2},
 									},
 									Right: AddExpr{
-										Left:  IdentExpr("$"),
-										Right: lang.Number(3),
+										Left:  IdentExpr{Ident: "$"},
+										Right: NumberExpr{Value: 3},
 									},
 								},
 							},
@@ -657,6 +788,54 @@ func Test_parse_ast(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "import",
+			src:  `m := import "lib.ylang" m.f(1)`,
+			want: []Statement{
+				DeclStmt{
+					Ident: "m",
+					Rhs:   ImportExpr{Path: "lib.ylang"},
+				},
+				InvocationStmt{
+					Invocation: CallExpr{
+						Callee: MemberExpr{Recvr: IdentExpr{Ident: "m"}, Member: "f"},
+						Args:   []Expression{NumberExpr{Value: 1}},
+					},
+				},
+			},
+		},
+		{
+			name: "parallel_for",
+			src:  "parallel for x in l reduce n, m: max { }",
+			want: []Statement{
+				ParallelForStmt{
+					ForStmt: ForStmt{
+						Ident:      "x",
+						Collection: IdentExpr{Ident: "l"},
+					},
+					Reductions: []Reduction{
+						{Ident: "n"},
+						{Ident: "m", Merge: IdentExpr{Ident: "max"}},
+					},
+				},
+			},
+		},
+		{
+			name: "param",
+			src:  "param threshold := 200",
+			want: []Statement{
+				DeclStmt{
+					Ident: "threshold",
+					Rhs:   NumberExpr{Value: 200},
+					Param: true,
+				},
+			},
+		},
+		{
+			name:    "param_without_default",
+			src:     "param threshold",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -672,3 +851,136 @@ func Test_parse_ast(t *testing.T) {
 		})
 	}
 }
+
+func Test_parse_positions(t *testing.T) {
+	tokens, err := lexer.Lex("x := 1\ny := x +\n  (2 * z)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Parse(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decl := prog.Stmts[1].(DeclStmt)
+	if tok := decl.Token(); tok.LineNumber != 2 || tok.Column != 1 {
+		t.Errorf("statement at %d:%d, want 2:1", tok.LineNumber, tok.Column)
+	}
+	add := decl.Rhs.(AddExpr)
+	if tok := add.Token(); tok.LineNumber != 2 || tok.Column != 8 {
+		t.Errorf("add expression at %d:%d, want 2:8", tok.LineNumber, tok.Column)
+	}
+	ident := add.Right.(MulExpr).Right.(IdentExpr)
+	if tok := ident.Token(); tok.LineNumber != 3 || tok.Column != 8 {
+		t.Errorf("ident expression at %d:%d, want 3:8", tok.LineNumber, tok.Column)
+	}
+}
+
+func Test_parse_errorPosition(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		wantLine int
+		wantCol  int
+	}{
+		{
+			name:     "unexpected_token",
+			src:      "x := 1\nif x > {",
+			wantLine: 2,
+			wantCol:  8,
+		},
+		{
+			name:     "break_outside_loop",
+			src:      "x := 1\nif x > 0 {\n    break\n}",
+			wantLine: 3,
+			wantCol:  5,
+		},
+		{
+			name:     "continue_outside_loop",
+			src:      "f := fn() {\n    continue\n}",
+			wantLine: 2,
+			wantCol:  5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexer.Lex(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Parse(tokens, false)
+			perr, ok := err.(*lang.Error)
+			if !ok {
+				t.Fatalf("Parse() error = %v, want *lang.Error", err)
+			}
+			if perr.Line != tt.wantLine || perr.Col != tt.wantCol {
+				t.Errorf("Parse() error at %d:%d, want %d:%d", perr.Line, perr.Col, tt.wantLine, tt.wantCol)
+			}
+		})
+	}
+}
+
+func Test_ParseInput(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		wantStmts int
+		wantExpr  bool // true if the last statement is an expression
+		wantErr   bool
+	}{
+		{
+			name:      "statements",
+			src:       "x := 1 log(x)",
+			wantStmts: 2,
+		},
+		{
+			name:      "expression",
+			src:       "1 + 2",
+			wantStmts: 1,
+			wantExpr:  true,
+		},
+		{
+			name:      "statements_and_expression",
+			src:       "x := 1\nx * 2",
+			wantStmts: 2,
+			wantExpr:  true,
+		},
+		{
+			name:      "invocation",
+			src:       "sqrt(2)",
+			wantStmts: 1,
+			wantExpr:  true,
+		},
+		{
+			name:    "expression_not_last",
+			src:     "1 + 2\nx := 1",
+			wantErr: true,
+		},
+		{
+			name:    "syntax_error",
+			src:     "x := (1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := lexer.Lex(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			prog, err := ParseInput(tokens)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(prog.Stmts) != tt.wantStmts {
+				t.Fatalf("ParseInput() returned %d statements, want %d", len(prog.Stmts), tt.wantStmts)
+			}
+			_, isExpr := prog.Stmts[len(prog.Stmts)-1].(InvocationStmt)
+			if isExpr != tt.wantExpr {
+				t.Errorf("ParseInput() last statement is expression = %v, want %v", isExpr, tt.wantExpr)
+			}
+		})
+	}
+}
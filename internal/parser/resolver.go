@@ -0,0 +1,474 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"sort"
+)
+
+// Resolve assigns a storage location to every variable of the program, which must be a single module:
+// the top-level declarations become globals of the module, all other variables become slots in the
+// frame of the declaring function. Variables of enclosing functions referred to by a function literal
+// are stored in cells that the function captures explicitly.
+// constants are the names of the constants predefined by the interpreter by index, isBuiltin
+// reports whether a name refers to a builtin function.
+// Redeclarations and undeclared identifiers are reported as *lang.Error.
+// If program.Globals is not empty, its names are globals declared before the program, e.g. by the
+// previous inputs of an interactive session. They are visible to the program and may be redeclared.
+func Resolve(program Program, constants []string, isBuiltin func(name string) bool) (Program, error) {
+	program, errs := ResolveAll(program, constants, isBuiltin)
+	if len(errs) > 0 {
+		return Program{}, errs[0]
+	}
+	return program, nil
+}
+
+// ResolveAll resolves the program like Resolve, but continues after an error and returns all errors
+// ordered by position. Identifiers that have not been declared refer to placeholder variables, so that
+// the returned program can still be analyzed. It must not be executed if errors have been returned.
+func ResolveAll(program Program, constants []string, isBuiltin func(name string) bool) (Program, []error) {
+	r := resolver{
+		constants:   make(map[string]*Var),
+		globals:     make(map[string]*Var),
+		isBuiltin:   isBuiltin,
+		predeclared: len(program.Globals),
+	}
+	for i, name := range constants {
+		r.constants[name] = &Var{Kind: ConstantVar, Index: i}
+	}
+	program.Globals = append([]string(nil), program.Globals...)
+	for i, name := range program.Globals {
+		r.globals[name] = &Var{Kind: GlobalVar, Index: i}
+	}
+	// functions may refer to top-level declarations that follow them
+	for _, stmt := range program.Stmts {
+		if decl, ok := stmt.(DeclStmt); ok {
+			if _, exists := r.globals[decl.Ident]; !exists {
+				r.globals[decl.Ident] = &Var{Kind: GlobalVar, Index: len(program.Globals)}
+				program.Globals = append(program.Globals, decl.Ident)
+			}
+		}
+	}
+	r.fn = &funcScope{frame: &Frame{}}
+	r.pushBlock()
+	program.Stmts = r.stmts(program.Stmts)
+	program.Frame = r.fn.frame
+	sort.SliceStable(r.errs, func(i, j int) bool {
+		a, b := r.errs[i].(*lang.Error), r.errs[j].(*lang.Error)
+		return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+	})
+	return program, r.errs
+}
+
+// ResolveExpr resolves the variables of an expression that is evaluated in the frame of a running
+// function, e.g. by a debugger. vars are the variables visible to the expression by name, size is the
+// number of local slots of the frame. Returns the resolved expression and the number of local slots
+// required to evaluate it, which is greater than size if the expression declares variables.
+// Function literals are reported as error since they cannot capture the variables of a running frame.
+func ResolveExpr(expr Expression, vars map[string]*Var, size int, isBuiltin func(name string) bool) (Expression, int, error) {
+	r := resolver{
+		constants: make(map[string]*Var),
+		globals:   make(map[string]*Var),
+		isBuiltin: isBuiltin,
+	}
+	Inspect(expr, func(node Node) bool {
+		if fn, ok := node.(FunctionExpr); ok {
+			r.errorAt(fn.tok, "function literals cannot be evaluated here")
+		}
+		return len(r.errs) == 0
+	})
+	if len(r.errs) > 0 {
+		return nil, 0, r.errs[0]
+	}
+	r.fn = &funcScope{frame: &Frame{Size: size}}
+	r.pushBlock()
+	for ident, v := range vars {
+		r.fn.blocks[0][ident] = v
+	}
+	r.pushBlock() // variables declared by the expression are never globals
+	if expr = r.expr(expr); len(r.errs) > 0 {
+		return nil, 0, r.errs[0]
+	}
+	return expr, r.fn.frame.Size, nil
+}
+
+type resolver struct {
+	constants   map[string]*Var
+	globals     map[string]*Var // all top-level declarations of the module
+	isBuiltin   func(name string) bool
+	fn          *funcScope // the innermost function being resolved
+	predeclared int        // the number of globals declared before the program
+	errs        []error    // the errors encountered so far
+}
+
+// funcScope holds the variables visible in a function body being resolved.
+// The top-level code of the module is the outermost funcScope.
+type funcScope struct {
+	outer    *funcScope
+	frame    *Frame
+	blocks   []map[string]*Var // the nested blocks of the function, innermost last
+	captures map[*Var]*Var     // maps variables of the enclosing function to the captures of this function
+}
+
+// errorAt records an error at the position of tok, the resolution continues
+func (r *resolver) errorAt(tok lexer.Token, format string, args ...interface{}) {
+	r.errs = append(r.errs, &lang.Error{
+		Line: tok.LineNumber,
+		Col:  tok.Column,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *resolver) pushBlock() {
+	r.fn.blocks = append(r.fn.blocks, make(map[string]*Var))
+}
+
+func (r *resolver) popBlock() {
+	r.fn.blocks = r.fn.blocks[:len(r.fn.blocks)-1]
+}
+
+// declare creates the variable ident in the innermost block.
+// A redeclaration is reported and replaces the previous variable.
+func (r *resolver) declare(tok lexer.Token, ident string) *Var {
+	block := r.fn.blocks[len(r.fn.blocks)-1]
+	if _, exists := block[ident]; exists {
+		r.errorAt(tok, "identifier '%s' has already been declared in this scope", ident)
+	}
+	var v *Var
+	if r.fn.outer == nil && len(r.fn.blocks) == 1 {
+		v = r.globals[ident]
+	} else {
+		v = r.local()
+	}
+	block[ident] = v
+	return v
+}
+
+// local allocates a slot in the frame of the innermost function
+func (r *resolver) local() *Var {
+	v := &Var{Kind: LocalVar, Index: r.fn.frame.Size}
+	r.fn.frame.Size++
+	return v
+}
+
+// lookup returns the variable ident refers to. If ident has not been declared, the error is
+// reported and a placeholder variable is returned.
+func (r *resolver) lookup(tok lexer.Token, ident string) *Var {
+	if v := r.fn.lookup(ident); v != nil {
+		return v
+	}
+	if v, ok := r.globals[ident]; ok && (r.fn.outer != nil || v.Index < r.predeclared) {
+		return v
+	}
+	if v, ok := r.constants[ident]; ok {
+		return v
+	}
+	r.errorAt(tok, "identifier '%s' not found", ident)
+	return r.local()
+}
+
+// lookup searches the blocks of fs and its enclosing functions for ident.
+// local variables of enclosing functions are turned into cells and captured.
+func (fs *funcScope) lookup(ident string) *Var {
+	for i := len(fs.blocks) - 1; i >= 0; i-- {
+		if v, ok := fs.blocks[i][ident]; ok {
+			return v
+		}
+	}
+	if fs.outer == nil {
+		return nil
+	}
+	v := fs.outer.lookup(ident)
+	if v == nil || v.Kind == GlobalVar {
+		return v
+	}
+	if v.Kind == LocalVar {
+		v.Kind, v.Index = CellVar, fs.outer.frame.Cells
+		fs.outer.frame.Cells++
+	}
+	if capture, ok := fs.captures[v]; ok {
+		return capture
+	}
+	capture := &Var{Kind: CaptureVar, Index: len(fs.frame.Captures)}
+	fs.frame.Captures = append(fs.frame.Captures, v)
+	fs.captures[v] = capture
+	return capture
+}
+
+func (r *resolver) stmts(stmts []Statement) []Statement {
+	resolved := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		resolved[i] = r.stmt(stmt)
+	}
+	return resolved
+}
+
+// block resolves stmts in a new block. nil stays nil, so that missing else branches are preserved.
+func (r *resolver) block(stmts []Statement) []Statement {
+	if stmts == nil {
+		return nil
+	}
+	r.pushBlock()
+	defer r.popBlock()
+	return r.stmts(stmts)
+}
+
+// loop resolves the loop variable ident and the loop body stmts, which share a new block
+func (r *resolver) loop(tok lexer.Token, ident string, stmts []Statement) (*Var, []Statement) {
+	r.pushBlock()
+	defer r.popBlock()
+	v := r.declare(tok, ident)
+	return v, r.stmts(stmts)
+}
+
+func (r *resolver) stmt(stmt Statement) Statement {
+	switch s := stmt.(type) {
+	case DeclStmt:
+		if s.Param && (r.fn.outer != nil || len(r.fn.blocks) > 1) {
+			r.errorAt(s.tok, "parameter '%s' must be declared at the top level", s.Ident)
+		}
+		if _, ok := s.Rhs.(FunctionExpr); ok {
+			// declare functions first, so that they can invoke themselves
+			s.Var = r.declare(s.tok, s.Ident)
+			s.Rhs = r.expr(s.Rhs)
+			return s
+		}
+		s.Rhs = r.expr(s.Rhs)
+		s.Var = r.declare(s.tok, s.Ident)
+		return s
+
+	case AssignStmt:
+		s.Rhs = r.expr(s.Rhs)
+		s.Var = r.lookup(s.tok, s.Ident)
+		return s
+
+	case IndexedAssignStmt:
+		s.Var = r.lookup(s.tok, s.Ident)
+		s.Index = r.expr(s.Index)
+		s.Rhs = r.expr(s.Rhs)
+		return s
+
+	case PixelAssignStmt:
+		s.Lhs = r.expr(s.Lhs)
+		s.Rhs = r.expr(s.Rhs)
+		return s
+
+	case InvocationStmt:
+		s.Invocation = r.expr(s.Invocation)
+		return s
+
+	case IfStmt:
+		s.Cond = r.expr(s.Cond)
+		s.TrueStmts = r.block(s.TrueStmts)
+		s.FalseStmts = r.block(s.FalseStmts)
+		return s
+
+	case ForStmt:
+		s.Collection = r.expr(s.Collection)
+		s.Var, s.Stmts = r.loop(s.tok, s.Ident, s.Stmts)
+		return s
+
+	case ParallelForStmt:
+		s.Collection = r.expr(s.Collection)
+		reductions := make([]Reduction, len(s.Reductions))
+		for i, red := range s.Reductions {
+			red.Var = r.lookup(s.tok, red.Ident)
+			if ident, ok := red.Merge.(IdentExpr); ok && r.isBuiltin(ident.Ident) {
+				reductions[i] = red // builtin merge functions are invoked by name
+				continue
+			}
+			if red.Merge != nil {
+				red.Merge = r.expr(red.Merge)
+			}
+			reductions[i] = red
+		}
+		s.Reductions = reductions
+		s.Var, s.Stmts = r.loop(s.tok, s.Ident, s.Stmts)
+		return s
+
+	case ForRangeStmt:
+		s.Lower = r.expr(s.Lower)
+		s.Upper = r.expr(s.Upper)
+		s.Step = r.expr(s.Step)
+		s.Var, s.Stmts = r.loop(s.tok, s.Ident, s.Stmts)
+		return s
+
+	case WhileStmt:
+		s.Cond = r.expr(s.Cond)
+		s.Stmts = r.block(s.Stmts)
+		return s
+
+	case YieldStmt:
+		s.Result = r.expr(s.Result)
+		return s
+
+	case LogStmt:
+		s.Args = r.exprs(s.Args)
+		return s
+
+	case ReturnStmt:
+		s.Result = r.expr(s.Result)
+		return s
+	}
+	return stmt
+}
+
+func (r *resolver) exprs(exprs []Expression) []Expression {
+	if exprs == nil {
+		return nil
+	}
+	resolved := make([]Expression, len(exprs))
+	for i, expr := range exprs {
+		resolved[i] = r.expr(expr)
+	}
+	return resolved
+}
+
+// binary resolves the operands of a binary expression
+func (r *resolver) binary(left Expression, right Expression) (Expression, Expression) {
+	return r.expr(left), r.expr(right)
+}
+
+func (r *resolver) expr(expr Expression) Expression {
+	switch e := expr.(type) {
+	case TernaryExpr:
+		e.Cond = r.expr(e.Cond)
+		e.TrueResult, e.FalseResult = r.binary(e.TrueResult, e.FalseResult)
+		return e
+	case OrExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case AndExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case EqExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case NeqExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case GtExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case GeExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case LtExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case LeExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case ConcatExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case AddExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case SubExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case MulExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case DivExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case ModExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case InExpr:
+		e.Left, e.Right = r.binary(e.Left, e.Right)
+		return e
+	case PosExpr:
+		e.X, e.Y = r.binary(e.X, e.Y)
+		return e
+	case NegExpr:
+		e.Inner = r.expr(e.Inner)
+		return e
+	case NotExpr:
+		e.Inner = r.expr(e.Inner)
+		return e
+	case AtExpr:
+		e.Inner = r.expr(e.Inner)
+		return e
+	case SampleExpr:
+		e.Image, e.Point = r.binary(e.Image, e.Point)
+		return e
+	case MemberExpr:
+		e.Recvr = r.expr(e.Recvr)
+		return e
+	case IndexExpr:
+		e.Recvr, e.Index = r.binary(e.Recvr, e.Index)
+		return e
+	case IndexRangeExpr:
+		e.Recvr = r.expr(e.Recvr)
+		e.Lower, e.Upper = r.binary(e.Lower, e.Upper)
+		return e
+
+	case IdentExpr:
+		e.Var = r.lookup(e.tok, e.Ident)
+		return e
+
+	case InvokeExpr:
+		e.Args = r.exprs(e.Args)
+		if r.isBuiltin(e.FuncName) {
+			return e
+		}
+		e.Var = r.lookup(e.tok, e.FuncName)
+		return e
+
+	case CallExpr:
+		e.Callee = r.expr(e.Callee)
+		e.Args = r.exprs(e.Args)
+		return e
+
+	case KernelExpr:
+		e.Elements = r.exprs(e.Elements)
+		return e
+
+	case ListExpr:
+		e.Elements = r.exprs(e.Elements)
+		return e
+
+	case HashMapExpr:
+		entries := make([]HashEntryExpr, len(e.Entries))
+		for i, entry := range e.Entries {
+			entry.Key, entry.Value = r.binary(entry.Key, entry.Value)
+			entries[i] = entry
+		}
+		e.Entries = entries
+		return e
+
+	case FunctionExpr:
+		return r.function(e)
+
+	case PipelineExpr:
+		e.Left = r.expr(e.Left)
+		r.pushBlock()
+		defer r.popBlock()
+		e.Var = r.declare(e.tok, lexer.TokenTypeName(lexer.TTDollar))
+		e.Right = r.expr(e.Right)
+		return e
+	}
+	return expr
+}
+
+// function resolves a function literal in a new funcScope
+func (r *resolver) function(e FunctionExpr) Expression {
+	fs := &funcScope{
+		outer:    r.fn,
+		frame:    &Frame{},
+		captures: make(map[*Var]*Var),
+	}
+	r.fn = fs
+	defer func() { r.fn = fs.outer }()
+	r.pushBlock()
+	for _, name := range e.ParameterNames {
+		fs.frame.Params = append(fs.frame.Params, r.declare(e.tok, name))
+	}
+	e.Body = r.stmts(e.Body)
+	e.Frame = fs.frame
+	return e
+}
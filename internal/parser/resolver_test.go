@@ -0,0 +1,241 @@
+package parser
+
+import (
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"reflect"
+	"testing"
+)
+
+func resolve(src string) (Program, error) {
+	tokens, err := lexer.Lex(src)
+	if err != nil {
+		return Program{}, err
+	}
+	program, err := Parse(tokens, false)
+	if err != nil {
+		return Program{}, err
+	}
+	return Resolve(program, []string{"Pi"}, func(name string) bool { return name == "sqrt" })
+}
+
+func Test_Resolve_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string // empty if no error is expected
+	}{
+		{
+			name: "shadowing",
+			src:  "x := 1 if x > 0 { x := 2 }",
+		},
+		{
+			name: "forward_reference_in_function",
+			src:  "f := fn() -> g() g := fn() -> 1",
+		},
+		{
+			name: "recursion",
+			src:  "f := fn(n) { if n > 0 { return f(n - 1) } return n }",
+		},
+		{
+			name: "constant_and_builtin",
+			src:  "x := sqrt(Pi)",
+		},
+		{
+			name:    "redeclaration",
+			src:     "x := 1\nx := 2",
+			wantErr: "2:1: identifier 'x' has already been declared in this scope",
+		},
+		{
+			name:    "parameter_redeclaration",
+			src:     "f := fn(a) {\n  a := 1\n}",
+			wantErr: "2:3: identifier 'a' has already been declared in this scope",
+		},
+		{
+			name:    "undeclared",
+			src:     "x := 1\ny := [x, z]",
+			wantErr: "2:10: identifier 'z' not found",
+		},
+		{
+			name:    "block_local",
+			src:     "if true { y := 1 }\nx := y",
+			wantErr: "2:6: identifier 'y' not found",
+		},
+		{
+			name:    "use_before_declaration",
+			src:     "x := y\ny := 1",
+			wantErr: "1:6: identifier 'y' not found",
+		},
+		{
+			name: "param",
+			src:  "param x := 1\ny := x + 1",
+		},
+		{
+			name:    "nested_param",
+			src:     "if true {\n  param x := 1\n}",
+			wantErr: "2:3: parameter 'x' must be declared at the top level",
+		},
+		{
+			name:    "param_in_function",
+			src:     "f := fn() {\n  param x := 1\n}",
+			wantErr: "2:3: parameter 'x' must be declared at the top level",
+		},
+		{
+			name:    "unknown_function",
+			src:     "x := foo(1)",
+			wantErr: "1:6: identifier 'foo' not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolve(tt.src)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Resolve() error = %v, want nil", err)
+				}
+				return
+			}
+			if _, ok := err.(*lang.Error); !ok || err.Error() != tt.wantErr {
+				t.Errorf("Resolve() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_ResolveAll(t *testing.T) {
+	src := `x := y
+f := fn(a, a) {
+    b := z
+    return b
+}
+w = x`
+	tokens, _ := lexer.Lex(src)
+	program, err := Parse(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, errs := ResolveAll(program, nil, func(name string) bool { return false })
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"1:6: identifier 'y' not found",
+		"2:6: identifier 'a' has already been declared in this scope",
+		"3:10: identifier 'z' not found",
+		"6:1: identifier 'w' not found",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ResolveAll() errors = %#v, want %#v", got, want)
+	}
+	if ident := program.Stmts[0].(DeclStmt).Rhs.(IdentExpr); ident.Var == nil {
+		t.Errorf("ResolveAll() left the undeclared identifier 'y' unresolved")
+	}
+}
+
+func Test_Resolve_frames(t *testing.T) {
+	src := `a := 1
+f := fn(x) {
+    y := x
+    unused := 0
+    return fn() -> y + a
+}`
+	program, err := resolve(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "f"}; !reflect.DeepEqual(program.Globals, want) {
+		t.Errorf("Resolve() globals = %v, want %v", program.Globals, want)
+	}
+	if want := (&Frame{}); !reflect.DeepEqual(program.Frame, want) {
+		t.Errorf("Resolve() top-level frame = %#v, want %#v", program.Frame, want)
+	}
+
+	outer := program.Stmts[1].(DeclStmt).Rhs.(FunctionExpr)
+	y := &Var{Kind: CellVar, Index: 0}
+	wantOuter := &Frame{
+		Size:   3, // x, y (moved to a cell) and unused
+		Cells:  1,
+		Params: []*Var{{Kind: LocalVar, Index: 0}},
+	}
+	if !reflect.DeepEqual(outer.Frame, wantOuter) {
+		t.Errorf("Resolve() outer frame = %#v, want %#v", outer.Frame, wantOuter)
+	}
+
+	inner := outer.Body[2].(ReturnStmt).Result.(FunctionExpr)
+	wantInner := &Frame{Captures: []*Var{y}}
+	if !reflect.DeepEqual(inner.Frame, wantInner) {
+		t.Errorf("Resolve() inner frame = %#v, want %#v", inner.Frame, wantInner)
+	}
+	sum := inner.Body[0].(ReturnStmt).Result.(AddExpr)
+	if got, want := sum.Left.(IdentExpr).Var, (&Var{Kind: CaptureVar, Index: 0}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve() y in closure = %#v, want %#v", got, want)
+	}
+	if got, want := sum.Right.(IdentExpr).Var, (&Var{Kind: GlobalVar, Index: 0}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve() a in closure = %#v, want %#v", got, want)
+	}
+}
+
+func Test_Resolve_predeclared(t *testing.T) {
+	tokens, _ := lexer.Lex("y := x + 1\nx := y")
+	program, err := Parse(tokens, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	program.Globals = []string{"x"}
+	program, err = Resolve(program, nil, nil)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if want := []string{"x", "y"}; !reflect.DeepEqual(program.Globals, want) {
+		t.Errorf("Resolve() globals = %v, want %v", program.Globals, want)
+	}
+	redecl := program.Stmts[1].(DeclStmt)
+	if want := (&Var{Kind: GlobalVar, Index: 0}); !reflect.DeepEqual(redecl.Var, want) {
+		t.Errorf("Resolve() redeclared x = %#v, want %#v", redecl.Var, want)
+	}
+}
+
+func Test_ResolveExpr(t *testing.T) {
+	x := &Var{Kind: LocalVar, Index: 1}
+	y := &Var{Kind: CaptureVar, Index: 0}
+	vars := map[string]*Var{"x": x, "y": y}
+	parse := func(src string) Expression {
+		tokens, err := lexer.Lex(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		program, err := ParseInput(tokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return program.Stmts[0].(InvocationStmt).Invocation
+	}
+
+	expr, size, err := ResolveExpr(parse("x + y"), vars, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := expr.(AddExpr)
+	if sum.Left.(IdentExpr).Var != x || sum.Right.(IdentExpr).Var != y || size != 2 {
+		t.Errorf("ResolveExpr() = %#v, %d, want x + y in 2 slots", expr, size)
+	}
+
+	expr, size, err = ResolveExpr(parse("x | $ * 2"), vars, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := expr.(PipelineExpr).Var, (&Var{Kind: LocalVar, Index: 2}); !reflect.DeepEqual(got, want) || size != 3 {
+		t.Errorf("ResolveExpr() $ = %#v in %d slots, want %#v in 3 slots", got, size, want)
+	}
+
+	if _, _, err := ResolveExpr(parse("z"), vars, 2, nil); err == nil {
+		t.Errorf("ResolveExpr() error = nil, want error for unknown identifier")
+	}
+	if _, _, err := ResolveExpr(parse("fn() -> x"), vars, 2, nil); err == nil {
+		t.Errorf("ResolveExpr() error = nil, want error for function literal")
+	}
+	if x.Kind != LocalVar || y.Kind != CaptureVar {
+		t.Errorf("ResolveExpr() modified the variables of the frame")
+	}
+}
@@ -0,0 +1,136 @@
+package parser
+
+import "github.com/smackem/ylang/internal/lexer"
+
+// Node is a statement or an expression
+type Node interface {
+	Token() lexer.Token
+}
+
+// Inspect traverses the syntax tree rooted at node in depth-first order, like ast.Inspect
+// of the go standard library: it calls f(node); if f returns true, Inspect invokes f
+// recursively for each of the non-nil children of node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	if !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case DeclStmt:
+		Inspect(n.Rhs, f)
+	case AssignStmt:
+		Inspect(n.Rhs, f)
+	case IndexedAssignStmt:
+		inspectExprs(f, n.Index, n.Rhs)
+	case PixelAssignStmt:
+		inspectExprs(f, n.Lhs, n.Rhs)
+	case InvocationStmt:
+		Inspect(n.Invocation, f)
+	case IfStmt:
+		Inspect(n.Cond, f)
+		InspectStmts(n.TrueStmts, f)
+		InspectStmts(n.FalseStmts, f)
+	case ForStmt:
+		Inspect(n.Collection, f)
+		InspectStmts(n.Stmts, f)
+	case ParallelForStmt:
+		Inspect(n.Collection, f)
+		for _, r := range n.Reductions {
+			inspectExprs(f, r.Merge)
+		}
+		InspectStmts(n.Stmts, f)
+	case ForRangeStmt:
+		inspectExprs(f, n.Lower, n.Upper, n.Step)
+		InspectStmts(n.Stmts, f)
+	case WhileStmt:
+		Inspect(n.Cond, f)
+		InspectStmts(n.Stmts, f)
+	case YieldStmt:
+		Inspect(n.Result, f)
+	case LogStmt:
+		inspectExprs(f, n.Args...)
+	case ReturnStmt:
+		inspectExprs(f, n.Result)
+
+	case TernaryExpr:
+		inspectExprs(f, n.Cond, n.TrueResult, n.FalseResult)
+	case PipelineExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case OrExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case AndExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case EqExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case NeqExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case GtExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case GeExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case LtExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case LeExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case ConcatExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case AddExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case SubExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case MulExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case DivExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case ModExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case InExpr:
+		inspectExprs(f, n.Left, n.Right)
+	case NegExpr:
+		Inspect(n.Inner, f)
+	case NotExpr:
+		Inspect(n.Inner, f)
+	case AtExpr:
+		Inspect(n.Inner, f)
+	case SampleExpr:
+		inspectExprs(f, n.Image, n.Point)
+	case PosExpr:
+		inspectExprs(f, n.X, n.Y)
+	case MemberExpr:
+		Inspect(n.Recvr, f)
+	case IndexExpr:
+		inspectExprs(f, n.Recvr, n.Index)
+	case IndexRangeExpr:
+		inspectExprs(f, n.Recvr, n.Lower, n.Upper)
+	case InvokeExpr:
+		inspectExprs(f, n.Args...)
+	case CallExpr:
+		Inspect(n.Callee, f)
+		inspectExprs(f, n.Args...)
+	case KernelExpr:
+		inspectExprs(f, n.Elements...)
+	case ListExpr:
+		inspectExprs(f, n.Elements...)
+	case HashMapExpr:
+		for _, entry := range n.Entries {
+			inspectExprs(f, entry.Key, entry.Value)
+		}
+	case FunctionExpr:
+		InspectStmts(n.Body, f)
+	}
+	f(nil)
+}
+
+// InspectStmts calls Inspect for each of the statements
+func InspectStmts(stmts []Statement, f func(Node) bool) {
+	for _, stmt := range stmts {
+		Inspect(stmt, f)
+	}
+}
+
+func inspectExprs(f func(Node) bool, exprs ...Expression) {
+	for _, expr := range exprs {
+		if expr != nil {
+			Inspect(expr, f)
+		}
+	}
+}
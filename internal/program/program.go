@@ -1,25 +1,261 @@
 package program
 
 import (
+	"context"
+	"fmt"
+	"github.com/smackem/ylang/internal/emitter"
 	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lang"
 	"github.com/smackem/ylang/internal/lexer"
 	"github.com/smackem/ylang/internal/parser"
+	"sort"
+	"strings"
 )
 
 // Compile compiles the given source code into a executable Program.
+// The source code must not contain import expressions.
+// A non-nil error is always of type *lang.Error.
 func Compile(src string) (parser.Program, error) {
-	tokens, err := lexer.Lex(src)
+	return CompileModule("", src, nil)
+}
+
+// CompileModule compiles the given source code into a executable Program,
+// loading all transitively imported modules through the specified Resolver.
+// name is the canonical name of the source code, which is passed to the resolver
+// as importer - it may be empty if the main script has no name.
+// A non-nil error is always of type *lang.Error.
+func CompileModule(name string, src string, resolver Resolver) (parser.Program, error) {
+	return CompileModuleWith(name, src, resolver, nil)
+}
+
+// CompileModuleWith compiles the given source code like CompileModule, resolving the script and all
+// modules it imports against the builtin functions and constants added to registry as well.
+// The Program must be executed with the registry in its options.
+func CompileModuleWith(name string, src string, resolver Resolver, registry *interpreter.Registry) (parser.Program, error) {
+	c := compiler{
+		resolver: resolver,
+		modules:  make(map[string]parser.Program),
+		registry: registry,
+	}
+	prog, err := c.compile(name, src)
 	if err != nil {
 		return parser.Program{}, err
 	}
-	prog, err := parser.Parse(tokens, false)
+	if err := c.checkParallelLoops(name, prog); err != nil {
+		return parser.Program{}, err
+	}
+	prog.Modules = c.modules
+	return prog, nil
+}
+
+// CompileInput compiles the input of an interactive session like CompileModule. The input may
+// end with an expression (see parser.ParseInput) and refer to the specified globals, which have
+// been declared by previous inputs.
+func CompileInput(src string, globals []string, resolver Resolver) (parser.Program, error) {
+	c := compiler{
+		resolver: resolver,
+		modules:  make(map[string]parser.Program),
+		input:    true,
+		globals:  globals,
+	}
+	prog, err := c.compile("", src)
 	if err != nil {
 		return parser.Program{}, err
 	}
+	if err := c.checkParallelLoops("", prog); err != nil {
+		return parser.Program{}, err
+	}
+	prog.Modules = c.modules
 	return prog, nil
 }
 
 // Execute executes the Program against the specified Bitmap.
-func Execute(prog parser.Program, bitmap interpreter.BitmapContext) error {
-	return interpreter.Interpret(prog, bitmap)
+// The execution is stopped if ctx is done or the program exceeds a limit of options,
+// the returned error then wraps an *interpreter.LimitError.
+// A non-nil error is always of type *lang.Error.
+func Execute(ctx context.Context, prog parser.Program, bitmap interpreter.BitmapContext, options interpreter.Options) error {
+	return interpreter.Interpret(ctx, prog, bitmap, options)
+}
+
+// Check compiles the given source code like CompileModule and analyzes the program and all modules
+// it imports with interpreter.Check. All identifiers of the program that cannot be resolved are
+// reported, followed by the diagnostics of the analysis. If the program does not parse or an
+// imported module does not compile, the compilation error is returned as the only diagnostic.
+func Check(name string, src string, resolver Resolver) []lang.Diagnostic {
+	return CheckWith(name, src, resolver, nil)
+}
+
+// CheckWith analyzes the given source code like Check, compiling it with CompileModuleWith.
+func CheckWith(name string, src string, resolver Resolver, registry *interpreter.Registry) []lang.Diagnostic {
+	c := compiler{
+		resolver: resolver,
+		modules:  make(map[string]parser.Program),
+		registry: registry,
+		check:    true,
+	}
+	prog, err := c.compile(name, src)
+	if err != nil {
+		return []lang.Diagnostic{compileDiagnostic(err)}
+	}
+	if len(c.errs) == 0 {
+		if err := c.checkParallelLoops(name, prog); err != nil {
+			c.errs = append(c.errs, err)
+		}
+	}
+	prog.Modules = c.modules
+	var diags []lang.Diagnostic
+	for _, err := range c.errs {
+		diags = append(diags, compileDiagnostic(err))
+	}
+	diags = append(diags, interpreter.Check(prog)...)
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+	})
+	names := make([]string, 0, len(prog.Modules))
+	for moduleName := range prog.Modules {
+		names = append(names, moduleName)
+	}
+	sort.Strings(names)
+	for _, moduleName := range names {
+		diags = append(diags, interpreter.Check(prog.Modules[moduleName])...)
+	}
+	return diags
+}
+
+// compileDiagnostic returns the diagnostic reporting a compilation error
+func compileDiagnostic(err error) lang.Diagnostic {
+	lerr := lang.ErrorAt(err, 0, 0)
+	return lang.Diagnostic{
+		File:     lerr.File,
+		Line:     lerr.Line,
+		Col:      lerr.Col,
+		Severity: lang.SeverityError,
+		Code:     interpreter.CheckCompile,
+		Msg:      lerr.Msg,
+	}
+}
+
+// Emit compiles the Program and all modules it imports into bytecode.
+func Emit(prog parser.Program) emitter.Program {
+	return emitter.Emit(prog)
+}
+
+// Run executes bytecode returned by Emit against the specified Bitmap like Execute.
+// A non-nil error is always of type *lang.Error.
+func Run(ctx context.Context, code emitter.Program, bitmap interpreter.BitmapContext, options interpreter.Options) error {
+	return interpreter.Run(ctx, code, bitmap, options)
+}
+
+type compiler struct {
+	resolver Resolver
+	modules  map[string]parser.Program
+	visiting []string // the chain of modules being compiled, used to detect cyclic imports
+	input    bool     // true if the main script is the input of an interactive session
+	globals  []string // the globals declared before the input
+	registry *interpreter.Registry
+	check    bool    // true if the main script is compiled to be checked, see CheckWith
+	errs     []error // the resolution errors of the main script if check is set
+}
+
+func (c *compiler) compile(name string, src string) (parser.Program, error) {
+	tokens, err := lexer.Lex(src)
+	if err != nil {
+		return parser.Program{}, withFile(lang.ErrorAt(err, 0, 0), name)
+	}
+	var prog parser.Program
+	if c.input && len(c.visiting) == 0 {
+		prog, err = parser.ParseInput(tokens)
+		prog.Globals = c.globals
+	} else {
+		prog, err = parser.Parse(tokens, false)
+	}
+	if err != nil {
+		return parser.Program{}, withFile(lang.ErrorAt(err, 0, 0), name)
+	}
+	// the parallel for loops are checked when all modules have been compiled
+	var errs []error
+	prog, errs = interpreter.ResolveAllWith(prog, c.registry)
+	if c.check && len(c.visiting) == 0 {
+		// report all resolution errors and analyze the program nevertheless
+		for _, err := range errs {
+			c.errs = append(c.errs, withFile(lang.ErrorAt(err, 0, 0), name))
+		}
+	} else if len(errs) > 0 {
+		return parser.Program{}, withFile(lang.ErrorAt(errs[0], 0, 0), name)
+	}
+	if len(c.visiting) > 0 && len(prog.Params) > 0 {
+		tok := prog.Params[0].Token()
+		return parser.Program{}, withFile(&lang.Error{
+			Line: tok.LineNumber,
+			Col:  tok.Column,
+			Msg:  fmt.Sprintf("parameter '%s' cannot be declared by a module", prog.Params[0].Ident),
+		}, name)
+	}
+	prog.File = name
+	if len(prog.Imports) == 0 {
+		return prog, nil
+	}
+
+	c.visiting = append(c.visiting, name)
+	defer func() { c.visiting = c.visiting[:len(c.visiting)-1] }()
+	prog.ImportNames = make(map[string]string)
+
+	for _, imp := range prog.Imports {
+		if _, ok := prog.ImportNames[imp.Path]; ok {
+			continue
+		}
+		moduleName, err := c.compileImport(name, imp.Path)
+		if err != nil {
+			tok := imp.Token()
+			return parser.Program{}, withFile(lang.ErrorAt(err, tok.LineNumber, tok.Column), name)
+		}
+		prog.ImportNames[imp.Path] = moduleName
+	}
+	return prog, nil
+}
+
+// checkParallelLoops checks the parallel for loops of the compiled main script named name
+// and all modules it imports, see interpreter.CheckParallelLoops
+func (c *compiler) checkParallelLoops(name string, prog parser.Program) error {
+	if err := interpreter.CheckParallelLoops(prog, c.modules, c.registry); err != nil {
+		return withFile(lang.ErrorAt(err, 0, 0), name)
+	}
+	return nil
+}
+
+func (c *compiler) compileImport(importer string, path string) (string, error) {
+	if c.resolver == nil {
+		return "", fmt.Errorf("cannot import '%s': imports are not supported here", path)
+	}
+	moduleName, err := c.resolver.Resolve(importer, path)
+	if err != nil {
+		return "", err
+	}
+	for i, visiting := range c.visiting {
+		if visiting == moduleName {
+			chain := append(append([]string(nil), c.visiting[i:]...), moduleName)
+			return "", fmt.Errorf("cyclic import: %s", strings.Join(chain, " -> "))
+		}
+	}
+	if _, ok := c.modules[moduleName]; ok {
+		return moduleName, nil
+	}
+	src, err := c.resolver.Load(moduleName)
+	if err != nil {
+		return "", err
+	}
+	module, err := c.compile(moduleName, src)
+	if err != nil {
+		return "", err
+	}
+	c.modules[moduleName] = module
+	return moduleName, nil
+}
+
+func withFile(err *lang.Error, file string) *lang.Error {
+	if err.File == "" {
+		err.File = file
+	}
+	return err
 }
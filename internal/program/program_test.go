@@ -0,0 +1,479 @@
+package program
+
+import (
+	"context"
+	"errors"
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/parser"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logBitmap is a BitmapContext of size 0x0 that records log output
+type logBitmap struct {
+	interpreter.BitmapContext
+	log []string
+}
+
+func (b *logBitmap) SourceWidth() int   { return 0 }
+func (b *logBitmap) SourceHeight() int  { return 0 }
+func (b *logBitmap) Log(message string) { b.log = append(b.log, message) }
+func (b *logBitmap) TargetWidth() int   { return 0 }
+func (b *logBitmap) TargetHeight() int  { return 0 }
+
+var engines = []struct {
+	name    string
+	execute func(ctx context.Context, prog parser.Program, bitmap interpreter.BitmapContext, options interpreter.Options) error
+}{
+	{"interpreter", Execute},
+	{"vm", func(ctx context.Context, prog parser.Program, bitmap interpreter.BitmapContext, options interpreter.Options) error {
+		return Run(ctx, Emit(prog), bitmap, options)
+	}},
+}
+
+func Test_CompileModule_execute(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		modules BundleResolver
+		want    []string
+	}{
+		{
+			name: "member_function",
+			src: `m := import "math.ylang"
+log(m.twice(21), " ", m.Answer)`,
+			modules: BundleResolver{
+				"math.ylang": `Answer := 42
+twice := fn(x) -> x * 2`,
+			},
+			want: []string{"42 42"},
+		},
+		{
+			name: "evaluated_once",
+			src: `a := import "lib.ylang"
+b := import "lib.ylang"
+log(a.counter(), b.counter())`,
+			modules: BundleResolver{
+				"lib.ylang": `log("loading")
+n := 0
+counter := fn() {
+    n = n + 1
+    return n
+}`,
+			},
+			want: []string{"loading", "12"},
+		},
+		{
+			name: "equality",
+			src: `a := import "lib.ylang"
+b := import "lib.ylang"
+c := import "other.ylang"
+log(a == b, " ", a == c, " ", a != c)`,
+			modules: BundleResolver{
+				"lib.ylang":   `x := 1`,
+				"other.ylang": `x := 1`,
+			},
+			want: []string{"true false true"},
+		},
+		{
+			name: "module_globals_are_private",
+			src: `x := 1
+m := import "lib.ylang"
+log(m.getX(), x)`,
+			modules: BundleResolver{
+				"lib.ylang": `x := 2
+getX := fn() -> x`,
+			},
+			want: []string{"21"},
+		},
+		{
+			name: "relative_to_importer",
+			src: `m := import "lib/a.ylang"
+log(m.value)`,
+			modules: BundleResolver{
+				"lib/a.ylang": `b := import "b.ylang"
+value := b.value + 1`,
+				"lib/b.ylang": `value := 1`,
+			},
+			want: []string{"2"},
+		},
+		{
+			name: "search_bundle_root",
+			src: `m := import "lib/a.ylang"
+log(m.value)`,
+			modules: BundleResolver{
+				"lib/a.ylang": `b := import "b.ylang"
+value := b.value + 1`,
+				"b.ylang": `value := 10`,
+			},
+			want: []string{"11"},
+		},
+		{
+			name: "parallel_loop",
+			src: `m := import "math.ylang"
+n := 0
+parallel for x in [1, 2, 3] reduce n { n = n + m.twice(x) }
+log(n)`,
+			modules: BundleResolver{
+				"math.ylang": `twice := fn(x) -> x * 2`,
+			},
+			want: []string{"12"},
+		},
+	}
+	for _, tt := range tests {
+		for _, engine := range engines {
+			t.Run(engine.name+"/"+tt.name, func(t *testing.T) {
+				prog, err := CompileModule("", tt.src, tt.modules)
+				if err != nil {
+					t.Fatalf("CompileModule() error = %v", err)
+				}
+				bitmap := &logBitmap{}
+				if err := engine.execute(context.Background(), prog, bitmap, interpreter.Options{}); err != nil {
+					t.Fatalf("execute() error = %v", err)
+				}
+				if !reflect.DeepEqual(bitmap.log, tt.want) {
+					t.Errorf("execute() log = %#v, want %#v", bitmap.log, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func Test_CompileModule_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		modules BundleResolver
+		want    string
+	}{
+		{
+			name:    "not_found",
+			src:     `m := import "missing.ylang"`,
+			modules: BundleResolver{},
+			want:    "main.ylang:1:6: module 'missing.ylang' not found",
+		},
+		{
+			name: "cycle",
+			src:  `m := import "a.ylang"`,
+			modules: BundleResolver{
+				"a.ylang": `b := import "b.ylang"`,
+				"b.ylang": `a := import "a.ylang"`,
+			},
+			want: "b.ylang:1:6: cyclic import: a.ylang -> b.ylang -> a.ylang",
+		},
+		{
+			name: "syntax_error_in_module",
+			src:  `m := import "a.ylang"`,
+			modules: BundleResolver{
+				"a.ylang": `x := `,
+			},
+			want: "a.ylang:1:",
+		},
+		{
+			name: "param_in_module",
+			src:  `m := import "a.ylang"`,
+			modules: BundleResolver{
+				"a.ylang": "x := 1\nparam y := 2",
+			},
+			want: "a.ylang:2:1: parameter 'y' cannot be declared by a module",
+		},
+		{
+			name: "parallel_loop_modifies_module",
+			src: `m := import "a.ylang"
+parallel for x in [1, 2] { y := m.inc() }`,
+			modules: BundleResolver{
+				"a.ylang": `n := 0
+inc := fn() {
+    n = n + 1
+    return n
+}`,
+			},
+			want: "a.ylang:3:5: parallel for: cannot assign 'n'",
+		},
+		{
+			name: "parallel_loop_in_module",
+			src: `m := import "a.ylang"
+m.apply(fn(x) -> random(0, x))`,
+			modules: BundleResolver{
+				"a.ylang": `apply := fn(f) {
+    parallel for x in [1, 2] { y := f(x) }
+}`,
+			},
+			want: "main.ylang:2:18: parallel for: function 'random' is not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileModule("main.ylang", tt.src, tt.modules)
+			if err == nil {
+				t.Fatalf("CompileModule() error = nil, want %s", tt.want)
+			}
+			if _, ok := err.(*lang.Error); !ok {
+				t.Errorf("CompileModule() error type = %T, want *lang.Error", err)
+			}
+			if !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("CompileModule() error = %s, want %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Compile_import(t *testing.T) {
+	if _, err := Compile(`m := import "a.ylang"`); err == nil {
+		t.Errorf("Compile() error = nil, want error for import")
+	}
+}
+
+func Test_Execute_errorInModule(t *testing.T) {
+	prog, err := CompileModule("main.ylang", `m := import "lib.ylang"
+m.fail(1)`, BundleResolver{
+		"lib.ylang": `fail := fn(x) {
+    return x + true
+}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lang.TraceFrame{
+		{File: "main.ylang", Func: "<script>", Line: 2},
+		{File: "lib.ylang", Func: "fail", Line: 2},
+	}
+	for _, engine := range engines {
+		err = engine.execute(context.Background(), prog, &logBitmap{}, interpreter.Options{})
+		lerr, ok := err.(*lang.Error)
+		if !ok {
+			t.Fatalf("%s: execute() error = %v, want *lang.Error", engine.name, err)
+		}
+		if lerr.File != "lib.ylang" || lerr.Line != 2 {
+			t.Errorf("%s: execute() error = %s, want error at lib.ylang:2", engine.name, lerr)
+		}
+		if !reflect.DeepEqual(lerr.Trace, want) {
+			t.Errorf("%s: execute() trace = %#v, want %#v", engine.name, lerr.Trace, want)
+		}
+	}
+}
+
+func Test_Execute_limits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		src     string
+		ctx     context.Context
+		options interpreter.Options
+		want    string // the message of the error
+		wantErr error  // the error wrapped by the LimitError
+	}{
+		{
+			name:    "within_limits",
+			src:     `f := fn(n) { return list(n, 0) } log(f(10).count)`,
+			options: interpreter.Options{MaxSteps: 100, Timeout: time.Minute, MaxAllocation: 10, MaxCallDepth: 1},
+		},
+		{
+			name:    "steps",
+			src:     `n := 0 while true { n = n + 1 }`,
+			options: interpreter.Options{MaxSteps: 5000},
+			want:    "maximum number of steps (5000) exceeded",
+		},
+		{
+			name:    "timeout",
+			src:     `while true { }`,
+			options: interpreter.Options{Timeout: 10 * time.Millisecond},
+			want:    "execution stopped: context deadline exceeded",
+			wantErr: context.DeadlineExceeded,
+		},
+		{
+			name:    "cancelled",
+			src:     `log(1)`,
+			ctx:     cancelled,
+			want:    "execution stopped: context canceled",
+			wantErr: context.Canceled,
+		},
+		{
+			name:    "kernel",
+			src:     `k := kernel(100000, 100000, 0)`,
+			options: interpreter.Options{MaxAllocation: 1000000},
+			want:    "cannot allocate 10000000000 elements, the maximum is 1000000",
+		},
+		{
+			name:    "resize",
+			src:     `resize(1000000, 1000000)`,
+			options: interpreter.Options{MaxAllocation: 1000000},
+			want:    "cannot allocate 1000000000000 elements, the maximum is 1000000",
+		},
+		{
+			name:    "concat",
+			src:     `l := [1] while true { l = l :: l }`,
+			options: interpreter.Options{MaxAllocation: 1000000},
+			want:    "cannot allocate 1048576 elements, the maximum is 1000000",
+		},
+		{
+			name:    "literal",
+			src:     `h := {a: [1, 2, 3]}`,
+			options: interpreter.Options{MaxAllocation: 2},
+			want:    "cannot allocate 3 elements, the maximum is 2",
+		},
+		{
+			name: "call_depth",
+			src: `f := fn(n) {
+    return f(n + 1)
+}
+f(0)`,
+			options: interpreter.Options{MaxCallDepth: 50},
+			want:    "maximum call depth (50) exceeded",
+		},
+	}
+	for _, engine := range engines {
+		for _, tt := range tests {
+			t.Run(engine.name+"/"+tt.name, func(t *testing.T) {
+				prog, err := Compile(tt.src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctx := tt.ctx
+				if ctx == nil {
+					ctx = context.Background()
+				}
+				err = engine.execute(ctx, prog, &logBitmap{}, tt.options)
+				if tt.want == "" {
+					if err != nil {
+						t.Errorf("execute() error = %v", err)
+					}
+					return
+				}
+				var limitErr *interpreter.LimitError
+				if lerr, ok := err.(*lang.Error); !ok || lerr.Msg != tt.want || !errors.As(err, &limitErr) {
+					t.Fatalf("execute() error = %v, want LimitError %s", err, tt.want)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("execute() error = %v, want it to wrap %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func Test_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		modules BundleResolver
+		want    []string
+	}{
+		{
+			name: "clean",
+			src:  `m := import "lib.ylang" log(m.twice(2))`,
+			modules: BundleResolver{
+				"lib.ylang": `twice := fn(x) -> x * 2`,
+			},
+			want: nil,
+		},
+		{
+			name: "compile_error",
+			src:  `log(y)`,
+			want: []string{"main.ylang:1:5: error: identifier 'y' not found [compile]"},
+		},
+		{
+			name: "all_errors",
+			src: `log(y)
+log(rgb(1, 2))
+f := fn() {
+    z = 1
+}`,
+			want: []string{
+				"main.ylang:1:5: error: identifier 'y' not found [compile]",
+				"main.ylang:2:5: error: wrong number of arguments for 'rgb': expected 1 or 3, got 2 [arity]",
+				"main.ylang:4:5: error: identifier 'z' not found [compile]",
+			},
+		},
+		{
+			name: "diagnostics_in_module",
+			src: `m := import "lib.ylang"
+log(rgb(1, 2))`,
+			modules: BundleResolver{
+				"lib.ylang": `f := fn() {
+    unused := 1
+}`,
+			},
+			want: []string{
+				"main.ylang:2:5: error: wrong number of arguments for 'rgb': expected 1 or 3, got 2 [arity]",
+				"lib.ylang:2:5: warning: variable 'unused' is declared but never used [unused]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, diag := range Check("main.ylang", tt.src, tt.modules) {
+				got = append(got, diag.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_CompileInput_session(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []string
+		want   []string // the result or error message of each input
+		log    []string
+	}{
+		{
+			name:   "globals_persist",
+			inputs: []string{"a := 20", "b := a + 1", "a + b"},
+			want:   []string{"", "", "41"},
+		},
+		{
+			name:   "closures_and_redeclaration",
+			inputs: []string{"n := 1", "inc := fn(x) -> x + n", "n := 10", "inc(1)"},
+			want:   []string{"", "", "", "11"},
+		},
+		{
+			name:   "value_formatting",
+			inputs: []string{"[1, 2]", `"abc"`, "#ff0000", "nil"},
+			want:   []string{"[\n  1,\n  2,\n]", "abc", "rgba(255,0,0:255)", "nil"},
+		},
+		{
+			name:   "multiline_block",
+			inputs: []string{"s := 0\nfor i in 0 .. 4 {\n    s = s + i\n}\ns", "log(s)"},
+			want:   []string{"6", ""},
+			log:    []string{"6"},
+		},
+		{
+			name:   "error_keeps_session",
+			inputs: []string{"a := 1", "a + true", "b", "a"},
+			want:   []string{"", "error", "error", "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bitmap := &logBitmap{}
+			session := interpreter.NewSession(bitmap)
+			for i, input := range tt.inputs {
+				got := ""
+				prog, err := CompileInput(input, session.Globals(), nil)
+				if err == nil {
+					got, err = session.Execute(prog)
+				}
+				if err != nil {
+					if _, ok := err.(*lang.Error); !ok {
+						t.Errorf("input %d: error = %v, want *lang.Error", i, err)
+					}
+					got = "error"
+				}
+				if got != tt.want[i] {
+					t.Errorf("input %d: got %q, want %q", i, got, tt.want[i])
+				}
+			}
+			if !reflect.DeepEqual(bitmap.log, tt.log) {
+				t.Errorf("log = %v, want %v", bitmap.log, tt.log)
+			}
+		})
+	}
+}
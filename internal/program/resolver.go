@@ -0,0 +1,81 @@
+package program
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Resolver locates and loads the modules imported by a ylang program.
+type Resolver interface {
+	// Resolve returns the canonical name of the module imported with the specified path
+	// from the module named importer. importer is empty for an unnamed main script.
+	Resolve(importer string, path string) (string, error)
+	// Load returns the source code of the module with the specified canonical name.
+	Load(name string) (string, error)
+}
+
+// FileResolver resolves imports to files. Import paths are resolved relative
+// to the importing file first, then relative to each directory in SearchPath.
+type FileResolver struct {
+	SearchPath []string
+}
+
+func (r FileResolver) Resolve(importer string, importPath string) (string, error) {
+	var candidates []string
+	if filepath.IsAbs(importPath) {
+		candidates = append(candidates, importPath)
+	} else {
+		dir := "."
+		if importer != "" {
+			dir = filepath.Dir(importer)
+		}
+		candidates = append(candidates, filepath.Join(dir, importPath))
+		for _, dir := range r.SearchPath {
+			candidates = append(candidates, filepath.Join(dir, importPath))
+		}
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() == false {
+			return filepath.Clean(candidate), nil
+		}
+	}
+	return "", fmt.Errorf("module '%s' not found", importPath)
+}
+
+func (r FileResolver) Load(name string) (string, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("error loading module '%s': %s", name, err)
+	}
+	return string(src), nil
+}
+
+// BundleResolver resolves imports to a set of named module sources,
+// e.g. sent along with a script to the server. Names are slash-separated paths;
+// import paths are resolved relative to the importing module first, then
+// relative to the bundle root.
+type BundleResolver map[string]string
+
+func (r BundleResolver) Resolve(importer string, importPath string) (string, error) {
+	candidates := []string{
+		path.Join(path.Dir(importer), importPath),
+		path.Clean(importPath),
+	}
+	for _, candidate := range candidates {
+		if _, ok := r[candidate]; ok {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("module '%s' not found", importPath)
+}
+
+func (r BundleResolver) Load(name string) (string, error) {
+	src, ok := r[name]
+	if !ok {
+		return "", fmt.Errorf("module '%s' not found", name)
+	}
+	return src, nil
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"github.com/smackem/ylang/internal/emitter"
+	"github.com/smackem/ylang/internal/imageio"
+	"github.com/smackem/ylang/internal/interpreter"
 	pb "github.com/smackem/ylang/internal/listener"
 	"github.com/smackem/ylang/internal/program"
 	"google.golang.org/grpc"
+	"image"
 	"io"
 	"log"
 	"net"
@@ -19,6 +24,7 @@ const (
 
 type server struct {
 	pb.UnimplementedImageProcServer
+	options interpreter.Options // the execution limits of the scripts
 }
 
 func (s *server) ProcessImage(srv pb.ImageProc_ProcessImageServer) error {
@@ -38,16 +44,25 @@ func (s *server) writeResponse(response *pb.ProcessImageResponse, srv pb.ImagePr
 	const chunkSize = 64 * 1024
 	index := 0
 	isFirstMessage := true
-	for remaining := len(response.ImageDataPng); remaining > 0 || isFirstMessage; {
+	data := response.ImageDataPng
+	if response.Format != "" {
+		data = response.ImageData
+	}
+	for remaining := len(data); remaining > 0 || isFirstMessage; {
 		toWrite := chunkSize
 		if toWrite > remaining {
 			toWrite = remaining
 		}
-		resp.ImageDataPng = response.ImageDataPng[index : index+toWrite]
+		if response.Format != "" {
+			resp.ImageData = data[index : index+toWrite]
+		} else {
+			resp.ImageDataPng = data[index : index+toWrite]
+		}
 		if isFirstMessage {
 			resp.Result = response.Result
 			resp.Message = response.Message
 			resp.LogOutput = response.LogOutput
+			resp.Format = response.Format
 			isFirstMessage = false
 		}
 		if err := srv.Send(&resp); err != nil {
@@ -73,14 +88,39 @@ func (s *server) readRequest(srv pb.ImageProc_ProcessImageServer) (*pb.ProcessIm
 		}
 		if first {
 			fullRequest.SourceCode = request.SourceCode
+			fullRequest.Modules = request.Modules
+			fullRequest.Params = request.Params
+			fullRequest.Format = request.Format
+			fullRequest.Quality = request.Quality
+			fullRequest.Depth = request.Depth
 		}
 		fullRequest.ImageDataPng = append(fullRequest.ImageDataPng, request.ImageDataPng...)
+		fullRequest.CompiledProgram = append(fullRequest.CompiledProgram, request.CompiledProgram...)
+		fullRequest.Images = appendImages(fullRequest.Images, request.Images)
 		first = false
 	}
 
 	return &fullRequest, nil
 }
 
+// appendImages appends the chunks of named images to images, joining the chunks of images with the same name
+func appendImages(images []*pb.NamedImage, chunks []*pb.NamedImage) []*pb.NamedImage {
+	for _, chunk := range chunks {
+		var img *pb.NamedImage
+		for _, other := range images {
+			if other.Name == chunk.Name {
+				img = other
+			}
+		}
+		if img == nil {
+			img = &pb.NamedImage{Name: chunk.Name}
+			images = append(images, img)
+		}
+		img.ImageData = append(img.ImageData, chunk.ImageData...)
+	}
+	return images
+}
+
 func (s *server) processImage(ctx context.Context, in *pb.ProcessImageRequest) (resp *pb.ProcessImageResponse, err error) {
 	defer func() {
 		if p := recover(); p != nil {
@@ -91,42 +131,105 @@ func (s *server) processImage(ctx context.Context, in *pb.ProcessImageRequest) (
 			}, nil
 		}
 	}()
-	surf, err := loadSurface(bytes.NewBuffer(in.ImageDataPng))
-	if err != nil {
-		return nil, fmt.Errorf("error decoding imageData: %s", err)
+	encoding := imageio.Options{Format: in.Format, Quality: int(in.Quality)}
+	if encoding.Format == "" {
+		encoding.Format = "png"
 	}
-
-	prog, err := program.Compile(string(in.SourceCode))
-	if err != nil {
+	if in.Depth != "" {
+		if encoding.Depth, err = imageio.ParseDepth(in.Depth); err != nil {
+			return &pb.ProcessImageResponse{
+				Result:       pb.ProcessImageResponse_ERROR,
+				Message:      err.Error(),
+				ImageDataPng: nil,
+			}, nil
+		}
+	}
+	if err := encoding.Validate(); err != nil {
 		return &pb.ProcessImageResponse{
 			Result:       pb.ProcessImageResponse_ERROR,
-			Message:      fmt.Sprintf("compilation error: %s", err),
+			Message:      err.Error(),
 			ImageDataPng: nil,
 		}, nil
 	}
+	surf, err := loadSurface(bytes.NewBuffer(in.ImageDataPng))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding imageData: %s", err)
+	}
+	for _, input := range in.Images {
+		img, _, err := image.Decode(bytes.NewReader(input.ImageData))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding imageData of image '%s': %s", input.Name, err)
+		}
+		surf.SetInput(input.Name, img)
+	}
+
+	resolver := program.BundleResolver(in.Modules)
+	options := s.options
+	options.Params = in.Params
+	var execute func() error
+	if len(in.CompiledProgram) > 0 {
+		compiled, err := emitter.Decode(in.CompiledProgram)
+		if err != nil {
+			return &pb.ProcessImageResponse{
+				Result:       pb.ProcessImageResponse_ERROR,
+				Message:      err.Error(),
+				ImageDataPng: nil,
+			}, nil
+		}
+		code := *compiled.Program
+		execute = func() error { return program.Run(ctx, code, surf, options) }
+	} else {
+		prog, err := program.CompileModule("", string(in.SourceCode), resolver)
+		if err != nil {
+			return &pb.ProcessImageResponse{
+				Result:       pb.ProcessImageResponse_ERROR,
+				Message:      fmt.Sprintf("compilation error: %s", describeError(err, "", string(in.SourceCode), resolver)),
+				ImageDataPng: nil,
+			}, nil
+		}
+		execute = func() error { return program.Execute(ctx, prog, surf, options) }
+	}
 
 	logOutput := strings.Builder{}
-	surf.log = func(message string) {
+	surf.SetLogSink(func(message string) {
 		logOutput.WriteString(message)
 		logOutput.WriteRune('\n')
-	}
+	})
 
-	err = program.Execute(prog, surf)
+	err = execute()
 	if err != nil {
+		if traceback := describeTraceback(err, ""); traceback != "" {
+			logOutput.WriteString(traceback)
+			logOutput.WriteRune('\n')
+		}
+		result := pb.ProcessImageResponse_ERROR
+		var limitErr *interpreter.LimitError
+		if errors.As(err, &limitErr) {
+			result = pb.ProcessImageResponse_ABORTED
+		}
 		return &pb.ProcessImageResponse{
-			Result:       pb.ProcessImageResponse_ERROR,
-			Message:      fmt.Sprintf("execution error: %s", err),
+			Result:       result,
+			Message:      fmt.Sprintf("execution error: %s", describeError(err, "", string(in.SourceCode), resolver)),
 			ImageDataPng: nil,
 			LogOutput:    logOutput.String(),
 		}, nil
 	}
 
 	buf := bytes.Buffer{}
-	err = writeImage(surf.target, &buf)
+	err = writeImage(targetImage(surf, encoding), &buf, encoding)
 	if err != nil {
 		return nil, fmt.Errorf("error encoding imageData : %s", err)
 	}
 
+	if in.Format != "" {
+		return &pb.ProcessImageResponse{
+			Result:    pb.ProcessImageResponse_OK,
+			Message:   "",
+			LogOutput: logOutput.String(),
+			Format:    in.Format,
+			ImageData: buf.Bytes(),
+		}, nil
+	}
 	return &pb.ProcessImageResponse{
 		Result:       pb.ProcessImageResponse_OK,
 		Message:      "",
@@ -135,13 +238,13 @@ func (s *server) processImage(ctx context.Context, in *pb.ProcessImageRequest) (
 	}, nil
 }
 
-func listenerMain() {
+func listenerMain(options interpreter.Options) {
 	lis, err := net.Listen("tcp", listenerPort)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 	s := grpc.NewServer()
-	pb.RegisterImageProcServer(s, &server{})
+	pb.RegisterImageProcServer(s, &server{options: options})
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
@@ -2,33 +2,109 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/smackem/ylang/internal/dap"
 	"github.com/smackem/ylang/internal/emitter"
+	"github.com/smackem/ylang/internal/format"
+	"github.com/smackem/ylang/internal/imageio"
 	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lsp"
 	"github.com/smackem/ylang/internal/program"
+	"github.com/smackem/ylang/ylang"
+	"image/jpeg"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 func main() {
-	sourceImgPath := flag.String("image", "", "the source image path")
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		fmtMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		replMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dap" {
+		dapMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		checkMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		lspMain(os.Args[2:])
+		return
+	}
+
+	sourceImgPath := flag.String("image", "", "the source image path, a glob pattern or a directory to process a batch of images")
 	sourceCodePath := flag.String("code", "", "the path of the source code file")
-	targetImgPath := flag.String("out", "", "the target image path")
+	targetImgPath := flag.String("out", "", "the target image path, which contains {name} for batches: the name of the source image without extension")
 	jsOutputPath := flag.String("js", "", "the javascript output path")
 	showHelp := flag.Bool("help", false, "display all ylang functions")
 	server := flag.Bool("server", false, "run as server")
+	engine := flag.String("engine", "interpreter", "the execution engine: 'interpreter' walks the syntax tree, 'vm' compiles to bytecode")
+	searchPath := flag.String("path", "", "the list of directories to search for imported modules, separated by the OS path list separator")
+	compilePath := flag.String("compile", "", "the path of a source code file to compile to bytecode, which is written to the path specified with -o")
+	compiledOutputPath := flag.String("o", "", "the output path of -compile")
+	threads := flag.Int("threads", 0, "the number of threads executing per-pixel loops in parallel, 0 for one thread per CPU, 1 to disable parallel execution")
+	maxSteps := flag.Int("maxsteps", 0, "the maximum number of statements a script may execute, 0 for no limit")
+	timeout := flag.Duration("timeout", 0, "the maximum execution time of a script, 0 for no limit")
+	maxAlloc := flag.Int("maxalloc", 0, "the maximum number of elements of lists, kernels and hashmaps created by literals, concatenations and builtin functions and of pixels of resized target images, 0 for no limit")
+	maxDepth := flag.Int("maxdepth", 0, "the maximum number of nested function invocations, 0 for no limit")
+	profile := flag.Bool("profile", false, "print the hit counts and times of all statements and functions after the execution")
+	pprofPath := flag.String("pprof", "", "the path to write the profile to in the format read by 'go tool pprof', implies -profile")
+	listParamsPath := flag.String("listparams", "", "the path of a source code file whose parameters are printed with their default values")
+	imageFormat := flag.String("format", "", "the format of the target image: png, jpeg, gif, bmp, tiff or pfm, defaults to the format of the -out file extension")
+	quality := flag.Int("quality", jpeg.DefaultQuality, "the quality of JPEG target images from 1 to 100")
+	compression := flag.String("compression", "default", "the compression of PNG and TIFF target images: default, none, speed or best")
+	depth := flag.String("depth", "", "the channel depth of the target image: 8, 16 (png and tiff) or float (pfm), defaults to the lowest depth of the format")
+	colors := flag.Int("colors", 256, "the maximum number of palette colors of GIF target images from 2 to 256")
+	dither := flag.Bool("dither", false, "dither GIF target images with Floyd-Steinberg error diffusion")
+	frameDelay := flag.Duration("delay", ylang.DefaultFrameDelay, "the delay of animation frames recorded by 'frame()' without delay argument or by -flipframes")
+	flipFrames := flag.Bool("flipframes", false, "record the target image as animation frame on each 'flip()' and after the execution")
+	jobs := flag.Int("jobs", 0, "the number of images of a batch processed in parallel, 0 for one per CPU")
+	params := paramFlag{}
+	flag.Var(params, "param", "the value of a script parameter as name=value, may be repeated")
+	inputPaths := paramFlag{}
+	flag.Var(inputPaths, "input", "an additional image as name=path, which scripts access as Images.name, may be repeated")
 	flag.Parse()
 
+	options := interpreter.Options{
+		Params:        params,
+		MaxSteps:      *maxSteps,
+		Timeout:       *timeout,
+		MaxAllocation: *maxAlloc,
+		MaxCallDepth:  *maxDepth,
+		Threads:       *threads,
+	}
+
 	if *showHelp {
 		fmt.Printf("%s", interpreter.PrintFunctions())
 		return
 	}
 
 	if *server {
-		serverMain()
+		serverMain(withServerDefaults(options))
+		return
+	}
+
+	resolver := program.FileResolver{SearchPath: filepath.SplitList(*searchPath)}
+	if *compilePath != "" {
+		compileMain(*compilePath, *compiledOutputPath, resolver)
+		return
+	}
+	if *listParamsPath != "" {
+		listParamsMain(*listParamsPath, resolver)
 		return
 	}
 
@@ -36,52 +112,455 @@ func main() {
 		flag.Usage()
 		return
 	}
-
-	sourceFile, err := os.Open(*sourceImgPath)
+	if *engine != "interpreter" && *engine != "vm" {
+		log.Fatalf("unknown engine '%s'", *engine)
+	}
+	compressionLevel, err := imageio.ParseCompression(*compression)
 	if err != nil {
-		log.Fatalf("Could not load %s: %s", *sourceImgPath, err.Error())
+		log.Fatal(err)
 	}
-	defer func() { _ = sourceFile.Close() }()
-
-	surf, err := loadSurface(sourceFile)
+	encoding := imageio.Options{Format: *imageFormat, Quality: *quality, Compression: compressionLevel, Colors: *colors, Dither: *dither}
+	if *depth != "" {
+		if encoding.Depth, err = imageio.ParseDepth(*depth); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if encoding.Format == "" {
+		if encoding.Format, err = imageio.FormatOf(*targetImgPath); err != nil {
+			log.Fatalf("%s, use -format to specify the format of '%s'", err, *targetImgPath)
+		}
+	}
+	if err = encoding.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	inputs, batch, err := batchInputs(*sourceImgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if batch && !strings.Contains(*targetImgPath, nameVar) {
+		log.Fatalf("-out must contain %s to name the target image of each source image of a batch", nameVar)
+	}
+	if err = checkBatchTargets(inputs, *targetImgPath); err != nil {
+		log.Fatal(err)
+	}
+	if *profile || *pprofPath != "" {
+		if *engine == "vm" || filepath.Ext(*sourceCodePath) == compiledFileExt {
+			log.Fatalf("profiling is only supported by the 'interpreter' engine")
+		}
+		if batch {
+			log.Fatalf("profiling is not supported for batches of images")
+		}
+		options.Profile = interpreter.NewProfile()
+	}
+	options.Registry = newFileRegistry()
+	inputImages, err := loadInputs(inputPaths)
 	if err != nil {
-		log.Fatalf("error loading image from '%s': %s", *sourceImgPath, err.Error())
+		log.Fatal(err)
 	}
+
 	src, err := ioutil.ReadFile(*sourceCodePath)
 	if err != nil {
 		log.Fatalf("error loading source code from '%s': %s", *sourceCodePath, err.Error())
 	}
 
-	prog, err := program.Compile(string(src))
-	if err != nil {
-		log.Fatalf("compilation error: %s", err.Error())
+	var execute func(surf *ylang.Surface, options interpreter.Options) error
+	if filepath.Ext(*sourceCodePath) == compiledFileExt {
+		code := loadCompiled(*sourceCodePath, src)
+		src = nil // errors point into the source code the program has been compiled from
+		execute = func(surf *ylang.Surface, options interpreter.Options) error {
+			return program.Run(context.Background(), code, surf, options)
+		}
+	} else {
+		prog, err := program.CompileModuleWith(*sourceCodePath, string(src), resolver, options.Registry)
+		if err != nil {
+			log.Fatalf("compilation error: %s", describeError(err, *sourceCodePath, string(src), resolver))
+		}
+
+		if *jsOutputPath != "" {
+			js := emitter.EmitJS(prog)
+			if err := ioutil.WriteFile(*jsOutputPath, []byte(js), 0644); err != nil {
+				log.Fatalf("error writing javascript to '%s': %s", *jsOutputPath, err)
+			}
+		}
+
+		if *engine == "vm" {
+			code := program.Emit(prog)
+			execute = func(surf *ylang.Surface, options interpreter.Options) error {
+				return program.Run(context.Background(), code, surf, options)
+			}
+		} else {
+			execute = func(surf *ylang.Surface, options interpreter.Options) error {
+				return program.Execute(context.Background(), prog, surf, options)
+			}
+		}
 	}
 
-	if *jsOutputPath != "" {
-		js := emitter.EmitJS(prog)
-		if err := ioutil.WriteFile(*jsOutputPath, []byte(js), 0644); err != nil {
-			log.Fatalf("error writing javascript to '%s': %s", *jsOutputPath, err)
+	animation := ylang.AnimationOptions{Delay: *frameDelay, FlipFrames: *flipFrames}
+	if batch {
+		process := func(sourcePath string, index int, targetPath string) error {
+			surf, err := openSurface(sourcePath, inputImages)
+			if err != nil {
+				return err
+			}
+			surf.SetAnimation(animation)
+			fileOptions := options
+			fileOptions.Constants = fileConstants(sourcePath, index)
+			if err := execute(surf, fileOptions); err != nil {
+				return fmt.Errorf("execution error: %s", describeError(err, *sourceCodePath, string(src), resolver))
+			}
+			if *flipFrames {
+				surf.Frame(-1)
+			}
+			return saveSurface(surf, targetPath, encoding)
+		}
+		start := time.Now()
+		failed := runBatch(inputs, *targetImgPath, *jobs, process)
+		log.Printf("processed %d images in %s", len(inputs), time.Since(start))
+		if failed > 0 {
+			log.Fatalf("%d of %d images failed", failed, len(inputs))
 		}
+		return
 	}
 
+	surf, err := openSurface(*sourceImgPath, inputImages)
+	if err != nil {
+		log.Fatal(err)
+	}
+	surf.SetAnimation(animation)
+	options.Constants = fileConstants(*sourceImgPath, 0)
+
 	start := time.Now()
-	err = program.Execute(prog, surf)
+	err = execute(surf, options)
 	if err != nil {
-		log.Fatalf("execution error: %s", err.Error())
+		if traceback := describeTraceback(err, *sourceCodePath); traceback != "" {
+			fmt.Fprintln(os.Stderr, traceback)
+		}
+		log.Fatalf("execution error: %s", describeError(err, *sourceCodePath, string(src), resolver))
 	}
 	log.Printf("execution took %s", time.Since(start))
 
-	if err = saveImage(surf.target, *targetImgPath); err != nil {
-		log.Fatalf("error saving image %s: %s", *targetImgPath, err.Error())
+	if options.Profile != nil {
+		writeProfile(options.Profile, *pprofPath, *sourceCodePath, string(src), resolver)
 	}
 
-	log.Printf("Saved image to '%s' as png", *targetImgPath)
+	if *flipFrames {
+		surf.Frame(-1)
+	}
+	if err = saveSurface(surf, *targetImgPath, encoding); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func serverMain() {
-	go httpMain()
-	go listenerMain()
+// writeProfile prints the report of profile to stdout and writes it to pprofPath unless empty.
+// the source code of imported modules is loaded through resolver.
+func writeProfile(profile *interpreter.Profile, pprofPath string, fileName string, src string, resolver program.Resolver) {
+	source := func(file string) (string, error) {
+		if file == fileName || file == "" {
+			return src, nil
+		}
+		return resolver.Load(file)
+	}
+	if err := profile.WriteReport(os.Stdout, source); err != nil {
+		log.Fatalf("error writing profile: %s", err)
+	}
+	if pprofPath == "" {
+		return
+	}
+	file, err := os.Create(pprofPath)
+	if err != nil {
+		log.Fatalf("error writing profile to '%s': %s", pprofPath, err)
+	}
+	defer func() { _ = file.Close() }()
+	if err := profile.WritePprof(file); err != nil {
+		log.Fatalf("error writing profile to '%s': %s", pprofPath, err)
+	}
+	log.Printf("Saved profile to '%s'", pprofPath)
+}
+
+// compiledFileExt is the file extension of compiled ylang programs
+const compiledFileExt = ".ylc"
+
+// compileMain compiles the source code file at srcPath to bytecode and writes it to outPath.
+// outPath defaults to srcPath with the extension replaced by compiledFileExt.
+func compileMain(srcPath string, outPath string, resolver program.Resolver) {
+	src, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		log.Fatalf("error loading source code from '%s': %s", srcPath, err.Error())
+	}
+	prog, err := program.CompileModuleWith(srcPath, string(src), resolver, newFileRegistry())
+	if err != nil {
+		log.Fatalf("compilation error: %s", describeError(err, srcPath, string(src), resolver))
+	}
+	data, err := emitter.Encode(program.Emit(prog), string(src))
+	if err != nil {
+		log.Fatalf("error encoding compiled program: %s", err)
+	}
+	if outPath == "" {
+		outPath = strings.TrimSuffix(srcPath, filepath.Ext(srcPath)) + compiledFileExt
+	}
+	if err := ioutil.WriteFile(outPath, data, 0644); err != nil {
+		log.Fatalf("error writing compiled program to '%s': %s", outPath, err)
+	}
+	log.Printf("Compiled '%s' to '%s'", srcPath, outPath)
+}
+
+// checkMain is the check subcommand: it analyzes the source code files and all modules they import
+// and writes the diagnostics to stdout as JSON array. Exits with status 1 if any diagnostic is an error.
+func checkMain(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	searchPath := flags.String("path", "", "the list of directories to search for imported modules, separated by the OS path list separator")
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("usage: ylang check [-path dirs] file...")
+	}
+
+	resolver := program.FileResolver{SearchPath: filepath.SplitList(*searchPath)}
+	diags := []lang.Diagnostic{}
+	for _, srcPath := range flags.Args() {
+		src, err := ioutil.ReadFile(srcPath)
+		if err != nil {
+			log.Fatalf("error loading source code from '%s': %s", srcPath, err.Error())
+		}
+		diags = append(diags, program.CheckWith(srcPath, string(src), resolver, newFileRegistry())...)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(diags); err != nil {
+		log.Fatalf("error writing diagnostics: %s", err)
+	}
+	for _, diag := range diags {
+		if diag.Severity == lang.SeverityError {
+			os.Exit(1)
+		}
+	}
+}
+
+// listParamsMain prints the parameters declared by the source code file at srcPath
+// with the source code of their default values
+func listParamsMain(srcPath string, resolver program.Resolver) {
+	src, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		log.Fatalf("error loading source code from '%s': %s", srcPath, err.Error())
+	}
+	prog, err := program.CompileModuleWith(srcPath, string(src), resolver, newFileRegistry())
+	if err != nil {
+		log.Fatalf("compilation error: %s", describeError(err, srcPath, string(src), resolver))
+	}
+	for _, decl := range prog.Params {
+		fmt.Printf("%s := %s\n", decl.Ident, format.Expr(decl.Rhs))
+	}
+}
+
+// paramFlag collects the script parameters or input images passed as repeated name=value flags
+type paramFlag map[string]string
+
+func (f paramFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for name, value := range f {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (f paramFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("expected name=value, found '%s'", s)
+	}
+	f[s[:i]] = s[i+1:]
+	return nil
+}
+
+// fmtMain implements `ylang fmt [-w] [-d] [files]`, which formats the specified
+// source code files or stdin and writes the result to stdout.
+func fmtMain(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the source file instead of stdout")
+	diff := flags.Bool("d", false, "print a diff of the changes instead of the formatted source")
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("error reading stdin: %s", err)
+		}
+		formatted, err := formatSource("<stdin>", src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *diff {
+			fmt.Print(format.Diff("<stdin>", "<stdin>", string(src), formatted))
+		} else {
+			fmt.Print(formatted)
+		}
+		return
+	}
+
+	failed := false
+	for _, path := range flags.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("error loading source code from '%s': %s", path, err)
+			failed = true
+			continue
+		}
+		formatted, err := formatSource(path, src)
+		if err != nil {
+			log.Print(err)
+			failed = true
+			continue
+		}
+		if *diff {
+			fmt.Print(format.Diff(path+".orig", path, string(src), formatted))
+		}
+		if *write {
+			if formatted != string(src) {
+				if err := ioutil.WriteFile(path, []byte(formatted), 0644); err != nil {
+					log.Printf("error writing '%s': %s", path, err)
+					failed = true
+				}
+			}
+		} else if !*diff {
+			fmt.Print(formatted)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// lspMain implements `ylang lsp`, which runs a language server communicating over stdin and stdout.
+// imported modules are searched relative to the importing file and in the directories of -path.
+func lspMain(args []string) {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	searchPath := flags.String("path", "", "the list of directories to search for imported modules, separated by the OS path list separator")
+	_ = flags.Parse(args)
+
+	resolver := program.FileResolver{SearchPath: filepath.SplitList(*searchPath)}
+	if err := lsp.NewServer(os.Stdin, os.Stdout, resolver).Serve(); err != nil {
+		log.Fatalf("language server error: %s", err)
+	}
+}
+
+// dapMain implements `ylang dap`, which runs a debug adapter communicating over stdin and stdout.
+// the program to debug and its source image are specified by the client.
+func dapMain(args []string) {
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	searchPath := flags.String("path", "", "the list of directories to search for imported modules, separated by the OS path list separator")
+	_ = flags.Parse(args)
+
+	resolver := program.FileResolver{SearchPath: filepath.SplitList(*searchPath)}
+	load := func(path string, log func(message string)) (dap.Bitmap, error) {
+		sourceFile, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = sourceFile.Close() }()
+		surf, err := loadSurface(sourceFile)
+		if err != nil {
+			return nil, err
+		}
+		surf.SetLogSink(log)
+		return surf, nil
+	}
+	if err := dap.NewServer(os.Stdin, os.Stdout, resolver, load).Serve(); err != nil {
+		log.Fatalf("debug adapter error: %s", err)
+	}
+}
+
+// formatSource formats the source code of the file path, compilation errors refer to the file
+func formatSource(path string, src []byte) (string, error) {
+	formatted, err := format.Source(string(src))
+	if lerr, ok := err.(*lang.Error); ok {
+		lerr.File = path
+	}
+	return formatted, err
+}
+
+// loadCompiled decodes the content of the compiled file at path.
+// logs a warning if the source code file the program has been compiled from has changed since.
+func loadCompiled(path string, data []byte) emitter.Program {
+	compiled, err := emitter.Decode(data)
+	if err != nil {
+		log.Fatalf("error loading compiled program from '%s': %s", path, err)
+	}
+	if src, err := ioutil.ReadFile(compiled.Program.File); err == nil && !compiled.IsCompiledFrom(string(src)) {
+		log.Printf("warning: '%s' has changed since it has been compiled to '%s'", compiled.Program.File, path)
+	}
+	return *compiled.Program
+}
+
+// serverDefaults are the execution limits of scripts run by the servers unless specified otherwise,
+// so that a single request cannot hang or exhaust the server
+var serverDefaults = interpreter.Options{
+	Timeout:       time.Minute,
+	MaxAllocation: 1 << 26,
+	MaxCallDepth:  1000,
+}
+
+// withServerDefaults returns options with all limits that are not specified set to serverDefaults
+func withServerDefaults(options interpreter.Options) interpreter.Options {
+	if options.MaxSteps == 0 {
+		options.MaxSteps = serverDefaults.MaxSteps
+	}
+	if options.Timeout == 0 {
+		options.Timeout = serverDefaults.Timeout
+	}
+	if options.MaxAllocation == 0 {
+		options.MaxAllocation = serverDefaults.MaxAllocation
+	}
+	if options.MaxCallDepth == 0 {
+		options.MaxCallDepth = serverDefaults.MaxCallDepth
+	}
+	return options
+}
+
+func serverMain(options interpreter.Options) {
+	go httpMain(options)
+	go listenerMain(options)
 	fmt.Printf("Running on port %d (HTTP) and %s (gRPC). Press Enter to quit...", httpPort, listenerPort)
 	reader := bufio.NewReader(os.Stdin)
 	_, _ = reader.ReadString('\n')
 }
+
+// describeError formats an error returned by program.Compile or program.Execute.
+// if err carries a source position, the offending line of src is appended,
+// marked with a caret. if the error occurred in an imported module, the source
+// of the module is loaded through resolver, which may be nil.
+func describeError(err error, fileName string, src string, resolver program.Resolver) string {
+	lerr, ok := err.(*lang.Error)
+	if !ok {
+		return err.Error()
+	}
+	e := *lerr
+	if e.File == "" {
+		e.File = fileName
+	}
+	if e.File != fileName {
+		if resolver == nil {
+			return e.Error()
+		}
+		var loadErr error
+		if src, loadErr = resolver.Load(e.File); loadErr != nil {
+			return e.Error()
+		}
+	}
+	if excerpt := e.Excerpt(src); excerpt != "" {
+		return e.Error() + "\n" + excerpt
+	}
+	return e.Error()
+}
+
+// describeTraceback returns the formatted call stack of a runtime error
+// returned by program.Execute or an empty string if err carries none.
+func describeTraceback(err error, fileName string) string {
+	lerr, ok := err.(*lang.Error)
+	if !ok {
+		return ""
+	}
+	e := *lerr
+	if e.File == "" {
+		e.File = fileName
+	}
+	return e.Traceback()
+}
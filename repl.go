@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/smackem/ylang/internal/imageio"
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/lexer"
+	"github.com/smackem/ylang/internal/program"
+	"github.com/smackem/ylang/ylang"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxUndo is the number of inputs that can be undone in the repl
+const maxUndo = 20
+
+const replHelp = `Enter statements or expressions, the values of expressions are printed.
+Blocks spanning multiple lines are continued until all brackets are closed.
+Commands:
+  :save PATH   save the target image as png
+  :reset       discard all variables and restore the source image
+  :undo        revert the last input that changed the image
+  :funcs       list all builtin functions
+  :help        show this message
+  :quit        exit the repl`
+
+// replMain implements `ylang repl -image PATH`, which executes the statements and expressions
+// read from stdin one after another against an image that is loaded once.
+func replMain(args []string) {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+	sourceImgPath := flags.String("image", "", "the source image path")
+	searchPath := flags.String("path", "", "the list of directories to search for imported modules, separated by the OS path list separator")
+	_ = flags.Parse(args)
+
+	if *sourceImgPath == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	sourceFile, err := os.Open(*sourceImgPath)
+	if err != nil {
+		log.Fatalf("Could not load %s: %s", *sourceImgPath, err.Error())
+	}
+	surf, err := loadSurface(sourceFile)
+	_ = sourceFile.Close()
+	if err != nil {
+		log.Fatalf("error loading image from '%s': %s", *sourceImgPath, err.Error())
+	}
+
+	r := newRepl(surf, program.FileResolver{SearchPath: filepath.SplitList(*searchPath)}, os.Stdout)
+	fmt.Fprintf(os.Stdout, "ylang repl - %dx%d image, type :help for help\n", surf.SourceWidth(), surf.SourceHeight())
+	r.run(os.Stdin)
+}
+
+// repl keeps an interpreter session and the surface it draws on alive across inputs
+type repl struct {
+	surf     *ylang.Surface
+	session  *interpreter.Session
+	resolver program.Resolver
+	undo     []ylang.SurfaceState
+	out      io.Writer
+}
+
+func newRepl(surf *ylang.Surface, resolver program.Resolver, out io.Writer) *repl {
+	r := &repl{
+		surf:     surf,
+		resolver: resolver,
+		out:      out,
+	}
+	surf.SetLogSink(func(message string) { fmt.Fprintln(r.out, message) })
+	r.session = interpreter.NewSession(surf)
+	return r
+}
+
+// run reads inputs from reader until it is exhausted or :quit is entered
+func (r *repl) run(reader io.Reader) {
+	scanner := bufio.NewScanner(reader)
+	var input strings.Builder
+	fmt.Fprint(r.out, "> ")
+	for scanner.Scan() {
+		line := scanner.Text()
+		if input.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if !r.command(strings.TrimSpace(line)) {
+				return
+			}
+			fmt.Fprint(r.out, "> ")
+			continue
+		}
+		input.WriteString(line)
+		input.WriteByte('\n')
+		// an empty line submits an incomplete input, which yields a syntax error
+		if openBrackets(input.String()) > 0 && strings.TrimSpace(line) != "" {
+			fmt.Fprint(r.out, "... ")
+			continue
+		}
+		if strings.TrimSpace(input.String()) != "" {
+			r.execute(input.String())
+		}
+		input.Reset()
+		fmt.Fprint(r.out, "> ")
+	}
+	fmt.Fprintln(r.out)
+}
+
+// command executes a repl command. returns false if the repl should exit.
+func (r *repl) command(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":save":
+		if len(fields) != 2 {
+			fmt.Fprintln(r.out, "usage: :save PATH")
+			break
+		}
+		if err := saveImage(r.surf.Target(), fields[1], imageio.Options{}); err != nil {
+			fmt.Fprintln(r.out, err)
+			break
+		}
+		fmt.Fprintf(r.out, "saved image to '%s'\n", fields[1])
+	case ":reset":
+		r.surf.Reset()
+		r.session = interpreter.NewSession(r.surf)
+		r.undo = nil
+	case ":undo":
+		if len(r.undo) == 0 {
+			fmt.Fprintln(r.out, "nothing to undo")
+			break
+		}
+		r.surf.Restore(r.undo[len(r.undo)-1])
+		r.undo = r.undo[:len(r.undo)-1]
+	case ":funcs":
+		fmt.Fprint(r.out, interpreter.PrintFunctions())
+	case ":help":
+		fmt.Fprintln(r.out, replHelp)
+	case ":quit", ":q":
+		return false
+	default:
+		fmt.Fprintf(r.out, "unknown command '%s', type :help for help\n", fields[0])
+	}
+	return true
+}
+
+// execute compiles and executes an input and prints its result or error
+func (r *repl) execute(src string) {
+	prog, err := program.CompileInput(src, r.session.Globals(), r.resolver)
+	if err != nil {
+		fmt.Fprintf(r.out, "compilation error: %s\n", describeError(err, "", src, r.resolver))
+		return
+	}
+	if interpreter.ModifiesBitmap(prog) {
+		r.snapshot()
+	}
+	result, err := r.session.Execute(prog)
+	if err != nil {
+		// the traceback is only of interest if the error occurred in a function
+		if lerr, ok := err.(*lang.Error); ok && len(lerr.Trace) > 1 {
+			fmt.Fprintln(r.out, describeTraceback(err, ""))
+		}
+		fmt.Fprintf(r.out, "execution error: %s\n", describeError(err, "", src, r.resolver))
+		return
+	}
+	if result != "" {
+		fmt.Fprintln(r.out, result)
+	}
+}
+
+// snapshot pushes the current state of the surface onto the undo stack before an input that
+// may modify it. variables are not reverted by undo.
+func (r *repl) snapshot() {
+	r.undo = append(r.undo, r.surf.Snapshot())
+	if len(r.undo) > maxUndo {
+		r.undo = r.undo[1:]
+	}
+}
+
+// openBrackets returns the number of brackets opened but not closed in src.
+// returns 0 if src cannot be lexed, so that the error is reported.
+func openBrackets(src string) int {
+	tokens, err := lexer.Lex(src)
+	if err != nil {
+		return 0
+	}
+	depth := 0
+	for _, tok := range tokens {
+		switch tok.Type {
+		case lexer.TTLParen, lexer.TTLBrace, lexer.TTLBracket:
+			depth++
+		case lexer.TTRParen, lexer.TTRBrace, lexer.TTRBracket:
+			depth--
+		}
+	}
+	return depth
+}
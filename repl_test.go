@@ -0,0 +1,95 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/program"
+	"github.com/smackem/ylang/ylang"
+)
+
+// newTestSurface returns a surface with a black source image of the specified size
+func newTestSurface(width, height int) *ylang.Surface {
+	source := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(source, source.Bounds(), image.Black, image.Point{}, draw.Src)
+	return ylang.NewSurface(source)
+}
+
+func Test_repl(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "expressions",
+			input: "a := 1 + 2\na * 2\nlog(W, \"x\", H)\n",
+			want:  "> > 6\n> 4x3\n> \n",
+		},
+		{
+			name:  "multiline",
+			input: "f := fn(x) {\nreturn x + 1\n}\nf(1)\n",
+			want:  "> ... ... > 2\n> \n",
+		},
+		{
+			name:  "undo",
+			input: "@(0;0) = #ff0000\nflip()\n@(0;0)\n:undo\n:undo\n@(0;0)\n:undo\n:undo\n:undo\n",
+			want:  "> > 0\n> rgba(255,0,0:255)\n> > > rgba(0,0,0:255)\n> nothing to undo\n> nothing to undo\n> nothing to undo\n> \n",
+		},
+		{
+			name:  "reset",
+			input: "a := 1\n:reset\na\n",
+			want:  "> > > compilation error: 1:1: identifier 'a' not found\na\n^\n> \n",
+		},
+		{
+			name:  "errors",
+			input: "1 + true\n:nope\n:quit\n1\n",
+			want: "> execution error: 1:3: type mismatch: expected number + number or number + color, found number + interpreter.Boolean\n" +
+				"1 + true\n  ^\n> unknown command ':nope', type :help for help\n> ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := strings.Builder{}
+			r := newRepl(newTestSurface(4, 3), program.BundleResolver{}, &out)
+			r.run(strings.NewReader(tt.input))
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_repl_reset(t *testing.T) {
+	surf := newTestSurface(4, 3)
+	r := newRepl(surf, nil, &strings.Builder{})
+	r.run(strings.NewReader("@(1;1) = #ffffff\nflip()\nresize(2, 2)\n:reset\n"))
+	if surf.GetPixel(1, 1) != lang.NewRgba(0, 0, 0, 255) || surf.Recall(0) == nil {
+		t.Errorf("source has not been restored")
+	}
+	if surf.TargetWidth() != 4 || surf.TargetHeight() != 3 || surf.Target().At(1, 1) != (color.NRGBA{}) {
+		t.Errorf("target has not been reset")
+	}
+}
+
+func Test_openBrackets(t *testing.T) {
+	tests := []struct {
+		src  string
+		want int
+	}{
+		{"a := 1", 0},
+		{"for i in 0 .. 1 {", 1},
+		{"f := fn(x) { log([x,", 3},
+		{"}", -1},
+		{`"unterminated {`, 0},
+	}
+	for _, tt := range tests {
+		if got := openBrackets(tt.src); got != tt.want {
+			t.Errorf("openBrackets(%q) = %d, want %d", tt.src, got, tt.want)
+		}
+	}
+}
@@ -311,3 +311,8 @@ function rgba01(r01, g01, b01, a01) {
 function rgb01(r01, g01, b01, a01) {
     return color01(r01, g01, b01, 1.0);
 }
+
+// generator objects returned by function* can be iterated directly
+Object.getPrototypeOf(function* () {}).prototype.iter = function () {
+    return this;
+};
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/program"
+	"github.com/smackem/ylang/ylang"
+)
+
+func BenchmarkEdges(b *testing.B) {
+	benchmarkSample(b, "edges.ylang")
+}
+
+func BenchmarkHough(b *testing.B) {
+	benchmarkSample(b, "hough.ylang")
+}
+
+// benchmarkSample measures compiling the sample script name and executing it
+// with both engines against a generated image.
+func benchmarkSample(b *testing.B, name string) {
+	path := filepath.Join("samples", name)
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	img := gradientPNG(b, 96, 64)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	resolver := program.FileResolver{}
+
+	b.Run("compile", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := program.CompileModule(path, string(src), resolver); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	prog, err := program.CompileModule(path, string(src), resolver)
+	if err != nil {
+		b.Fatal(err)
+	}
+	code := program.Emit(prog)
+	engines := []struct {
+		name    string
+		execute func(surf *ylang.Surface) error
+	}{
+		{"interpreter", func(surf *ylang.Surface) error {
+			return program.Execute(context.Background(), prog, surf, interpreter.Options{})
+		}},
+		{"vm", func(surf *ylang.Surface) error {
+			return program.Run(context.Background(), code, surf, interpreter.Options{})
+		}},
+	}
+	for _, engine := range engines {
+		b.Run(engine.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				surf, err := loadSurface(bytes.NewReader(img))
+				if err != nil {
+					b.Fatal(err)
+				}
+				surf.SetLogSink(ylang.DiscardLog)
+				b.StartTimer()
+				if err := engine.execute(surf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// gradientPNG returns a png encoded image with a diagonal edge between two gradients
+func gradientPNG(b *testing.B, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / width)
+			if x > y {
+				v = 255 - uint8(y*255/height)
+			}
+			img.Set(x, y, color.NRGBA{R: v, G: v / 2, B: 255 - v, A: 255})
+		}
+	}
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
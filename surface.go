@@ -3,298 +3,158 @@ package main
 import (
 	"fmt"
 	"image"
-	"image/draw"
-	_ "image/gif"
-	_ "image/jpeg"
-	"image/png"
 	"io"
 	"log"
-	"math"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/imageio"
+	"github.com/smackem/ylang/ylang"
 )
 
-type surface struct {
-	source        *ymage
-	target        *ymage
-	sourceHistory []*ymage
-	clipRect      image.Rectangle
-	log           func(string)
-}
-
-type ymage struct {
-	pixels []lang.Color
-	width  int
-	height int
-}
-
-func loadSurface(reader io.Reader) (*surface, error) {
-	source, err := loadImage(reader)
+func loadSurface(reader io.Reader) (*ylang.Surface, error) {
+	source, encoding, err := image.Decode(reader)
 	if err != nil {
 		return nil, err
 	}
-	target := &ymage{
-		pixels: make([]lang.Color, len(source.pixels)),
-		width:  source.width,
-		height: source.height,
-	}
-	return &surface{
-		source:        source,
-		target:        target,
-		sourceHistory: nil,
-	}, nil
-}
-
-func (surf *surface) GetPixel(x int, y int) lang.Color {
-	return surf.source.pixels[y*surf.source.width+x]
+	log.Printf("Image decoded as %s", encoding)
+	return ylang.NewSurface(source), nil
 }
 
-func (surf *surface) SetPixel(x int, y int, col lang.Color) {
-	if surf.clipRect.Empty() == false {
-		pt := image.Point{x, y}
-		if pt.In(surf.clipRect) == false {
-			return
+// loadInputs decodes the input images at the paths of inputs, keyed by name
+func loadInputs(inputs map[string]string) (map[string]image.Image, error) {
+	images := make(map[string]image.Image, len(inputs))
+	for name, path := range inputs {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not load input image %s: %s", path, err)
 		}
-	}
-	surf.target.pixels[y*surf.target.width+x] = col
-}
-
-func (surf *surface) SourceWidth() int {
-	return surf.source.width
-}
-
-func (surf *surface) SourceHeight() int {
-	return surf.source.height
-}
-
-func (surf *surface) TargetWidth() int {
-	return surf.target.width
-}
-
-func (surf *surface) TargetHeight() int {
-	return surf.target.height
-}
-
-func (surf *surface) Convolute(x, y, width, height int, kernel []lang.Number) lang.Color {
-	kernelSum := lang.Number(0)
-	r := lang.Number(0)
-	g := lang.Number(0)
-	b := lang.Number(0)
-	a := lang.Number(255)
-	kernelIndex := 0
-	w := surf.SourceWidth()
-	h := surf.SourceHeight()
-
-	for kernelY := 0; kernelY < height; kernelY++ {
-		for kernelX := 0; kernelX < width; kernelX++ {
-			sourceY := y - (height / 2) + kernelY
-			sourceX := x - (width / 2) + kernelX
-			if sourceX >= 0 && sourceX < w && sourceY >= 0 && sourceY < h {
-				value := kernel[kernelIndex]
-				px := surf.GetPixel(sourceX, sourceY)
-				r += value * px.R
-				g += value * px.G
-				b += value * px.B
-				kernelSum += value
-
-				if sourceX == x && sourceY == y {
-					a = px.A
-				}
-			}
-			kernelIndex++
+		img, _, err := image.Decode(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error loading input image '%s' from '%s': %s", name, path, err)
 		}
+		images[name] = img
 	}
-	if kernelSum == 0.0 {
-		return lang.NewRgba(r, g, b, a)
-	}
-
-	return lang.NewRgba(r/kernelSum, g/kernelSum, b/kernelSum, a)
+	return images, nil
 }
 
-func (surf *surface) mapChannel(x, y, width, height int, kernel []lang.Number, mapper func(lang.Color) lang.Number) []lang.Number {
-	result := make([]lang.Number, len(kernel))
-	kernelIndex := 0
-	w := surf.SourceWidth()
-	h := surf.SourceHeight()
-
-	for kernelY := 0; kernelY < height; kernelY++ {
-		for kernelX := 0; kernelX < width; kernelX++ {
-			sourceY := y - (height / 2) + kernelY
-			sourceX := x - (width / 2) + kernelX
-			if sourceX >= 0 && sourceX < w && sourceY >= 0 && sourceY < h {
-				px := surf.GetPixel(sourceX, sourceY)
-				result[kernelIndex] = kernel[kernelIndex] * mapper(px)
-			}
-			kernelIndex++
-		}
+// openSurface loads the image at path into a new surface with the specified input images
+func openSurface(path string, inputs map[string]image.Image) (*ylang.Surface, error) {
+	sourceFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s: %s", path, err.Error())
 	}
-	return result
-}
-
-func (surf *surface) MapRed(x, y, radius, width int, kernel []lang.Number) []lang.Number {
-	return surf.mapChannel(x, y, radius, width, kernel, func(px lang.Color) lang.Number { return px.R })
-}
-
-func (surf *surface) MapGreen(x, y, radius, width int, kernel []lang.Number) []lang.Number {
-	return surf.mapChannel(x, y, radius, width, kernel, func(px lang.Color) lang.Number { return px.G })
-}
-
-func (surf *surface) MapBlue(x, y, radius, width int, kernel []lang.Number) []lang.Number {
-	return surf.mapChannel(x, y, radius, width, kernel, func(px lang.Color) lang.Number { return px.B })
-}
-
-func (surf *surface) MapAlpha(x, y, radius, width int, kernel []lang.Number) []lang.Number {
-	return surf.mapChannel(x, y, radius, width, kernel, func(px lang.Color) lang.Number { return px.A })
-}
-
-func (surf *surface) Blt(x, y, width, height int) {
-	bltRect := image.Rect(x, y, width, height)
-	srcRect := image.Rect(0, 0, surf.source.width, surf.source.height)
-	trgRect := image.Rect(0, 0, surf.target.width, surf.target.height)
+	defer func() { _ = sourceFile.Close() }()
 
-	if bltRect == srcRect && trgRect == srcRect {
-		if surf.clipRect.Empty() || surf.clipRect == bltRect {
-			copy(surf.target.pixels, surf.source.pixels)
-			return
-		}
+	surf, err := loadSurface(sourceFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading image from '%s': %s", path, err.Error())
 	}
-
-	rect := bltRect.Intersect(srcRect).Intersect(trgRect).Intersect(surf.clipRect)
-
-	for iy := rect.Min.Y; iy < rect.Max.Y; iy++ {
-		for ix := rect.Min.X; ix < rect.Max.X; ix++ {
-			index := iy*surf.target.width + ix
-			surf.target.pixels[index] = surf.source.pixels[index]
-		}
+	for name, img := range inputs {
+		surf.SetInput(name, img)
 	}
+	return surf, nil
 }
 
-func (surf *surface) ResizeTarget(width, height int) {
-	surf.target = &ymage{
-		width:  width,
-		height: height,
-		pixels: make([]lang.Color, width*height),
+// saveSurface writes the frames recorded by surf to targetPath, see saveFrames, or its target
+// image if there are none
+func saveSurface(surf *ylang.Surface, targetPath string, options imageio.Options) error {
+	frames := surf.Frames()
+	if len(frames) == 0 {
+		if err := saveImage(targetImage(surf, options), targetPath, options); err != nil {
+			return fmt.Errorf("error saving image %s: %s", targetPath, err.Error())
+		}
+		log.Printf("Saved image to '%s' as %s", targetPath, options.Format)
+		return nil
 	}
-	surf.clipRect = image.Rectangle{}
-}
-
-func (surf *surface) Flip() int {
-	oldSourceID := len(surf.sourceHistory)
-	surf.sourceHistory = append(surf.sourceHistory, surf.source)
-	surf.source = surf.target
-	surf.target = &ymage{
-		width:  surf.source.width,
-		height: surf.source.height,
-		pixels: append([]lang.Color(nil), surf.source.pixels...),
+	if err := saveFrames(frames, targetPath, options); err != nil {
+		return fmt.Errorf("error saving frames %s: %s", targetPath, err.Error())
 	}
-	surf.clipRect = image.Rectangle{}
-	return oldSourceID
-}
-
-func (surf *surface) Recall(imageID int) error {
-	if imageID >= len(surf.sourceHistory) {
-		return fmt.Errorf("unknown context %d - cannot recall", imageID)
+	if options.Format == "gif" {
+		log.Printf("Saved %d frames to '%s' as animated gif", len(frames), targetPath)
+	} else {
+		log.Printf("Saved %d frames to '%s'...'%s' as %s", len(frames), framePath(targetPath, 0), framePath(targetPath, len(frames)-1), options.Format)
 	}
-	surf.source = surf.sourceHistory[imageID]
 	return nil
 }
 
-func (surf *surface) ClipRect() image.Rectangle {
-	return surf.clipRect
-}
-
-func (surf *surface) SetClipRect(rect image.Rectangle) {
-	surf.clipRect = rect
+// targetImage returns the target image of surf with the channel depth images are encoded with
+func targetImage(surf *ylang.Surface, options imageio.Options) image.Image {
+	switch options.EffectiveDepth() {
+	case imageio.Depth16:
+		return surf.Target16()
+	case imageio.DepthFloat:
+		return surf.TargetFloat()
+	}
+	return surf.Target()
 }
 
-func (surf *surface) Log(message string) {
-	if surf.log == nil {
-		fmt.Println(message)
-		return
+// frameImage returns the image of frame with the channel depth images are encoded with
+func frameImage(frame ylang.Frame, options imageio.Options) image.Image {
+	switch options.EffectiveDepth() {
+	case imageio.Depth16:
+		return frame.Image16()
+	case imageio.DepthFloat:
+		return frame.ImageFloat()
 	}
-	surf.log(message)
+	return frame.Image()
 }
 
-func (surf *surface) InterpolatePixel(x float32, y float32) *lang.Color {
-	if x >= float32(surf.source.width) || y >= float32(surf.source.height) {
-		return nil
-	}
-	x -= 0.5
-	y -= 0.5
-	if x < 0.0 || y < 0.0 {
+// saveFrames writes frames to targetPath as animated GIF if the format of options is gif.
+// Otherwise each frame is written to its own file, see framePath.
+func saveFrames(frames []ylang.Frame, targetPath string, options imageio.Options) error {
+	if options.Format != "gif" {
+		for i, frame := range frames {
+			if err := saveImage(frameImage(frame, options), framePath(targetPath, i), options); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
-	baseX, baseY := int(x), int(y)
-	ratioX, ratioY := x-float32(baseX), y-float32(baseY)
-	baseColor := surf.GetPixel(baseX, baseY)
-	rightColor := surf.GetPixel(baseX+1, baseY)
-	bottomColor := surf.GetPixel(baseX, baseY+1)
-	return &lang.Color{
-		A: baseColor.A,
-		R: interpolate(baseColor.R, rightColor.R, bottomColor.R, ratioX, ratioY),
-		G: interpolate(baseColor.G, rightColor.G, bottomColor.G, ratioX, ratioY),
-		B: interpolate(baseColor.B, rightColor.B, bottomColor.B, ratioX, ratioY),
+	images := make([]image.Image, len(frames))
+	delays := make([]time.Duration, len(frames))
+	for i, frame := range frames {
+		images[i] = frame.Image()
+		delays[i] = frame.Delay
 	}
-}
-
-func interpolate(orig lang.Number, right lang.Number, bottom lang.Number, ratioX float32, ratioY float32) lang.Number {
-	dx := float64(right-orig) * float64(ratioX)
-	dy := float64(bottom-orig) * float64(ratioY)
-	return orig + lang.Number(math.Sqrt(dx*dx+dy*dy))
-}
-
-func loadImage(reader io.Reader) (*ymage, error) {
-	source, encoding, err := image.Decode(reader)
+	targetFile, err := os.Create(targetPath)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("error creating file %s: %s", targetPath, err)
 	}
+	defer targetFile.Close()
 
-	log.Printf("Image decoded as %s", encoding)
-	target := image.NewNRGBA(source.Bounds())
-	draw.Draw(target, target.Bounds(), source, image.Point{0, 0}, draw.Src)
-
-	byteCount := len(target.Pix)
-	pixels := make([]lang.Color, byteCount/4)
-	j := 0
-	for i := 0; i < byteCount; i += 4 {
-		pixels[j] = lang.NewRgba(
-			lang.Number(target.Pix[i+0]),
-			lang.Number(target.Pix[i+1]),
-			lang.Number(target.Pix[i+2]),
-			lang.Number(target.Pix[i+3]))
-		j++
-	}
+	return imageio.EncodeAnimation(targetFile, images, delays, options)
+}
 
-	return &ymage{
-		pixels: pixels,
-		width:  target.Rect.Dx(),
-		height: target.Rect.Dy(),
-	}, nil
+// framePath returns the path of the frame with the zero-based index of an image sequence
+// written to targetPath, which is numbered before the extension: out.png -> out-007.png
+func framePath(targetPath string, index int) string {
+	ext := filepath.Ext(targetPath)
+	return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(targetPath, ext), index, ext)
 }
 
-func saveImage(ymg *ymage, targetPath string) error {
+// saveImage writes img to targetPath in the format of options,
+// which defaults to the format of the file extension of targetPath
+func saveImage(img image.Image, targetPath string, options imageio.Options) error {
+	if options.Format == "" {
+		format, err := imageio.FormatOf(targetPath)
+		if err != nil {
+			return err
+		}
+		options.Format = format
+	}
 	targetFile, err := os.Create(targetPath)
 	if err != nil {
 		return fmt.Errorf("error creating file %s: %s", targetPath, err)
 	}
 	defer targetFile.Close()
 
-	return writeImage(ymg, targetFile)
+	return writeImage(img, targetFile, options)
 }
 
-func writeImage(ymg *ymage, writer io.Writer) error {
-	img := image.NewNRGBA(image.Rect(0, 0, ymg.width, ymg.height))
-	byteCount := len(img.Pix)
-	j := 0
-	for i := 0; i < byteCount; i += 4 {
-		rgba := ymg.pixels[j].Clamp()
-		img.Pix[i+0] = byte(rgba.R)
-		img.Pix[i+1] = byte(rgba.G)
-		img.Pix[i+2] = byte(rgba.B)
-		img.Pix[i+3] = byte(rgba.A)
-		j++
-	}
-
-	return png.Encode(writer, img)
+func writeImage(img image.Image, writer io.Writer, options imageio.Options) error {
+	return imageio.Encode(writer, img, options)
 }
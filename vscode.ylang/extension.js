@@ -0,0 +1,56 @@
+const vscode = require('vscode');
+const { LanguageClient } = require('vscode-languageclient/node');
+
+let client;
+
+function activate(context) {
+    const config = vscode.workspace.getConfiguration('ylang');
+    const args = ['lsp'];
+    const searchPath = config.get('searchPath');
+    if (searchPath) {
+        args.push('-path', searchPath);
+    }
+    const server = { command: config.get('executable'), args: args };
+    client = new LanguageClient('ylang', 'ylang language server', server, {
+        documentSelector: [{ scheme: 'file', language: 'ylang' }],
+    });
+    context.subscriptions.push(client.start());
+
+    context.subscriptions.push(vscode.debug.registerDebugAdapterDescriptorFactory('ylang', {
+        createDebugAdapterDescriptor() {
+            const args = ['dap'];
+            if (searchPath) {
+                args.push('-path', searchPath);
+            }
+            return new vscode.DebugAdapterExecutable(config.get('executable'), args);
+        },
+    }));
+    context.subscriptions.push(vscode.commands.registerCommand('ylang.showTargetImage', showTargetImage));
+}
+
+// showTargetImage displays the target image of the paused ylang program in a webview
+async function showTargetImage() {
+    const session = vscode.debug.activeDebugSession;
+    if (!session || session.type !== 'ylang') {
+        vscode.window.showErrorMessage('No ylang program is being debugged');
+        return;
+    }
+    let image;
+    try {
+        image = await session.customRequest('targetImage');
+    } catch (err) {
+        vscode.window.showErrorMessage(err.message);
+        return;
+    }
+    const panel = vscode.window.createWebviewPanel('ylangTargetImage',
+        `Target Image (${image.width}x${image.height})`, vscode.ViewColumn.Beside, {});
+    panel.webview.html = `<!DOCTYPE html><html><body>
+<img src="data:${image.mimeType};base64,${image.data}" style="image-rendering: pixelated; max-width: 100%">
+</body></html>`;
+}
+
+function deactivate() {
+    return client ? client.stop() : undefined;
+}
+
+module.exports = { activate, deactivate };
@@ -0,0 +1,57 @@
+package ylang
+
+import (
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/program"
+)
+
+// Registry holds builtin functions, constants and value types a host adds to the predefined ones.
+// Scripts using them are compiled with CompileModuleWith. A Registry must not be modified while
+// programs compiled with it are executed.
+type Registry = interpreter.Registry
+
+// FunctionDecl is an overload of a builtin function, see NewFunctionDecl.
+type FunctionDecl = interpreter.FunctionDecl
+
+// Value is a ylang value at runtime. Hosts may implement it to add custom types with Registry.AddType.
+type Value = interpreter.Value
+
+// The predefined value types that are passed to and returned by builtin functions
+type (
+	NumberValue  = interpreter.Number
+	BoolValue    = interpreter.Boolean
+	StrValue     = interpreter.Str
+	ColorValue   = interpreter.Color
+	PointValue   = interpreter.Point
+	RectValue    = interpreter.Rect
+	KernelValue  = interpreter.Kernel
+	ListValue    = interpreter.List
+	HashMapValue = interpreter.HashMap
+	ImageValue   = interpreter.Image
+	NilValue     = interpreter.Nilval
+)
+
+// NewRegistry returns an empty Registry
+var NewRegistry = interpreter.NewRegistry
+
+// NewFunctionDecl returns an overload of a builtin function, which is invoked with the arguments
+// of an invocation if their types match the reflect.Type of each parameter, e.g.
+// reflect.TypeOf(NumberValue(0)). The last parameter may be a slice type like []NumberValue,
+// which matches any number of trailing arguments. ValueType matches any argument.
+// body must be safe for concurrent use, since programs sharing the registry may be executed at the
+// same time. Set the Pure field of the declaration to allow invoking the function in parallel for
+// loops, which requires that it neither modifies the bitmap nor other shared state.
+var NewFunctionDecl = interpreter.NewFunctionDecl
+
+// ValueType is the parameter type of builtin functions accepting any value
+var ValueType = interpreter.ValueType
+
+// CompileModuleWith compiles a script like CompileModule against the builtin functions, constants
+// and types added to registry as well. The program is executed with registry by Run.
+func CompileModuleWith(name string, src string, resolver Resolver, registry *Registry) (*Program, error) {
+	prog, err := program.CompileModuleWith(name, src, resolver, registry)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{prog: prog, registry: registry}, nil
+}
@@ -0,0 +1,552 @@
+package ylang
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"time"
+
+	"github.com/smackem/ylang/internal/imageio"
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lang"
+)
+
+// InputImage is a named image scripts read besides the source image, see Surface.SetInput
+type InputImage = interpreter.InputImage
+
+// FloatImage is an image with float channels that are neither clamped nor rounded, e.g. returned
+// by Surface.TargetFloat. A channel value of 1 is full intensity.
+// NewSurface keeps the values of FloatImages, like images decoded from PFM files.
+type FloatImage = imageio.FloatImage
+
+// Surface is the BitmapContext ylang scripts are executed against by the ylang command.
+// It keeps the source and target images as colors with float channels, which are only
+// clamped to 0..255 when the target image is retrieved with Target or Target16.
+// A Surface must not be used by more than one execution at a time.
+type Surface struct {
+	original      *ymage
+	source        *ymage
+	target        *ymage
+	sourceHistory []*ymage
+	clipRect      image.Rectangle
+	log           LogSink
+	frames        []Frame
+	animation     AnimationOptions
+	inputs        map[string]*ymage
+}
+
+// AnimationOptions control how a Surface records the frames of an animation
+type AnimationOptions struct {
+	Delay      time.Duration // the delay of frames recorded without explicit delay, 0 for DefaultFrameDelay
+	FlipFrames bool          // if true, each flip records the target image as frame before it becomes the source image
+}
+
+// DefaultFrameDelay is the delay of frames recorded without explicit delay if AnimationOptions specify none
+const DefaultFrameDelay = 100 * time.Millisecond
+
+// Frame is an image of an animation recorded by a Surface, either by the frame function
+// or by flip if AnimationOptions.FlipFrames is set.
+type Frame struct {
+	Delay time.Duration // the time the frame is shown
+	image *ymage
+}
+
+// Image returns the image of the frame with all channels clamped to 0..255, see Surface.Target
+func (f Frame) Image() image.Image {
+	return f.image.toNRGBA()
+}
+
+// Image16 returns the image of the frame with 16 bit channels, see Surface.Target16
+func (f Frame) Image16() *image.NRGBA64 {
+	return f.image.toNRGBA64()
+}
+
+// ImageFloat returns the image of the frame with float channels, see Surface.TargetFloat
+func (f Frame) ImageFloat() FloatImage {
+	return f.image.toFloat()
+}
+
+type ymage struct {
+	pixels []lang.Color
+	width  int
+	height int
+}
+
+// NewSurface returns a Surface with a copy of img as source image and an empty
+// target image of the same size. Messages logged by scripts are written to stdout.
+func NewSurface(img image.Image) *Surface {
+	source := newYmage(img)
+	return &Surface{
+		original: source,
+		source:   source,
+		target: &ymage{
+			pixels: make([]lang.Color, len(source.pixels)),
+			width:  source.width,
+			height: source.height,
+		},
+	}
+}
+
+// SetInput adds a copy of img as input image with the specified name, which scripts access
+// as Images.name or Images["name"]. An input image with the same name is replaced.
+func (surf *Surface) SetInput(name string, img image.Image) {
+	if surf.inputs == nil {
+		surf.inputs = make(map[string]*ymage)
+	}
+	surf.inputs[name] = newYmage(img)
+}
+
+// Inputs returns the input images added with SetInput by name
+func (surf *Surface) Inputs() map[string]InputImage {
+	inputs := make(map[string]InputImage, len(surf.inputs))
+	for name, img := range surf.inputs {
+		inputs[name] = img
+	}
+	return inputs
+}
+
+// SetAnimation sets the options controlling the recording of frames
+func (surf *Surface) SetAnimation(options AnimationOptions) {
+	surf.animation = options
+}
+
+// Frames returns the frames recorded by the executed scripts in the order of recording
+func (surf *Surface) Frames() []Frame {
+	return surf.frames
+}
+
+// SetLogSink directs the messages logged by scripts to sink, nil restores the default
+func (surf *Surface) SetLogSink(sink LogSink) {
+	surf.log = sink
+}
+
+func (surf *Surface) GetPixel(x int, y int) lang.Color {
+	return surf.source.GetPixel(x, y)
+}
+
+func (surf *Surface) SetPixel(x int, y int, col lang.Color) {
+	if x < 0 || y < 0 || x >= surf.target.width || y >= surf.target.height {
+		return
+	}
+	if surf.clipRect.Empty() == false {
+		pt := image.Point{x, y}
+		if pt.In(surf.clipRect) == false {
+			return
+		}
+	}
+	surf.target.pixels[y*surf.target.width+x] = col
+}
+
+func (surf *Surface) SourceWidth() int {
+	return surf.source.width
+}
+
+func (surf *Surface) SourceHeight() int {
+	return surf.source.height
+}
+
+func (surf *Surface) TargetWidth() int {
+	return surf.target.width
+}
+
+func (surf *Surface) TargetHeight() int {
+	return surf.target.height
+}
+
+func (surf *Surface) Convolute(x, y, width, height int, kernel []lang.Number) lang.Color {
+	return surf.source.Convolute(x, y, width, height, kernel)
+}
+
+func (surf *Surface) MapRed(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return surf.source.MapRed(x, y, width, height, kernel)
+}
+
+func (surf *Surface) MapGreen(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return surf.source.MapGreen(x, y, width, height, kernel)
+}
+
+func (surf *Surface) MapBlue(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return surf.source.MapBlue(x, y, width, height, kernel)
+}
+
+func (surf *Surface) MapAlpha(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return surf.source.MapAlpha(x, y, width, height, kernel)
+}
+
+func (surf *Surface) Blt(x, y, width, height int) {
+	bltRect := image.Rect(x, y, width, height)
+	srcRect := image.Rect(0, 0, surf.source.width, surf.source.height)
+	trgRect := image.Rect(0, 0, surf.target.width, surf.target.height)
+
+	if bltRect == srcRect && trgRect == srcRect {
+		if surf.clipRect.Empty() || surf.clipRect == bltRect {
+			copy(surf.target.pixels, surf.source.pixels)
+			return
+		}
+	}
+
+	rect := bltRect.Intersect(srcRect).Intersect(trgRect).Intersect(surf.clipRect)
+
+	for iy := rect.Min.Y; iy < rect.Max.Y; iy++ {
+		for ix := rect.Min.X; ix < rect.Max.X; ix++ {
+			index := iy*surf.target.width + ix
+			surf.target.pixels[index] = surf.source.pixels[index]
+		}
+	}
+}
+
+func (surf *Surface) ResizeTarget(width, height int) {
+	surf.target = &ymage{
+		width:  width,
+		height: height,
+		pixels: make([]lang.Color, width*height),
+	}
+	surf.clipRect = image.Rectangle{}
+}
+
+func (surf *Surface) Flip() int {
+	if surf.animation.FlipFrames {
+		surf.Frame(-1)
+	}
+	oldSourceID := len(surf.sourceHistory)
+	surf.sourceHistory = append(surf.sourceHistory, surf.source)
+	surf.source = surf.target
+	surf.target = &ymage{
+		width:  surf.source.width,
+		height: surf.source.height,
+		pixels: append([]lang.Color(nil), surf.source.pixels...),
+	}
+	surf.clipRect = image.Rectangle{}
+	return oldSourceID
+}
+
+func (surf *Surface) Recall(imageID int) error {
+	if imageID >= len(surf.sourceHistory) {
+		return fmt.Errorf("unknown context %d - cannot recall", imageID)
+	}
+	surf.source = surf.sourceHistory[imageID]
+	return nil
+}
+
+func (surf *Surface) Frame(delay int) {
+	frame := Frame{
+		Delay: time.Duration(delay) * time.Millisecond,
+		image: &ymage{
+			width:  surf.target.width,
+			height: surf.target.height,
+			pixels: append([]lang.Color(nil), surf.target.pixels...),
+		},
+	}
+	if delay < 0 {
+		frame.Delay = surf.animation.Delay
+		if frame.Delay <= 0 {
+			frame.Delay = DefaultFrameDelay
+		}
+	}
+	surf.frames = append(surf.frames, frame)
+}
+
+func (surf *Surface) ClipRect() image.Rectangle {
+	return surf.clipRect
+}
+
+func (surf *Surface) SetClipRect(rect image.Rectangle) {
+	surf.clipRect = rect
+}
+
+func (surf *Surface) Log(message string) {
+	if surf.log == nil {
+		fmt.Println(message)
+		return
+	}
+	surf.log(message)
+}
+
+func (surf *Surface) InterpolatePixel(x float32, y float32) *lang.Color {
+	if x >= float32(surf.source.width) || y >= float32(surf.source.height) {
+		return nil
+	}
+	x -= 0.5
+	y -= 0.5
+	if x < 0.0 || y < 0.0 {
+		return nil
+	}
+	baseX, baseY := int(x), int(y)
+	ratioX, ratioY := x-float32(baseX), y-float32(baseY)
+	baseColor := surf.GetPixel(baseX, baseY)
+	rightColor := surf.GetPixel(baseX+1, baseY)
+	bottomColor := surf.GetPixel(baseX, baseY+1)
+	return &lang.Color{
+		A: baseColor.A,
+		R: interpolate(baseColor.R, rightColor.R, bottomColor.R, ratioX, ratioY),
+		G: interpolate(baseColor.G, rightColor.G, bottomColor.G, ratioX, ratioY),
+		B: interpolate(baseColor.B, rightColor.B, bottomColor.B, ratioX, ratioY),
+	}
+}
+
+func interpolate(orig lang.Number, right lang.Number, bottom lang.Number, ratioX float32, ratioY float32) lang.Number {
+	dx := float64(right-orig) * float64(ratioX)
+	dy := float64(bottom-orig) * float64(ratioY)
+	return orig + lang.Number(math.Sqrt(dx*dx+dy*dy))
+}
+
+// Target returns the target image with all channels clamped to 0..255
+func (surf *Surface) Target() image.Image {
+	return surf.target.toNRGBA()
+}
+
+// Target16 returns the target image with 16 bit channels, clamped to 0..255 and scaled to 0..65535.
+func (surf *Surface) Target16() *image.NRGBA64 {
+	return surf.target.toNRGBA64()
+}
+
+// TargetFloat returns the target image with float channels that are neither clamped nor rounded,
+// scaled from 0..255 to 0..1. Negative values are kept as well.
+func (surf *Surface) TargetFloat() FloatImage {
+	return surf.target.toFloat()
+}
+
+// SurfaceState is a snapshot of a Surface taken with Snapshot
+type SurfaceState struct {
+	source       *ymage
+	target       *ymage
+	clipRect     image.Rectangle
+	historyCount int
+	frameCount   int
+}
+
+// Snapshot returns the current state of the surface, to which it can be reverted with Restore.
+// the source images are not copied since Flip never modifies them - it moves them to the history.
+func (surf *Surface) Snapshot() SurfaceState {
+	target := *surf.target
+	target.pixels = append([]lang.Color(nil), target.pixels...)
+	return SurfaceState{
+		source:       surf.source,
+		target:       &target,
+		clipRect:     surf.clipRect,
+		historyCount: len(surf.sourceHistory),
+		frameCount:   len(surf.frames),
+	}
+}
+
+// Restore reverts the surface to a state returned by Snapshot. Snapshots taken after
+// the state must not be restored anymore.
+func (surf *Surface) Restore(state SurfaceState) {
+	surf.source = state.source
+	surf.target = state.target
+	surf.clipRect = state.clipRect
+	surf.sourceHistory = surf.sourceHistory[:state.historyCount]
+	surf.frames = surf.frames[:state.frameCount]
+}
+
+// Reset reverts the surface to the state returned by NewSurface
+func (surf *Surface) Reset() {
+	surf.source = surf.original
+	surf.sourceHistory = nil
+	surf.frames = nil
+	surf.ResizeTarget(surf.original.width, surf.original.height)
+}
+
+// ymage implements interpreter.InputImage, so that the source image and the input images
+// are read the same way
+
+func (ymg *ymage) GetPixel(x int, y int) lang.Color {
+	return ymg.pixels[y*ymg.width+x]
+}
+
+func (ymg *ymage) Width() int {
+	return ymg.width
+}
+
+func (ymg *ymage) Height() int {
+	return ymg.height
+}
+
+func (ymg *ymage) Convolute(x, y, width, height int, kernel []lang.Number) lang.Color {
+	kernelSum := lang.Number(0)
+	r := lang.Number(0)
+	g := lang.Number(0)
+	b := lang.Number(0)
+	a := lang.Number(255)
+	kernelIndex := 0
+	w := ymg.width
+	h := ymg.height
+
+	for kernelY := 0; kernelY < height; kernelY++ {
+		for kernelX := 0; kernelX < width; kernelX++ {
+			sourceY := y - (height / 2) + kernelY
+			sourceX := x - (width / 2) + kernelX
+			if sourceX >= 0 && sourceX < w && sourceY >= 0 && sourceY < h {
+				value := kernel[kernelIndex]
+				px := ymg.GetPixel(sourceX, sourceY)
+				r += value * px.R
+				g += value * px.G
+				b += value * px.B
+				kernelSum += value
+
+				if sourceX == x && sourceY == y {
+					a = px.A
+				}
+			}
+			kernelIndex++
+		}
+	}
+	if kernelSum == 0.0 {
+		return lang.NewRgba(r, g, b, a)
+	}
+
+	return lang.NewRgba(r/kernelSum, g/kernelSum, b/kernelSum, a)
+}
+
+func (ymg *ymage) mapChannel(x, y, width, height int, kernel []lang.Number, mapper func(lang.Color) lang.Number) []lang.Number {
+	result := make([]lang.Number, len(kernel))
+	kernelIndex := 0
+	w := ymg.width
+	h := ymg.height
+
+	for kernelY := 0; kernelY < height; kernelY++ {
+		for kernelX := 0; kernelX < width; kernelX++ {
+			sourceY := y - (height / 2) + kernelY
+			sourceX := x - (width / 2) + kernelX
+			if sourceX >= 0 && sourceX < w && sourceY >= 0 && sourceY < h {
+				px := ymg.GetPixel(sourceX, sourceY)
+				result[kernelIndex] = kernel[kernelIndex] * mapper(px)
+			}
+			kernelIndex++
+		}
+	}
+	return result
+}
+
+func (ymg *ymage) MapRed(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return ymg.mapChannel(x, y, width, height, kernel, func(px lang.Color) lang.Number { return px.R })
+}
+
+func (ymg *ymage) MapGreen(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return ymg.mapChannel(x, y, width, height, kernel, func(px lang.Color) lang.Number { return px.G })
+}
+
+func (ymg *ymage) MapBlue(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return ymg.mapChannel(x, y, width, height, kernel, func(px lang.Color) lang.Number { return px.B })
+}
+
+func (ymg *ymage) MapAlpha(x, y, width, height int, kernel []lang.Number) []lang.Number {
+	return ymg.mapChannel(x, y, width, height, kernel, func(px lang.Color) lang.Number { return px.A })
+}
+
+// newYmage converts img to colors with channels ranging from 0 to 255. The channels of 16 bit
+// images keep their precision as fractions, those of float images are not clamped. The channels
+// of all other images are integers.
+func newYmage(img image.Image) *ymage {
+	bounds := img.Bounds()
+	switch i := img.(type) {
+	case FloatImage:
+		return newFloatYmage(i)
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		return newYmage16(img)
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+
+	byteCount := len(nrgba.Pix)
+	pixels := make([]lang.Color, byteCount/4)
+	j := 0
+	for i := 0; i < byteCount; i += 4 {
+		pixels[j] = lang.NewRgba(
+			lang.Number(nrgba.Pix[i+0]),
+			lang.Number(nrgba.Pix[i+1]),
+			lang.Number(nrgba.Pix[i+2]),
+			lang.Number(nrgba.Pix[i+3]))
+		j++
+	}
+
+	return &ymage{
+		pixels: pixels,
+		width:  nrgba.Rect.Dx(),
+		height: nrgba.Rect.Dy(),
+	}
+}
+
+// newFloatYmage converts the float channels of img from 0..1 to 0..255 without clamping them
+func newFloatYmage(img FloatImage) *ymage {
+	bounds := img.Bounds()
+	pixels := make([]lang.Color, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.FloatAt(x, y)
+			pixels = append(pixels, lang.NewRgba(lang.Number(r*255), lang.Number(g*255), lang.Number(b*255), lang.Number(a*255)))
+		}
+	}
+	return &ymage{pixels: pixels, width: bounds.Dx(), height: bounds.Dy()}
+}
+
+// newYmage16 converts the 16 bit channels of img to 0..255, keeping their precision as fractions
+func newYmage16(img image.Image) *ymage {
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+
+	byteCount := len(nrgba.Pix)
+	pixels := make([]lang.Color, byteCount/8)
+	j := 0
+	channel := func(i int) lang.Number {
+		return lang.Number(int(nrgba.Pix[i])<<8|int(nrgba.Pix[i+1])) / 257
+	}
+	for i := 0; i < byteCount; i += 8 {
+		pixels[j] = lang.NewRgba(channel(i), channel(i+2), channel(i+4), channel(i+6))
+		j++
+	}
+
+	return &ymage{
+		pixels: pixels,
+		width:  nrgba.Rect.Dx(),
+		height: nrgba.Rect.Dy(),
+	}
+}
+
+func (ymg *ymage) toNRGBA() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, ymg.width, ymg.height))
+	byteCount := len(img.Pix)
+	j := 0
+	for i := 0; i < byteCount; i += 4 {
+		rgba := ymg.pixels[j].Clamp()
+		img.Pix[i+0] = byte(rgba.R)
+		img.Pix[i+1] = byte(rgba.G)
+		img.Pix[i+2] = byte(rgba.B)
+		img.Pix[i+3] = byte(rgba.A)
+		j++
+	}
+	return img
+}
+
+func (ymg *ymage) toNRGBA64() *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, ymg.width, ymg.height))
+	byteCount := len(img.Pix)
+	j := 0
+	channel := func(i int, v lang.Number) {
+		n := uint16(math.Round(float64(v) * 257))
+		img.Pix[i], img.Pix[i+1] = byte(n>>8), byte(n)
+	}
+	for i := 0; i < byteCount; i += 8 {
+		rgba := ymg.pixels[j].Clamp()
+		channel(i, rgba.R)
+		channel(i+2, rgba.G)
+		channel(i+4, rgba.B)
+		channel(i+6, rgba.A)
+		j++
+	}
+	return img
+}
+
+func (ymg *ymage) toFloat() *imageio.Float {
+	img := imageio.NewFloat(image.Rect(0, 0, ymg.width, ymg.height))
+	for i, col := range ymg.pixels {
+		img.Pix[i*4+0] = float32(col.R / 255)
+		img.Pix[i*4+1] = float32(col.G / 255)
+		img.Pix[i*4+2] = float32(col.B / 255)
+		img.Pix[i*4+3] = float32(col.A / 255)
+	}
+	return img
+}
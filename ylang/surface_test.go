@@ -0,0 +1,172 @@
+package ylang
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSurface_precision(t *testing.T) {
+	src := image.NewNRGBA64(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA64(0, 0, color.NRGBA64{R: 0x1234, G: 0x8000, B: 0xffff, A: 0xffff})
+	src.SetNRGBA64(1, 0, color.NRGBA64{R: 0x0101, A: 0xffff})
+	prog, err := Compile(`@(0;0) = @(0;0)
+@(1;0) = rgba(-255, 510, 127.5, 255)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	surf := NewSurface(src)
+	if err := Run(context.Background(), prog, surf, Options{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if got, want := surf.Target16().NRGBA64At(0, 0), src.NRGBA64At(0, 0); got != want {
+		t.Errorf("Target16() pixel = %v, want %v", got, want)
+	}
+	if got, want := surf.Target16().NRGBA64At(1, 0), (color.NRGBA64{G: 0xffff, B: 0x8000, A: 0xffff}); got != want {
+		t.Errorf("Target16() pixel = %v, want clamped %v", got, want)
+	}
+	if got, want := surf.Target().At(0, 0), (color.NRGBA{R: 0x12, G: 0x7f, B: 0xff, A: 0xff}); got != want {
+		t.Errorf("Target() pixel = %v, want %v", got, want)
+	}
+
+	float := surf.TargetFloat()
+	if r, g, b, a := float.FloatAt(1, 0); r != -1 || g != 2 || b != 0.5 || a != 1 {
+		t.Errorf("TargetFloat() pixel = %v %v %v %v, want -1 2 0.5 1", r, g, b, a)
+	}
+	// a float image keeps its values when it is loaded again
+	reloaded := NewSurface(float)
+	if col := reloaded.GetPixel(1, 0); col.R != -255 || col.G != 510 || col.B != 127.5 {
+		t.Errorf("GetPixel() of float image = %v, want -255 510 127.5", col)
+	}
+}
+
+func TestSurface_8bit(t *testing.T) {
+	// decoded JPEG images are YCbCr images with 8 bit channels
+	src := image.NewYCbCr(image.Rect(0, 0, 1, 1), image.YCbCrSubsampleRatio444)
+	src.Y[0], src.Cb[0], src.Cr[0] = 30, 160, 110
+	prog, err := Compile(`log(@(0;0))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	if err := Run(context.Background(), prog, NewSurface(src), Options{Log: func(msg string) { out = append(out, msg) }}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	r, g, b := color.YCbCrToRGB(30, 160, 110)
+	if want := fmt.Sprintf("rgba(%d,%d,%d:255)", r, g, b); len(out) != 1 || out[0] != want {
+		t.Errorf("log = %q, want %q", out, want)
+	}
+}
+
+func TestSurface_frames(t *testing.T) {
+	prog, err := Compile(`@(0;0) = #ff0000
+frame()
+@(0;0) = #00ff00
+frame(250)
+flip()
+resize(3, 2)
+@(2;1) = #0000ff`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		engine     Engine
+		animation  AnimationOptions
+		log        LogSink
+		wantDelays []time.Duration
+	}{
+		{"interpreter", Interpreter, AnimationOptions{}, nil, []time.Duration{DefaultFrameDelay, 250 * time.Millisecond}},
+		{"vm", VM, AnimationOptions{Delay: time.Second}, nil, []time.Duration{time.Second, 250 * time.Millisecond}},
+		{"flip", Interpreter, AnimationOptions{FlipFrames: true}, nil, []time.Duration{DefaultFrameDelay, 250 * time.Millisecond, DefaultFrameDelay}},
+		{"log", Interpreter, AnimationOptions{}, func(string) {}, []time.Duration{DefaultFrameDelay, 250 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			surf := NewSurface(image.NewNRGBA(image.Rect(0, 0, 2, 2)))
+			surf.SetAnimation(tt.animation)
+			if err := Run(context.Background(), prog, surf, Options{Engine: tt.engine, Log: tt.log}); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			frames := surf.Frames()
+			if len(frames) != len(tt.wantDelays) {
+				t.Fatalf("len(Frames()) = %d, want %d", len(frames), len(tt.wantDelays))
+			}
+			for i, frame := range frames {
+				if frame.Delay != tt.wantDelays[i] {
+					t.Errorf("frame %d delay = %s, want %s", i, frame.Delay, tt.wantDelays[i])
+				}
+				if bounds := frame.Image().Bounds(); bounds != image.Rect(0, 0, 2, 2) {
+					t.Errorf("frame %d bounds = %v", i, bounds)
+				}
+			}
+			if got := frames[0].Image().At(0, 0); got != (color.NRGBA{R: 255, A: 255}) {
+				t.Errorf("first frame pixel = %v, want red", got)
+			}
+			if got := frames[1].Image16().At(0, 0); got != (color.NRGBA64{G: 0xffff, A: 0xffff}) {
+				t.Errorf("second frame pixel = %v, want green", got)
+			}
+		})
+	}
+
+	surf := NewSurface(image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	state := surf.Snapshot()
+	surf.Frame(-1)
+	surf.Restore(state)
+	if len(surf.Frames()) != 0 {
+		t.Errorf("Restore() kept %d frames", len(surf.Frames()))
+	}
+	prog, err = Compile("frame(-1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Run(context.Background(), prog, surf, Options{}); err == nil || !strings.Contains(err.Error(), "invalid frame delay -1") {
+		t.Errorf("Run() of negative delay error = %v", err)
+	}
+	// a BitmapContext that does not implement FrameRecorder
+	bitmap := struct{ BitmapContext }{surf}
+	if err := Run(context.Background(), prog, bitmap, Options{}); err == nil || !strings.Contains(err.Error(), "recording frames is not supported") {
+		t.Errorf("Run() without FrameRecorder error = %v", err)
+	}
+}
+
+func TestSurface_inputs(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	bg := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	for x := 0; x < 3; x++ {
+		src.SetNRGBA(x, 0, color.NRGBA{R: 100, G: 50, A: 255})
+		bg.SetNRGBA(x, 0, color.NRGBA{R: uint8(x * 30), G: 50, A: 255})
+	}
+	prog, err := Compile(`bg := Images.background
+for p in Bounds {
+    diff := @p - bg@p
+    @p = rgb(diff.r, convolute(bg, p, |1 1 1
+                                        1 1 1
+                                        1 1 1|).r, fetchRed(bg, p, |0 0 0
+                                                                     0 0 1
+                                                                     0 0 0|)[5])
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, options := range []Options{{Engine: Interpreter}, {Engine: VM}, {Engine: VM, Log: func(string) {}}} {
+		engine := options.Engine
+		surf := NewSurface(src)
+		surf.SetInput("background", bg)
+		if err := Run(context.Background(), prog, surf, options); err != nil {
+			t.Fatalf("Run() engine %d error = %v", engine, err)
+		}
+		// red: src - bg, green: mean of the neighbours of bg, blue: right neighbour of bg
+		want := []color.NRGBA{{R: 100, G: 15, B: 30, A: 255}, {R: 70, G: 30, B: 60, A: 255}, {R: 40, G: 45, A: 255}}
+		for x, w := range want {
+			if got := surf.Target().At(x, 0); got != w {
+				t.Errorf("engine %d pixel %d = %v, want %v", engine, x, got, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package ylang compiles ylang scripts and executes them against images.
+//
+// A script is compiled once with Compile or CompileModule and can then be executed any number
+// of times, also concurrently, with Run against a BitmapContext like Surface or with RunImage
+// against an image.Image:
+//
+//	prog, err := ylang.Compile(`for p in Bounds { @p = -@p }`)
+//	if err != nil {
+//		return err
+//	}
+//	out, err := ylang.RunImage(ctx, prog, img, ylang.Options{})
+//
+// All errors returned by this package while compiling or executing a script are of type *Error.
+package ylang
+
+import (
+	"context"
+	"fmt"
+	"github.com/smackem/ylang/internal/emitter"
+	"github.com/smackem/ylang/internal/interpreter"
+	"github.com/smackem/ylang/internal/lang"
+	"github.com/smackem/ylang/internal/parser"
+	"github.com/smackem/ylang/internal/program"
+	"image"
+	"io"
+	"sync"
+	"time"
+)
+
+// Error is an error that occurred while compiling or executing a script.
+// Line and Col are 1-based and denote the start of the offending source span, Line is 0 if
+// the position is unknown. Runtime errors carry the call stack in Trace.
+type Error = lang.Error
+
+// TraceFrame describes a function call that was active when a runtime error occurred.
+type TraceFrame = lang.TraceFrame
+
+// LimitError is the cause of the *Error returned if a script exceeds one of its Limits
+// or if its context is done. Use errors.As to detect it.
+type LimitError = interpreter.LimitError
+
+// Color is the color of a pixel, with channels ranging from 0 to 255 as long as they are not clamped.
+type Color = lang.Color
+
+// Number is the numeric type of ylang.
+type Number = lang.Number
+
+// BitmapContext is the surface a script works on: it reads the pixels of a source image
+// and writes the pixels of a target image. Surface is the implementation used by the ylang command.
+// Since Options.Threads defaults to GOMAXPROCS, the methods reading pixels, sizes and the clip rect
+// and SetPixel are called concurrently by the workers of parallel loops and must be safe for
+// concurrent use, with each worker setting different pixels. Set Options.Threads to 1 otherwise.
+type BitmapContext = interpreter.BitmapContext
+
+// FrameRecorder is implemented by a BitmapContext like Surface that records the frames of animations
+// drawn by scripts with frame.
+type FrameRecorder = interpreter.FrameRecorder
+
+// InputProvider is implemented by a BitmapContext like Surface that provides named input images,
+// which scripts access through the Images constant.
+type InputProvider = interpreter.InputProvider
+
+// Resolver locates and loads the modules imported by a script.
+type Resolver = program.Resolver
+
+// FileResolver resolves imported modules relative to the importing script and in a list of directories.
+type FileResolver = program.FileResolver
+
+// BundleResolver resolves imported modules from a map of canonical module names to source code.
+type BundleResolver = program.BundleResolver
+
+// Program is a compiled script. It is safe for concurrent use.
+type Program struct {
+	prog     parser.Program
+	registry *Registry // the builtin functions and constants the program has been compiled with, may be nil
+	emitOnce sync.Once
+	code     emitter.Program // the bytecode executed by the VM engine, emitted on first use
+}
+
+// Compile compiles the source code of a script that does not import modules.
+func Compile(src string) (*Program, error) {
+	return CompileModule("", src, nil)
+}
+
+// CompileModule compiles the source code of a script, loading all transitively imported
+// modules through resolver. name is the canonical name of the script, which is passed to the
+// resolver as importer - it may be empty if the script has no name.
+func CompileModule(name string, src string, resolver Resolver) (*Program, error) {
+	return CompileModuleWith(name, src, resolver, nil)
+}
+
+// Params returns the names of the parameters the script declares with 'param name := default'
+// in order of declaration. The value of a parameter passed in Options.Params replaces the default
+// value: it is taken as is by parameters with a string default, all other parameters take the
+// value parsed as boolean, number, color (#rrggbb) or point (x;y), which must have the type of
+// the default value.
+func (p *Program) Params() []string {
+	return interpreter.ParamNames(p.prog)
+}
+
+// Engine selects how a Program is executed
+type Engine int
+
+const (
+	// Interpreter walks the syntax tree of the program
+	Interpreter Engine = iota
+	// VM compiles the program to bytecode once and executes it on a stack machine
+	VM
+)
+
+// Limits restricts the resources a script may use. Zero values impose no limit.
+type Limits struct {
+	MaxSteps      int           // the maximum number of statements and loop iterations, or of instructions for the VM engine
+	Timeout       time.Duration // the maximum wall-clock time of the execution
+	MaxAllocation int           // the maximum number of elements of a list, kernel or hashmap created by a literal, a concatenation or a builtin function and of pixels of a resized target
+	MaxCallDepth  int           // the maximum number of nested function invocations
+}
+
+// LogSink receives the messages scripts log with the builtin function log.
+// It may be called concurrently by parallel loops of the script.
+type LogSink func(message string)
+
+// WriterLogSink returns a LogSink writing each message as a line to w
+func WriterLogSink(w io.Writer) LogSink {
+	mu := sync.Mutex{}
+	return func(message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = fmt.Fprintln(w, message)
+	}
+}
+
+// DiscardLog is a LogSink ignoring all messages
+func DiscardLog(message string) {}
+
+// Options control the execution of a Program
+type Options struct {
+	Engine Engine
+	Limits Limits
+	Log    LogSink           // receives the messages logged by the script, if nil they are passed to the BitmapContext
+	Params map[string]string // the values of the parameters declared by the script, see Program.Params
+
+	// Threads is the maximum number of goroutines executing a parallel loop of the Interpreter engine,
+	// 0 for GOMAXPROCS. 1 executes all loops serially, which is required if the BitmapContext
+	// is not safe for concurrent use.
+	Threads int
+
+	// Constants replace the values of the constants added to the Registry the program has been compiled
+	// with by name, e.g. to execute a program against multiple images with constants describing each image.
+	Constants map[string]Value
+}
+
+// Run executes prog against bitmap. The execution is stopped if ctx is done or the script
+// exceeds one of the limits of options, the returned error then wraps a *LimitError.
+func Run(ctx context.Context, prog *Program, bitmap BitmapContext, options Options) error {
+	if options.Log != nil {
+		if recorder, ok := bitmap.(FrameRecorder); ok {
+			bitmap = frameLogBitmap{logBitmap: logBitmap{BitmapContext: bitmap, log: options.Log}, FrameRecorder: recorder}
+		} else {
+			bitmap = logBitmap{BitmapContext: bitmap, log: options.Log}
+		}
+	}
+	execOptions := interpreter.Options{
+		Registry:      prog.registry,
+		Params:        options.Params,
+		Constants:     options.Constants,
+		MaxSteps:      options.Limits.MaxSteps,
+		Timeout:       options.Limits.Timeout,
+		MaxAllocation: options.Limits.MaxAllocation,
+		MaxCallDepth:  options.Limits.MaxCallDepth,
+		Threads:       options.Threads,
+	}
+	switch options.Engine {
+	case Interpreter:
+		return program.Execute(ctx, prog.prog, bitmap, execOptions)
+	case VM:
+		prog.emitOnce.Do(func() { prog.code = program.Emit(prog.prog) })
+		return program.Run(ctx, prog.code, bitmap, execOptions)
+	}
+	return &Error{Msg: fmt.Sprintf("unknown engine %d", options.Engine)}
+}
+
+// RunImage executes prog against a Surface with img as source image and returns the target image.
+func RunImage(ctx context.Context, prog *Program, img image.Image, options Options) (image.Image, error) {
+	surf := NewSurface(img)
+	if err := Run(ctx, prog, surf, options); err != nil {
+		return nil, err
+	}
+	return surf.Target(), nil
+}
+
+// logBitmap passes the messages logged by a script to a LogSink instead of the wrapped BitmapContext
+type logBitmap struct {
+	BitmapContext
+	log LogSink
+}
+
+func (b logBitmap) Log(message string) {
+	b.log(message)
+}
+
+func (b logBitmap) Inputs() map[string]InputImage {
+	if provider, ok := b.BitmapContext.(InputProvider); ok {
+		return provider.Inputs()
+	}
+	return nil
+}
+
+// frameLogBitmap is a logBitmap wrapping a BitmapContext that records frames
+type frameLogBitmap struct {
+	logBitmap
+	FrameRecorder
+}
@@ -0,0 +1,199 @@
+package ylang
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+	src.SetNRGBA(1, 1, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+	prog, err := Compile(`for p in Bounds {
+    @p = -@p
+}
+log(W, "x", H)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, engine := range []Engine{Interpreter, VM} {
+		out := strings.Builder{}
+		img, err := RunImage(context.Background(), prog, src, Options{Engine: engine, Log: WriterLogSink(&out)})
+		if err != nil {
+			t.Fatalf("RunImage() engine %d error = %v", engine, err)
+		}
+		if got, want := img.At(1, 1), (color.NRGBA{R: 245, G: 235, B: 225, A: 255}); got != want {
+			t.Errorf("RunImage() engine %d pixel = %v, want %v", engine, got, want)
+		}
+		if got, want := img.At(0, 0), (color.NRGBA{A: 255}); got != want {
+			t.Errorf("RunImage() engine %d pixel = %v, want %v", engine, got, want)
+		}
+		if out.String() != "3x2\n" {
+			t.Errorf("RunImage() engine %d log = %q, want %q", engine, out.String(), "3x2\n")
+		}
+	}
+}
+
+func TestRun_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		options Options
+		line    int
+		limit   bool
+	}{
+		{
+			name: "runtime",
+			src:  "a := 1\nb := a + true",
+			line: 2,
+		},
+		{
+			name:    "steps",
+			src:     "while true {\n}",
+			options: Options{Limits: Limits{MaxSteps: 100}},
+			line:    1,
+			limit:   true,
+		},
+		{
+			name:    "timeout",
+			src:     "while true {\n}",
+			options: Options{Engine: VM, Limits: Limits{Timeout: 10 * time.Millisecond}},
+			limit:   true,
+		},
+		{
+			name:    "engine",
+			src:     "a := 1",
+			options: Options{Engine: Engine(7)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := Compile(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Run(context.Background(), prog, NewSurface(image.NewNRGBA(image.Rect(0, 0, 1, 1))), tt.options)
+			var yerr *Error
+			if !errors.As(err, &yerr) {
+				t.Fatalf("Run() error = %v, want *Error", err)
+			}
+			if tt.line != 0 && yerr.Line != tt.line {
+				t.Errorf("Run() error line = %d, want %d", yerr.Line, tt.line)
+			}
+			var limitErr *LimitError
+			if errors.As(err, &limitErr) != tt.limit {
+				t.Errorf("Run() error = %v, want limit error = %v", err, tt.limit)
+			}
+		})
+	}
+}
+
+func TestCompileModule(t *testing.T) {
+	resolver := BundleResolver{"lib.ylang": "double := fn(x) -> x * 2"}
+	prog, err := CompileModule("", `lib := import "lib.ylang"
+log(lib.double(21))`, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	options := Options{Log: func(message string) { got = append(got, message) }}
+	if err := Run(context.Background(), prog, NewSurface(image.NewNRGBA(image.Rect(0, 0, 1, 1))), options); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(got) != 1 || got[0] != "42" {
+		t.Errorf("Run() logged %q, want [42]", got)
+	}
+
+	_, err = CompileModule("", "x := \n", resolver)
+	var yerr *Error
+	if !errors.As(err, &yerr) || yerr.Line == 0 {
+		t.Errorf("CompileModule() error = %v, want *Error with position", err)
+	}
+}
+
+func TestProgram_Params(t *testing.T) {
+	prog, err := Compile("param factor := 2\nparam name := \"x\"\nlog(name, factor * 21)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prog.Params(); !reflect.DeepEqual(got, []string{"factor", "name"}) {
+		t.Errorf("Params() = %v, want [factor name]", got)
+	}
+	for _, engine := range []Engine{Interpreter, VM} {
+		var got []string
+		options := Options{
+			Engine: engine,
+			Log:    func(message string) { got = append(got, message) },
+			Params: map[string]string{"factor": "3", "name": "y"},
+		}
+		if _, err := RunImage(context.Background(), prog, image.NewNRGBA(image.Rect(0, 0, 1, 1)), options); err != nil {
+			t.Fatalf("RunImage() engine %d error = %v", engine, err)
+		}
+		if len(got) != 1 || got[0] != "y63" {
+			t.Errorf("RunImage() engine %d logged %q, want [y63]", engine, got)
+		}
+	}
+}
+
+// defect is a custom value type returned by the builtin function classify
+type defect struct {
+	StrValue
+}
+
+func TestCompileModuleWith(t *testing.T) {
+	registry := NewRegistry()
+	classify := func(bitmap BitmapContext, args []Value) (Value, error) {
+		if args[0].(ColorValue).R > 128 {
+			return defect{"scratch"}, nil
+		}
+		return defect{"none"}, nil
+	}
+	describe := func(bitmap BitmapContext, args []Value) (Value, error) {
+		return StrValue("defect: " + args[0].(defect).PrintStr()), nil
+	}
+	for _, err := range []error{
+		registry.AddType(defect{}),
+		registry.AddFunction("classify", NewFunctionDecl(classify, reflect.TypeOf(ColorValue{}))),
+		registry.AddFunction("describe", NewFunctionDecl(describe, reflect.TypeOf(defect{}))),
+		registry.AddConstant("Origin", PointValue{}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := "log(describe(classify(@Origin)))"
+	if _, err := Compile(src); err == nil {
+		t.Fatalf("Compile() without registry succeeded")
+	}
+	prog, err := CompileModuleWith("", src, nil, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 200, A: 255})
+	for _, engine := range []Engine{Interpreter, VM} {
+		var got []string
+		options := Options{Engine: engine, Log: func(message string) { got = append(got, message) }}
+		if _, err := RunImage(context.Background(), prog, img, options); err != nil {
+			t.Fatalf("RunImage() engine %d error = %v", engine, err)
+		}
+		options.Constants = map[string]Value{"Origin": PointValue{X: 1}}
+		if _, err := RunImage(context.Background(), prog, img, options); err != nil {
+			t.Fatalf("RunImage() engine %d with constants error = %v", engine, err)
+		}
+		if len(got) != 2 || got[0] != "defect: scratch" || got[1] != "defect: none" {
+			t.Errorf("RunImage() engine %d logged %q, want [defect: scratch defect: none]", engine, got)
+		}
+	}
+	if printed := registry.PrintFunctions(); !strings.Contains(printed, "  fn describe(defect)\n") {
+		t.Errorf("PrintFunctions() = %s, want it to list describe", printed)
+	}
+}